@@ -12,6 +12,9 @@ export async function POST(request: NextRequest) {
     const userId = request.headers.get('X-User-ID') || '';
     // Get LiteLLM Virtual Key from request header (passed from client, set by market.niyogen.com)
     const litellmApiKey = request.headers.get('X-LiteLLM-API-Key') || '';
+    // The gateway only trusts verified credentials (bearer token or session cookie)
+    const authorization = request.headers.get('Authorization') || '';
+    const cookie = request.headers.get('Cookie') || '';
 
     console.log(`[Chat Proxy] Sending request to: ${API_BASE_URL}/api/chat/stream (Device: ${deviceId})`);
 
@@ -24,6 +27,8 @@ export async function POST(request: NextRequest) {
     };
     if (deviceId) headers['X-Device-ID'] = deviceId;
     if (userId) headers['X-User-ID'] = userId;
+    if (authorization) headers['Authorization'] = authorization;
+    if (cookie) headers['Cookie'] = cookie;
     if (litellmApiKey) headers['X-LiteLLM-API-Key'] = litellmApiKey;
 
     // Forward the request to the backend and stream the response
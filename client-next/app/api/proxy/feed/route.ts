@@ -11,7 +11,9 @@ export async function GET(request: Request) {
   let timeoutId: NodeJS.Timeout | null = null;
   const deviceId = request.headers.get('X-Device-ID') || '';
   const userId = request.headers.get('X-User-ID') || '';
-  // Authorization might be needed too if we use it later
+  // The gateway only trusts verified credentials (bearer token or session cookie)
+  const authorization = request.headers.get('Authorization') || '';
+  const cookie = request.headers.get('Cookie') || '';
 
   try {
     console.log(`[Feed Proxy] Fetching from: ${API_BASE_URL}/api/feed (Device: ${deviceId}, User: ${userId})`);
@@ -25,6 +27,8 @@ export async function GET(request: Request) {
     };
     if (deviceId) headers['X-Device-ID'] = deviceId;
     if (userId) headers['X-User-ID'] = userId;
+    if (authorization) headers['Authorization'] = authorization;
+    if (cookie) headers['Cookie'] = cookie;
 
     const response = await fetch(`${API_BASE_URL}/api/feed`, {
       method: 'GET',
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"guardian-gateway/pkg/agentinput"
+	"guardian-gateway/pkg/logging"
+	"guardian-gateway/pkg/session"
+	"guardian-gateway/pkg/trip"
+	"guardian-gateway/pkg/variables"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// inputShapes caches each agent's declared input shape until its file changes,
+// sparing a 'fastgraph inspect' per chat run
+var inputShapes = struct {
+	sync.Mutex
+	byPath map[string]cachedShape
+}{byPath: make(map[string]cachedShape)}
+
+type cachedShape struct {
+	modTime time.Time
+	shape   agentinput.Shape
+}
+
+// agentInputShape returns the input the agent at agentPath declares in its
+// metadata. An agent that declares no inputs gets schema's variables as text.
+func agentInputShape(ctx context.Context, agentPath string, schema *variables.Schema) agentinput.Shape {
+	var modTime time.Time
+	if info, err := os.Stat(agentPath); err == nil {
+		modTime = info.ModTime()
+	}
+	inputShapes.Lock()
+	cached, ok := inputShapes.byPath[agentPath]
+	inputShapes.Unlock()
+	if ok && cached.modTime.Equal(modTime) {
+		return cached.shape
+	}
+
+	shape := agentinput.Shape{Format: agentinput.FormatText}
+	for _, v := range schema.Variables {
+		shape.Fields = append(shape.Fields, v.Name)
+	}
+	meta, err := engine.Inspect(agentPath)
+	if err != nil {
+		// Not cached: the next run asks again
+		slog.DebugContext(ctx, "agent input shape unavailable, using the variable schema", "agent", agentPath, logging.Err(err))
+		return shape
+	}
+	if len(meta.Inputs) > 0 {
+		shape.Fields = meta.Inputs
+	}
+	if format, err := agentinput.ParseFormat(meta.InputFormat); err == nil {
+		shape.Format = format
+	} else {
+		slog.WarnContext(ctx, "invalid agent input format, using text", "agent", agentPath, logging.Err(err))
+	}
+
+	inputShapes.Lock()
+	inputShapes.byPath[agentPath] = cachedShape{modTime: modTime, shape: shape}
+	inputShapes.Unlock()
+	return shape
+}
+
+// buildAgentInput renders a chat run's input from the session's trip, when it
+// has one, over its variables, so the same trip always gives the same input
+func buildAgentInput(shape agentinput.Shape, sess *session.Session, t *trip.Trip, note string) string {
+	values := sess.GetVariables()
+	if t != nil {
+		for name, v := range t.Variables() {
+			values[name] = v
+		}
+	}
+	return agentinput.Build(shape, values, time.Now(), note)
+}
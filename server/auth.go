@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"guardian-gateway/pkg/auth"
+	"guardian-gateway/pkg/config"
+	"guardian-gateway/pkg/identity"
+	"guardian-gateway/pkg/session"
+	"guardian-gateway/pkg/store"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newAuthenticator builds the request authenticator. A JWKS URL takes
+// precedence over a shared secret; with neither only anonymous (device ID) access works.
+func newAuthenticator(cfg config.AuthConfig) *auth.Authenticator {
+	a := &auth.Authenticator{
+		CookieName:     cfg.CookieName,
+		AllowAnonymous: cfg.AllowAnonymous,
+	}
+
+	var keys auth.KeySource
+	switch {
+	case cfg.JWKSURL != "":
+		keys = auth.NewJWKS(cfg.JWKSURL)
+		slog.Info("verifying tokens against JWKS", "url", cfg.JWKSURL)
+	case cfg.JWTSecret != "":
+		keys = auth.SharedSecret(cfg.JWTSecret)
+		slog.Info("verifying tokens with shared secret")
+	default:
+		slog.Warn("AUTH_JWKS_URL / AUTH_JWT_SECRET not set, only anonymous access is possible")
+		return a
+	}
+
+	a.Verifier = &auth.JWTVerifier{
+		Keys:     keys,
+		Issuer:   strings.TrimSpace(cfg.Issuer),
+		Audience: strings.TrimSpace(cfg.Audience),
+		Leeway:   cfg.ClockSkew,
+	}
+	return a
+}
+
+// requestPrincipal returns the caller set by the auth middleware, writing a 401
+// when the handler was reached without one
+func requestPrincipal(c *gin.Context) (*auth.Principal, bool) {
+	p, ok := auth.FromContext(c)
+	if !ok || p.OwnerID() == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return nil, false
+	}
+	return p, true
+}
+
+// feedLinkStore resolves the global store per call, since the DB may connect after startup
+type feedLinkStore struct{}
+
+func (feedLinkStore) MergeDeviceIntoUser(ctx context.Context, deviceID, deviceOwner, userID string) (*store.MergeResult, error) {
+	if feedStore == nil {
+		return nil, errors.New("DB not initialized")
+	}
+	return feedStore.MergeDeviceIntoUser(ctx, deviceID, deviceOwner, userID)
+}
+
+func (feedLinkStore) LoadSession(ctx context.Context, id string) (*store.SessionRecord, error) {
+	if feedStore == nil {
+		return nil, store.ErrNotFound
+	}
+	return feedStore.LoadSession(ctx, id)
+}
+
+func (feedLinkStore) SaveSession(ctx context.Context, rec *store.SessionRecord) error {
+	if feedStore == nil {
+		return nil
+	}
+	return feedStore.SaveSession(ctx, rec)
+}
+
+// newIdentityService merges anonymous device data into users as they log in
+func newIdentityService() *identity.Service {
+	svc := identity.NewService(feedLinkStore{}, func() *session.SessionManager { return session.GlobalManager })
+	svc.Saved = feedLinkStore{}
+	return svc
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"guardian-gateway/pkg/logging"
+	"guardian-gateway/pkg/store"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cardWriter persists the cards of one agent run. Streamed chunks accumulate
+// per node and the node's card is written at most once per window; a node is
+// flushed as soon as the run moves on to the next node, and close flushes the
+// rest. A card is classified and its image looked up on its first write;
+// later writes just refresh its text, except the node's final write, which
+// classifies the whole text again (keeping the image unless the category
+// changed). Once closed, each node's card is checked for material changes
+// against its previous revision.
+type cardWriter struct {
+	ctx         context.Context
+	ownerID     string
+	destination string
+	window      time.Duration
+	// stickyKey, when set, is the store.Card.StickyKey of the node cards
+	stickyKey string
+
+	// build, classify, save and notify default to buildCard, classifyCard,
+	// feedStore.UpsertCard and notifyMaterialChanges
+	build    func(ctx context.Context, eventJSON, destination string) *store.Card
+	classify func(ctx context.Context, eventJSON, destination string) *store.Card
+	save     func(ctx context.Context, ownerID string, card *store.Card) error
+	notify   func(ctx context.Context, ownerID string, card *store.Card)
+
+	mu      sync.Mutex
+	current string // node the run is streaming
+	nodes   map[string]*nodeCard
+	closed  bool
+	timers  sync.WaitGroup
+
+	flushMu sync.Mutex // serializes writes so a card never goes back to older text
+}
+
+type nodeCard struct {
+	text  strings.Builder
+	dirty bool
+	done  bool // The run moved on or the writer closed; the next write is final
+	timer *time.Timer
+	card  *store.Card // last written version; guarded by flushMu
+}
+
+func newCardWriter(ctx context.Context, ownerID, destination string) *cardWriter {
+	return &cardWriter{
+		ctx:         ctx,
+		ownerID:     ownerID,
+		destination: destination,
+		window:      appConfig.Feed.FlushInterval,
+		build:       buildCard,
+		classify:    classifyCard,
+		save: func(ctx context.Context, ownerID string, card *store.Card) error {
+			if feedStore == nil {
+				return nil
+			}
+			return feedStore.UpsertCard(ctx, ownerID, card)
+		},
+		notify: notifyMaterialChanges,
+		nodes:  make(map[string]*nodeCard),
+	}
+}
+
+// event takes one engine event. Chunks of a node are accumulated; events
+// seen before any node are saved right away, as processAndSaveFeed does.
+func (w *cardWriter) event(eventJSON string) {
+	var evt struct {
+		Node    string `json:"node"`
+		Message string `json:"message"`
+		Text    string `json:"text"`
+		Type    string `json:"type"`
+	}
+	_ = json.Unmarshal([]byte(eventJSON), &evt) // Best effort
+	if evt.Type == "usage" {
+		return // Metered, not shown
+	}
+	content := evt.Message
+	if content == "" {
+		content = evt.Text
+	}
+
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return
+	}
+	if evt.Node != "" && evt.Node != w.current {
+		// The previous node is complete
+		if prev := w.nodes[w.current]; prev != nil {
+			prev.done = true
+			w.scheduleLocked(w.current, 0)
+		}
+		w.current = evt.Node
+		if n := w.nodes[evt.Node]; n != nil {
+			n.done = false // The run came back to it
+		}
+	}
+	node := w.current
+	if node == "" || content == "" {
+		w.mu.Unlock()
+		processAndSaveFeed(w.ctx, w.ownerID, eventJSON, w.destination)
+		return
+	}
+	n := w.nodes[node]
+	if n == nil {
+		n = &nodeCard{}
+		w.nodes[node] = n
+	}
+	n.text.WriteString(content)
+	n.dirty = true
+	if n.timer == nil {
+		w.scheduleLocked(node, w.window)
+	}
+	w.mu.Unlock()
+}
+
+// scheduleLocked flushes node after d, moving an already scheduled flush
+// forward when d is sooner. w.mu must be held.
+func (w *cardWriter) scheduleLocked(node string, d time.Duration) {
+	n := w.nodes[node]
+	if n == nil || !n.dirty {
+		return
+	}
+	if n.timer != nil {
+		if d > 0 || !n.timer.Stop() {
+			return // Already due, or firing now
+		}
+		w.timers.Done()
+	}
+	w.timers.Add(1)
+	n.timer = time.AfterFunc(d, func() {
+		defer w.timers.Done()
+		w.mu.Lock()
+		n.timer = nil
+		w.mu.Unlock()
+		w.flush(node)
+	})
+}
+
+// close flushes every pending card and waits for the writes to finish
+func (w *cardWriter) close() {
+	w.mu.Lock()
+	w.closed = true
+	pending := make([]string, 0, len(w.nodes))
+	for node, n := range w.nodes {
+		n.done = true
+		if n.timer != nil && n.timer.Stop() {
+			n.timer = nil
+			w.timers.Done()
+		}
+		pending = append(pending, node)
+	}
+	w.mu.Unlock()
+
+	w.timers.Wait()
+	for _, node := range pending {
+		w.flush(node)
+	}
+
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+	for _, node := range pending {
+		if card := w.nodes[node].card; card != nil && card.ID != "" {
+			w.notify(w.ctx, w.ownerID, card)
+		}
+	}
+}
+
+func (w *cardWriter) flush(node string) {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	w.mu.Lock()
+	n := w.nodes[node]
+	if n == nil || !n.dirty {
+		w.mu.Unlock()
+		return
+	}
+	text := n.text.String()
+	final := n.done
+	n.dirty = false
+	w.mu.Unlock()
+
+	event, err := json.Marshal(map[string]string{"type": "chunk", "node": node, "message": text})
+	if err != nil {
+		return
+	}
+	var card *store.Card
+	switch {
+	case n.card == nil:
+		if card = w.build(w.ctx, string(event), w.destination); card == nil {
+			return
+		}
+		card.StickyKey = w.stickyKey
+	case final:
+		// Text that arrived after the first write can change the type
+		// (a SAFETY: line turns the card into an alert)
+		if card = w.classify(w.ctx, string(event), w.destination); card != nil {
+			card = withCardText(w.reclassified(n.card, card), text)
+		} else {
+			card = withCardText(n.card, text)
+		}
+	default:
+		card = withCardText(n.card, text)
+	}
+	n.card = card
+
+	if err := w.save(w.ctx, w.ownerID, card); err != nil {
+		slog.ErrorContext(w.ctx, "failed to save card", "node", card.SourceNode, logging.Err(err))
+	}
+}
+
+// reclassified returns next as the new version of prev: the same card, with
+// prev's image unless the category changed
+func (w *cardWriter) reclassified(prev, next *store.Card) *store.Card {
+	next.ID, next.StickyKey = prev.ID, prev.StickyKey
+	if prev.Data["category"] == next.Data["category"] {
+		for _, field := range []string{"imageUrl", "imageUser", "imageUserLink"} {
+			if v, ok := prev.Data[field]; ok {
+				next.Data[field] = v
+			}
+		}
+	} else {
+		setCardImage(w.ctx, next.Data, w.destination)
+	}
+	return next
+}
+
+// withCardText returns a copy of card showing message, keeping its
+// classification and image
+func withCardText(card *store.Card, message string) *store.Card {
+	text := cleanMessage(message)
+	data := make(map[string]interface{}, len(card.Data))
+	for k, v := range card.Data {
+		data[k] = v
+	}
+	data["summary"] = text
+	// Card types that repeat the text under their own field
+	for _, field := range []string{"message", "description"} {
+		if _, ok := data[field]; ok {
+			data[field] = text
+		}
+	}
+	cp := *card
+	cp.Data = data
+	return &cp
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"guardian-gateway/pkg/store"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingWriter returns a cardWriter whose builds and saves are recorded
+func recordingWriter(window time.Duration) (*cardWriter, *cardLog) {
+	log := &cardLog{builds: map[string]int{}}
+	w := newCardWriter(context.Background(), "device:d1", "Lisbon, Portugal")
+	w.window = window
+	w.build = func(ctx context.Context, eventJSON, destination string) *store.Card {
+		card := buildCard(ctx, eventJSON, destination)
+		if card != nil {
+			log.mu.Lock()
+			log.builds[card.SourceNode]++
+			log.mu.Unlock()
+		}
+		return card
+	}
+	w.save = func(ctx context.Context, ownerID string, card *store.Card) error {
+		log.mu.Lock()
+		defer log.mu.Unlock()
+		log.saves = append(log.saves, card)
+		return nil
+	}
+	return w, log
+}
+
+type cardLog struct {
+	mu     sync.Mutex
+	builds map[string]int
+	saves  []*store.Card
+}
+
+func (l *cardLog) snapshot() (map[string]int, []*store.Card) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	builds := map[string]int{}
+	for k, v := range l.builds {
+		builds[k] = v
+	}
+	return builds, append([]*store.Card(nil), l.saves...)
+}
+
+func TestCardWriter_CoalescesChunks(t *testing.T) {
+	w, log := recordingWriter(time.Hour)
+	w.event(`{"type": "chunk", "node": "GenerateReport", "message": "Day 1: "}`)
+	for i := 0; i < 50; i++ {
+		w.event(`{"type": "chunk", "message": "walk. "}`)
+	}
+	w.event(`{"type": "usage", "node": "GenerateReport", "usage": {"total_tokens": 10}}`)
+
+	_, saves := log.snapshot()
+	assert.Empty(t, saves, "nothing is written before the window ends")
+
+	w.close()
+	builds, saves := log.snapshot()
+	require.Len(t, saves, 1)
+	assert.Equal(t, "GenerateReport", saves[0].SourceNode)
+	assert.Contains(t, saves[0].Data["summary"], "Day 1: walk. walk.")
+	assert.Equal(t, 1, builds["GenerateReport"])
+
+	w.event(`{"type": "chunk", "node": "GenerateReport", "message": "late"}`)
+	_, saves = log.snapshot()
+	assert.Len(t, saves, 1, "a closed writer ignores events")
+}
+
+func TestCardWriter_FlushesCompletedNodes(t *testing.T) {
+	w, log := recordingWriter(time.Hour)
+	w.event(`{"type": "chunk", "node": "NewsAlert", "message": "SAFETY: protests downtown"}`)
+	w.event(`{"type": "chunk", "node": "GenerateReport", "message": "Day 1"}`)
+
+	// Moving on to GenerateReport completes NewsAlert
+	require.Eventually(t, func() bool {
+		_, saves := log.snapshot()
+		return len(saves) == 1
+	}, time.Second, 5*time.Millisecond)
+	_, saves := log.snapshot()
+	assert.Equal(t, "NewsAlert", saves[0].SourceNode)
+	assert.Equal(t, "safe_alert", saves[0].CardType)
+
+	w.close()
+	_, saves = log.snapshot()
+	require.Len(t, saves, 2)
+	assert.Equal(t, "GenerateReport", saves[1].SourceNode)
+}
+
+func TestCardWriter_ClassifiesOnce(t *testing.T) {
+	w, log := recordingWriter(5 * time.Millisecond)
+	w.event(`{"type": "chunk", "node": "NewsAlert", "message": "SAFETY: protests"}`)
+	require.Eventually(t, func() bool {
+		_, saves := log.snapshot()
+		return len(saves) == 1
+	}, time.Second, time.Millisecond)
+
+	w.event(`{"type": "chunk", "message": " downtown"}`)
+	w.close()
+
+	builds, saves := log.snapshot()
+	require.Len(t, saves, 2)
+	assert.Equal(t, 1, builds["NewsAlert"], "later writes reuse the classification")
+	assert.Equal(t, saves[0].CardType, saves[1].CardType)
+	assert.Equal(t, cleanMessage("SAFETY: protests downtown"), saves[1].Data["summary"])
+	assert.Equal(t, saves[1].Data["summary"], saves[1].Data["message"])
+	assert.Equal(t, cleanMessage("SAFETY: protests"), saves[0].Data["summary"], "earlier versions are not modified")
+}
+
+// countImageLookups stubs fetchImage for the test and counts its calls
+func countImageLookups(t *testing.T) *int {
+	orig := fetchImage
+	t.Cleanup(func() { fetchImage = orig })
+	var mu sync.Mutex
+	n := new(int)
+	fetchImage = func(ctx context.Context, query string) (string, string, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		*n++
+		return "https://images.example/" + query, "Ann", "https://unsplash.example/ann"
+	}
+	return n
+}
+
+func TestBuildCard_LooksUpImageOnce(t *testing.T) {
+	lookups := countImageLookups(t)
+	card := buildCard(context.Background(), `{"type": "chunk", "node": "CheckWeather", "message": "Weather in Lisbon: sunny"}`, "Lisbon, Portugal")
+	require.NotNil(t, card)
+	assert.Equal(t, "weather", card.CardType)
+	assert.Equal(t, 1, *lookups, "both classifiers match, the image is looked up once")
+	assert.Equal(t, "https://images.example/Portugal", card.Data["imageUrl"])
+}
+
+func TestCardWriter_ReclassifiesFinalWrite(t *testing.T) {
+	lookups := countImageLookups(t)
+	w, log := recordingWriter(5 * time.Millisecond)
+	w.event(`{"type": "chunk", "node": "CheckWeather", "message": "Sunny all week."}`)
+	require.Eventually(t, func() bool {
+		_, saves := log.snapshot()
+		return len(saves) == 1
+	}, time.Second, time.Millisecond)
+	_, saves := log.snapshot()
+	assert.Equal(t, "weather", saves[0].CardType)
+
+	w.event(`{"type": "chunk", "message": " Warning: heatwave on Friday."}`)
+	w.close()
+
+	builds, saves := log.snapshot()
+	require.Len(t, saves, 2)
+	assert.Equal(t, 1, builds["CheckWeather"])
+	assert.Equal(t, "safe_alert", saves[1].CardType, "a warning arriving late still makes an alert")
+	assert.Equal(t, "high", saves[1].Priority)
+	assert.Equal(t, saves[1].Data["summary"], saves[1].Data["message"])
+	assert.NotContains(t, saves[1].Data, "imageUrl", "alerts carry no photo")
+	assert.Equal(t, 1, *lookups)
+}
+
+func TestCardWriter_FinalWriteKeepsImage(t *testing.T) {
+	lookups := countImageLookups(t)
+	w, log := recordingWriter(5 * time.Millisecond)
+	w.event(`{"type": "chunk", "node": "GeniusLoci", "message": "Fado started in Alfama."}`)
+	require.Eventually(t, func() bool {
+		_, saves := log.snapshot()
+		return len(saves) == 1
+	}, time.Second, time.Millisecond)
+
+	w.event(`{"type": "chunk", "message": " Tip the singers."}`)
+	w.close()
+
+	_, saves := log.snapshot()
+	require.Len(t, saves, 2)
+	assert.Equal(t, "cultural_tip", saves[1].CardType)
+	assert.Equal(t, saves[0].Data["imageUrl"], saves[1].Data["imageUrl"])
+	assert.Equal(t, 1, *lookups, "the image is looked up once per card")
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"guardian-gateway/pkg/logging"
+	"guardian-gateway/pkg/store"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultFeedLimit = 50
+	maxFeedLimit     = 200
+)
+
+// parseFeedQuery reads GET /api/feed's paging and filter parameters
+func parseFeedQuery(c *gin.Context) (store.FeedQuery, error) {
+	q := store.FeedQuery{
+		Limit:       defaultFeedLimit,
+		Cursor:      c.Query("cursor"),
+		CardTypes:   queryList(c, "card_type"),
+		Priorities:  queryList(c, "priority"),
+		SourceNodes: queryList(c, "source_node"),
+		TripIDs:     queryList(c, "trip_id"),
+	}
+	limit, err := queryLimit(c)
+	if err != nil {
+		return q, err
+	}
+	q.Limit = limit
+	for name, dst := range map[string]*time.Time{"from": &q.From, "to": &q.To, "since": &q.Since} {
+		if v := c.Query(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return q, fmt.Errorf("Invalid %s (expected RFC 3339)", name)
+			}
+			*dst = t
+		}
+	}
+	return q, nil
+}
+
+// queryLimit reads the page size parameter: defaultFeedLimit when absent
+func queryLimit(c *gin.Context) (int, error) {
+	v := c.Query("limit")
+	if v == "" {
+		return defaultFeedLimit, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 || n > maxFeedLimit {
+		return 0, fmt.Errorf("Invalid limit (1-%d)", maxFeedLimit)
+	}
+	return n, nil
+}
+
+// queryList collects a filter given as repeated and/or comma-separated values
+func queryList(c *gin.Context, name string) []string {
+	var values []string
+	for _, v := range c.QueryArray(name) {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+	}
+	return values
+}
+
+// writeFeedPage sends the page's cards with its next cursor, or 304 when the
+// client already has this exact page
+func writeFeedPage(c *gin.Context, page *store.FeedPage) {
+	body, err := json.Marshal(page.Cards)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode feed"})
+		return
+	}
+	sum := sha256.Sum256(append(body, page.NextCursor...))
+	etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "private, no-cache")
+	if page.NextCursor != "" {
+		c.Header("X-Next-Cursor", page.NextCursor)
+	}
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.AbortWithStatus(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
+
+// etagMatches applies If-None-Match's weak comparison
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// cardPatchRequest is PATCH /api/feed/{id}'s body. expires_at is an RFC 3339
+// time, or null to clear it.
+type cardPatchRequest struct {
+	Read      *bool           `json:"read"`
+	Dismissed *bool           `json:"dismissed"`
+	Pinned    *bool           `json:"pinned"`
+	ExpiresAt json.RawMessage `json:"expires_at"`
+}
+
+func (r cardPatchRequest) patch() (store.CardPatch, error) {
+	p := store.CardPatch{Read: r.Read, Dismissed: r.Dismissed, Pinned: r.Pinned}
+	if len(r.ExpiresAt) > 0 {
+		var at time.Time
+		if !bytes.Equal(r.ExpiresAt, []byte("null")) {
+			if err := json.Unmarshal(r.ExpiresAt, &at); err != nil {
+				return p, fmt.Errorf("Invalid expires_at (expected RFC 3339 or null)")
+			}
+		}
+		p.ExpiresAt = &at
+	}
+	if p.Read == nil && p.Dismissed == nil && p.Pinned == nil && p.ExpiresAt == nil {
+		return p, fmt.Errorf("Nothing to update (read, dismissed, pinned or expires_at)")
+	}
+	return p, nil
+}
+
+// UpdateCardHandler godoc
+// @Summary      Update Card
+// @Description  Mark a card read, dismiss it, pin it to the top of the feed or set when it expires. Dismissed and expired cards leave the feed; pinned cards never expire.
+// @Tags         feed
+// @Accept       json
+// @Produce      json
+// @Param        id    path      string  true  "Card ID"
+// @Success      200   {object}  FeedItem
+// @Failure      400   {object}  map[string]string
+// @Failure      404   {object}  map[string]string
+// @Router       /api/feed/{id} [patch]
+func UpdateCardHandler(c *gin.Context) {
+	principal, ok := requestPrincipal(c)
+	if !ok {
+		return
+	}
+
+	var req cardPatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	patch, err := req.patch()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if feedStore == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB not initialized"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	card, err := feedStore.UpdateCard(ctx, principal.OwnerID(), c.Param("id"), patch)
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Card not found"})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to update card", logging.Err(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update card"})
+		return
+	}
+	c.JSON(http.StatusOK, card)
+}
+
+// DeleteCardHandler godoc
+// @Summary      Delete Card
+// @Description  Delete one card from the feed
+// @Tags         feed
+// @Produce      json
+// @Param        id    path      string  true  "Card ID"
+// @Success      200   {object}  map[string]string
+// @Failure      404   {object}  map[string]string
+// @Router       /api/feed/{id} [delete]
+func DeleteCardHandler(c *gin.Context) {
+	principal, ok := requestPrincipal(c)
+	if !ok {
+		return
+	}
+
+	if feedStore == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB not initialized"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	err := feedStore.DeleteCard(ctx, principal.OwnerID(), c.Param("id"))
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Card not found"})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to delete card", logging.Err(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete card"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+// startFeedRetention periodically purges expired cards and cards dismissed
+// longer than dismissedRetention ago
+func startFeedRetention(ctx context.Context, interval, dismissedRetention time.Duration) {
+	slog.Info("feed retention started", "interval", interval, "dismissed_retention", dismissedRetention)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if feedStore == nil {
+			continue
+		}
+		tickCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+		n, err := feedStore.PurgeCards(tickCtx, time.Now().Add(-dismissedRetention))
+		cancel()
+		if err != nil {
+			slog.Error("feed retention failed", logging.Err(err))
+			continue
+		}
+		if n > 0 {
+			slog.Info("purged feed cards", "cards", n)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"guardian-gateway/pkg/auth"
+	"guardian-gateway/pkg/store"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetFeedHandler_Pages(t *testing.T) {
+	origStore := feedStore
+	defer func() { feedStore = origStore }()
+	s := store.NewMemoryStore()
+	feedStore = s
+	ctx := context.Background()
+	for _, node := range []string{"Weather", "NewsAlert", "GenerateReport"} {
+		card := &store.Card{CardType: "article", Priority: "medium", SourceNode: node, Data: map[string]interface{}{"summary": node}}
+		if node == "NewsAlert" {
+			card.CardType, card.Priority = "safe_alert", "high"
+		}
+		require.NoError(t, s.UpsertCard(ctx, "device:test-device", card))
+	}
+
+	gin.SetMode(gin.TestMode)
+	get := func(url string, header http.Header) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("GET", url, nil)
+		for k, v := range header {
+			c.Request.Header[k] = v
+		}
+		auth.SetPrincipal(c, &auth.Principal{Kind: auth.KindAnonymous, DeviceID: "test-device"})
+		GetFeedHandler(c)
+		return w
+	}
+	cards := func(w *httptest.ResponseRecorder) []store.Card {
+		var page []store.Card
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		return page
+	}
+
+	w := get("/api/feed?limit=2", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, cards(w), 2)
+	next := w.Header().Get("X-Next-Cursor")
+	require.NotEmpty(t, next)
+
+	w = get("/api/feed?limit=2&cursor="+next, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, cards(w), 1)
+	assert.Empty(t, w.Header().Get("X-Next-Cursor"), "last page")
+
+	w = get("/api/feed?priority=high,low", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Len(t, cards(w), 1)
+	assert.Equal(t, "NewsAlert", cards(w)[0].SourceNode)
+
+	// Polling with the ETag is answered with 304 until the feed changes
+	w = get("/api/feed", nil)
+	etag := w.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+	w = get("/api/feed", http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	require.NoError(t, s.UpsertCard(ctx, "device:test-device", &store.Card{CardType: "weather", Priority: "medium", SourceNode: "CheckWeather"}))
+	w = get("/api/feed", http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, cards(w), 4)
+
+	for _, bad := range []string{"limit=0", "limit=500", "since=yesterday", "cursor=bogus", "cursor=ZmFsc2V8MXwxIE9SIDE9MQ"} {
+		w = get("/api/feed?"+bad, nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code, bad)
+	}
+}
+
+func TestEtagMatches(t *testing.T) {
+	assert.True(t, etagMatches(`W/"abc"`, `W/"abc"`))
+	assert.True(t, etagMatches(`"abc"`, `W/"abc"`), "weak comparison")
+	assert.True(t, etagMatches(`"x", W/"abc"`, `W/"abc"`))
+	assert.True(t, etagMatches(`*`, `W/"abc"`))
+	assert.False(t, etagMatches(``, `W/"abc"`))
+	assert.False(t, etagMatches(`W/"abd"`, `W/"abc"`))
+}
+
+func TestCardHandlers(t *testing.T) {
+	origStore := feedStore
+	defer func() { feedStore = origStore }()
+	s := store.NewMemoryStore()
+	feedStore = s
+	ctx := context.Background()
+	alert := &store.Card{CardType: "safe_alert", Priority: "high", SourceNode: "NewsAlert", RunID: "run-1"}
+	report := &store.Card{CardType: "article", Priority: "medium", SourceNode: "GenerateReport", RunID: "run-1"}
+	require.NoError(t, s.UpsertCard(ctx, "device:test-device", alert))
+	require.NoError(t, s.UpsertCard(ctx, "device:test-device", report))
+
+	gin.SetMode(gin.TestMode)
+	call := func(method, id, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest(method, "/api/feed/"+id, strings.NewReader(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Params = gin.Params{{Key: "id", Value: id}}
+		auth.SetPrincipal(c, &auth.Principal{Kind: auth.KindAnonymous, DeviceID: "test-device"})
+		if method == http.MethodPatch {
+			UpdateCardHandler(c)
+		} else {
+			DeleteCardHandler(c)
+		}
+		return w
+	}
+	feed := func() []*store.Card {
+		page, err := s.GetFeed(ctx, "device:test-device", store.FeedQuery{Limit: 10})
+		require.NoError(t, err)
+		return page.Cards
+	}
+
+	w := call(http.MethodPatch, report.ID, `{"pinned": true, "read": true, "expires_at": "2030-01-01T00:00:00Z"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var card store.Card
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &card))
+	assert.True(t, card.Pinned)
+	assert.NotNil(t, card.ReadAt)
+	require.NotNil(t, card.ExpiresAt)
+	assert.Equal(t, 2030, card.ExpiresAt.Year())
+	assert.Equal(t, report.ID, feed()[0].ID, "pinned first")
+
+	w = call(http.MethodPatch, report.ID, `{"expires_at": null}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Nil(t, feed()[0].ExpiresAt)
+
+	w = call(http.MethodPatch, alert.ID, `{"dismissed": true}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Len(t, feed(), 1, "dismissed cards leave the feed")
+
+	for _, body := range []string{`{}`, `{"expires_at": "soon"}`, `not json`} {
+		w = call(http.MethodPatch, report.ID, body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+	w = call(http.MethodPatch, "missing", `{"read": true}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = call(http.MethodDelete, report.ID, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, feed())
+	w = call(http.MethodDelete, report.ID, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"guardian-gateway/pkg/config"
+	"guardian-gateway/pkg/geocode"
+	"guardian-gateway/pkg/logging"
+	"guardian-gateway/pkg/store"
+	"guardian-gateway/pkg/trip"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// geocoder resolves destinations; offline (the bundled gazetteer) until main
+// configures a Nominatim service
+var geocoder geocode.Geocoder = geocode.NewResolver(nil)
+
+// initGeocoding puts the configured Nominatim service in front of the gazetteer
+func initGeocoding(cfg config.GeocodingConfig) {
+	var remote geocode.Geocoder
+	if cfg.NominatimURL != "" {
+		remote = &geocode.Nominatim{
+			BaseURL:     cfg.NominatimURL,
+			UserAgent:   cfg.UserAgent,
+			Email:       cfg.Email,
+			Client:      &http.Client{Timeout: cfg.Timeout},
+			MinInterval: cfg.MinInterval,
+		}
+	}
+	resolver := geocode.NewResolver(remote)
+	resolver.TTL = cfg.CacheTTL
+	geocoder = resolver
+	slog.Info("geocoding configured", "nominatim", cfg.NominatimURL != "")
+}
+
+// geocodePlace resolves a destination, or returns nil when it cannot be
+func geocodePlace(ctx context.Context, destination string) *geocode.Place {
+	if strings.TrimSpace(destination) == "" {
+		return nil
+	}
+	ctx, span := otel.Tracer(tracerScope).Start(ctx, "geocode", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	place, err := geocoder.Geocode(ctx, destination)
+	if errors.Is(err, geocode.ErrNotFound) {
+		span.SetAttributes(attribute.String("geocode.outcome", "not_found"))
+		slog.DebugContext(ctx, "destination not found", "destination", destination)
+		return nil
+	}
+	if err != nil {
+		span.SetAttributes(attribute.String("geocode.outcome", "error"))
+		slog.WarnContext(ctx, "geocoding failed", "destination", destination, logging.Err(err))
+		return nil
+	}
+	span.SetAttributes(attribute.String("geocode.outcome", "ok"), attribute.String("geocode.source", place.Source))
+	return place
+}
+
+// locateDestination fills the destination's missing coordinates, country and
+// timezone from its name
+func locateDestination(ctx context.Context, d *trip.Destination) {
+	if d.Geo != nil && d.Country != "" && d.Timezone != "" {
+		return
+	}
+	place := geocodePlace(ctx, d.Name)
+	if place == nil {
+		return
+	}
+	if d.Geo == nil {
+		d.Geo = &trip.GeoPoint{Lat: place.Lat, Lon: place.Lon}
+	}
+	if d.Country == "" {
+		d.Country = place.Country
+	}
+	if d.Timezone == "" {
+		d.Timezone = place.Timezone
+	}
+}
+
+// destinationCountry is the country a destination is in, the subject of its
+// cards' images. A destination that cannot be placed is used as given.
+func destinationCountry(ctx context.Context, destination string) string {
+	if place := geocodePlace(ctx, destination); place != nil && place.Country != "" {
+		return place.Country
+	}
+	if name, _, ok := geocode.Bundled().Country(destination); ok {
+		return name
+	}
+	return strings.TrimSpace(destination)
+}
+
+// mapCard is the map_coord card of a trip's destination, or nil when it has
+// no coordinates. There is one per trip, moved when the destination changes.
+func mapCard(ctx context.Context, t *trip.Trip) *store.Card {
+	d := t.Destination
+	if d.Geo == nil {
+		return nil
+	}
+	data := map[string]interface{}{
+		"title":    "Destination",
+		"label":    d.Name,
+		"summary":  fmt.Sprintf("%s (%.4f, %.4f)", d.Name, d.Geo.Lat, d.Geo.Lon),
+		"lat":      d.Geo.Lat,
+		"lng":      d.Geo.Lon,
+		"category": "Map",
+	}
+	if d.Country != "" {
+		data["country"] = d.Country
+	}
+	if d.Timezone != "" {
+		data["timezone"] = d.Timezone
+	}
+	return &store.Card{
+		RunID:        logging.RunID(ctx),
+		TripID:       t.ID,
+		AgentVersion: agentVersion(ctx),
+		StickyKey:    "map:" + t.ID,
+		CardType:     "map_coord",
+		Priority:     "low",
+		SourceNode:   "Destination",
+		Data:         data,
+	}
+}
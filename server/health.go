@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"guardian-gateway/pkg/config"
+	"guardian-gateway/pkg/fastgraph/runtime"
+	"guardian-gateway/pkg/health"
+	"guardian-gateway/pkg/llm"
+	"guardian-gateway/pkg/store"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readiness runs the /readyz dependency checks; built by initHealthChecks
+var readiness *health.Checker
+
+// schedulerState tracks the proactive scheduler for the readiness probe
+type schedulerState struct {
+	mu       sync.Mutex
+	agent    string
+	interval time.Duration
+	started  time.Time
+	lastTick time.Time
+	lastErr  error
+	failed   error // the scheduler could not start
+}
+
+var scheduler schedulerState
+
+func (s *schedulerState) start(agentPath string, interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.agent, s.interval, s.started, s.failed = filepath.Base(agentPath), interval, time.Now(), nil
+}
+
+func (s *schedulerState) fail(agentPath string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.agent, s.failed = filepath.Base(agentPath), err
+}
+
+func (s *schedulerState) tick() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastTick = time.Now()
+}
+
+func (s *schedulerState) finish(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastErr = err
+}
+
+// check fails when the scheduler could not start or has not ticked for two
+// intervals (a run is hanging); a failed last run is only reported
+func (s *schedulerState) check(enabled bool) (health.Detail, error) {
+	if !enabled {
+		return nil, health.Skip("scheduler disabled")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failed != nil {
+		return health.Detail{"agent": s.agent}, s.failed
+	}
+	if s.started.IsZero() {
+		return nil, health.Skip("no proactive agent scheduled")
+	}
+
+	detail := health.Detail{"agent": s.agent, "interval": s.interval.String(), "started_at": s.started.UTC()}
+	last := s.started
+	if !s.lastTick.IsZero() {
+		last = s.lastTick
+		detail["last_tick_at"] = s.lastTick.UTC()
+		detail["last_outcome"] = "ok"
+		if s.lastErr != nil {
+			detail["last_outcome"] = "error"
+			detail["last_error"] = s.lastErr.Error()
+		}
+	}
+	if since := time.Since(last); since > 2*s.interval {
+		return detail, fmt.Errorf("no tick for %s", since.Round(time.Second))
+	}
+	return detail, nil
+}
+
+// initHealthChecks builds the readiness checks. The database and fastgraph
+// binary are critical; LiteLLM reachability and the scheduler only degrade.
+func initHealthChecks(cfg *config.Config) {
+	checks := []health.Check{
+		{Name: "database", Critical: true, Timeout: cfg.Health.CheckTimeout, Func: checkDatabase},
+		{
+			Name:     "fastgraph",
+			Critical: true,
+			Timeout:  cfg.Health.CheckTimeout,
+			// The binary doesn't change under a running server; avoid a fork per probe
+			CacheTTL: time.Minute,
+			Func: func(ctx context.Context) (health.Detail, error) {
+				return checkFastgraph(ctx, cfg.Agent.Path)
+			},
+		},
+		{Name: "scheduler", Func: func(context.Context) (health.Detail, error) {
+			return scheduler.check(cfg.Features.Scheduler)
+		}},
+	}
+	if cfg.Health.CheckLiteLLM {
+		checks = append(checks, health.Check{
+			Name:     "litellm",
+			Timeout:  cfg.Health.CheckTimeout,
+			CacheTTL: cfg.Health.LiteLLMCacheTTL,
+			Func:     checkLiteLLM,
+		})
+	}
+	readiness = health.NewChecker(checks...)
+}
+
+func checkDatabase(ctx context.Context) (health.Detail, error) {
+	s := feedStore
+	if s == nil {
+		return nil, errors.New("not connected (retrying in background)")
+	}
+	if err := s.Ping(ctx); err != nil {
+		return nil, err
+	}
+	detail := health.Detail{"driver": appConfig.Database.Driver}
+	if db := storeDB(s); db != nil {
+		stats := db.Stats()
+		detail["open_connections"], detail["in_use"] = stats.OpenConnections, stats.InUse
+	}
+	return detail, nil
+}
+
+// storeDB returns the connection pool behind SQL-backed stores (nil for the memory store)
+func storeDB(s store.FeedStore) *sql.DB {
+	switch s := s.(type) {
+	case *store.PostgresStore:
+		return s.DB
+	case *store.SQLiteStore:
+		return s.DB
+	}
+	return nil
+}
+
+// checkFastgraph asks the binary for its version; older binaries without
+// --version are accepted if they can inspect the configured agent
+func checkFastgraph(ctx context.Context, agentPath string) (health.Detail, error) {
+	if engine == nil {
+		return nil, errors.New("engine not initialised")
+	}
+	detail := health.Detail{"path": engine.BinPath}
+	version, err := engine.Version(ctx)
+	if err == nil {
+		detail["version"] = version
+		return detail, nil
+	}
+	if runtime.IsBinaryMissing(err) {
+		return detail, errors.New("binary not found")
+	}
+	meta, inspectErr := engine.Inspect(agentPath)
+	if inspectErr != nil {
+		return detail, fmt.Errorf("%v; %v", err, inspectErr)
+	}
+	detail["version"] = "unknown"
+	detail["agent"] = meta.Name
+	return detail, nil
+}
+
+func checkLiteLLM(ctx context.Context) (health.Detail, error) {
+	err := llm.Ping(ctx)
+	if errors.Is(err, llm.ErrNotConfigured) {
+		return nil, health.Skip(err.Error())
+	}
+	return nil, err
+}
+
+// LivezHandler godoc
+// @Summary      Liveness probe
+// @Description  Reports that the process is up; performs no dependency checks
+// @Tags         system
+// @Produce      json
+// @Success      200  {object}  map[string]string
+// @Router       /livez [get]
+func LivezHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// ReadyzHandler godoc
+// @Summary      Readiness probe
+// @Description  Checks the database, fastgraph binary, LiteLLM proxy and scheduler. Returns 503 when a critical check fails; non-critical failures report "degraded" with 200.
+// @Tags         system
+// @Produce      json
+// @Success      200  {object}  health.Report
+// @Failure      503  {object}  health.Report
+// @Router       /readyz [get]
+func ReadyzHandler(c *gin.Context) {
+	if readiness == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": health.StatusUnavailable, "error": "health checks not initialised"})
+		return
+	}
+	if inflight.Draining() {
+		// Take the task out of the load balancer while streams drain
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": health.StatusUnavailable, "error": "shutting down"})
+		return
+	}
+	report := readiness.Run(c.Request.Context())
+	status := http.StatusOK
+	if !report.Ready() {
+		status = http.StatusServiceUnavailable
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(status, report)
+}
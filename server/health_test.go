@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"guardian-gateway/pkg/config"
+	"guardian-gateway/pkg/fastgraph/runtime"
+	"guardian-gateway/pkg/health"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadyzHandler_NoDatabase(t *testing.T) {
+	origStore, origEngine, origReadiness := feedStore, engine, readiness
+	defer func() { feedStore, engine, readiness = origStore, origEngine, origReadiness }()
+	feedStore = nil
+	engine = &runtime.Engine{BinPath: filepath.Join(t.TempDir(), "fastgraph")}
+
+	cfg := config.Default()
+	cfg.Features.Scheduler = false
+	cfg.Health.CheckLiteLLM = false
+	initHealthChecks(cfg)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/livez", LivezHandler)
+	r.GET("/readyz", ReadyzHandler)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/livez", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	var report health.Report
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Equal(t, health.StatusUnavailable, report.Status)
+	assert.Equal(t, health.StatusFail, report.Checks["database"].Status)
+	assert.Contains(t, report.Checks["database"].Error, "not connected")
+	assert.Equal(t, health.StatusFail, report.Checks["fastgraph"].Status)
+	assert.Equal(t, "binary not found", report.Checks["fastgraph"].Error)
+	assert.Equal(t, health.StatusSkipped, report.Checks["scheduler"].Status)
+	assert.NotContains(t, report.Checks, "litellm")
+}
+
+func TestSchedulerStateCheck(t *testing.T) {
+	var s schedulerState
+
+	_, err := s.check(true)
+	assert.EqualError(t, err, "no proactive agent scheduled")
+
+	s.fail("./agents/a.m", errors.New("invalid schedule interval \"x\""))
+	_, err = s.check(true)
+	assert.Error(t, err)
+
+	s.start("./agents/a.m", time.Minute)
+	detail, err := s.check(true)
+	require.NoError(t, err)
+	assert.Equal(t, "a.m", detail["agent"])
+
+	s.tick()
+	s.finish(errors.New("agent crashed"))
+	detail, err = s.check(true)
+	require.NoError(t, err, "a failed run alone does not fail the check")
+	assert.Equal(t, "error", detail["last_outcome"])
+
+	s.lastTick = time.Now().Add(-3 * time.Minute)
+	_, err = s.check(true)
+	assert.ErrorContains(t, err, "no tick for")
+
+	_, err = s.check(false)
+	assert.EqualError(t, err, "scheduler disabled")
+}
@@ -6,6 +6,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"guardian-gateway/pkg/auth"
 	"guardian-gateway/pkg/fastgraph/runtime"
 	"guardian-gateway/pkg/session"
 
@@ -28,12 +29,13 @@ func TestIntegration_RouterWiring(t *testing.T) {
 	// Setup Router (Real Setup)
 	gin.SetMode(gin.TestMode)
 	r := gin.New()
-	r.POST("/api/chat/stream", ChatStreamHandler)
+	r.POST("/api/chat/stream", (&auth.Authenticator{AllowAnonymous: true}).Anonymous(), ChatStreamHandler)
 
 	// Create Request
 	reqBody := []byte(`{"input": "Integration Test", "agent_path": "mock.m"}`)
 	req, _ := http.NewRequest("POST", "/api/chat/stream", bytes.NewBuffer(reqBody))
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Device-ID", "integration-device")
 
 	w := httptest.NewRecorder()
 	r.ServeHTTP(w, req)
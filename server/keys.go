@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"guardian-gateway/pkg/logging"
+	"guardian-gateway/pkg/store"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newKeyManager returns a key manager for the current store, or nil if the DB
+// or the LiteLLM admin credentials are not available yet
+func newKeyManager() *store.KeyManager {
+	proxyURL := appConfig.LiteLLM.ProxyURL
+	masterKey := appConfig.LiteLLM.MasterKey
+	if feedStore == nil || proxyURL == "" || masterKey == "" {
+		return nil
+	}
+	return store.NewKeyManager(feedStore, proxyURL, masterKey)
+}
+
+// keyProvisioner serializes first-time key generation; set by initStore when
+// the LiteLLM admin credentials are configured
+var keyProvisioner *store.KeyProvisioner
+
+// newKeyProvisioner returns a provisioner for the current store, or nil if key
+// management is not available
+func newKeyProvisioner() *store.KeyProvisioner {
+	km := newKeyManager()
+	if km == nil {
+		return nil
+	}
+	return store.NewKeyProvisioner(feedStore, km)
+}
+
+// userLiteLLMKey returns the user's LiteLLM key, provisioning one on first use.
+// Without admin credentials only existing keys are returned.
+func userLiteLLMKey(ctx context.Context, userID string) (string, error) {
+	if userID == "" || feedStore == nil {
+		return "", nil
+	}
+	if keyProvisioner == nil {
+		return feedStore.GetUserLiteLLMKey(ctx, userID)
+	}
+	return keyProvisioner.Provision(ctx, userID)
+}
+
+// startKeyReconciliation periodically marks keys deleted on the proxy side as missing
+func startKeyReconciliation(ctx context.Context, interval time.Duration) {
+	slog.Info("LiteLLM key reconciliation started", "interval", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		km := newKeyManager()
+		if km == nil {
+			continue
+		}
+		tickCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+		report, err := km.Reconcile(tickCtx)
+		cancel()
+		if err != nil {
+			slog.Error("LiteLLM key reconciliation failed", logging.Err(err))
+			continue
+		}
+		slog.Info("reconciled LiteLLM keys", "checked", report.Checked, "missing", len(report.Missing), "errors", report.Errors)
+	}
+}
+
+// keyManagerOrAbort writes a 503 and returns nil when key management is unavailable
+func keyManagerOrAbort(c *gin.Context) *store.KeyManager {
+	km := newKeyManager()
+	if km == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Key management not configured"})
+	}
+	return km
+}
+
+// writeKeyError maps key manager errors to HTTP responses
+func writeKeyError(c *gin.Context, userID string, err error) {
+	switch {
+	case errors.Is(err, store.ErrNoUserKey):
+		c.JSON(http.StatusNotFound, gin.H{"error": "No active key for user"})
+	case errors.Is(err, store.ErrKeyNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Key no longer exists on the proxy"})
+	default:
+		slog.ErrorContext(c.Request.Context(), "key operation failed", "user_id", userID, logging.Err(err))
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Key operation failed"})
+	}
+}
+
+// AdminGetKeyHandler godoc
+// @Summary      Get User Key (Admin)
+// @Description  Get a user's LiteLLM key record and live budget
+// @Tags         admin
+// @Produce      json
+// @Param        user_id  path      string  true  "User ID"
+// @Success      200      {object}  map[string]interface{}
+// @Failure      404      {object}  map[string]string
+// @Router       /api/admin/keys/{user_id} [get]
+func AdminGetKeyHandler(c *gin.Context) {
+	km := keyManagerOrAbort(c)
+	if km == nil {
+		return
+	}
+	userID := c.Param("user_id")
+
+	rec, err := km.Store.GetUserLiteLLMKeyRecord(c.Request.Context(), userID)
+	if err != nil {
+		writeKeyError(c, userID, err)
+		return
+	}
+	if rec == nil {
+		writeKeyError(c, userID, store.ErrNoUserKey)
+		return
+	}
+
+	resp := gin.H{"key": rec}
+	if rec.Status == store.KeyStatusActive {
+		if budget, err := km.Budget(c.Request.Context(), userID); err == nil {
+			resp["budget"] = budget
+		} else {
+			resp["budget_error"] = err.Error()
+		}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// AdminRotateKeyHandler godoc
+// @Summary      Rotate User Key (Admin)
+// @Description  Regenerate a user's LiteLLM key on the proxy. A revoked key is replaced by a new one, lifting the revocation.
+// @Tags         admin
+// @Produce      json
+// @Param        user_id  path      string  true  "User ID"
+// @Success      200      {object}  store.UserLiteLLMKey
+// @Failure      404      {object}  map[string]string
+// @Router       /api/admin/keys/{user_id}/rotate [post]
+func AdminRotateKeyHandler(c *gin.Context) {
+	km := keyManagerOrAbort(c)
+	if km == nil {
+		return
+	}
+	userID := c.Param("user_id")
+
+	rec, err := km.Rotate(c.Request.Context(), userID)
+	if err != nil {
+		writeKeyError(c, userID, err)
+		return
+	}
+	slog.InfoContext(c.Request.Context(), "rotated LiteLLM key", "user_id", userID)
+	c.JSON(http.StatusOK, rec)
+}
+
+// AdminRevokeKeyHandler godoc
+// @Summary      Revoke User Key (Admin)
+// @Description  Delete a user's LiteLLM key on the proxy and mark it revoked
+// @Tags         admin
+// @Produce      json
+// @Param        user_id  path      string  true  "User ID"
+// @Success      200      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Router       /api/admin/keys/{user_id} [delete]
+func AdminRevokeKeyHandler(c *gin.Context) {
+	km := keyManagerOrAbort(c)
+	if km == nil {
+		return
+	}
+	userID := c.Param("user_id")
+
+	if err := km.Revoke(c.Request.Context(), userID); err != nil {
+		writeKeyError(c, userID, err)
+		return
+	}
+	slog.InfoContext(c.Request.Context(), "revoked LiteLLM key", "user_id", userID)
+	c.JSON(http.StatusOK, gin.H{"status": "revoked"})
+}
+
+// AdminUpdateKeyBudgetHandler godoc
+// @Summary      Update User Budget (Admin)
+// @Description  Change the max budget of a user's LiteLLM key
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        user_id  path      string  true  "User ID"
+// @Param        request  body      object  true  "{\"max_budget\": 20}"
+// @Success      200      {object}  map[string]interface{}
+// @Failure      400      {object}  map[string]string
+// @Router       /api/admin/keys/{user_id}/budget [patch]
+func AdminUpdateKeyBudgetHandler(c *gin.Context) {
+	var req struct {
+		MaxBudget *float64 `json:"max_budget"`
+	}
+	if err := c.BindJSON(&req); err != nil || req.MaxBudget == nil || *req.MaxBudget < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "max_budget must be a non-negative number"})
+		return
+	}
+	km := keyManagerOrAbort(c)
+	if km == nil {
+		return
+	}
+	userID := c.Param("user_id")
+
+	if err := km.UpdateBudget(c.Request.Context(), userID, *req.MaxBudget); err != nil {
+		writeKeyError(c, userID, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "updated", "max_budget": *req.MaxBudget})
+}
+
+// AdminReconcileKeysHandler godoc
+// @Summary      Reconcile Keys (Admin)
+// @Description  Check all active keys against the proxy and mark deleted ones as missing
+// @Tags         admin
+// @Produce      json
+// @Success      200  {object}  store.ReconcileReport
+// @Router       /api/admin/keys/reconcile [post]
+func AdminReconcileKeysHandler(c *gin.Context) {
+	km := keyManagerOrAbort(c)
+	if km == nil {
+		return
+	}
+	report, err := km.Reconcile(c.Request.Context())
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "LiteLLM key reconciliation failed", logging.Err(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Reconciliation failed"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
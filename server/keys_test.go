@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"guardian-gateway/pkg/auth"
+	"guardian-gateway/pkg/llm"
+	"guardian-gateway/pkg/session"
+	"guardian-gateway/pkg/store"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminKeyHandlers_NotConfigured(t *testing.T) {
+	globalFeedStore := feedStore
+	defer func() { feedStore = globalFeedStore }()
+	feedStore = nil
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/api/admin/keys/:user_id", AdminGetKeyHandler)
+	r.POST("/api/admin/keys/:user_id/rotate", AdminRotateKeyHandler)
+	r.POST("/api/admin/keys/reconcile", AdminReconcileKeysHandler)
+
+	for _, tc := range []struct{ method, path string }{
+		{"GET", "/api/admin/keys/u1"},
+		{"POST", "/api/admin/keys/u1/rotate"},
+		{"POST", "/api/admin/keys/reconcile"},
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(tc.method, tc.path, nil)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code, tc.path)
+	}
+}
+
+func TestAdminUpdateKeyBudgetHandler_Validation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, body := range []string{`{}`, `{"max_budget": -1}`, `not json`} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("PATCH", "/api/admin/keys/u1/budget", bytes.NewBufferString(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+
+		AdminUpdateKeyBudgetHandler(c)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+}
+
+func TestChatStreamHandler_RevokedKeyIsNotReprovisioned(t *testing.T) {
+	var generated atomic.Int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/key/generate":
+			fmt.Fprintf(w, `{"key": "sk-%d"}`, generated.Add(1))
+		default:
+			_, _ = w.Write([]byte(`{"keys": []}`))
+		}
+	}))
+	defer proxy.Close()
+
+	origStore, origProvisioner := feedStore, keyProvisioner
+	defer func() { feedStore, keyProvisioner = origStore, origProvisioner }()
+	s := store.NewMemoryStore()
+	feedStore = s
+	km := store.NewKeyManager(s, proxy.URL, "master")
+	keyProvisioner = store.NewKeyProvisioner(s, km)
+	session.Init()
+
+	originalGenerate := GenerateContentFunc
+	defer func() { GenerateContentFunc = originalGenerate }()
+	var llmCalls int
+	GenerateContentFunc = func(ctx context.Context, history []map[string]interface{}, systemPrompt string, userApiKey ...string) (string, *llm.Usage, error) {
+		llmCalls++
+		return "ACTION: ASK_QUESTION Where to?", nil, nil
+	}
+
+	gin.SetMode(gin.TestMode)
+	chat := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("POST", "/api/chat/stream", bytes.NewBufferString(`{"input": "Hi", "agent_path": "mock.m"}`))
+		c.Request.Header.Set("Content-Type", "application/json")
+		auth.SetPrincipal(c, &auth.Principal{Kind: auth.KindUser, UserID: "u1"})
+		ChatStreamHandler(c)
+		return w
+	}
+
+	require.Equal(t, http.StatusOK, chat().Code)
+	require.Equal(t, int32(1), generated.Load())
+	require.NoError(t, km.Revoke(context.Background(), "u1"))
+
+	w := chat()
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, int32(1), generated.Load(), "no new key after a revoke")
+	assert.Equal(t, 1, llmCalls)
+}
@@ -3,16 +3,27 @@ package main
 import (
 	"context" // Added context
 	"encoding/json"
+	"errors"
 	"fmt"
+	"guardian-gateway/pkg/config"
 	"guardian-gateway/pkg/fastgraph/runtime"
 	"guardian-gateway/pkg/llm"
+	"guardian-gateway/pkg/logging"
+	"guardian-gateway/pkg/metrics"
 	"guardian-gateway/pkg/session"
 	"guardian-gateway/pkg/store" // New import
+	"guardian-gateway/pkg/tracing"
+	"guardian-gateway/pkg/trip"
+	"guardian-gateway/pkg/variables"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -23,6 +34,10 @@ import (
 
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 
 	_ "guardian-gateway/docs" // Import generated docs
 )
@@ -30,15 +45,22 @@ import (
 // FeedItem represents a card in the Insight Stream
 type FeedItem struct {
 	ID         string                 `json:"id"`
-	CardType   string                 `json:"card_type"`   // weather | safe_alert | cultural_tip | map_coord | article
+	CardType   string                 `json:"card_type"`   // weather | safe_alert | cultural_tip | map_coord | article | change_alert
 	Priority   string                 `json:"priority"`    // high | medium | low
 	Timestamp  string                 `json:"timestamp"`   // ISO 8601 timestamp
 	SourceNode string                 `json:"source_node"` // ID of the agent node producing this item
 	Data       map[string]interface{} `json:"data"`
 }
 
+// tracerScope names the spans started by the gateway's handlers
+const tracerScope = "guardian-gateway"
+
 var engine *runtime.Engine
-var feedStore *store.PostgresStore // New Global Store
+var feedStore store.FeedStore // nil until the database connects
+
+// appConfig is the effective configuration, loaded and validated in main().
+// Tests run against the defaults.
+var appConfig = config.Default()
 
 // Atomic counter for unique IDs
 // var eventCounter int64 (Removed: Unused)
@@ -57,158 +79,392 @@ var feedStore *store.PostgresStore // New Global Store
 func main() {
 	// Load .env file if it exists
 	// Load .env file and OVERWRITE system env if present
-	if err := godotenv.Overload(); err != nil {
-		fmt.Printf("INFO: No .env file loaded. Error: %v\n", err)
+	envErr := godotenv.Overload()
+
+	// Load, validate and print the effective configuration (CONFIG_FILE = optional YAML)
+	cfg, err := config.Load(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		// The logger isn't configured yet; the default handler still writes to stderr
+		fatal("invalid configuration", err)
+	}
+	appConfig = cfg
+	if err := logging.Setup(logging.Options{Level: cfg.Log.Level, Format: cfg.Log.Format}); err != nil {
+		fatal("invalid log configuration", err)
+	}
+	if envErr != nil {
+		slog.Info("no .env file loaded", logging.Err(envErr))
 	} else {
-		fmt.Println("INFO: Loaded and overloaded config from .env")
+		slog.Info("loaded .env file")
+	}
+	slog.Info("effective configuration", "config", cfg.Redacted())
+
+	// guardian-gateway migrate [up | down [N] | status]
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(context.Background(), cfg, os.Args[2:], os.Stdout); err != nil {
+			fatal("migration failed", err)
+		}
+		return
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options{
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		ServiceName: cfg.Tracing.ServiceName,
+		SampleRatio: cfg.Tracing.SampleRatio,
+	})
+	if err != nil {
+		fatal("invalid tracing configuration", err)
 	}
+	llm.Configure(llm.Settings{
+		ProxyURL: cfg.LiteLLM.ProxyURL,
+		APIKey:   cfg.LiteLLM.APIKey,
+		Model:    cfg.LiteLLM.Model,
+		Timeout:  cfg.LiteLLM.Timeout,
+	})
+
+	initGeocoding(cfg.Geocoding)
 
 	// Init Engine
 	engine = runtime.New()
+	engine.StopGrace = cfg.Agent.StopGrace
+	engine.GoogleMapsKey = cfg.Agent.GoogleMapsKey
 
-	// Init Database Store
-	connStr := os.Getenv("DATABASE_URL")
-	if connStr == "" {
-		fmt.Println("FATAL: DATABASE_URL environment variable is required")
-		os.Exit(1)
+	// Load the keyring used to encrypt LiteLLM keys at rest
+	keyring, err := store.LoadKeyring(cfg.LiteLLM.Keyring, cfg.LiteLLM.KeyringFile)
+	if err != nil {
+		fatal("invalid LiteLLM keyring", err)
 	}
-	var err error
+	if keyring == nil && cfg.Database.Driver != "memory" {
+		// Config validation only lets this through with LITELLM_ALLOW_PLAINTEXT_KEYS
+		slog.Warn("LITELLM_KEYRING not set; LiteLLM keys will be stored unencrypted")
+	}
+
+	// Init Database Store
 	// Attempt connection with timeout to avoid blocking startup indefinitely
 	// We'll treat the store as optional for startup to allow debugging logs to flush
 	// Try initial connection
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	pgStore, err := store.NewPostgresStore(ctx, connStr)
+	sticky := store.StickyPolicy{Window: cfg.Feed.StickyWindow}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Database.ConnectTimeout)
+	s, err := openStore(ctx, cfg.Database, sticky, keyring, cfg.LiteLLM.AllowPlaintextKeys)
 	cancel()
 
 	if err != nil {
-		fmt.Printf("WARNING: Failed to connect to DB initially: %v. Retrying in background...\n", err)
+		slog.Warn("failed to connect to database, retrying in background", "driver", cfg.Database.Driver, logging.Err(err))
 		// Retry in background
 		go func() {
 			for {
-				time.Sleep(10 * time.Second)
-				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-				s, err := store.NewPostgresStore(ctx, connStr)
+				select {
+				case <-appCtx.Done():
+					return
+				case <-time.After(10 * time.Second):
+				}
+				ctx, cancel := context.WithTimeout(appCtx, 10*time.Second)
+				s, err := openStore(ctx, cfg.Database, sticky, keyring, cfg.LiteLLM.AllowPlaintextKeys)
 				cancel()
 				if err == nil {
-					fmt.Println("INFO: Connected to Postgres Store (Background Recovery)")
-					feedStore = s
+					slog.Info("connected to store", "driver", cfg.Database.Driver, "recovered", true)
+					initStore(s, keyring)
 					return
 				}
-				fmt.Printf("WARNING: Background DB retry failed: %v\n", err)
+				slog.Warn("background database retry failed", logging.Err(err))
 			}
 		}()
 	} else {
-		fmt.Println("INFO: Connected to Postgres Store")
-		feedStore = pgStore
+		slog.Info("connected to store", "driver", cfg.Database.Driver)
+		initStore(s, keyring)
 	}
 
 	// Init Session Manager
 	session.Init()
 
+	if err := initRateLimiting(cfg.RateLimit); err != nil {
+		fatal("invalid rate limit config", err)
+	}
+
+	// Periodically detect LiteLLM keys deleted on the proxy side
+	if cfg.LiteLLM.MasterKey != "" && cfg.LiteLLM.KeyReconcileInterval > 0 {
+		go startKeyReconciliation(appCtx, cfg.LiteLLM.KeyReconcileInterval)
+	}
+
+	// Periodically purge expired and dismissed cards
+	if cfg.Feed.RetentionInterval > 0 {
+		go startFeedRetention(appCtx, cfg.Feed.RetentionInterval, cfg.Feed.DismissedRetention)
+	}
+
+	initHealthChecks(cfg)
+
 	// Auto-load pre-deployed agent
-	agentPath := "./agents/trip-guardian/trip_guardian_v3.m"
+	agentPath := cfg.Agent.Path
 	if _, err := os.Stat(agentPath); err == nil {
-		fmt.Printf("INFO: Loading pre-deployed agent: %s\n", agentPath)
+		slog.Info("loading pre-deployed agent", "agent", agentPath)
 		meta, err := engine.Inspect(agentPath)
 		if err != nil {
-			fmt.Printf("WARNING: Failed to inspect agent: %v\n", err)
+			slog.Warn("failed to inspect agent", "agent", agentPath, logging.Err(err))
 		} else {
-			fmt.Printf("INFO: Agent loaded: %s (Capabilities: %v)\n", meta.Name, meta.Capabilities)
+			slog.Info("agent loaded", "name", meta.Name, "capabilities", meta.Capabilities)
 			// Start scheduled execution if configured
-			if meta.Schedule != nil && meta.Schedule.Mode == "proactive" {
-				go startScheduledExecution(agentPath, meta.Schedule)
+			if meta.Schedule != nil && meta.Schedule.Mode == "proactive" && cfg.Features.Scheduler {
+				go startScheduledExecution(appCtx, agentPath, meta.Schedule)
 			}
 		}
 	} else {
-		fmt.Printf("WARNING: Pre-deployed agent not found at %s\n", agentPath)
+		slog.Warn("pre-deployed agent not found", "agent", agentPath)
 	}
 
-	r := gin.Default()
+	r := gin.New()
+	r.Use(tracing.Middleware(), logging.Middleware(), metrics.Middleware(), gin.Recovery())
+
+	// Only trust X-Forwarded-For from known proxies, otherwise per-IP limits are spoofable
+	if err := trustProxies(r, cfg.Server.TrustedProxies); err != nil {
+		fatal("invalid TRUSTED_PROXIES", err)
+	}
 
 	// CORS Middleware
-	// CORS Middleware
-	config := cors.DefaultConfig()
-	// config.AllowAllOrigins = true // Cannot use * with AllowCredentials
-	config.AllowOrigins = []string{
-		"http://localhost:3000",
-		"http://127.0.0.1:3000",
-		"https://market.niyogen.com",
-		"https://travel.niyogen.com",
-		"https://guardian-client-x6n5sofwia-uc.a.run.app", // Cloud Run Frontend
-	}
-	config.AllowCredentials = true
-	config.AddAllowHeaders("Authorization", "X-Device-ID") // Added X-Device-ID
-	r.Use(cors.New(config))
-
-	// Health Check
+	corsConfig := cors.DefaultConfig()
+	// Cannot use * with AllowCredentials (rejected by config validation)
+	corsConfig.AllowOrigins = cfg.CORS.AllowedOrigins
+	corsConfig.AllowCredentials = cfg.CORS.AllowCredentials
+	corsConfig.AddAllowHeaders("Authorization", "X-Device-ID", "If-None-Match") // Added X-Device-ID
+	corsConfig.AddExposeHeaders("Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy",
+		"ETag", "X-Next-Cursor")
+	r.Use(cors.New(corsConfig))
+
+	// Health Check (legacy), liveness and readiness probes
 	r.GET("/health", HealthHandler)
+	r.GET("/livez", LivezHandler)
+	r.GET("/readyz", ReadyzHandler)
+
+	// Prometheus metrics (bearer token optional)
+	if cfg.Features.Metrics {
+		metrics.SetActiveSessionsFunc(activeSessionCount)
+		r.GET("/metrics", metrics.GinHandler(cfg.Admin.MetricsToken))
+	}
+
+	// Verified identity (JWT / session cookie); device IDs only where anonymous use is allowed
+	authn := newAuthenticator(cfg.Auth)
+	anonymous := authn.Anonymous()
+	// Carry a device's anonymous cards and session over on its first authenticated request
+	linkDevice := newIdentityService().Middleware()
 
 	// GET /api/feed
-	r.GET("/api/feed", GetFeedHandler)
+	r.GET("/api/feed", anonymous, linkDevice, rateLimiter.Middleware(feedPolicy), GetFeedHandler)
+
+	// GET /api/usage
+	r.GET("/api/usage", anonymous, linkDevice, UsageHandler)
+
+	// Admin API (X-Admin-Key)
+	admin := r.Group("/api/admin", requireAdmin())
+	admin.GET("/usage", AdminUsageHandler)
+	admin.POST("/keys/reconcile", AdminReconcileKeysHandler)
+	admin.GET("/keys/:user_id", AdminGetKeyHandler)
+	admin.DELETE("/keys/:user_id", AdminRevokeKeyHandler)
+	admin.POST("/keys/:user_id/rotate", AdminRotateKeyHandler)
+	admin.PATCH("/keys/:user_id/budget", AdminUpdateKeyBudgetHandler)
 
 	// DELETE /api/feed
-	r.DELETE("/api/feed", ClearFeedHandler)
+	r.DELETE("/api/feed", anonymous, linkDevice, rateLimiter.Middleware(feedPolicy), ClearFeedHandler)
+
+	// PATCH/DELETE /api/feed/{id}: per-card read, dismissed, pinned and expiry state
+	r.PATCH("/api/feed/:id", anonymous, linkDevice, rateLimiter.Middleware(feedPolicy), UpdateCardHandler)
+	r.DELETE("/api/feed/:id", anonymous, linkDevice, rateLimiter.Middleware(feedPolicy), DeleteCardHandler)
+	r.GET("/api/feed/:id/history", anonymous, linkDevice, rateLimiter.Middleware(feedPolicy), CardHistoryHandler)
+
+	// /api/trips: typed trips that sessions, runs and cards reference
+	trips := r.Group("/api/trips", anonymous, linkDevice, rateLimiter.Middleware(feedPolicy))
+	trips.POST("", CreateTripHandler)
+	trips.GET("", ListTripsHandler)
+	trips.GET("/:id", GetTripHandler)
+	trips.PUT("/:id", UpdateTripHandler)
+	trips.DELETE("/:id", DeleteTripHandler)
 
 	// POST /api/agent/upload - DISABLED (agents are pre-deployed)
 	// r.POST("/api/agent/upload", UploadAgentHandler)
 
 	// POST /api/chat/stream
-	r.POST("/api/chat/stream", ChatStreamHandler)
+	r.POST("/api/chat/stream", anonymous, linkDevice, rateLimiter.Middleware(chatPolicy), ChatStreamHandler)
 
-	// Swagger Redirects
-	r.GET("/docs", func(c *gin.Context) {
-		c.Redirect(http.StatusMovedPermanently, "/swagger/index.html")
-	})
-	r.GET("/swagger", func(c *gin.Context) {
-		c.Redirect(http.StatusMovedPermanently, "/swagger/index.html")
-	})
-	// Swagger Handler
-	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+	if cfg.Features.Swagger {
+		// Swagger Redirects
+		r.GET("/docs", func(c *gin.Context) {
+			c.Redirect(http.StatusMovedPermanently, "/swagger/index.html")
+		})
+		r.GET("/swagger", func(c *gin.Context) {
+			c.Redirect(http.StatusMovedPermanently, "/swagger/index.html")
+		})
+		// Swagger Handler
+		r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+	}
 
 	// Start Server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	srv := &http.Server{
+		Addr:              ":" + strconv.Itoa(cfg.Server.Port),
+		Handler:           r,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+
+	// SIGTERM (ECS/Kubernetes stop) and Ctrl-C drain instead of killing streams
+	signals, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if cfg.Server.TLSCertPath != "" {
+			slog.Info("server starting", "port", cfg.Server.Port, "tls", true)
+			serveErr <- srv.ListenAndServeTLS(cfg.Server.TLSCertPath, cfg.Server.TLSKeyPath)
+		} else {
+			slog.Info("server starting", "port", cfg.Server.Port, "tls", false)
+			serveErr <- srv.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-serveErr:
+		flushTraces(shutdownTracing)
+		fatal("server failed", err)
+	case <-signals.Done():
+		// A second signal kills the process immediately
+		stopSignals()
+		shutdown(srv, shutdownTracing)
+	}
+}
+
+// flushTraces exports buffered spans before the process exits
+func flushTraces(shutdown func(context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdown(ctx); err != nil {
+		slog.Warn("failed to flush traces", logging.Err(err))
+	}
+}
+
+// unsplashOutcome counts an Unsplash lookup result and tags its span
+func unsplashOutcome(span trace.Span, outcome string) {
+	metrics.UnsplashFetches.WithLabelValues(outcome).Inc()
+	span.SetAttributes(attribute.String("unsplash.outcome", outcome))
+	if outcome == "error" || outcome == "http_error" || outcome == "decode_error" {
+		span.SetStatus(codes.Error, outcome)
 	}
+}
+
+// activeSessionCount feeds the sessions_active gauge: sessions seen in the last 30 minutes
+func activeSessionCount() float64 {
+	if session.GlobalManager == nil {
+		return 0
+	}
+	return float64(session.GlobalManager.ActiveSince(time.Now().Add(-30 * time.Minute)))
+}
 
-	certPath := os.Getenv("SSL_CERT_PATH")
-	keyPath := os.Getenv("SSL_KEY_PATH")
+// fatal logs err and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, logging.Err(err))
+	os.Exit(1)
+}
 
-	if certPath != "" && keyPath != "" {
-		fmt.Printf("Server starting on port %s (HTTPS)...\n", port)
-		if err := r.RunTLS(":"+port, certPath, keyPath); err != nil {
-			fmt.Printf("Fatal: Server failed to start (HTTPS): %v\n", err)
+// openStore connects the configured backend. Postgres is migrated (if
+// enabled) and closed again when that fails, so the caller can retry with a
+// fresh connection; SQLite upgrades its own schema. Without a keyring, keys
+// are only stored when allowPlaintext is set.
+func openStore(ctx context.Context, cfg config.DatabaseConfig, sticky store.StickyPolicy, keyring *store.Keyring, allowPlaintext bool) (store.FeedStore, error) {
+	switch cfg.Driver {
+	case "memory":
+		slog.Warn("using the in-memory store; cards, keys and sessions are lost on restart")
+		s := store.NewMemoryStore()
+		s.Sticky = sticky
+		return s, nil
+	case "sqlite":
+		s, err := store.NewSQLiteStore(ctx, cfg.Path)
+		if err != nil {
+			return nil, err
 		}
-	} else {
-		fmt.Printf("Server starting on port %s (HTTP)...\n", port)
-		if err := r.Run(":" + port); err != nil {
-			fmt.Printf("Fatal: Server failed to start: %v\n", err)
+		s.Keyring, s.AllowPlaintextKeys, s.Sticky = keyring, allowPlaintext, sticky
+		return s, nil
+	default:
+		s, err := store.NewPostgresStore(ctx, cfg.URL)
+		if err != nil {
+			return nil, err
 		}
+		if err := migrateStore(appCtx, s.DB); err != nil {
+			s.DB.Close()
+			return nil, fmt.Errorf("failed to migrate database: %w", err)
+		}
+		s.Keyring, s.AllowPlaintextKeys, s.Sticky = keyring, allowPlaintext, sticky
+		return s, nil
+	}
+}
+
+// initStore publishes the store and migrates legacy key rows
+func initStore(s store.FeedStore, keyring *store.Keyring) {
+	feedStore = s
+	keyProvisioner = newKeyProvisioner()
+	if keyring == nil {
+		return
 	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		n, failed, err := s.ReencryptLiteLLMKeys(ctx)
+		if err != nil {
+			slog.Warn("LiteLLM key re-encryption failed", "rows", n, "failed", failed, logging.Err(err))
+			return
+		}
+		if n > 0 || failed > 0 {
+			slog.Info("re-encrypted LiteLLM keys", "rows", n, "failed", failed, "kek_id", keyring.PrimaryID())
+		}
+	}()
 }
 
-func startScheduledExecution(agentPath string, schedule *runtime.ScheduleInfo) {
+func startScheduledExecution(ctx context.Context, agentPath string, schedule *runtime.ScheduleInfo) {
 	interval, err := time.ParseDuration(schedule.Interval)
 	if err != nil {
-		fmt.Println("Error parsing interval:", err)
+		slog.Error("invalid agent schedule interval", "agent", agentPath, logging.Err(err))
+		scheduler.fail(agentPath, fmt.Errorf("invalid schedule interval %q", schedule.Interval))
 		return
 	}
 
-	fmt.Printf("SCHEDULE: Starting %s every %s\n", agentPath, schedule.Interval)
+	slog.Info("scheduler started", "agent", agentPath, "interval", interval)
+	scheduler.start(agentPath, interval)
 	ticker := time.NewTicker(interval)
-	for range ticker.C {
-		fmt.Println("SCHEDULE: Triggering proactive run...")
-		fmt.Println("SCHEDULE: Triggering proactive run...")
-		if err := engine.Run(agentPath, "Proactive Check", loadMemoryConfig(), func(eventJSON string) {
-			processAndSaveFeed(context.Background(), "system_broadcast", eventJSON, "")
-		}); err != nil {
-			fmt.Printf("Error running scheduled check for %s: %v\n", agentPath, err)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("scheduler stopped", "agent", agentPath)
+			return
+		case <-ticker.C:
+		}
+		done, ok := inflight.begin()
+		if !ok {
+			return
 		}
+		scheduler.tick()
+		// A run in progress at shutdown is drained like a chat stream, not cut off
+		runCtx, cancel := inflight.runContext(ctx)
+		runCtx = logging.WithRunID(runCtx, logging.NewID())
+		runCtx = logging.WithOwnerID(runCtx, "system_broadcast")
+		runCtx = withAgentVersion(runCtx, agentPath)
+		slog.InfoContext(runCtx, "triggering proactive run", "agent", agentPath)
+		finishRun := recordRun(runCtx, "system_broadcast", agentPath, "schedule")
+		cards := newCardWriter(runCtx, "system_broadcast", "")
+		// Each run updates the agent's standing cards, recording a revision
+		cards.stickyKey = "schedule:" + filepath.Base(agentPath)
+		err := engine.RunContext(runCtx, agentPath, "Proactive Check", loadMemoryConfig(), cards.event)
+		cards.close()
+		finishRun(err, 0)
+		metrics.SchedulerTicks.WithLabelValues(filepath.Base(agentPath), metrics.Outcome(err)).Inc()
+		scheduler.finish(err)
+		if err != nil {
+			slog.ErrorContext(runCtx, "scheduled run failed", "agent", agentPath, logging.Err(err))
+		}
+		cancel()
+		done()
 	}
 }
 
 func loadMemoryConfig() *runtime.MemoryConfig {
-	projectID := os.Getenv("VERTEX_PROJECT_ID")
+	projectID := appConfig.Memory.VertexProjectID
 	// Only enable if project ID is set, or forcing a specific store
 	if projectID == "" {
 		return nil
@@ -217,8 +473,8 @@ func loadMemoryConfig() *runtime.MemoryConfig {
 		Enabled:    true,
 		Store:      "inmemory", // Fallback to inmemory until Vertex allowlist is approved/binary supports it
 		ProjectID:  projectID,
-		Location:   os.Getenv("VERTEX_LOCATION"),
-		CorpusName: os.Getenv("VERTEX_CORPUS_NAME"),
+		Location:   appConfig.Memory.VertexLocation,
+		CorpusName: appConfig.Memory.VertexCorpusName,
 	}
 }
 
@@ -227,7 +483,32 @@ func loadMemoryConfig() *runtime.MemoryConfig {
 var lastActiveNode string
 
 func processAndSaveFeed(ctx context.Context, deviceID string, eventJSON string, destination string) {
-	fmt.Println("ENGINE EVENT:", eventJSON)
+	card := buildCard(ctx, eventJSON, destination)
+	if card == nil || feedStore == nil {
+		return
+	}
+	if summary, ok := card.Data["summary"].(string); ok {
+		slog.DebugContext(ctx, "saving card", "node", card.SourceNode, "summary_len", len(summary))
+	}
+	if err := feedStore.UpsertCard(ctx, deviceID, card); err != nil { // Use deviceID parameter
+		slog.ErrorContext(ctx, "failed to save card", "node", card.SourceNode, logging.Err(err))
+	}
+}
+
+// buildCard classifies an engine event into a card and looks up its image,
+// or returns nil when the event is not shown in the feed
+func buildCard(ctx context.Context, eventJSON string, destination string) *store.Card {
+	card := classifyCard(ctx, eventJSON, destination)
+	if card != nil {
+		setCardImage(ctx, card.Data, destination)
+	}
+	return card
+}
+
+// classifyCard is buildCard without the image lookup
+func classifyCard(ctx context.Context, eventJSON string, destination string) *store.Card {
+	// Raw events carry user content; only their size is logged
+	slog.DebugContext(ctx, "engine event", "bytes", len(eventJSON))
 
 	// ... [Existing parsing logic mostly same, but need to adapt] ...
 	// Parse the JSON event to extract a clean message
@@ -257,11 +538,11 @@ func processAndSaveFeed(ctx context.Context, deviceID string, eventJSON string,
 	}
 
 	if shouldSkipMessage(message, evt.Type, eventJSON, incomingNode) {
-		return
+		return nil
 	}
 
 	// Default UI mapping
-	cardType, priority, data := mapToCard(message, destination)
+	cardType, priority, data := mapToCard(ctx, message, destination)
 
 	// Try to extract node information from the message content (nested JSON)
 	var nodeInfo struct {
@@ -275,7 +556,7 @@ func processAndSaveFeed(ctx context.Context, deviceID string, eventJSON string,
 		if err := json.Unmarshal([]byte(message), &nodeInfo); err == nil && nodeInfo.Node != "" {
 			incomingNode = nodeInfo.Node
 			cleanText = nodeInfo.Text
-			cardType, priority, data = mapToCard(cleanText, destination)
+			cardType, priority, data = mapToCard(ctx, cleanText, destination)
 			data["source_node"] = incomingNode
 		} else {
 			cleanText = cleanMessage(message)
@@ -286,7 +567,7 @@ func processAndSaveFeed(ctx context.Context, deviceID string, eventJSON string,
 		if t, ok := data["title"].(string); !ok || t == "" {
 			data["title"] = incomingNode
 		}
-		refineCardType(incomingNode, message, &cardType, &priority, data, destination)
+		refineCardType(ctx, incomingNode, message, &cardType, &priority, data, destination)
 		data["summary"] = cleanText
 		data["source_node"] = incomingNode
 	}
@@ -299,27 +580,18 @@ func processAndSaveFeed(ctx context.Context, deviceID string, eventJSON string,
 	}
 	// Only drop technical extraction nodes. Allow KnowledgeCheck to show up.
 	if incomingNode == "" || incomingNode == "ExtractDetails" || incomingNode == "ExtractCity" {
-		fmt.Printf("DEBUG: Dropping message from node '%s': %s (len=%d)\n", incomingNode, message, len(message))
-		return
+		slog.DebugContext(ctx, "dropping message", "node", incomingNode, "len", len(message))
+		return nil
 	}
 
-	// SAVE TO DB
-	if feedStore != nil {
-		card := &store.Card{
-			CardType:   cardType,
-			Priority:   priority,
-			SourceNode: incomingNode,
-			Data:       data,
-		}
-
-		// DEBUG: Check summary length
-		if summary, ok := data["summary"].(string); ok {
-			fmt.Printf("DEBUG: Saving Card Node='%s' SummaryLen=%d\n", incomingNode, len(summary))
-		}
-
-		if err := feedStore.UpsertCard(ctx, deviceID, card); err != nil { // Use deviceID parameter
-			fmt.Printf("DB ERROR: %v\n", err)
-		}
+	return &store.Card{
+		RunID:        logging.RunID(ctx),
+		TripID:       tripID(ctx),
+		AgentVersion: agentVersion(ctx),
+		CardType:     cardType,
+		Priority:     priority,
+		SourceNode:   incomingNode,
+		Data:         data,
 	}
 }
 
@@ -390,7 +662,7 @@ func shouldSkipMessage(message, eventType, rawJSON, nodeName string) bool {
 	return false
 }
 
-func mapToCard(message string, destination string) (string, string, map[string]interface{}) {
+func mapToCard(ctx context.Context, message string, destination string) (string, string, map[string]interface{}) {
 	var cardType = "article"
 	var priority = "medium"
 	var data = map[string]interface{}{
@@ -421,84 +693,15 @@ func mapToCard(message string, destination string) (string, string, map[string]i
 
 	// CARD TYPE MAPPING (based on the now-known title or content)
 	title, _ := data["title"].(string)
-
-	if title == "NewsAlert" || contains(message, "SAFETY:") || contains(message, "Warning") {
-		cardType = "safe_alert"
-		priority = "high"
-		data["message"] = data["summary"]
-		data["level"] = "warning"
-		data["category"] = "Safety"
-		data["colorTheme"] = "red"
-	} else if title == "CheckWeather" || contains(message, "Weather") {
-		cardType = "weather"
-		data["source"] = "Weather Agent"
-		data["category"] = "Weather"
-		data["colorTheme"] = "blue"
-		data["imageUrl"] = "https://images.unsplash.com/photo-1592210454359-9043f067919b?auto=format&fit=crop&w=800&q=80"
-		data["description"] = data["summary"]
-		data["temp"] = "22°C"
-		data["location"] = "Destination"
-		data["condition"] = "Cloudy"
-		data["condition"] = "Cloudy"
-
-		// Extract country from destination (e.g., "Delhi, India" -> "India")
-		country := extractCountry(destination)
-		query := "landscape"
-		if country != "" {
-			query = country // Just the country name
-		}
-		fmt.Printf("DEBUG: Unsplash Weather Query: '%s' (Country: '%s')\n", query, country)
-		if img, name, link := fetchUnsplashImage(query); img != "" {
-			data["imageUrl"] = img
-			data["imageUser"] = name
-			data["imageUserLink"] = link
-		} else {
-			data["imageUrl"] = "https://images.unsplash.com/photo-1592210454359-9043f067919b?auto=format&fit=crop&w=800&q=80"
-		}
-	} else if title == "GeniusLoci" || title == "KnowledgeCheck" || title == "ReviewSummarizer" {
-		cardType = "cultural_tip"
-		data["source"] = "Genius Loci"
-		data["category"] = "Culture"
-		data["colorTheme"] = "purple"
-
-		country := extractCountry(destination)
-		query := "culture"
-		if country != "" {
-			query = country // Just the country name
-		}
-		fmt.Printf("DEBUG: Unsplash Culture Query: '%s' (Country: '%s')\n", query, country)
-		if img, name, link := fetchUnsplashImage(query); img != "" {
-			data["imageUrl"] = img
-			data["imageUser"] = name
-			data["imageUserLink"] = link
-		} else {
-			data["imageUrl"] = "https://images.unsplash.com/photo-1528642474498-1af0c17fd8c3?auto=format&fit=crop&w=800&q=80"
-		}
-	} else if title == "GenerateReport" {
-		cardType = "article"
-		data["source"] = "Final Synthesis"
-		data["category"] = "Report"
-		data["colorTheme"] = "green"
-
-		country := extractCountry(destination)
-		query := "travel"
-		if country != "" {
-			query = country // Just the country name
-		}
-		fmt.Printf("DEBUG: Unsplash Report Query: '%s' (Country: '%s')\n", query, country)
-		if img, name, link := fetchUnsplashImage(query); img != "" {
-			data["imageUrl"] = img
-			data["imageUser"] = name
-			data["imageUserLink"] = link
-		} else {
-			data["imageUrl"] = "https://images.unsplash.com/photo-1469854523086-cc02fe5d8800?auto=format&fit=crop&w=800&q=80"
-		}
-	}
+	refineCardType(ctx, title, message, &cardType, &priority, data, destination)
 
 	return cardType, priority, data
 }
 
-func refineCardType(title string, message string, cardType *string, priority *string, data map[string]interface{}, destination string) {
+// refineCardType sets the card type, priority and styling for a node title
+// and message. Images are added separately by setCardImage, once the final
+// type is known.
+func refineCardType(ctx context.Context, title string, message string, cardType *string, priority *string, data map[string]interface{}, destination string) {
 	if title == "NewsAlert" || contains(message, "SAFETY:") || contains(message, "Warning") {
 		*cardType = "safe_alert"
 		*priority = "high"
@@ -511,70 +714,88 @@ func refineCardType(title string, message string, cardType *string, priority *st
 		data["source"] = "Weather Agent"
 		data["category"] = "Weather"
 		data["colorTheme"] = "blue"
-		data["imageUrl"] = "https://images.unsplash.com/photo-1592210454359-9043f067919b?auto=format&fit=crop&w=800&q=80"
 		data["description"] = data["summary"]
 		data["temp"] = "22°C"
 		data["location"] = "Destination"
 		data["condition"] = "Cloudy"
-		data["condition"] = "Cloudy"
-		country := extractCountry(destination)
-		query := "landscape"
-		if country != "" {
-			query = country // Just the country name
-		}
-		if img, name, link := fetchUnsplashImage(query); img != "" {
-			data["imageUrl"] = img
-			data["imageUser"] = name
-			data["imageUserLink"] = link
-		} else {
-			data["imageUrl"] = "https://images.unsplash.com/photo-1592210454359-9043f067919b?auto=format&fit=crop&w=800&q=80"
-		}
 	} else if title == "GeniusLoci" || title == "KnowledgeCheck" || title == "ReviewSummarizer" {
 		*cardType = "cultural_tip"
 		data["source"] = "Genius Loci"
 		data["category"] = "Culture"
 		data["colorTheme"] = "purple"
-		data["colorTheme"] = "purple"
-		country := extractCountry(destination)
-		query := "culture"
-		if country != "" {
-			query = country // Just the country name
-		}
-		if img, name, link := fetchUnsplashImage(query); img != "" {
-			data["imageUrl"] = img
-			data["imageUser"] = name
-			data["imageUserLink"] = link
-		} else {
-			data["imageUrl"] = "https://images.unsplash.com/photo-1528642474498-1af0c17fd8c3?auto=format&fit=crop&w=800&q=80"
-		}
 	} else if title == "GenerateReport" {
 		*cardType = "article"
 		data["source"] = "Final Synthesis"
 		data["category"] = "Report"
 		data["colorTheme"] = "green"
-		data["colorTheme"] = "green"
-		country := extractCountry(destination)
-		query := "travel landscape"
-		if country != "" {
-			query = country + " travel landscape"
-		}
-		if img, name, link := fetchUnsplashImage(query); img != "" {
-			data["imageUrl"] = img
-			data["imageUser"] = name
-			data["imageUserLink"] = link
-		} else {
-			data["imageUrl"] = "https://images.unsplash.com/photo-1469854523086-cc02fe5d8800?auto=format&fit=crop&w=800&q=80"
-		}
 	}
 }
 
+// fetchImage looks up card images; a variable for testing
+var fetchImage = fetchUnsplashImage
+
+// setCardImage adds the photo for the card's category to data, replacing
+// any image it had
+func setCardImage(ctx context.Context, data map[string]interface{}, destination string) {
+	category, _ := data["category"].(string)
+	img, name, link := cardImage(ctx, category, destination)
+	delete(data, "imageUser")
+	delete(data, "imageUserLink")
+	if img == "" {
+		delete(data, "imageUrl")
+		return
+	}
+	data["imageUrl"] = img
+	if name != "" {
+		data["imageUser"] = name
+		data["imageUserLink"] = link
+	}
+}
+
+// cardImage returns the photo, photographer and profile link for a card
+// category, falling back to a stock photo when Unsplash has none. Categories
+// without images return empty strings.
+func cardImage(ctx context.Context, category string, destination string) (string, string, string) {
+	var query, suffix, fallback string
+	switch category {
+	case "Weather":
+		query = "landscape"
+		fallback = "https://images.unsplash.com/photo-1592210454359-9043f067919b?auto=format&fit=crop&w=800&q=80"
+	case "Culture":
+		query = "culture"
+		fallback = "https://images.unsplash.com/photo-1528642474498-1af0c17fd8c3?auto=format&fit=crop&w=800&q=80"
+	case "Report":
+		query, suffix = "travel landscape", " travel landscape"
+		fallback = "https://images.unsplash.com/photo-1469854523086-cc02fe5d8800?auto=format&fit=crop&w=800&q=80"
+	default:
+		return "", "", ""
+	}
+	country := destinationCountry(ctx, destination)
+	if country != "" {
+		query = country + suffix
+	}
+	slog.DebugContext(ctx, "unsplash query", "category", category, "query", query, "country", country)
+	if img, name, link := fetchImage(ctx, query); img != "" {
+		return img, name, link
+	}
+	return fallback, "", ""
+}
+
 // fetchUnsplashImage queries the Unsplash API for a random photo matching the query.
 // It returns the photo URL, photographer name, and profile link (or empty strings).
-func fetchUnsplashImage(query string) (string, string, string) {
-	fmt.Printf("🔍 fetchUnsplashImage called with query: '%s'\n", query)
-	apiKey := os.Getenv("UNSPLASH_ACCESS_KEY")
+func fetchUnsplashImage(ctx context.Context, query string) (string, string, string) {
+	ctx, span := otel.Tracer(tracerScope).Start(ctx, "unsplash.fetch", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("unsplash.query", query)))
+	defer span.End()
+
+	if !appConfig.Features.UnsplashImages {
+		unsplashOutcome(span, "disabled")
+		return "", "", ""
+	}
+	apiKey := appConfig.Unsplash.AccessKey
 	if apiKey == "" {
-		fmt.Println("❌ UNSPLASH_ACCESS_KEY not set!")
+		unsplashOutcome(span, "no_key")
+		slog.Warn("UNSPLASH_ACCESS_KEY not set, skipping image lookup")
 		return "", "", ""
 	}
 
@@ -585,16 +806,22 @@ func fetchUnsplashImage(query string) (string, string, string) {
 		strings.ReplaceAll(query, " ", "%20"), apiKey)
 
 	client := &http.Client{Timeout: 5 * time.Second}
-	resp, err := client.Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		unsplashOutcome(span, "error")
+		return "", "", ""
+	}
+	resp, err := client.Do(req)
 	if err != nil {
-		fmt.Printf("⚠️ Unsplash API Error: %v\n", err)
+		// The error embeds the URL; client_id is masked by the log redaction
+		unsplashOutcome(span, "error")
+		slog.Warn("unsplash request failed", "query", query, logging.Err(err))
 		return "", "", ""
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusNotFound {
 		// Query too specific, try simpler fallback
-		fmt.Printf("⚠️ Unsplash returned 404 for '%s', trying simpler query...\n", query)
 
 		// Extract just the destination (remove ", Sri Lanka" or similar patterns)
 		simplifiedQuery := query
@@ -606,17 +833,20 @@ func fetchUnsplashImage(query string) (string, string, string) {
 		simplifiedQuery = strings.ReplaceAll(simplifiedQuery, " weather sky", " landscape")
 
 		if simplifiedQuery != query {
-			fmt.Printf("🔄 Retrying with: '%s'\n", simplifiedQuery)
+			unsplashOutcome(span, "retry")
+			slog.Debug("unsplash found no images, retrying with simpler query", "query", query, "retry_query", simplifiedQuery)
 			// Recursive retry with simplified query
-			return fetchUnsplashImage(simplifiedQuery)
+			return fetchUnsplashImage(ctx, simplifiedQuery)
 		}
 
-		fmt.Printf("⚠️ Unsplash API Returned: 404 (No images found)\n")
+		unsplashOutcome(span, "not_found")
+		slog.Debug("unsplash found no images", "query", query)
 		return "", "", ""
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		fmt.Printf("⚠️ Unsplash API Returned: %d\n", resp.StatusCode)
+		unsplashOutcome(span, "http_error")
+		slog.Warn("unsplash request failed", "query", query, "status", resp.StatusCode)
 		return "", "", ""
 	}
 
@@ -637,14 +867,14 @@ func fetchUnsplashImage(query string) (string, string, string) {
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		fmt.Printf("⚠️ Unsplash Decode Error: %v\n", err)
+		unsplashOutcome(span, "decode_error")
+		slog.Warn("failed to decode unsplash response", logging.Err(err))
 		return "", "", ""
 	}
 
 	// Trigger download tracking (required by Unsplash API guidelines)
 	// Run asynchronously with timeout to prevent blocking if Unsplash is down
 	if result.Links.DownloadLocation != "" {
-		fmt.Printf("📸 Unsplash: Triggering download for image...\n")
 		go func(downloadURL, clientID string) {
 			// Append client_id to download URL (required for authentication)
 			if !strings.Contains(downloadURL, "client_id=") {
@@ -658,14 +888,15 @@ func fetchUnsplashImage(query string) (string, string, string) {
 			trackClient := &http.Client{Timeout: 2 * time.Second}
 			trackResp, err := trackClient.Get(downloadURL)
 			if err != nil {
-				fmt.Printf("⚠️ Unsplash download tracking failed (non-critical): %v\n", err)
+				slog.Debug("unsplash download tracking failed", logging.Err(err))
 				return
 			}
 			defer trackResp.Body.Close()
-			fmt.Printf("✅ Unsplash download tracked! (Status: %d)\n", trackResp.StatusCode)
+			slog.Debug("unsplash download tracked", "status", trackResp.StatusCode)
 		}(result.Links.DownloadLocation, apiKey)
 	}
 
+	unsplashOutcome(span, "ok")
 	return result.Urls.Regular, result.User.Name, result.User.Links.Html
 }
 
@@ -685,23 +916,6 @@ func contains(s, substr string) bool {
 	return strings.Contains(s, substr)
 }
 
-// extractCountry extracts the country from a destination string
-// Examples: "Delhi, India" -> "India", "Pasikuda, Sri Lanka" -> "Sri Lanka"
-func extractCountry(destination string) string {
-	if destination == "" {
-		return ""
-	}
-
-	// Check if destination contains a comma (e.g., "City, Country")
-	if idx := strings.LastIndex(destination, ","); idx > 0 && idx < len(destination)-1 {
-		country := strings.TrimSpace(destination[idx+1:])
-		return country
-	}
-
-	// If no comma, assume the whole destination is the country/region
-	return destination
-}
-
 // HealthHandler godoc
 // @Summary      Health Check
 // @Description  Get service health status
@@ -715,20 +929,34 @@ func HealthHandler(c *gin.Context) {
 
 // GetFeedHandler godoc
 // @Summary      Get Feed
-// @Description  Get the current insight stream feed
+// @Description  Get the insight stream feed, pinned cards first, then newest first; dismissed and expired cards are left out. The next page's cursor is in the X-Next-Cursor header; an unchanged page answers If-None-Match with 304.
 // @Tags         feed
 // @Produce      json
+// @Param        limit        query     int     false  "Page size (default 50, max 200)"
+// @Param        cursor       query     string  false  "X-Next-Cursor of the previous page"
+// @Param        card_type    query     string  false  "Comma-separated card types"
+// @Param        priority     query     string  false  "Comma-separated priorities"
+// @Param        source_node  query     string  false  "Comma-separated source nodes"
+// @Param        trip_id      query     string  false  "Comma-separated trip IDs"
+// @Param        from         query     string  false  "RFC 3339; cards updated at or after"
+// @Param        to           query     string  false  "RFC 3339; cards updated before"
+// @Param        since        query     string  false  "RFC 3339; only cards updated after, dismissed ones included (incremental sync)"
 // @Success      200  {array}   FeedItem
+// @Success      304
+// @Failure      400  {object}  map[string]string
 // @Router       /api/feed [get]
 func GetFeedHandler(c *gin.Context) {
-	// Identify User
-	// Identify User (Hybrid: Auth User > Device > IP)
-	ownerID := c.GetHeader("X-User-ID")
-	if ownerID == "" {
-		ownerID = c.GetHeader("X-Device-ID")
+	// Identify User (verified user, or device for anonymous access)
+	principal, ok := requestPrincipal(c)
+	if !ok {
+		return
 	}
-	if ownerID == "" {
-		ownerID = c.ClientIP()
+	ownerID := principal.OwnerID()
+
+	q, err := parseFeedQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
 	if feedStore == nil {
@@ -736,12 +964,16 @@ func GetFeedHandler(c *gin.Context) {
 		return
 	}
 
-	feed, err := feedStore.GetFeed(c.Request.Context(), ownerID, 50)
+	page, err := feedStore.GetFeed(c.Request.Context(), ownerID, q)
+	if errors.Is(err, store.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch feed"})
 		return
 	}
-	c.JSON(http.StatusOK, feed)
+	writeFeedPage(c, page)
 }
 
 // ClearFeedHandler godoc
@@ -753,30 +985,27 @@ func GetFeedHandler(c *gin.Context) {
 // @Router       /api/feed [delete]
 func ClearFeedHandler(c *gin.Context) {
 	// Identify User
-	ownerID := c.GetHeader("X-User-ID")
-	if ownerID == "" {
-		ownerID = c.GetHeader("X-Device-ID")
-	}
-	if ownerID == "" {
-		ownerID = c.ClientIP()
+	principal, ok := requestPrincipal(c)
+	if !ok {
+		return
 	}
+	ownerID := principal.OwnerID()
 
-	fmt.Printf("DEBUG ClearFeed: Attempting to delete for ownerID='%s'\n", ownerID)
-	fmt.Printf("DEBUG ClearFeed: X-User-ID='%s', X-Device-ID='%s', ClientIP='%s'\n",
-		c.GetHeader("X-User-ID"), c.GetHeader("X-Device-ID"), c.ClientIP())
+	ctx := c.Request.Context()
+	slog.DebugContext(ctx, "clearing feed", "kind", principal.Kind)
 
 	if feedStore == nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB not initialized"})
 		return
 	}
 
-	if err := feedStore.DeleteFeed(c.Request.Context(), ownerID); err != nil {
-		fmt.Printf("Error clearing feed for %s: %v\n", ownerID, err)
+	if err := feedStore.DeleteFeed(ctx, ownerID); err != nil {
+		slog.ErrorContext(ctx, "failed to clear feed", logging.Err(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear feed"})
 		return
 	}
 
-	fmt.Printf("INFO: Feed cleared for %s\n", ownerID)
+	slog.InfoContext(ctx, "feed cleared")
 	c.JSON(http.StatusOK, gin.H{"status": "cleared", "message": "Feed cleared"})
 }
 
@@ -809,13 +1038,13 @@ func UploadAgentHandler(c *gin.Context) {
 	meta, err := engine.Inspect(savePath)
 	if err != nil {
 		// Fallback if inspection fails (old binary or bad agent)
-		fmt.Println("Warning: Inspection failed:", err)
+		slog.Warn("agent inspection failed", "agent", savePath, logging.Err(err))
 		meta = &runtime.AgentMetadata{Name: "Unknown Agent"}
 	}
 
 	// Start Scheduled Execution if present
 	if meta.Schedule != nil && meta.Schedule.Mode == "proactive" {
-		go startScheduledExecution(savePath, meta.Schedule)
+		go startScheduledExecution(appCtx, savePath, meta.Schedule)
 	}
 
 	// Initial Run (Reactive) - REMOVED per user request to wait for first prompt
@@ -823,7 +1052,7 @@ func UploadAgentHandler(c *gin.Context) {
 	// 	if err := engine.Run(savePath, "Start Analysis", func(eventJSON string) {
 	// 		processAndAppendFeed(eventJSON)
 	// 	}); err != nil {
-	// 		fmt.Printf("Error running initial analysis for %s: %v\n", savePath, err)
+	// 		slog.Error("initial analysis failed", "agent", savePath, logging.Err(err))
 	// 	}
 	// }()
 
@@ -836,6 +1065,35 @@ func UploadAgentHandler(c *gin.Context) {
 	})
 }
 
+// resolveAgentPath picks the agent a chat runs: the requested one, else the
+// configured default, else the first uploaded agent. "" when there is none.
+func resolveAgentPath(requested string) string {
+	if requested != "" {
+		return requested
+	}
+	if matches, _ := filepath.Glob(appConfig.Agent.Path); len(matches) > 0 {
+		return appConfig.Agent.Path
+	}
+	if matches, _ := filepath.Glob("uploaded_*.m"); len(matches) > 0 {
+		return matches[0]
+	}
+	return ""
+}
+
+// agentSchema returns the variables the agent at agentPath declares, falling
+// back to the default schema when its declaration is invalid
+func agentSchema(ctx context.Context, agentPath string) *variables.Schema {
+	if agentPath == "" {
+		return variables.Default()
+	}
+	schema, err := variables.ForAgent(agentPath)
+	if err != nil {
+		slog.WarnContext(ctx, "invalid agent variable schema, using the default", "agent", agentPath, logging.Err(err))
+		return variables.Default()
+	}
+	return schema
+}
+
 // ChatStreamHandler godoc
 // @Summary      Chat with Agent (Streaming)
 // @Description  Send a message to an agent and stream the response via SSE
@@ -847,27 +1105,53 @@ func UploadAgentHandler(c *gin.Context) {
 // @Failure      400     {object}  map[string]string
 // @Router       /api/chat/stream [post]
 // function variable for testing
-var GenerateContentFunc = llm.GenerateContent
+var GenerateContentFunc = llm.GenerateContentContext
 
 func ChatStreamHandler(c *gin.Context) {
 	var req struct {
 		Input     string `json:"input"`
 		AgentPath string `json:"agent_path"`
+		TripID    string `json:"trip_id"` // Optional: plan this trip
 	}
 	if err := c.BindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
 
-	// 1. Get Session Key (Use Hybrid Identity)
-	sessionKey := c.GetHeader("X-User-ID")
-	if sessionKey == "" {
-		sessionKey = c.GetHeader("X-Device-ID")
+	// 1. Get Session Key (verified user, or device for anonymous access)
+	principal, ok := requestPrincipal(c)
+	if !ok {
+		return
+	}
+
+	// Hold shutdown until this stream finishes; refuse new chats once it started
+	streamDone, ok := inflight.begin()
+	if !ok {
+		rejectWhileDraining(c)
+		return
+	}
+	defer streamDone()
+	sessionKey := principal.OwnerID()
+	var requestedTrip *trip.Trip
+	if req.TripID != "" && feedStore != nil {
+		t, err := trip.Get(c.Request.Context(), feedStore, sessionKey, req.TripID)
+		if errors.Is(err, store.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Trip not found"})
+			return
+		} else if err != nil {
+			slog.ErrorContext(c.Request.Context(), "failed to load trip", logging.Err(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load trip"})
+			return
+		}
+		requestedTrip = t
 	}
-	if sessionKey == "" {
-		sessionKey = c.ClientIP()
+	sess := loadSession(c.Request.Context(), sessionKey)
+	defer saveSession(c.Request.Context(), sess)
+	if requestedTrip != nil && requestedTrip.ID != sess.GetTripID() {
+		// The owner has one session; the trip it was planning must not leave
+		// its variables to be written onto this one
+		sess.SwitchTrip(requestedTrip.ID, requestedTrip.Variables())
 	}
-	sess := session.GlobalManager.GetOrCreate(sessionKey)
 
 	// 2. Append User Message
 	sess.AppendMessage("user", req.Input)
@@ -881,6 +1165,10 @@ func ChatStreamHandler(c *gin.Context) {
 	// Check State
 	isPostReport := sess.State == session.StatePostReport
 
+	// The agent declares the variables to collect
+	agentPath := resolveAgentPath(req.AgentPath)
+	schema := agentSchema(c.Request.Context(), agentPath)
+
 	systemMsg := fmt.Sprintf(`You are the "Guardian Assistant" for Trip Guardian.
 
 CURRENT STATE:
@@ -896,18 +1184,10 @@ SAFETY RAILS (ALWAYS ACTIVE):
    - Response: "I cannot help with that. I am only a travel assistant."
 2. PERSONA: Stay in character as a helpful Travel Guardian.
 
-TIER 1 (MANDATORY - BLOCKER):
-- Destination (City/Location)
-- Start Date (When?)
-- Duration (How long?)
-- Arrival/Departure Times (Time?)
-
-TIER 2 (OPTIONAL - ASK ONCE):
-- Specific Venues, Budget, Interests, Mode
-
+%s
 INSTRUCTIONS:
 1. Analyze conversation.
-2. If new info is found, output: UPDATE_STATE: Key=Value
+2. If new info is found, output: UPDATE_STATE: Key=Value (Key is a variable name above)
 3. LOGIC:
    [IF Report Generated = FALSE]
    - If Tier 1 MISSING -> ACTION: ASK_QUESTION <Specific Question>
@@ -926,58 +1206,53 @@ INSTRUCTIONS:
 
 4. Format:
 UPDATE_STATE: Key=Value
-ACTION: ...`, string(varsJSON), isPostReport)
+ACTION: ...`, string(varsJSON), isPostReport, schema.Prompt())
 
 	// Get or Generate Per-User LiteLLM Key
-	userID := c.GetHeader("X-User-ID")
+	// Only verified users get a provisioned key
+	userID := principal.UserID
 	var litellmApiKey string
 
 	if userID != "" {
-		// Try to get existing key from database
-		key, err := feedStore.GetUserLiteLLMKey(c.Request.Context(), userID)
-		if err != nil {
-			fmt.Printf("⚠️ Error fetching user key: %v\n", err)
+		key, err := userLiteLLMKey(c.Request.Context(), userID)
+		if errors.Is(err, store.ErrKeyRevoked) {
+			// Don't fall back to another key, that would undo the revocation
+			c.JSON(http.StatusForbidden, gin.H{"error": "LLM access revoked"})
+			return
 		}
-
-		if key == "" {
-			// Generate new key for this user
-			fmt.Printf("🔑 Generating new LiteLLM key for user: %s\n", userID)
-			proxyURL := os.Getenv("LITELLM_PROXY_URL")
-			masterKey := os.Getenv("LITELLM_MASTER_KEY")
-
-			if proxyURL != "" && masterKey != "" {
-				newKey, keyName, err := store.GenerateLiteLLMKey(proxyURL, masterKey, userID, 10.00)
-				if err != nil {
-					fmt.Printf("❌ Failed to generate key: %v\n", err)
-				} else {
-					// Store the new key
-					if err := feedStore.StoreUserLiteLLMKey(c.Request.Context(), userID, newKey, keyName, 10.00); err != nil {
-						fmt.Printf("❌ Failed to store key: %v\n", err)
-					} else {
-						litellmApiKey = newKey
-						fmt.Printf("✅ Generated and stored key: %s\n", keyName)
-					}
-				}
-			}
-		} else {
-			litellmApiKey = key
-			fmt.Printf("✅ Using existing key for user: %s\n", userID)
+		if err != nil {
+			slog.WarnContext(c.Request.Context(), "failed to get LiteLLM key", logging.Err(err))
 		}
+		litellmApiKey = key
 	}
 
 	// Fallback: Check if client sent their own key (for development/testing)
 	if litellmApiKey == "" {
 		litellmApiKey = c.GetHeader("X-LiteLLM-API-Key")
 		if litellmApiKey != "" {
-			fmt.Println("ℹ️ Using client-provided LiteLLM key")
+			slog.DebugContext(c.Request.Context(), "using client-provided LiteLLM key")
 		}
 	}
 
-	fmt.Printf("GATEWAY: Thinking... (History: %d msgs)\n", len(history))
-	if litellmApiKey != "" {
-		fmt.Printf("GATEWAY: Using LiteLLM API Key\n")
+	// Condense older turns so the prompt stays bounded on long sessions
+	usageOwner := sessionKey
+	if compacted, err := sess.Summarize(loadSummaryPolicy(), newHistorySummarizer(c.Request.Context(), usageOwner, litellmApiKey)); err != nil {
+		slog.WarnContext(c.Request.Context(), "history summarization failed", logging.Err(err))
+	} else if compacted {
+		slog.InfoContext(c.Request.Context(), "summarized older history")
+	}
+	history = sess.GetContext()
+
+	// Whether the turn runs an agent is the model's decision, so the agent
+	// limit can only be enforced after this call. When it is already used up
+	// the model is told, so it answers rather than picking a run we'd refuse.
+	if !rateLimiter.Available(c, agentPolicy) {
+		systemMsg += "\n\nAgent runs are rate limited right now: do not choose RUN_AGENT. If the user asks for one, tell them to try again later with ACTION: ASK_QUESTION."
 	}
-	decision, err := GenerateContentFunc(convertHistory(history), systemMsg, litellmApiKey)
+
+	slog.DebugContext(c.Request.Context(), "generating decision", "history", len(history), "litellm_key_set", litellmApiKey != "")
+	decision, usage, err := GenerateContentFunc(c.Request.Context(), convertHistory(history), systemMsg, litellmApiKey)
+	recordUsage(c.Request.Context(), usageOwner, "chat", usage)
 
 	// Default fallback
 	action := "ACTION: ASK_QUESTION Sorry, I am having trouble thinking right now."
@@ -1005,10 +1280,20 @@ ACTION: ...`, string(varsJSON), isPostReport)
 			}
 		}
 
-		// Apply updates to session
-		if len(updates) > 0 {
-			sess.UpdateVariables(updates)
-			fmt.Printf("GATEWAY UPDATED STATE: %v\n", updates)
+		// Apply updates to session: declared variables are normalized, and a
+		// value that cannot be read is asked for again instead of acted on
+		values, rejected := schema.Apply(updates, time.Now())
+		if len(values) > 0 {
+			sess.UpdateVariables(values)
+			slog.DebugContext(c.Request.Context(), "session state updated", "updates", values)
+		}
+		if len(rejected) > 0 {
+			questions := make([]string, len(rejected))
+			for i, r := range rejected {
+				questions[i] = r.Question()
+				slog.DebugContext(c.Request.Context(), "variable rejected", "variable", r.Variable.Name, logging.Err(r.Err))
+			}
+			action = "ACTION: ASK_QUESTION " + strings.Join(questions, " ")
 		}
 
 		// Fallback: If no ACTION was found but we have valid text, treat it as a question/response
@@ -1028,11 +1313,18 @@ ACTION: ...`, string(varsJSON), isPostReport)
 		}
 	} else {
 		// Log actual error for admin
-		fmt.Printf("GATEWAY ERROR: %v\n", err)
+		slog.ErrorContext(c.Request.Context(), "decision generation failed", logging.Err(err))
 		// Friendly message for user
 		action = "ACTION: ASK_QUESTION I'm currently experiencing high traffic or a temporary system issue. Please try again in a moment."
 	}
-	fmt.Println("GATEWAY DECISION:", action)
+	slog.DebugContext(c.Request.Context(), "decision", "action", action)
+
+	// Agent runs are far more expensive than chat turns and have their own limit.
+	// Checked before the SSE headers go out so the client gets a plain 429.
+	if strings.Contains(action, "ACTION: RUN_AGENT") && !rateLimiter.Allow(c, agentPolicy) {
+		sess.AppendMessage("model", "Agent run rate limit reached, please try again later.")
+		return
+	}
 
 	// Set SSE headers
 	c.Writer.Header().Set("Content-Type", "text/event-stream")
@@ -1040,40 +1332,31 @@ ACTION: ...`, string(varsJSON), isPostReport)
 	c.Writer.Header().Set("Connection", "keep-alive")
 	c.Writer.Flush()
 
+	// Warn users approaching their monthly LiteLLM budget
+	if userID != "" {
+		if warning := checkBudget(c.Request.Context(), userID); warning != nil {
+			if warnBytes, err := json.Marshal(warning); err == nil {
+				c.SSEvent("warning", string(warnBytes))
+				c.Writer.Flush()
+			}
+		}
+	}
+
 	// 4. Act on Decision
 	if strings.Contains(action, "ACTION: RUN_AGENT") {
 		// Set state to POST_REPORT to prevent auto-retriggering
 		sess.SetState(session.StatePostReport)
 
-		// Construct robust agent input from Session Variables + System Time
-		// This replaces reliance on the LLM's "SUMMARY" which can be flaky or hallucinated.
-		vars := sess.GetVariables()
-		inputBuilder := strings.Builder{}
-		inputBuilder.WriteString(fmt.Sprintf("Current System Date: %s.\n", time.Now().Format("2006-01-02")))
-		inputBuilder.WriteString("Trip Details:\n")
-		for k, v := range vars {
-			inputBuilder.WriteString(fmt.Sprintf("- %s: %s\n", k, v))
-		}
-		// Append the actual user input just in case context is needed, but variables are primary.
-		inputBuilder.WriteString(fmt.Sprintf("\nUser Note: %s", req.Input))
-
-		agentInput := inputBuilder.String()
-		fmt.Printf("GATEWAY: Running Agent with Synthesized Input:\n%s\n", agentInput)
+		// The run outlives a disconnected client (its cards are still saved) and
+		// is only cut off when shutdown stops waiting for it
+		runCtx, cancelRun := inflight.runContext(c.Request.Context())
+		defer cancelRun()
+		runCtx = logging.WithRunID(runCtx, logging.NewID())
+		runCtx = withAgentVersion(runCtx, agentPath)
 
 		// --- RUN AGENT PATH ---
 		sess.AppendMessage("model", "Starting Trip Guardian analysis...")
 
-		// Determine Agent Path (Legacy Logic)
-		agentPath := req.AgentPath
-		if agentPath == "" {
-			defaultAgent := "agents/trip-guardian/trip_guardian_v3.m"
-			if matches, _ := filepath.Glob(defaultAgent); len(matches) > 0 {
-				agentPath = defaultAgent
-			} else if matches, _ := filepath.Glob("uploaded_*.m"); len(matches) > 0 {
-				agentPath = matches[0]
-			}
-		}
-
 		if agentPath == "" {
 			c.SSEvent("error", "No agent found. Upload one first.")
 			return
@@ -1086,75 +1369,51 @@ ACTION: ...`, string(varsJSON), isPostReport)
 		// Prepare Accumulator
 		var fullOutput strings.Builder
 		var mu sync.Mutex
-		nodeAccumulators := make(map[string]string)
-		currentAccumulatingNode := ""
-		var finalDest string // Capture destination for final flush
+		runUsage := &llm.Usage{}
+
+		// Declared variables are stored under their schema name
+		dest := sess.GetVariables()["Destination"]
+
+		// Save the trip the conversation planned; the run and its cards belong to it
+		t := syncSessionTrip(runCtx, sess, sessionKey)
+		if t != nil {
+			runCtx = withTripID(runCtx, t.ID)
+			if tripBytes, err := json.Marshal(t); err == nil {
+				c.SSEvent("trip", string(tripBytes))
+				c.Writer.Flush()
+			}
+		}
+
+		// The agent reads the trip rather than the LLM's summary, which can be
+		// flaky or hallucinated, in the order and format it declares
+		shape := agentInputShape(runCtx, agentPath, schema)
+		agentInput := buildAgentInput(shape, sess, t, req.Input)
+		// The input is the user's trip, so only its shape is logged
+		slog.DebugContext(runCtx, "built agent input", "format", shape.Format, "fields", len(shape.Fields), "bytes", len(agentInput))
 
 		// Reset Stream State
-		// bucketMutex.Lock() // Removed
-		// nodeBuckets = map[string]*FeedItem{}
 		lastActiveNode = ""
-		// bucketMutex.Unlock() // Removed
+		cards := newCardWriter(runCtx, sessionKey, dest)
 
 		// Run Agent
-		err := engine.Run(agentPath, agentInput, loadMemoryConfig(), func(eventJSON string) {
-			fmt.Println("RAW FASTGRAPH EVENT:", eventJSON)
+		slog.InfoContext(runCtx, "agent run started", "agent", agentPath)
+		finishRun := recordRun(runCtx, sessionKey, agentPath, "chat")
+		err := engine.RunContext(runCtx, agentPath, agentInput, loadMemoryConfig(), func(eventJSON string) {
 			mu.Lock()
 			defer mu.Unlock()
 
-			// Feed Update
-			// Robust Destination Lookup
-			dest := vars["Destination"]
-			if dest == "" {
-				dest = vars["destination"] // Try lowercase fallback
-			}
-			finalDest = dest // Capture for final flush
-			fmt.Printf("DEBUG: Feed Update - Destination: '%s'\n", dest)
-
-			// ACCUMULATION LOGIC:
-			// Parse event to extract content and node
+			// Usage reports are metered, not shown
 			var evt struct {
-				Node    string `json:"node"`
-				Message string `json:"message"`
-				Text    string `json:"text"`
-				Type    string `json:"type"`
+				Type string `json:"type"`
 			}
-			// Best effort parse
 			_ = json.Unmarshal([]byte(eventJSON), &evt)
-
-			content := evt.Message
-			if content == "" {
-				content = evt.Text
-			}
-
-			// Update Current Node Context if explicit
-			if evt.Node != "" {
-				currentAccumulatingNode = evt.Node
+			if evt.Type == "usage" {
+				accumulateRunUsage(runUsage, eventJSON)
+				return
 			}
 
-			// If we have a current node context and content, accumulate and send FULL content
-			if currentAccumulatingNode != "" && content != "" {
-				nodeAccumulators[currentAccumulatingNode] += content
-
-				// Construct Synthetic Event with FULL accumulated content
-				// This ensures the DB Upsert replaces the card with the COMPLETE text so far
-				fullEventObj := map[string]string{
-					"type":    evt.Type, // Use original type (chunk)
-					"node":    currentAccumulatingNode,
-					"message": nodeAccumulators[currentAccumulatingNode],
-				}
-				// Default type to chunk if missing
-				if fullEventObj["type"] == "" {
-					fullEventObj["type"] = "chunk"
-				}
-
-				if fullEventBytes, err := json.Marshal(fullEventObj); err == nil {
-					processAndSaveFeed(c.Request.Context(), sessionKey, string(fullEventBytes), dest)
-				}
-			} else {
-				// Fallback for system events (like done/error) or chunks before any node is seen
-				processAndSaveFeed(c.Request.Context(), sessionKey, eventJSON, dest)
-			}
+			// Feed Update: chunks are accumulated per node and written in batches
+			cards.event(eventJSON)
 
 			// Stream to Client (Send ORIGINAL chunk)
 			c.SSEvent("message", eventJSON)
@@ -1163,30 +1422,29 @@ ACTION: ...`, string(varsJSON), isPostReport)
 			// Accumulate for Done
 			fullOutput.WriteString(extractTextFromEvent(eventJSON))
 		})
+		// Every node is saved in its final state
+		cards.close()
 
-		// --- FINAL CONSISTENCY FLUSH ---
-		// Ensure all accumulated nodes are saved in their final state
-		fmt.Println("DEBUG: Performing Final Consistency Flush of all cards...")
-		mu.Lock() // Safe access to nodeAccumulators
-		for node, content := range nodeAccumulators {
-			if node != "" && content != "" {
-				// Re-construct the full event structure
-				fullEventObj := map[string]string{
-					"type":    "chunk",
-					"node":    node,
-					"message": content,
-				}
-				if fullEventBytes, err := json.Marshal(fullEventObj); err == nil {
-					// Use the existing processAndSaveFeed logic which handles mapToCard, DB upsert, etc.
-					processAndSaveFeed(c.Request.Context(), sessionKey, string(fullEventBytes), finalDest)
-				}
-			}
+		if runUsage.TotalTokens > 0 {
+			recordUsage(runCtx, usageOwner, "agent_run", runUsage)
+		}
+		finishRun(err, runUsage.TotalTokens)
+
+		if stoppedByShutdown(runCtx) {
+			slog.WarnContext(runCtx, "agent run interrupted by shutdown", "agent", agentPath)
+			// Let the next message rerun the report instead of treating it as done
+			sess.SetState(session.StateReady)
+			sess.AppendMessage("model", "The report was interrupted by a server restart.")
+			c.SSEvent("shutdown", `{"reason": "server restarting", "retry": true}`)
+			c.Writer.Flush()
+			return
 		}
-		mu.Unlock()
-		// -------------------------------
 
 		if err != nil {
+			slog.ErrorContext(runCtx, "agent run failed", "agent", agentPath, logging.Err(err))
 			c.SSEvent("error", err.Error())
+		} else {
+			slog.InfoContext(runCtx, "agent run finished", "agent", agentPath, "total_tokens", runUsage.TotalTokens)
 		}
 
 		sess.AppendMessage("model", "Report generated.")
@@ -1218,6 +1476,36 @@ ACTION: ...`, string(varsJSON), isPostReport)
 	}
 }
 
+func loadSummaryPolicy() session.SummaryPolicy {
+	return session.SummaryPolicy{
+		MaxMessages: appConfig.Session.SummaryMaxMessages,
+		KeepRecent:  appConfig.Session.SummaryKeepRecent,
+	}
+}
+
+// newHistorySummarizer returns a session.Summarizer backed by the gateway LLM
+func newHistorySummarizer(ctx context.Context, ownerID, apiKey string) session.Summarizer {
+	return func(previous string, older []session.Message) (string, error) {
+		prompt := `You maintain a running summary of a conversation between a traveller and the "Guardian Assistant".
+Merge the EXISTING SUMMARY with the new messages into one concise summary (max 200 words).
+Keep decisions, preferences, open questions and whether a report was generated.
+Do NOT list trip variables (destination, dates, duration, times); they are tracked separately.
+Output only the summary text.
+
+EXISTING SUMMARY:
+` + previous
+		if previous == "" {
+			prompt += "(none)"
+		}
+		summary, usage, err := GenerateContentFunc(ctx, convertHistory(older), prompt, apiKey)
+		recordUsage(ctx, ownerID, "summary", usage)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(summary), nil
+	}
+}
+
 // Helper to convert session history to map format for LLM
 func convertHistory(hist []session.Message) []map[string]interface{} {
 	var res []map[string]interface{}
@@ -2,16 +2,21 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
+	"guardian-gateway/pkg/auth"
 	"guardian-gateway/pkg/fastgraph/runtime"
+	"guardian-gateway/pkg/llm"
 	"guardian-gateway/pkg/session"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestHealthHandler(t *testing.T) {
@@ -32,8 +37,8 @@ func TestChatStreamHandler(t *testing.T) {
 	// Mock GenerateContentFunc
 	originalGenerate := GenerateContentFunc
 	defer func() { GenerateContentFunc = originalGenerate }()
-	GenerateContentFunc = func(history []map[string]interface{}, systemPrompt string, userApiKey ...string) (string, error) {
-		return "ACTION: RUN_AGENT SUMMARY: Run requested by test", nil
+	GenerateContentFunc = func(ctx context.Context, history []map[string]interface{}, systemPrompt string, userApiKey ...string) (string, *llm.Usage, error) {
+		return "ACTION: RUN_AGENT SUMMARY: Run requested by test", nil, nil
 	}
 
 	// Setup Mock Engine
@@ -56,6 +61,7 @@ func TestChatStreamHandler(t *testing.T) {
 	c.Request, _ = http.NewRequest("POST", "/api/chat/stream", bytes.NewBuffer(reqBody))
 	c.Request.Header.Set("Content-Type", "application/json")
 	c.Request.RemoteAddr = "127.0.0.1:12345" // Needed for ClientIP binding
+	auth.SetPrincipal(c, &auth.Principal{Kind: auth.KindAnonymous, DeviceID: "test-device"})
 
 	// Call Handler
 	ChatStreamHandler(c)
@@ -76,6 +82,78 @@ func TestChatStreamHandler(t *testing.T) {
 	assert.Contains(t, compact, `data:{"type":"chunk","message":"World"}`)
 }
 
+func TestChatStreamHandler_AsksAgainForInvalidVariable(t *testing.T) {
+	session.Init()
+
+	originalGenerate := GenerateContentFunc
+	defer func() { GenerateContentFunc = originalGenerate }()
+	var prompt string
+	GenerateContentFunc = func(ctx context.Context, history []map[string]interface{}, systemPrompt string, userApiKey ...string) (string, *llm.Usage, error) {
+		prompt = systemPrompt
+		return "UPDATE_STATE: destination=Lisbon\nUPDATE_STATE: Start_Date=10 June 2026\nUPDATE_STATE: Budget=1500\nACTION: RUN_AGENT SUMMARY: go", nil, nil
+	}
+	mockEngine := runtime.New()
+	ran := false
+	mockEngine.MockRun = func(agentPath, input string, memory *runtime.MemoryConfig, onEvent func(string)) error {
+		ran = true
+		return nil
+	}
+	origEngine := engine
+	defer func() { engine = origEngine }()
+	engine = mockEngine
+
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/api/chat/stream", bytes.NewBufferString(`{"input": "Lisbon on 10 June, budget 1500", "agent_path": "mock.m"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	auth.SetPrincipal(c, &auth.Principal{Kind: auth.KindAnonymous, DeviceID: "test-device"})
+
+	ChatStreamHandler(c)
+
+	assert.Contains(t, prompt, "- Destination (City/Location)", "the prompt lists the agent's variables")
+	assert.False(t, ran, "an unreadable value is asked for before the agent runs")
+	assert.Contains(t, w.Body.String(), `for budget (no currency given). How much, and in which currency?`)
+	vars := session.GlobalManager.GetOrCreate("device:test-device").GetVariables()
+	assert.Equal(t, map[string]string{"Destination": "Lisbon", "Start Date": "2026-06-10"}, vars)
+}
+
+func TestChatStreamHandler_BuildsAgentInputFromDeclaredShape(t *testing.T) {
+	session.Init()
+
+	originalGenerate := GenerateContentFunc
+	defer func() { GenerateContentFunc = originalGenerate }()
+	GenerateContentFunc = func(ctx context.Context, history []map[string]interface{}, systemPrompt string, userApiKey ...string) (string, *llm.Usage, error) {
+		return "UPDATE_STATE: Interests=food, fado\nUPDATE_STATE: Destination=Lisbon\nUPDATE_STATE: Mode=walking\nUPDATE_STATE: Start Date=2026-06-10\nACTION: RUN_AGENT SUMMARY: go", nil, nil
+	}
+	mockEngine := runtime.New()
+	var inputs []string
+	mockEngine.MockRun = func(agentPath, input string, memory *runtime.MemoryConfig, onEvent func(string)) error {
+		inputs = append(inputs, input)
+		return nil
+	}
+	mockEngine.MockInspect = func(agentPath string) (*runtime.AgentMetadata, error) {
+		return &runtime.AgentMetadata{Inputs: []string{"Destination", "Start Date", "Budget"}, InputFormat: "json"}, nil
+	}
+	origEngine := engine
+	defer func() { engine = origEngine }()
+	engine = mockEngine
+
+	gin.SetMode(gin.TestMode)
+	for _, device := range []string{"device-a", "device-b"} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("POST", "/api/chat/stream", bytes.NewBufferString(`{"input": "go", "agent_path": "structured.m"}`))
+		c.Request.Header.Set("Content-Type", "application/json")
+		auth.SetPrincipal(c, &auth.Principal{Kind: auth.KindAnonymous, DeviceID: device})
+		ChatStreamHandler(c)
+	}
+
+	require.Len(t, inputs, 2)
+	assert.Equal(t, inputs[0], inputs[1], "the same trip gives the same input")
+	assert.Equal(t, `{"current_date":"`+time.Now().Format("2006-01-02")+`","trip":{"destination":"Lisbon","start_date":"2026-06-10","budget":null,"interests":"food, fado","mode":"walking"},"note":"go"}`, inputs[0])
+}
+
 func TestGetFeedHandler(t *testing.T) {
 	// Note: This requires mocking feedStore
 	// Since feedStore is a global, we'd need to refactor for proper testing
@@ -89,7 +167,7 @@ func TestGetFeedHandler(t *testing.T) {
 	w := httptest.NewRecorder()
 	c, _ := gin.CreateTestContext(w)
 	c.Request, _ = http.NewRequest("GET", "/api/feed", nil)
-	c.Request.Header.Set("X-Device-ID", "test-device")
+	auth.SetPrincipal(c, &auth.Principal{Kind: auth.KindAnonymous, DeviceID: "test-device"})
 
 	GetFeedHandler(c)
 
@@ -97,6 +175,20 @@ func TestGetFeedHandler(t *testing.T) {
 	assert.Contains(t, w.Body.String(), "DB not initialized")
 }
 
+func TestFeedHandlers_RequirePrincipal(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, h := range []gin.HandlerFunc{GetFeedHandler, ClearFeedHandler} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("GET", "/api/feed", nil)
+		c.Request.Header.Set("X-User-ID", "someone-else")
+
+		h(c)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+}
+
 func TestClearFeedHandler(t *testing.T) {
 	globalFeedStore := feedStore
 	defer func() { feedStore = globalFeedStore }()
@@ -108,7 +200,7 @@ func TestClearFeedHandler(t *testing.T) {
 	w := httptest.NewRecorder()
 	c, _ := gin.CreateTestContext(w)
 	c.Request, _ = http.NewRequest("DELETE", "/api/feed", nil)
-	c.Request.Header.Set("X-Device-ID", "test-device")
+	auth.SetPrincipal(c, &auth.Principal{Kind: auth.KindAnonymous, DeviceID: "test-device"})
 
 	ClearFeedHandler(c)
 
@@ -187,7 +279,7 @@ func TestMapToCard(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			cardType, priority, data := mapToCard(tt.message, tt.destination)
+			cardType, priority, data := mapToCard(context.Background(), tt.message, tt.destination)
 			assert.Equal(t, tt.expCardType, cardType)
 			if tt.expPriority != "" {
 				assert.Equal(t, tt.expPriority, priority)
@@ -199,6 +291,19 @@ func TestMapToCard(t *testing.T) {
 	}
 }
 
+func TestDestinationCountry(t *testing.T) {
+	ctx := context.Background()
+	for destination, want := range map[string]string{
+		"Pasikuda, Sri Lanka":  "Sri Lanka",
+		"Delhi":                "India",
+		"Nuwara Eliya, Ceylon": "Sri Lanka",
+		"Kyoto, Kansai":        "Japan",
+		"Atlantis":             "Atlantis",
+	} {
+		assert.Equal(t, want, destinationCountry(ctx, destination), destination)
+	}
+}
+
 func TestCleanMessage(t *testing.T) {
 	tests := []struct {
 		name     string
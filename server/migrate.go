@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"guardian-gateway/migrations"
+	"guardian-gateway/pkg/config"
+	"guardian-gateway/pkg/migrate"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+const migrateUsage = "usage: guardian-gateway migrate [up | down [N] | status]"
+
+// newMigrator returns a Migrator for the embedded migrations
+func newMigrator(db *sql.DB) (*migrate.Migrator, error) {
+	all, err := migrate.Load(migrations.FS)
+	if err != nil {
+		return nil, err
+	}
+	return migrate.New(db, all), nil
+}
+
+// migrateStore applies pending migrations when DATABASE_MIGRATE_ON_STARTUP is set
+func migrateStore(ctx context.Context, db *sql.DB) error {
+	if !appConfig.Database.MigrateOnStartup {
+		return nil
+	}
+	m, err := newMigrator(db)
+	if err != nil {
+		return err
+	}
+	// Another replica may hold the lock while it migrates; wait for it
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+	_, err = m.Up(ctx)
+	return err
+}
+
+// runMigrate implements "guardian-gateway migrate ...", writing results to out
+func runMigrate(ctx context.Context, cfg *config.Config, args []string, out io.Writer) error {
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+
+	steps := 1
+	switch cmd {
+	case "up", "status":
+		if len(args) > 1 {
+			return fmt.Errorf("%s", migrateUsage)
+		}
+	case "down":
+		if len(args) > 2 {
+			return fmt.Errorf("%s", migrateUsage)
+		}
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n <= 0 {
+				return fmt.Errorf("down: N must be a positive number, got %q", args[1])
+			}
+			steps = n
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q\n%s", cmd, migrateUsage)
+	}
+
+	if cfg.Database.Driver != "postgres" {
+		return fmt.Errorf("migrate manages the postgres schema; the %s store upgrades itself when opened", cfg.Database.Driver)
+	}
+	db, err := sql.Open("pgx", cfg.Database.URL)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+	pingCtx, cancel := context.WithTimeout(ctx, cfg.Database.ConnectTimeout)
+	err = db.PingContext(pingCtx)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("failed to ping db: %w", err)
+	}
+
+	m, err := newMigrator(db)
+	if err != nil {
+		return err
+	}
+	switch cmd {
+	case "up":
+		applied, err := m.Up(ctx)
+		for _, mig := range applied {
+			fmt.Fprintf(out, "applied  %03d_%s\n", mig.Version, mig.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Fprintln(out, "schema is up to date")
+		}
+		return err
+	case "down":
+		reverted, err := m.Down(ctx, steps)
+		for _, mig := range reverted {
+			fmt.Fprintf(out, "reverted %03d_%s\n", mig.Version, mig.Name)
+		}
+		return err
+	default:
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		printMigrationStatus(out, statuses)
+		return nil
+	}
+}
+
+func printMigrationStatus(out io.Writer, statuses []migrate.Status) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT\tDOWN")
+	for _, s := range statuses {
+		state, at := "pending", ""
+		switch {
+		case s.Unknown:
+			state = "applied (newer build)"
+		case s.Modified:
+			state = "MODIFIED"
+		case s.Applied:
+			state = "applied"
+		}
+		if s.AppliedAt != nil {
+			at = s.AppliedAt.UTC().Format(time.RFC3339)
+		}
+		down := "no"
+		if s.Reversible {
+			down = "yes"
+		}
+		fmt.Fprintf(w, "%03d\t%s\t%s\t%s\t%s\n", s.Version, s.Name, state, at, down)
+	}
+	w.Flush()
+}
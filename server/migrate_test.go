@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"guardian-gateway/pkg/config"
+	"guardian-gateway/pkg/migrate"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunMigrate_Usage(t *testing.T) {
+	cfg := config.Default()
+	for _, args := range [][]string{
+		{"sideways"},
+		{"up", "extra"},
+		{"down", "0"},
+		{"down", "two"},
+		{"status", "now"},
+	} {
+		err := runMigrate(context.Background(), cfg, args, &bytes.Buffer{})
+		assert.Error(t, err, "%v", args)
+	}
+}
+
+func TestPrintMigrationStatus(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var out bytes.Buffer
+	printMigrationStatus(&out, []migrate.Status{
+		{Version: 1, Name: "create_cards", Applied: true, AppliedAt: &at, Reversible: true},
+		{Version: 3, Name: "add_user_litellm_keys", Applied: true, AppliedAt: &at, Modified: true},
+		{Version: 8, Name: "rate_limit_buckets"},
+	})
+	assert.Contains(t, out.String(), "001      create_cards")
+	assert.Contains(t, out.String(), "2024-05-01T12:00:00Z")
+	assert.Contains(t, out.String(), "MODIFIED")
+	assert.Contains(t, out.String(), "pending")
+}
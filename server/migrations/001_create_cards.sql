@@ -0,0 +1,17 @@
+-- Migration: Create cards table
+-- Insight-stream cards, one per agent node per session window (see PostgresStore.UpsertCard).
+-- Previously created by cmd/setup_db; IF NOT EXISTS keeps existing databases intact.
+
+CREATE TABLE IF NOT EXISTS cards (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    owner_id TEXT NOT NULL,
+    card_type TEXT NOT NULL,
+    priority TEXT NOT NULL,
+    source_node TEXT NOT NULL,
+    content JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_cards_owner_created ON cards (owner_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_cards_owner_node ON cards (owner_id, source_node);
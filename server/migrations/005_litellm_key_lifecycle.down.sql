@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_user_litellm_keys_status;
+
+ALTER TABLE user_litellm_keys DROP COLUMN IF EXISTS reconciled_at;
+ALTER TABLE user_litellm_keys DROP COLUMN IF EXISTS revoked_at;
+ALTER TABLE user_litellm_keys DROP COLUMN IF EXISTS rotated_at;
+ALTER TABLE user_litellm_keys DROP COLUMN IF EXISTS status;
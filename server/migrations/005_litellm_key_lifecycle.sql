@@ -0,0 +1,9 @@
+-- Migration: Track LiteLLM key lifecycle
+-- status: active | revoked | missing (deleted on the proxy side, found by reconciliation)
+
+ALTER TABLE user_litellm_keys ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'active';
+ALTER TABLE user_litellm_keys ADD COLUMN IF NOT EXISTS rotated_at TIMESTAMP;
+ALTER TABLE user_litellm_keys ADD COLUMN IF NOT EXISTS revoked_at TIMESTAMP;
+ALTER TABLE user_litellm_keys ADD COLUMN IF NOT EXISTS reconciled_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_user_litellm_keys_status ON user_litellm_keys(status);
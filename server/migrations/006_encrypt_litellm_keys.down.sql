@@ -0,0 +1,17 @@
+-- Keys stored only as ciphertext cannot be decrypted here and would be lost, so refuse to
+-- revert while any exist; revoke or delete those keys first.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM user_litellm_keys WHERE litellm_key IS NULL) THEN
+        RAISE EXCEPTION 'user_litellm_keys has encrypted keys with no plaintext copy';
+    END IF;
+END $$;
+
+DROP INDEX IF EXISTS idx_litellm_kek_id;
+DROP INDEX IF EXISTS idx_litellm_key_hash;
+CREATE INDEX IF NOT EXISTS idx_litellm_key ON user_litellm_keys(litellm_key);
+
+ALTER TABLE user_litellm_keys ALTER COLUMN litellm_key SET NOT NULL;
+ALTER TABLE user_litellm_keys DROP COLUMN IF EXISTS litellm_key_hash;
+ALTER TABLE user_litellm_keys DROP COLUMN IF EXISTS kek_id;
+ALTER TABLE user_litellm_keys DROP COLUMN IF EXISTS litellm_key_ciphertext;
@@ -0,0 +1,19 @@
+-- Migration: Envelope-encrypt LiteLLM keys at rest
+-- litellm_key_ciphertext holds "base64(nonce|wrapped DEK).base64(nonce|ciphertext)" sealed with KEK kek_id.
+-- litellm_key_hash (SHA-256 hex) replaces the plaintext index for reverse lookups.
+-- This migration does not touch key values: SQL has no access to the keyring, so existing plaintext rows
+-- are re-encrypted by the gateway on startup (PostgresStore.ReencryptLiteLLMKeys), which also clears
+-- litellm_key. Until a gateway with LITELLM_KEYRING (or LITELLM_KEYRING_FILE) starts, they stay plaintext.
+
+ALTER TABLE user_litellm_keys ADD COLUMN IF NOT EXISTS litellm_key_ciphertext TEXT;
+ALTER TABLE user_litellm_keys ADD COLUMN IF NOT EXISTS kek_id VARCHAR(50);
+ALTER TABLE user_litellm_keys ADD COLUMN IF NOT EXISTS litellm_key_hash CHAR(64);
+ALTER TABLE user_litellm_keys ALTER COLUMN litellm_key DROP NOT NULL;
+
+UPDATE user_litellm_keys
+SET litellm_key_hash = encode(sha256(convert_to(litellm_key, 'UTF8')), 'hex')
+WHERE litellm_key IS NOT NULL AND litellm_key_hash IS NULL;
+
+DROP INDEX IF EXISTS idx_litellm_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_litellm_key_hash ON user_litellm_keys(litellm_key_hash);
+CREATE INDEX IF NOT EXISTS idx_litellm_kek_id ON user_litellm_keys(kek_id);
@@ -0,0 +1,15 @@
+-- Migration: Record device <-> user links
+-- Written when a device that was used anonymously first authenticates as a user;
+-- its cards are merged into the user's feed in the same transaction.
+
+CREATE TABLE IF NOT EXISTS device_user_links (
+    device_id VARCHAR(255) NOT NULL,
+    user_id VARCHAR(255) NOT NULL,
+    linked_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_merged_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    cards_moved INTEGER NOT NULL DEFAULT 0,
+    cards_dropped INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (device_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_device_user_links_user ON device_user_links(user_id);
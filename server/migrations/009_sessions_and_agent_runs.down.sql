@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS agent_runs;
+DROP TABLE IF EXISTS sessions;
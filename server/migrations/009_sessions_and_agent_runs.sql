@@ -0,0 +1,23 @@
+-- Migration: Persisted conversation sessions and the agent run log
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id VARCHAR(255) PRIMARY KEY,
+    data JSONB NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_updated ON sessions(updated_at);
+
+CREATE TABLE IF NOT EXISTS agent_runs (
+    id VARCHAR(64) PRIMARY KEY,
+    owner_id VARCHAR(255) NOT NULL,
+    agent TEXT NOT NULL,
+    source VARCHAR(20) NOT NULL,          -- chat | schedule
+    status VARCHAR(20) NOT NULL,          -- running | succeeded | failed | cancelled
+    error TEXT NOT NULL DEFAULT '',
+    total_tokens INTEGER NOT NULL DEFAULT 0,
+    started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_agent_runs_owner_started ON agent_runs(owner_id, started_at DESC);
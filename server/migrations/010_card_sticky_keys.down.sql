@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_cards_sticky;
+ALTER TABLE cards DROP COLUMN IF EXISTS sticky_key;
+ALTER TABLE cards DROP COLUMN IF EXISTS run_id;
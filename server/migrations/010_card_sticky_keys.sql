@@ -0,0 +1,14 @@
+-- Migration: Stable sticky card keys
+-- A card is identified by (owner_id, sticky_key, source_node), so UpsertCard can
+-- be a single INSERT ... ON CONFLICT instead of update-then-insert. The key is
+-- the agent run ID, or a fixed time window for updates without one (see
+-- store.StickyPolicy). Existing cards get a key of their own so none collide.
+
+ALTER TABLE cards ADD COLUMN IF NOT EXISTS run_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE cards ADD COLUMN IF NOT EXISTS sticky_key TEXT;
+
+UPDATE cards SET sticky_key = 'legacy:' || id::text WHERE sticky_key IS NULL;
+
+ALTER TABLE cards ALTER COLUMN sticky_key SET NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_cards_sticky ON cards (owner_id, sticky_key, source_node);
@@ -0,0 +1,4 @@
+-- Migration: Index for feed pages
+-- GET /api/feed pages by (updated_at, id) newest first (see store.FeedQuery).
+
+CREATE INDEX IF NOT EXISTS idx_cards_owner_updated ON cards (owner_id, updated_at DESC, id DESC);
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_cards_dismissed;
+DROP INDEX IF EXISTS idx_cards_expires;
+DROP INDEX IF EXISTS idx_cards_owner_feed;
+CREATE INDEX IF NOT EXISTS idx_cards_owner_updated ON cards (owner_id, updated_at DESC, id DESC);
+
+ALTER TABLE cards DROP COLUMN IF EXISTS expires_at;
+ALTER TABLE cards DROP COLUMN IF EXISTS pinned;
+ALTER TABLE cards DROP COLUMN IF EXISTS dismissed_at;
+ALTER TABLE cards DROP COLUMN IF EXISTS read_at;
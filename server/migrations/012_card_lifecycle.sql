@@ -0,0 +1,14 @@
+-- Migration: Card lifecycle
+-- Per-card state set through PATCH /api/feed/{id} (see store.CardPatch).
+-- Dismissed and expired cards are hidden from the feed and purged by the
+-- retention job; pinned cards lead the feed and never expire.
+
+ALTER TABLE cards ADD COLUMN IF NOT EXISTS read_at TIMESTAMPTZ;
+ALTER TABLE cards ADD COLUMN IF NOT EXISTS dismissed_at TIMESTAMPTZ;
+ALTER TABLE cards ADD COLUMN IF NOT EXISTS pinned BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE cards ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+
+DROP INDEX IF EXISTS idx_cards_owner_updated;
+CREATE INDEX IF NOT EXISTS idx_cards_owner_feed ON cards (owner_id, pinned DESC, updated_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_cards_expires ON cards (expires_at) WHERE expires_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_cards_dismissed ON cards (dismissed_at) WHERE dismissed_at IS NOT NULL;
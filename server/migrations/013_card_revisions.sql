@@ -0,0 +1,18 @@
+-- Migration: Card revisions
+-- Each card keeps the content every agent run left it showing, newest last,
+-- for GET /api/feed/{id}/history (see store.CardRevision). UpsertCard updates
+-- the run's revision in place and keeps the latest 50 per card.
+
+CREATE TABLE IF NOT EXISTS card_revisions (
+    id BIGSERIAL PRIMARY KEY,
+    card_id UUID NOT NULL REFERENCES cards (id) ON DELETE CASCADE,
+    run_id TEXT NOT NULL DEFAULT '',
+    agent_version TEXT NOT NULL DEFAULT '',
+    card_type TEXT NOT NULL,
+    priority TEXT NOT NULL,
+    content JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_card_revisions_card ON card_revisions (card_id, id DESC);
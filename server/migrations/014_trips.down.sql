@@ -0,0 +1,6 @@
+ALTER TABLE agent_runs DROP COLUMN IF EXISTS trip_id;
+
+DROP INDEX IF EXISTS idx_cards_owner_trip;
+ALTER TABLE cards DROP COLUMN IF EXISTS trip_id;
+
+DROP TABLE IF EXISTS trips;
@@ -0,0 +1,19 @@
+-- Migration: Trips
+-- A typed trip per owner (see store.TripRecord and pkg/trip), replacing trip
+-- details kept only in free-form session variables. Cards and agent runs
+-- reference the trip they belong to; '' means none.
+
+CREATE TABLE IF NOT EXISTS trips (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    owner_id TEXT NOT NULL,
+    data JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_trips_owner_updated ON trips (owner_id, updated_at DESC);
+
+ALTER TABLE cards ADD COLUMN IF NOT EXISTS trip_id TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_cards_owner_trip ON cards (owner_id, trip_id);
+
+ALTER TABLE agent_runs ADD COLUMN IF NOT EXISTS trip_id TEXT NOT NULL DEFAULT '';
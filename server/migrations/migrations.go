@@ -0,0 +1,13 @@
+// Package migrations embeds the numbered SQL schema migrations applied by
+// pkg/migrate. Files are named NNN_description.sql, with an optional
+// NNN_description.down.sql that reverts them. Versions need not be contiguous
+// (there is no 002). Never edit a migration that has shipped: its checksum is
+// recorded when applied. Add a new one instead.
+package migrations
+
+import "embed"
+
+// FS holds every *.sql file in this directory
+//
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,136 @@
+// Package agentinput renders the input document a chat hands an agent: the
+// current date, the trip's fields in a fixed order and the user's note. The
+// same trip always renders the same document, so runs are reproducible and
+// agent-side caches can key on the input.
+package agentinput
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"guardian-gateway/pkg/variables"
+)
+
+// Format is how an agent reads its input
+type Format string
+
+const (
+	FormatText Format = "text" // "- Field: value" lines
+	FormatJSON Format = "json" // One JSON object
+)
+
+// Missing stands in for an expected field with no value in text input; agents
+// are prompted to ask for MISSING details rather than invent them
+const Missing = "MISSING"
+
+// Shape is the input an agent expects: its fields in order, and the format
+type Shape struct {
+	Fields []string
+	Format Format
+}
+
+// ParseFormat reads an agent's declared input format; "" is FormatText
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(strings.TrimSpace(s))); f {
+	case "", FormatText:
+		return FormatText, nil
+	case FormatJSON:
+		return FormatJSON, nil
+	default:
+		return "", fmt.Errorf("unknown input format %q", s)
+	}
+}
+
+// Build renders the input for shape from values (trip fields by name). The
+// shape's fields come first, in order, and are always present: MISSING in
+// text, null in JSON. Other values follow sorted by name. Field names match
+// values ignoring case, spaces, underscores and hyphens.
+func Build(shape Shape, values map[string]string, now time.Time, note string) string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// Of names that fold together, the first in sorted order wins
+	byKey := make(map[string]string, len(values))
+	for _, name := range names {
+		k := variables.FoldKey(name)
+		if _, dup := byKey[k]; dup {
+			continue
+		}
+		if v := strings.TrimSpace(values[name]); v != "" {
+			byKey[k] = v
+		}
+	}
+
+	type field struct {
+		name  string
+		value string
+		set   bool
+	}
+	var fields []field
+	seen := make(map[string]bool)
+	for _, name := range shape.Fields {
+		k := variables.FoldKey(name)
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		v, ok := byKey[k]
+		fields = append(fields, field{name: name, value: v, set: ok})
+	}
+	for _, name := range names {
+		if k := variables.FoldKey(name); !seen[k] && byKey[k] != "" {
+			seen[k] = true
+			fields = append(fields, field{name: name, value: byKey[k], set: true})
+		}
+	}
+
+	date := now.Format("2006-01-02")
+	if shape.Format == FormatJSON {
+		var b bytes.Buffer
+		b.WriteString(`{"current_date":` + quote(date) + `,"trip":{`)
+		for i, f := range fields {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(quote(jsonKey(f.name)) + ":")
+			if f.set {
+				b.WriteString(quote(f.value))
+			} else {
+				b.WriteString("null")
+			}
+		}
+		b.WriteString(`},"note":` + quote(note) + "}")
+		return b.String()
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Current System Date: %s.\n", date)
+	b.WriteString("Trip Details:\n")
+	for _, f := range fields {
+		v := f.value
+		if !f.set {
+			v = Missing
+		}
+		fmt.Fprintf(&b, "- %s: %s\n", f.name, v)
+	}
+	fmt.Fprintf(&b, "\nUser Note: %s", note)
+	return b.String()
+}
+
+func quote(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// jsonKey turns a field name into a snake_case key: "Start Date" is start_date
+func jsonKey(name string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return r == ' ' || r == '_' || r == '-' || r == '/'
+	}), "_")
+}
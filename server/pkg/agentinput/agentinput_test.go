@@ -0,0 +1,50 @@
+package agentinput
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuild(t *testing.T) {
+	now := time.Date(2026, 6, 1, 9, 0, 0, 0, time.UTC)
+	values := map[string]string{
+		"Mode":        "walking",
+		"start_date":  "2026-06-10",
+		"Destination": "Lisbon",
+		"Interests":   "food, fado",
+		"Budget":      " ",
+	}
+	shape := Shape{Fields: []string{"Destination", "Start Date", "Budget"}}
+
+	text := Build(shape, values, now, "see the fado")
+	assert.Equal(t, "Current System Date: 2026-06-01.\n"+
+		"Trip Details:\n"+
+		"- Destination: Lisbon\n"+
+		"- Start Date: 2026-06-10\n"+
+		"- Budget: MISSING\n"+
+		"- Interests: food, fado\n"+
+		"- Mode: walking\n"+
+		"\nUser Note: see the fado", text)
+	for i := 0; i < 20; i++ {
+		require.Equal(t, text, Build(shape, values, now, "see the fado"), "output does not depend on map order")
+	}
+
+	shape.Format = FormatJSON
+	doc := Build(shape, values, now, `say "olá"`)
+	assert.Equal(t, `{"current_date":"2026-06-01","trip":{"destination":"Lisbon","start_date":"2026-06-10","budget":null,"interests":"food, fado","mode":"walking"},"note":"say \"olá\""}`, doc)
+	assert.True(t, json.Valid([]byte(doc)))
+}
+
+func TestParseFormat(t *testing.T) {
+	for in, want := range map[string]Format{"": FormatText, "text": FormatText, " JSON ": FormatJSON} {
+		got, err := ParseFormat(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+	_, err := ParseFormat("xml")
+	assert.Error(t, err)
+}
@@ -0,0 +1,222 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var b64 = base64.RawURLEncoding
+
+func segment(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	return b64.EncodeToString(data)
+}
+
+func claims(sub string, exp time.Time) map[string]interface{} {
+	return map[string]interface{}{"sub": sub, "exp": exp.Unix(), "iss": "https://market.niyogen.com", "aud": "guardian"}
+}
+
+func signHS256(t *testing.T, secret string, header, body map[string]interface{}) string {
+	t.Helper()
+	signed := segment(t, header) + "." + segment(t, body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + b64.EncodeToString(mac.Sum(nil))
+}
+
+func signRS256(t *testing.T, key *rsa.PrivateKey, kid string, body map[string]interface{}) string {
+	t.Helper()
+	signed := segment(t, map[string]string{"alg": "RS256", "kid": kid}) + "." + segment(t, body)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	require.NoError(t, err)
+	return signed + "." + b64.EncodeToString(sig)
+}
+
+func signES256(t *testing.T, key *ecdsa.PrivateKey, kid string, body map[string]interface{}) string {
+	t.Helper()
+	signed := segment(t, map[string]string{"alg": "ES256", "kid": kid}) + "." + segment(t, body)
+	sum := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, key, sum[:])
+	require.NoError(t, err)
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return signed + "." + b64.EncodeToString(sig)
+}
+
+func TestJWTVerifier_SharedSecret(t *testing.T) {
+	v := &JWTVerifier{Keys: SharedSecret("s3cret"), Issuer: "https://market.niyogen.com", Audience: "guardian"}
+	hs := map[string]interface{}{"alg": "HS256", "typ": "JWT"}
+	ctx := context.Background()
+
+	c, err := v.Verify(ctx, signHS256(t, "s3cret", hs, claims("user-1", time.Now().Add(time.Hour))))
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", c.Subject)
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"wrong secret", signHS256(t, "other", hs, claims("user-1", time.Now().Add(time.Hour)))},
+		{"expired", signHS256(t, "s3cret", hs, claims("user-1", time.Now().Add(-time.Hour)))},
+		{"alg none", segment(t, map[string]string{"alg": "none"}) + "." + segment(t, claims("user-1", time.Now().Add(time.Hour))) + "."},
+		{"alg RS256 with secret", signHS256(t, "s3cret", map[string]interface{}{"alg": "RS256"}, claims("user-1", time.Now().Add(time.Hour)))},
+		{"wrong issuer", signHS256(t, "s3cret", hs, map[string]interface{}{"sub": "u", "exp": time.Now().Add(time.Hour).Unix(), "iss": "evil", "aud": "guardian"})},
+		{"wrong audience", signHS256(t, "s3cret", hs, map[string]interface{}{"sub": "u", "exp": time.Now().Add(time.Hour).Unix(), "iss": "https://market.niyogen.com", "aud": []string{"other"}})},
+		{"missing exp", signHS256(t, "s3cret", hs, map[string]interface{}{"sub": "u", "iss": "https://market.niyogen.com", "aud": "guardian"})},
+		{"malformed", "not-a-jwt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.Verify(ctx, tt.token)
+			assert.ErrorIs(t, err, ErrInvalidToken)
+		})
+	}
+}
+
+func TestJWTVerifier_JWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	var fetches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa-1", "alg": "RS256", "use": "sig",
+				"n": b64.EncodeToString(rsaKey.N.Bytes()), "e": b64.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec-1", "crv": "P-256",
+				"x": b64.EncodeToString(ecKey.X.Bytes()), "y": b64.EncodeToString(ecKey.Y.Bytes())},
+		}})
+	}))
+	defer srv.Close()
+
+	v := &JWTVerifier{Keys: NewJWKS(srv.URL)}
+	ctx := context.Background()
+	body := claims("user-2", time.Now().Add(time.Hour))
+
+	c, err := v.Verify(ctx, signRS256(t, rsaKey, "rsa-1", body))
+	require.NoError(t, err)
+	assert.Equal(t, "user-2", c.Subject)
+
+	_, err = v.Verify(ctx, signES256(t, ecKey, "ec-1", body))
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches), "key set should be cached")
+
+	// Key/alg mismatches and HMAC tokens are rejected
+	_, err = v.Verify(ctx, signRS256(t, rsaKey, "ec-1", body))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, err = v.Verify(ctx, signHS256(t, "x", map[string]interface{}{"alg": "HS256", "kid": "rsa-1"}, body))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	// Unknown kids don't trigger a refetch inside the refresh window
+	_, err = v.Verify(ctx, signRS256(t, rsaKey, "rotated", body))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+}
+
+func TestAuthenticator(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	a := &Authenticator{Verifier: &JWTVerifier{Keys: SharedSecret("s3cret")}, AllowAnonymous: true}
+	valid := signHS256(t, "s3cret", map[string]interface{}{"alg": "HS256"}, claims("user-1", time.Now().Add(time.Hour)))
+
+	newRouter := func(mw gin.HandlerFunc) *gin.Engine {
+		r := gin.New()
+		r.GET("/", mw, func(c *gin.Context) {
+			p, ok := FromContext(c)
+			require.True(t, ok)
+			ctxP, ok := PrincipalFrom(c.Request.Context())
+			require.True(t, ok)
+			assert.Same(t, p, ctxP)
+			c.String(http.StatusOK, p.OwnerID())
+		})
+		return r
+	}
+	do := func(r *gin.Engine, setup func(*http.Request)) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		setup(req)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	anon := newRouter(a.Anonymous())
+	required := newRouter(a.Required())
+
+	t.Run("bearer token", func(t *testing.T) {
+		w := do(required, func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+valid) })
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "user-1", w.Body.String())
+	})
+
+	t.Run("session cookie", func(t *testing.T) {
+		w := do(required, func(r *http.Request) { r.AddCookie(&http.Cookie{Name: DefaultCookieName, Value: valid}) })
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "user-1", w.Body.String())
+	})
+
+	t.Run("spoofed user header is ignored", func(t *testing.T) {
+		w := do(required, func(r *http.Request) { r.Header.Set("X-User-ID", "user-1") })
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		w = do(anon, func(r *http.Request) {
+			r.Header.Set("X-User-ID", "user-1")
+			r.Header.Set("X-Device-ID", "device-1234")
+		})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "device:device-1234", w.Body.String())
+	})
+
+	t.Run("device ID only on anonymous routes", func(t *testing.T) {
+		w := do(required, func(r *http.Request) { r.Header.Set("X-Device-ID", "device-1234") })
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("device ID cannot impersonate a user", func(t *testing.T) {
+		w := do(anon, func(r *http.Request) { r.Header.Set("X-Device-ID", "user-0001") })
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "device:user-0001", w.Body.String())
+	})
+
+	t.Run("invalid token is not downgraded to anonymous", func(t *testing.T) {
+		w := do(anon, func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer "+valid+"x")
+			r.Header.Set("X-Device-ID", "device-1234")
+		})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Contains(t, w.Header().Get("WWW-Authenticate"), "invalid_token")
+	})
+
+	t.Run("no credentials", func(t *testing.T) {
+		w := do(anon, func(r *http.Request) {})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("anonymous access disabled", func(t *testing.T) {
+		strict := &Authenticator{Verifier: a.Verifier}
+		w := do(newRouter(strict.Anonymous()), func(r *http.Request) { r.Header.Set("X-Device-ID", "device-1234") })
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
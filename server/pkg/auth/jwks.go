@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultJWKSTTL = 10 * time.Minute
+	// minJWKSRefresh bounds refetches triggered by unknown kids, so forged
+	// tokens can't make us hammer the auth service
+	minJWKSRefresh = 30 * time.Second
+)
+
+// JWKS is a KeySource backed by a JSON Web Key Set URL. Keys are cached and
+// refreshed when the TTL expires or a token names an unknown kid.
+type JWKS struct {
+	URL        string
+	TTL        time.Duration
+	HTTPClient *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]jwk
+	fetchedAt time.Time
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+
+	pub interface{}
+}
+
+// NewJWKS creates a JWKS key source for url
+func NewJWKS(url string) *JWKS {
+	return &JWKS{
+		URL:        url,
+		TTL:        defaultJWKSTTL,
+		HTTPClient: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Key returns the public key for kid, refreshing the set when needed
+func (j *JWKS) Key(ctx context.Context, alg, kid string) (interface{}, error) {
+	if !strings.HasPrefix(alg, "RS") && !strings.HasPrefix(alg, "ES") {
+		return nil, fmt.Errorf("alg %s not allowed with JWKS", alg)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	ttl := j.TTL
+	if ttl <= 0 {
+		ttl = defaultJWKSTTL
+	}
+	stale := time.Since(j.fetchedAt) > ttl
+	k, found := j.lookup(kid)
+	if stale || (!found && time.Since(j.fetchedAt) > minJWKSRefresh) {
+		if err := j.refresh(ctx); err != nil && j.keys == nil {
+			return nil, err
+		}
+		k, found = j.lookup(kid)
+	}
+	if !found {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if k.Alg != "" && k.Alg != alg {
+		return nil, fmt.Errorf("alg %s does not match key %q", alg, kid)
+	}
+	if (k.Kty == "RSA") != strings.HasPrefix(alg, "RS") {
+		return nil, fmt.Errorf("alg %s does not match key type %s", alg, k.Kty)
+	}
+	return k.pub, nil
+}
+
+func (j *JWKS) lookup(kid string) (jwk, bool) {
+	if kid == "" && len(j.keys) == 1 {
+		for _, k := range j.keys {
+			return k, true
+		}
+	}
+	k, ok := j.keys[kid]
+	return k, ok
+}
+
+// refresh fetches the key set. Callers must hold j.mu.
+func (j *JWKS) refresh(ctx context.Context) error {
+	// Record the attempt first so a failing endpoint is not retried per request
+	j.fetchedAt = time.Now()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.URL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create JWKS request: %w", err)
+	}
+	resp, err := j.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("JWKS endpoint returned status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]jwk, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			continue // Skip key types we don't support
+		}
+		k.pub = pub
+		keys[k.Kid] = k
+	}
+	j.keys = keys
+	return nil
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("rsa exponent out of range")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,234 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// ErrInvalidToken is returned for any token that fails verification
+var ErrInvalidToken = errors.New("invalid token")
+
+// Verifier checks a bearer token or session cookie and returns its claims
+type Verifier interface {
+	Verify(ctx context.Context, token string) (*Claims, error)
+}
+
+// KeySource resolves the verification key for a token's alg and kid.
+// Implementations must reject algs that don't match their key type, which is
+// what prevents alg-confusion attacks.
+type KeySource interface {
+	Key(ctx context.Context, alg, kid string) (interface{}, error)
+}
+
+// Audience is the "aud" claim, which may be a string or an array
+type Audience []string
+
+// UnmarshalJSON accepts both forms of the aud claim
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// Claims are the registered and profile claims we read from tokens
+type Claims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss"`
+	Audience  Audience `json:"aud"`
+	ExpiresAt float64  `json:"exp"`
+	NotBefore float64  `json:"nbf"`
+	IssuedAt  float64  `json:"iat"`
+	Email     string   `json:"email"`
+	Name      string   `json:"name"`
+}
+
+// Expiry returns exp as a time
+func (c *Claims) Expiry() time.Time {
+	return time.Unix(int64(c.ExpiresAt), 0)
+}
+
+// JWTVerifier verifies compact JWS tokens (HS*, RS*, ES*)
+type JWTVerifier struct {
+	Keys     KeySource
+	Issuer   string // Required iss when set
+	Audience string // Required aud member when set
+	Leeway   time.Duration
+	Now      func() time.Time
+}
+
+// Verify checks the signature and the exp, nbf, iss and aud claims
+func (v *JWTVerifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrInvalidToken, err)
+	}
+	if header.Alg == "" || strings.EqualFold(header.Alg, "none") {
+		return nil, fmt.Errorf("%w: unsigned token", ErrInvalidToken)
+	}
+
+	key, err := v.Keys.Key(ctx, header.Alg, header.Kid)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature encoding", ErrInvalidToken)
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrInvalidToken, err)
+	}
+	if err := v.validate(&claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return &claims, nil
+}
+
+func (v *JWTVerifier) validate(c *Claims) error {
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+	if c.Subject == "" {
+		return errors.New("missing sub")
+	}
+	if c.ExpiresAt == 0 {
+		return errors.New("missing exp")
+	}
+	if now.After(c.Expiry().Add(v.Leeway)) {
+		return errors.New("token expired")
+	}
+	if c.NotBefore != 0 && now.Add(v.Leeway).Before(time.Unix(int64(c.NotBefore), 0)) {
+		return errors.New("token not yet valid")
+	}
+	if v.Issuer != "" && c.Issuer != v.Issuer {
+		return fmt.Errorf("unexpected issuer %q", c.Issuer)
+	}
+	if v.Audience != "" {
+		found := false
+		for _, aud := range c.Audience {
+			if aud == v.Audience {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errors.New("audience mismatch")
+		}
+	}
+	return nil
+}
+
+// SharedSecret is a KeySource for HMAC-signed tokens
+type SharedSecret []byte
+
+// Key returns the secret for HS256/384/512 and rejects every other alg
+func (s SharedSecret) Key(_ context.Context, alg, _ string) (interface{}, error) {
+	if !strings.HasPrefix(alg, "HS") {
+		return nil, fmt.Errorf("alg %s not allowed with a shared secret", alg)
+	}
+	return []byte(s), nil
+}
+
+func verifySignature(alg string, key interface{}, signed, sig []byte) error {
+	hash, err := hashFor(alg)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToUpper(alg[:2]) {
+	case "HS":
+		secret, ok := key.([]byte)
+		if !ok || len(secret) == 0 {
+			return errors.New("key type mismatch")
+		}
+		mac := hmac.New(hash.New, secret)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return errors.New("signature mismatch")
+		}
+		return nil
+	case "RS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("key type mismatch")
+		}
+		h := hash.New()
+		h.Write(signed)
+		if err := rsa.VerifyPKCS1v15(pub, hash, h.Sum(nil), sig); err != nil {
+			return errors.New("signature mismatch")
+		}
+		return nil
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("key type mismatch")
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errors.New("signature mismatch")
+		}
+		h := hash.New()
+		h.Write(signed)
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, h.Sum(nil), r, s) {
+			return errors.New("signature mismatch")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported alg %s", alg)
+}
+
+func hashFor(alg string) (crypto.Hash, error) {
+	if len(alg) != 5 {
+		return 0, fmt.Errorf("unsupported alg %s", alg)
+	}
+	switch alg[2:] {
+	case "256":
+		return crypto.SHA256, nil
+	case "384":
+		return crypto.SHA384, nil
+	case "512":
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("unsupported alg %s", alg)
+}
+
+func decodeSegment(seg string, out interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultCookieName is the session cookie set by the market.niyogen.com auth service
+const DefaultCookieName = "session"
+
+// deviceIDPattern accepts the random IDs clients generate (UUIDs and similar)
+var deviceIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{8,128}$`)
+
+// Authenticator turns request credentials into a Principal
+type Authenticator struct {
+	// Verifier checks bearer tokens and session cookies. When nil no user can
+	// authenticate and only anonymous routes are reachable.
+	Verifier Verifier
+	// CookieName is the session cookie read when there is no Authorization header
+	CookieName string
+	// AllowAnonymous enables device-ID access on routes that opt into it
+	AllowAnonymous bool
+}
+
+// Required rejects requests without a verified user token
+func (a *Authenticator) Required() gin.HandlerFunc {
+	return a.handler(false)
+}
+
+// Anonymous accepts a verified user token or, failing that, an X-Device-ID.
+// Only routes that explicitly support anonymous use should be mounted with it.
+func (a *Authenticator) Anonymous() gin.HandlerFunc {
+	return a.handler(true)
+}
+
+func (a *Authenticator) handler(anonymous bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		deviceID := strings.TrimSpace(c.GetHeader("X-Device-ID"))
+		if deviceID != "" && !deviceIDPattern.MatchString(deviceID) {
+			deviceID = ""
+		}
+
+		if token := a.token(c); token != "" {
+			if a.Verifier == nil {
+				abortUnauthorized(c, "Authentication is not configured")
+				return
+			}
+			claims, err := a.Verifier.Verify(c.Request.Context(), token)
+			if err != nil {
+				c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+				abortUnauthorized(c, "Invalid or expired credentials")
+				return
+			}
+			SetPrincipal(c, &Principal{
+				Kind:      KindUser,
+				UserID:    claims.Subject,
+				DeviceID:  deviceID,
+				Email:     claims.Email,
+				Name:      claims.Name,
+				ExpiresAt: claims.Expiry(),
+			})
+			c.Next()
+			return
+		}
+
+		if anonymous && a.AllowAnonymous && deviceID != "" {
+			SetPrincipal(c, &Principal{Kind: KindAnonymous, DeviceID: deviceID})
+			c.Next()
+			return
+		}
+
+		c.Header("WWW-Authenticate", "Bearer")
+		abortUnauthorized(c, "Authentication required")
+	}
+}
+
+// token returns the bearer token, falling back to the session cookie
+func (a *Authenticator) token(c *gin.Context) string {
+	if h := c.GetHeader("Authorization"); h != "" {
+		if len(h) > 7 && strings.EqualFold(h[:7], "bearer ") {
+			return strings.TrimSpace(h[7:])
+		}
+		return ""
+	}
+	name := a.CookieName
+	if name == "" {
+		name = DefaultCookieName
+	}
+	if v, err := c.Cookie(name); err == nil {
+		return v
+	}
+	return ""
+}
+
+func abortUnauthorized(c *gin.Context, msg string) {
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": msg})
+}
@@ -0,0 +1,93 @@
+// Package auth verifies callers and exposes who they are to handlers as a
+// typed Principal.
+package auth
+
+import (
+	"context"
+	"time"
+
+	"guardian-gateway/pkg/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Kind distinguishes verified users from anonymous devices
+type Kind string
+
+const (
+	KindUser      Kind = "user"
+	KindAnonymous Kind = "anonymous"
+)
+
+// anonymousPrefix namespaces device owners so a device ID can never collide
+// with (and read the data of) a real user ID
+const anonymousPrefix = "device:"
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	Kind      Kind      `json:"kind"`
+	UserID    string    `json:"user_id,omitempty"`   // Verified token subject; empty for anonymous callers
+	DeviceID  string    `json:"device_id,omitempty"` // X-Device-ID as sent by the client (not verified)
+	Email     string    `json:"email,omitempty"`
+	Name      string    `json:"name,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// Authenticated reports whether the principal carries a verified user identity
+func (p *Principal) Authenticated() bool {
+	return p != nil && p.Kind == KindUser && p.UserID != ""
+}
+
+// OwnerID is the key the caller's cards, session and usage are stored under
+func (p *Principal) OwnerID() string {
+	if p == nil {
+		return ""
+	}
+	if p.Authenticated() {
+		return p.UserID
+	}
+	return AnonymousOwnerID(p.DeviceID)
+}
+
+// AnonymousOwnerID returns the owner ID used for an anonymous device
+func AnonymousOwnerID(deviceID string) string {
+	if deviceID == "" {
+		return ""
+	}
+	return anonymousPrefix + deviceID
+}
+
+const ginPrincipalKey = "auth.principal"
+
+type principalCtxKey struct{}
+
+// SetPrincipal stores p in the gin context and in the request context, so code
+// that only sees a context.Context can still find the caller
+func SetPrincipal(c *gin.Context, p *Principal) {
+	c.Set(ginPrincipalKey, p)
+	if c.Request != nil {
+		ctx := logging.WithOwnerID(WithPrincipal(c.Request.Context(), p), p.OwnerID())
+		c.Request = c.Request.WithContext(ctx)
+	}
+}
+
+// FromContext returns the principal set by the middleware
+func FromContext(c *gin.Context) (*Principal, bool) {
+	v, ok := c.Get(ginPrincipalKey)
+	if !ok {
+		return nil, false
+	}
+	p, ok := v.(*Principal)
+	return p, ok && p != nil
+}
+
+// WithPrincipal returns a copy of ctx carrying p
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalCtxKey{}, p)
+}
+
+// PrincipalFrom returns the principal carried by ctx
+func PrincipalFrom(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalCtxKey{}).(*Principal)
+	return p, ok && p != nil
+}
@@ -0,0 +1,466 @@
+// Package config loads the gateway's configuration from defaults, an optional
+// YAML file and the environment (including .env), in increasing precedence.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"guardian-gateway/pkg/logging"
+	"guardian-gateway/pkg/ratelimit"
+)
+
+// Config is the complete, typed server configuration. The env tag names the
+// variable that overrides each field; secret fields are redacted when printed.
+type Config struct {
+	Server    ServerConfig    `yaml:"server"`
+	CORS      CORSConfig      `yaml:"cors"`
+	Database  DatabaseConfig  `yaml:"database"`
+	Feed      FeedConfig      `yaml:"feed"`
+	LiteLLM   LiteLLMConfig   `yaml:"litellm"`
+	Auth      AuthConfig      `yaml:"auth"`
+	Admin     AdminConfig     `yaml:"admin"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	Session   SessionConfig   `yaml:"session"`
+	Agent     AgentConfig     `yaml:"agent"`
+	Memory    MemoryConfig    `yaml:"memory"`
+	Unsplash  UnsplashConfig  `yaml:"unsplash"`
+	Geocoding GeocodingConfig `yaml:"geocoding"`
+	Features  FeaturesConfig  `yaml:"features"`
+	Log       LogConfig       `yaml:"log"`
+	Tracing   TracingConfig   `yaml:"tracing"`
+	Health    HealthConfig    `yaml:"health"`
+}
+
+type ServerConfig struct {
+	Port              int           `yaml:"port" env:"PORT"`
+	TLSCertPath       string        `yaml:"tls_cert_path" env:"SSL_CERT_PATH"`
+	TLSKeyPath        string        `yaml:"tls_key_path" env:"SSL_KEY_PATH"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" env:"SERVER_READ_HEADER_TIMEOUT"`
+	ReadTimeout       time.Duration `yaml:"read_timeout" env:"SERVER_READ_TIMEOUT"`
+	// WriteTimeout bounds whole responses, including SSE streams; 0 disables it
+	WriteTimeout   time.Duration `yaml:"write_timeout" env:"SERVER_WRITE_TIMEOUT"`
+	IdleTimeout    time.Duration `yaml:"idle_timeout" env:"SERVER_IDLE_TIMEOUT"`
+	TrustedProxies []string      `yaml:"trusted_proxies" env:"TRUSTED_PROXIES"`
+	// ShutdownTimeout is how long in-flight chat streams and agent runs may
+	// finish after SIGTERM before they are cancelled
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SERVER_SHUTDOWN_TIMEOUT"`
+}
+
+type CORSConfig struct {
+	AllowedOrigins   []string `yaml:"allowed_origins" env:"CORS_ALLOWED_ORIGINS"`
+	AllowCredentials bool     `yaml:"allow_credentials" env:"CORS_ALLOW_CREDENTIALS"`
+}
+
+type DatabaseConfig struct {
+	// Driver selects the store: postgres (production), sqlite (single node,
+	// offline) or memory (nothing persists; local development)
+	Driver         string        `yaml:"driver" env:"DATABASE_DRIVER"`
+	URL            string        `yaml:"url" env:"DATABASE_URL" secret:"true"`
+	Path           string        `yaml:"path" env:"DATABASE_PATH"` // SQLite file
+	ConnectTimeout time.Duration `yaml:"connect_timeout" env:"DATABASE_CONNECT_TIMEOUT"`
+	// MigrateOnStartup applies pending migrations before the store is used
+	// (replicas serialise on an advisory lock); otherwise run "guardian-gateway migrate up"
+	MigrateOnStartup bool `yaml:"migrate_on_startup" env:"DATABASE_MIGRATE_ON_STARTUP"`
+}
+
+type FeedConfig struct {
+	// StickyWindow groups card updates made outside an agent run: within one
+	// window a node keeps updating its card (see store.StickyPolicy)
+	StickyWindow time.Duration `yaml:"sticky_window" env:"FEED_STICKY_WINDOW"`
+	// FlushInterval is how long streamed chunks for a node are coalesced
+	// before its card is written; 0 writes on every chunk
+	FlushInterval time.Duration `yaml:"flush_interval" env:"FEED_FLUSH_INTERVAL"`
+	// RetentionInterval is how often expired and dismissed cards are purged; 0 disables
+	RetentionInterval time.Duration `yaml:"retention_interval" env:"FEED_RETENTION_INTERVAL"`
+	// DismissedRetention keeps dismissed cards this long before they are purged
+	DismissedRetention time.Duration `yaml:"dismissed_retention" env:"FEED_DISMISSED_RETENTION"`
+}
+
+type LiteLLMConfig struct {
+	ProxyURL               string        `yaml:"proxy_url" env:"LITELLM_PROXY_URL"`
+	APIKey                 string        `yaml:"api_key" env:"LITELLM_API_KEY" secret:"true"`
+	MasterKey              string        `yaml:"master_key" env:"LITELLM_MASTER_KEY" secret:"true"`
+	Model                  string        `yaml:"model" env:"LITELLM_MODEL"`
+	Timeout                time.Duration `yaml:"timeout" env:"LITELLM_TIMEOUT"`
+	Keyring                string        `yaml:"keyring" env:"LITELLM_KEYRING" secret:"true"`
+	KeyringFile            string        `yaml:"keyring_file" env:"LITELLM_KEYRING_FILE"`
+	KeyReconcileInterval   time.Duration `yaml:"key_reconcile_interval" env:"KEY_RECONCILE_INTERVAL"`
+	BudgetWarningThreshold float64       `yaml:"budget_warning_threshold" env:"BUDGET_WARNING_THRESHOLD"`
+	// AllowPlaintextKeys lets the postgres and sqlite drivers run without a
+	// keyring, storing LiteLLM keys unencrypted
+	AllowPlaintextKeys bool `yaml:"allow_plaintext_keys" env:"LITELLM_ALLOW_PLAINTEXT_KEYS"`
+}
+
+type AuthConfig struct {
+	JWKSURL        string        `yaml:"jwks_url" env:"AUTH_JWKS_URL"`
+	JWTSecret      string        `yaml:"jwt_secret" env:"AUTH_JWT_SECRET" secret:"true"`
+	Issuer         string        `yaml:"issuer" env:"AUTH_ISSUER"`
+	Audience       string        `yaml:"audience" env:"AUTH_AUDIENCE"`
+	CookieName     string        `yaml:"cookie_name" env:"AUTH_COOKIE_NAME"`
+	ClockSkew      time.Duration `yaml:"clock_skew" env:"AUTH_CLOCK_SKEW"`
+	AllowAnonymous bool          `yaml:"allow_anonymous" env:"AUTH_ALLOW_ANONYMOUS"`
+}
+
+type AdminConfig struct {
+	APIKey string `yaml:"api_key" env:"ADMIN_API_KEY" secret:"true"`
+	// MetricsToken, when set, is required as a bearer token to scrape /metrics
+	MetricsToken string `yaml:"metrics_token" env:"METRICS_TOKEN" secret:"true"`
+}
+
+type RateLimitConfig struct {
+	Enabled bool   `yaml:"enabled" env:"RATE_LIMIT_ENABLED"`
+	Backend string `yaml:"backend" env:"RATE_LIMIT_BACKEND"` // memory | postgres
+	Chat    string `yaml:"chat" env:"RATE_LIMIT_CHAT"`
+	ChatIP  string `yaml:"chat_ip" env:"RATE_LIMIT_CHAT_IP"`
+	Agent   string `yaml:"agent" env:"RATE_LIMIT_AGENT"`
+	AgentIP string `yaml:"agent_ip" env:"RATE_LIMIT_AGENT_IP"`
+	Feed    string `yaml:"feed" env:"RATE_LIMIT_FEED"`
+	FeedIP  string `yaml:"feed_ip" env:"RATE_LIMIT_FEED_IP"`
+}
+
+type SessionConfig struct {
+	SummaryMaxMessages int `yaml:"summary_max_messages" env:"SESSION_SUMMARY_MAX_MESSAGES"`
+	SummaryKeepRecent  int `yaml:"summary_keep_recent" env:"SESSION_SUMMARY_KEEP_RECENT"`
+}
+
+type AgentConfig struct {
+	// Path is the pre-deployed agent loaded at startup and used when a chat names none
+	Path string `yaml:"path" env:"AGENT_PATH"`
+	// StopGrace is how long a cancelled agent's process group has between SIGTERM and SIGKILL
+	StopGrace time.Duration `yaml:"stop_grace" env:"AGENT_STOP_GRACE"`
+	// GoogleMapsKey is handed to agent runs for their Maps tools
+	GoogleMapsKey string `yaml:"google_maps_key" env:"GOOGLE_MAPS_KEY" secret:"true"`
+}
+
+type MemoryConfig struct {
+	VertexProjectID  string `yaml:"vertex_project_id" env:"VERTEX_PROJECT_ID"`
+	VertexLocation   string `yaml:"vertex_location" env:"VERTEX_LOCATION"`
+	VertexCorpusName string `yaml:"vertex_corpus_name" env:"VERTEX_CORPUS_NAME"`
+}
+
+type UnsplashConfig struct {
+	AccessKey string `yaml:"access_key" env:"UNSPLASH_ACCESS_KEY" secret:"true"`
+}
+
+type GeocodingConfig struct {
+	// NominatimURL is a Nominatim-compatible service, e.g.
+	// https://nominatim.openstreetmap.org; empty geocodes offline from the
+	// bundled gazetteer only
+	NominatimURL string `yaml:"nominatim_url" env:"GEOCODING_NOMINATIM_URL"`
+	// UserAgent and Email identify the gateway to the service, as the public
+	// Nominatim usage policy requires
+	UserAgent string        `yaml:"user_agent" env:"GEOCODING_USER_AGENT"`
+	Email     string        `yaml:"email" env:"GEOCODING_EMAIL"`
+	Timeout   time.Duration `yaml:"timeout" env:"GEOCODING_TIMEOUT"`
+	// MinInterval spaces requests to the service (the public one allows 1/s)
+	MinInterval time.Duration `yaml:"min_interval" env:"GEOCODING_MIN_INTERVAL"`
+	// CacheTTL is how long a resolved place name is remembered
+	CacheTTL time.Duration `yaml:"cache_ttl" env:"GEOCODING_CACHE_TTL"`
+}
+
+type FeaturesConfig struct {
+	Swagger        bool `yaml:"swagger" env:"FEATURE_SWAGGER"`
+	Scheduler      bool `yaml:"scheduler" env:"FEATURE_SCHEDULER"`
+	UnsplashImages bool `yaml:"unsplash_images" env:"FEATURE_UNSPLASH_IMAGES"`
+	Metrics        bool `yaml:"metrics" env:"FEATURE_METRICS"`
+}
+
+type LogConfig struct {
+	Level  string `yaml:"level" env:"LOG_LEVEL"`   // debug | info | warn | error
+	Format string `yaml:"format" env:"LOG_FORMAT"` // json | text
+}
+
+type TracingConfig struct {
+	Exporter string `yaml:"exporter" env:"TRACING_EXPORTER"` // none | otlp | stdout
+	// Endpoint is the OTLP/HTTP traces URL; empty uses the standard OTEL_EXPORTER_OTLP_* variables
+	Endpoint    string  `yaml:"endpoint" env:"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"`
+	ServiceName string  `yaml:"service_name" env:"OTEL_SERVICE_NAME"`
+	SampleRatio float64 `yaml:"sample_ratio" env:"TRACING_SAMPLE_RATIO"`
+}
+
+type HealthConfig struct {
+	// CheckTimeout bounds each /readyz dependency check
+	CheckTimeout time.Duration `yaml:"check_timeout" env:"HEALTH_CHECK_TIMEOUT"`
+	// CheckLiteLLM adds a non-critical LiteLLM proxy reachability check, cached for LiteLLMCacheTTL
+	CheckLiteLLM    bool          `yaml:"check_litellm" env:"HEALTH_CHECK_LITELLM"`
+	LiteLLMCacheTTL time.Duration `yaml:"litellm_cache_ttl" env:"HEALTH_LITELLM_CACHE_TTL"`
+}
+
+// Default returns the built-in defaults
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Port:              8080,
+			ReadHeaderTimeout: 10 * time.Second,
+			ReadTimeout:       30 * time.Second,
+			IdleTimeout:       120 * time.Second,
+			ShutdownTimeout:   20 * time.Second,
+		},
+		CORS: CORSConfig{
+			AllowedOrigins: []string{
+				"http://localhost:3000",
+				"http://127.0.0.1:3000",
+				"https://market.niyogen.com",
+				"https://travel.niyogen.com",
+				"https://guardian-client-x6n5sofwia-uc.a.run.app", // Cloud Run Frontend
+			},
+			AllowCredentials: true,
+		},
+		Database: DatabaseConfig{Driver: "postgres", Path: "guardian.db", ConnectTimeout: 5 * time.Second},
+		Feed: FeedConfig{StickyWindow: 60 * time.Minute, FlushInterval: time.Second,
+			RetentionInterval: time.Hour, DismissedRetention: 24 * time.Hour},
+		LiteLLM: LiteLLMConfig{
+			Model:                  "gemini-2.0-flash",
+			Timeout:                30 * time.Second,
+			KeyReconcileInterval:   6 * time.Hour,
+			BudgetWarningThreshold: 0.8,
+		},
+		Auth: AuthConfig{
+			CookieName:     "session",
+			ClockSkew:      30 * time.Second,
+			AllowAnonymous: true,
+		},
+		RateLimit: RateLimitConfig{
+			Enabled: true,
+			Backend: "memory",
+			Chat:    "20/m",
+			ChatIP:  "60/m",
+			Agent:   "10/h",
+			AgentIP: "30/h",
+			Feed:    "120/m",
+			FeedIP:  "600/m",
+		},
+		Session: SessionConfig{SummaryMaxMessages: 20, SummaryKeepRecent: 8},
+		Agent:   AgentConfig{Path: "./agents/trip-guardian/trip_guardian_v3.m", StopGrace: 5 * time.Second},
+		Geocoding: GeocodingConfig{
+			UserAgent:   "guardian-gateway",
+			Timeout:     5 * time.Second,
+			MinInterval: time.Second,
+			CacheTTL:    24 * time.Hour,
+		},
+		Features: FeaturesConfig{
+			Swagger:        true,
+			Scheduler:      true,
+			UnsplashImages: true,
+			Metrics:        true,
+		},
+		Log: LogConfig{Level: "info", Format: "json"},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			ServiceName: "guardian-gateway",
+			SampleRatio: 1,
+		},
+		Health: HealthConfig{
+			CheckTimeout:    2 * time.Second,
+			CheckLiteLLM:    true,
+			LiteLLMCacheTTL: 30 * time.Second,
+		},
+	}
+}
+
+// Load builds the configuration: defaults, then the YAML file at path (if not
+// empty), then environment variables. The result is validated.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if path != "" {
+		if err := loadYAML(path, cfg); err != nil {
+			return nil, err
+		}
+	}
+	if err := applyEnv(cfg, os.LookupEnv); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate reports every invalid setting at once
+func (c *Config) Validate() error {
+	var errs []error
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		add("PORT must be between 1 and 65535, got %d", c.Server.Port)
+	}
+	if (c.Server.TLSCertPath == "") != (c.Server.TLSKeyPath == "") {
+		add("SSL_CERT_PATH and SSL_KEY_PATH must be set together")
+	}
+	for _, p := range []string{c.Server.TLSCertPath, c.Server.TLSKeyPath} {
+		if p != "" {
+			if _, err := os.Stat(p); err != nil {
+				add("TLS file %s: %v", p, err)
+			}
+		}
+	}
+	for name, d := range map[string]time.Duration{
+		"SERVER_READ_HEADER_TIMEOUT": c.Server.ReadHeaderTimeout,
+		"SERVER_READ_TIMEOUT":        c.Server.ReadTimeout,
+		"SERVER_WRITE_TIMEOUT":       c.Server.WriteTimeout,
+		"SERVER_IDLE_TIMEOUT":        c.Server.IdleTimeout,
+		"SERVER_SHUTDOWN_TIMEOUT":    c.Server.ShutdownTimeout,
+		"AGENT_STOP_GRACE":           c.Agent.StopGrace,
+	} {
+		if d < 0 {
+			add("%s must not be negative", name)
+		}
+	}
+
+	for _, origin := range c.CORS.AllowedOrigins {
+		if origin == "*" {
+			if c.CORS.AllowCredentials {
+				add("CORS_ALLOWED_ORIGINS cannot contain * when credentials are allowed")
+			}
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			add("CORS origin %q must be scheme://host[:port]", origin)
+		}
+	}
+
+	switch c.Database.Driver {
+	case "postgres":
+		if c.Database.URL == "" {
+			add("DATABASE_URL is required")
+		}
+	case "sqlite":
+		if c.Database.Path == "" {
+			add("DATABASE_PATH is required for the sqlite driver")
+		}
+	case "memory":
+	default:
+		add("DATABASE_DRIVER must be postgres, sqlite or memory, got %q", c.Database.Driver)
+	}
+	if c.Database.ConnectTimeout <= 0 {
+		add("DATABASE_CONNECT_TIMEOUT must be positive")
+	}
+	if c.Feed.StickyWindow <= 0 {
+		add("FEED_STICKY_WINDOW must be positive")
+	}
+	if c.Feed.FlushInterval < 0 {
+		add("FEED_FLUSH_INTERVAL must not be negative")
+	}
+	if c.Feed.RetentionInterval < 0 || c.Feed.DismissedRetention < 0 {
+		add("FEED_RETENTION_INTERVAL and FEED_DISMISSED_RETENTION must not be negative")
+	}
+
+	if c.LiteLLM.ProxyURL != "" {
+		if u, err := url.Parse(c.LiteLLM.ProxyURL); err != nil || u.Scheme == "" || u.Host == "" {
+			add("LITELLM_PROXY_URL %q is not a valid URL", c.LiteLLM.ProxyURL)
+		}
+	}
+	if c.LiteLLM.Timeout <= 0 {
+		add("LITELLM_TIMEOUT must be positive")
+	}
+	if c.LiteLLM.KeyReconcileInterval < 0 {
+		add("KEY_RECONCILE_INTERVAL must not be negative")
+	}
+	if c.LiteLLM.BudgetWarningThreshold <= 0 || c.LiteLLM.BudgetWarningThreshold > 1 {
+		add("BUDGET_WARNING_THRESHOLD must be in (0, 1]")
+	}
+	if c.LiteLLM.Keyring != "" && c.LiteLLM.KeyringFile != "" {
+		add("set only one of LITELLM_KEYRING and LITELLM_KEYRING_FILE")
+	}
+	if c.Database.Driver != "memory" && c.LiteLLM.Keyring == "" && c.LiteLLM.KeyringFile == "" && !c.LiteLLM.AllowPlaintextKeys {
+		add("LITELLM_KEYRING or LITELLM_KEYRING_FILE is required to store LiteLLM keys (set LITELLM_ALLOW_PLAINTEXT_KEYS=true to store them unencrypted)")
+	}
+
+	if c.Auth.JWKSURL != "" {
+		if u, err := url.Parse(c.Auth.JWKSURL); err != nil || u.Scheme != "https" && u.Scheme != "http" || u.Host == "" {
+			add("AUTH_JWKS_URL %q is not a valid URL", c.Auth.JWKSURL)
+		}
+	}
+	if c.Auth.ClockSkew < 0 {
+		add("AUTH_CLOCK_SKEW must not be negative")
+	}
+
+	if c.RateLimit.Backend != "memory" && c.RateLimit.Backend != "postgres" {
+		add("RATE_LIMIT_BACKEND must be memory or postgres, got %q", c.RateLimit.Backend)
+	} else if c.RateLimit.Backend == "postgres" && c.Database.Driver != "postgres" {
+		add("RATE_LIMIT_BACKEND=postgres requires DATABASE_DRIVER=postgres")
+	}
+	for name, spec := range map[string]string{
+		"RATE_LIMIT_CHAT": c.RateLimit.Chat, "RATE_LIMIT_CHAT_IP": c.RateLimit.ChatIP,
+		"RATE_LIMIT_AGENT": c.RateLimit.Agent, "RATE_LIMIT_AGENT_IP": c.RateLimit.AgentIP,
+		"RATE_LIMIT_FEED": c.RateLimit.Feed, "RATE_LIMIT_FEED_IP": c.RateLimit.FeedIP,
+	} {
+		if _, err := ratelimit.ParseLimit(spec); err != nil {
+			add("%s: %v", name, err)
+		}
+	}
+
+	if c.Session.SummaryMaxMessages < 0 || c.Session.SummaryKeepRecent < 0 {
+		add("session summary limits must not be negative")
+	}
+	if c.Session.SummaryMaxMessages > 0 && c.Session.SummaryKeepRecent >= c.Session.SummaryMaxMessages {
+		add("SESSION_SUMMARY_KEEP_RECENT must be less than SESSION_SUMMARY_MAX_MESSAGES")
+	}
+
+	if _, err := logging.ParseLevel(c.Log.Level); err != nil {
+		add("LOG_LEVEL: %v", err)
+	}
+	if f := strings.ToLower(c.Log.Format); f != "json" && f != "text" {
+		add("LOG_FORMAT must be json or text, got %q", c.Log.Format)
+	}
+
+	switch strings.ToLower(c.Tracing.Exporter) {
+	case "none", "otlp", "stdout":
+	default:
+		add("TRACING_EXPORTER must be none, otlp or stdout, got %q", c.Tracing.Exporter)
+	}
+	if c.Tracing.Endpoint != "" {
+		if u, err := url.Parse(c.Tracing.Endpoint); err != nil || u.Scheme != "https" && u.Scheme != "http" || u.Host == "" {
+			add("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT %q is not a valid URL", c.Tracing.Endpoint)
+		}
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		add("TRACING_SAMPLE_RATIO must be in [0, 1]")
+	}
+	if c.Tracing.ServiceName == "" {
+		add("OTEL_SERVICE_NAME must not be empty")
+	}
+
+	if c.Geocoding.NominatimURL != "" {
+		if u, err := url.Parse(c.Geocoding.NominatimURL); err != nil || u.Scheme != "https" && u.Scheme != "http" || u.Host == "" {
+			add("GEOCODING_NOMINATIM_URL %q is not a valid URL", c.Geocoding.NominatimURL)
+		}
+		if c.Geocoding.UserAgent == "" {
+			add("GEOCODING_USER_AGENT is required with GEOCODING_NOMINATIM_URL")
+		}
+	}
+	if c.Geocoding.Timeout <= 0 {
+		add("GEOCODING_TIMEOUT must be positive")
+	}
+	if c.Geocoding.MinInterval < 0 || c.Geocoding.CacheTTL < 0 {
+		add("GEOCODING_MIN_INTERVAL and GEOCODING_CACHE_TTL must not be negative")
+	}
+
+	if c.Health.CheckTimeout <= 0 {
+		add("HEALTH_CHECK_TIMEOUT must be positive")
+	}
+	if c.Health.LiteLLMCacheTTL < 0 {
+		add("HEALTH_LITELLM_CACHE_TTL must not be negative")
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid configuration:\n  %w", joinIndented(errs))
+}
+
+func joinIndented(errs []error) error {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return errors.New(strings.Join(msgs, "\n  "))
+}
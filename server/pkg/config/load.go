@@ -0,0 +1,164 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// loadYAML overlays the YAML file at path onto cfg. Unknown keys are rejected
+// so typos fail fast instead of being silently ignored.
+func loadYAML(path string, cfg *Config) error {
+	data, err := os.ReadFile(path) // #nosec G304 -- operator-provided path
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// applyEnv overrides every field with an env tag whose variable is set
+func applyEnv(cfg *Config, lookup func(string) (string, bool)) error {
+	var errs []string
+	walk(reflect.ValueOf(cfg).Elem(), func(f reflect.StructField, v reflect.Value) {
+		name := f.Tag.Get("env")
+		raw, ok := lookup(name)
+		if name == "" || !ok {
+			return
+		}
+		if err := setField(v, strings.TrimSpace(raw)); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+		}
+	})
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+// walk calls fn for every leaf field of the (nested) struct v
+func walk(v reflect.Value, fn func(reflect.StructField, reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f, fv := t.Field(i), v.Field(i)
+		if fv.Kind() == reflect.Struct && fv.Type() != durationType {
+			walk(fv, fn)
+			continue
+		}
+		fn(f, fv)
+	}
+}
+
+func setField(v reflect.Value, raw string) error {
+	// An empty value (e.g. "PORT=" in .env) keeps the default for typed fields
+	if raw == "" && v.Kind() != reflect.String && v.Kind() != reflect.Slice {
+		return nil
+	}
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", raw)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		v.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		items := []string{}
+		for _, s := range strings.Split(raw, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				items = append(items, s)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported field type %s", v.Type())
+	}
+	return nil
+}
+
+// Redacted renders the effective configuration, one "ENV_NAME = value" per
+// line, with secrets masked and credentials stripped from URLs
+func (c *Config) Redacted() string {
+	var b strings.Builder
+	walk(reflect.ValueOf(c).Elem(), func(f reflect.StructField, v reflect.Value) {
+		name := f.Tag.Get("env")
+		if name == "" {
+			return
+		}
+		value := formatValue(v)
+		if f.Tag.Get("secret") == "true" {
+			value = redact(value)
+		}
+		fmt.Fprintf(&b, "%s = %s\n", name, value)
+	})
+	return b.String()
+}
+
+func formatValue(v reflect.Value) string {
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+	if v.Kind() == reflect.Slice {
+		parts := make([]string, v.Len())
+		for i := range parts {
+			parts[i] = fmt.Sprint(v.Index(i).Interface())
+		}
+		return strings.Join(parts, ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// redact masks a secret. URLs keep their host so operators can tell which
+// database or service is configured.
+func redact(value string) string {
+	if value == "" {
+		return "(unset)"
+	}
+	if u, err := url.Parse(value); err == nil && u.Scheme != "" && u.Host != "" {
+		creds := ""
+		if u.User != nil {
+			creds = "***@"
+		}
+		return u.Scheme + "://" + creds + u.Host + u.Path
+	}
+	return "***"
+}
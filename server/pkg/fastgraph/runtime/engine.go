@@ -2,14 +2,29 @@ package runtime
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"sync"
+	"time"
+
+	"guardian-gateway/pkg/metrics"
+	"guardian-gateway/pkg/tracing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const tracerScope = "guardian-gateway/pkg/fastgraph/runtime"
+
 // inferNodeFromLine attempts to infer a FastGraph node name from a plain-text line.
 // Many agents print "NodeName: ..." prefixes; we use those as a best-effort mapping.
 func inferNodeFromLine(line string) (string, bool) {
@@ -40,7 +55,11 @@ type AgentMetadata struct {
 	Capabilities []string      `json:"capabilities"`
 	Schedule     *ScheduleInfo `json:"schedule"`
 	Nodes        []string      `json:"nodes"`
-	Inputs       []string      `json:"inputs"`
+	// Inputs are the trip fields the agent expects in its input, in order
+	Inputs []string `json:"inputs"`
+	// InputFormat is how the agent reads its input: "text" (the default) or
+	// "json" for a structured document
+	InputFormat string `json:"input_format,omitempty"`
 }
 
 type ScheduleInfo struct {
@@ -57,10 +76,20 @@ type MemoryConfig struct {
 	CorpusName string
 }
 
+// DefaultStopGrace is how long a cancelled agent has to exit after SIGTERM
+const DefaultStopGrace = 5 * time.Second
+
 // Real Engine Wrapper
 type Engine struct {
 	BinPath string
-	MockRun func(agentPath, input string, memory *MemoryConfig, onEvent func(string)) error
+	// StopGrace is how long a cancelled run's process group has to exit after
+	// SIGTERM before it is killed; 0 uses DefaultStopGrace
+	StopGrace time.Duration
+	MockRun   func(agentPath, input string, memory *MemoryConfig, onEvent func(string)) error
+	// MockInspect, when set, replaces 'fastgraph inspect'
+	MockInspect func(agentPath string) (*AgentMetadata, error)
+	// GoogleMapsKey is passed to agents as GOOGLE_MAPS_KEY
+	GoogleMapsKey string
 }
 
 func New() *Engine {
@@ -79,22 +108,26 @@ func New() *Engine {
 				if _, err := os.Stat(binPath); os.IsNotExist(err) {
 					binPath = "./fastgraph"
 					if _, err := os.Stat(binPath); os.IsNotExist(err) {
-						fmt.Println("WARNING: fastgraph binary not found")
+						slog.Warn("fastgraph binary not found")
 					}
 				}
 			}
 		}
 	}
 
-	fmt.Printf("INFO: Using FastGraph binary: %s\n", binPath)
+	slog.Info("using fastgraph binary", "path", binPath)
 
 	return &Engine{
-		BinPath: binPath,
+		BinPath:   binPath,
+		StopGrace: DefaultStopGrace,
 	}
 }
 
 // Inspect runs 'fastgraph inspect' and returns metadata
 func (e *Engine) Inspect(agentPath string) (*AgentMetadata, error) {
+	if e.MockInspect != nil {
+		return e.MockInspect(agentPath)
+	}
 	cmd := exec.Command(e.BinPath, "inspect", agentPath) // #nosec G204
 	// Pass environment variables to the subprocess
 	cmd.Env = os.Environ()
@@ -111,8 +144,150 @@ func (e *Engine) Inspect(agentPath string) (*AgentMetadata, error) {
 	return nil, fmt.Errorf("failed to parse inspect output: %v", err)
 }
 
+// Version runs 'fastgraph --version' and returns its trimmed output
+func (e *Engine) Version(ctx context.Context) (string, error) {
+	if _, err := os.Stat(e.BinPath); err != nil {
+		return "", fmt.Errorf("%w: %v", errBinaryMissing, err)
+	}
+	cmd := exec.CommandContext(ctx, e.BinPath, "--version") // #nosec G204
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get fastgraph version: %w", err)
+	}
+	version := strings.TrimSpace(string(output))
+	if version == "" {
+		return "", errors.New("fastgraph --version printed nothing")
+	}
+	return version, nil
+}
+
+// IsBinaryMissing reports whether err means the fastgraph binary was not found
+func IsBinaryMissing(err error) bool {
+	return errors.Is(err, errBinaryMissing)
+}
+
 // Run executes the agent via CLI and streams output to the callback
 func (e *Engine) Run(agentPath string, input string, memory *MemoryConfig, onEvent func(string)) error {
+	return e.RunContext(context.Background(), agentPath, input, memory, onEvent)
+}
+
+// RunContext is Run with a context; its log attributes (request, run and
+// owner IDs) are attached to the engine's log records
+func (e *Engine) RunContext(ctx context.Context, agentPath string, input string, memory *MemoryConfig, onEvent func(string)) error {
+	agent := filepath.Base(agentPath)
+	ctx, span := otel.Tracer(tracerScope).Start(ctx, "agent.run", trace.WithAttributes(
+		attribute.String("agent.name", agent),
+		attribute.Bool("agent.memory", memory != nil && memory.Enabled),
+	))
+	defer span.End()
+
+	start := time.Now()
+	events := newEventObserver(ctx, onEvent)
+	err := e.run(ctx, agentPath, input, memory, events.wrap())
+	events.finish()
+
+	outcome := metrics.Outcome(err)
+	if errors.Is(err, errBinaryMissing) {
+		// The caller already got a stub event explaining the problem
+		outcome, err = "binary_missing", nil
+	} else if err != nil && ctx.Err() != nil {
+		outcome = "cancelled"
+	}
+	metrics.AgentRuns.WithLabelValues(agent, outcome).Inc()
+	metrics.AgentRunDuration.WithLabelValues(agent, outcome).Observe(time.Since(start).Seconds())
+
+	span.SetAttributes(attribute.String("agent.outcome", outcome))
+	if outcome != "ok" {
+		span.SetStatus(codes.Error, outcome)
+		if err != nil {
+			span.RecordError(err)
+		}
+	}
+	return err
+}
+
+func (e *Engine) stopGrace() time.Duration {
+	if e.StopGrace > 0 {
+		return e.StopGrace
+	}
+	return DefaultStopGrace
+}
+
+// errBinaryMissing reports that the fastgraph binary could not be started
+var errBinaryMissing = errors.New("fastgraph binary missing")
+
+// eventObserver counts stream events per node and type and traces each node
+// as a child span of the run, lasting from its first to its last event.
+// Nodes may interleave, so every node keeps its own span until the run ends.
+type eventObserver struct {
+	ctx     context.Context
+	onEvent func(string)
+
+	mu    sync.Mutex
+	nodes map[string]*nodeSpan
+}
+
+type nodeSpan struct {
+	span   trace.Span
+	last   time.Time
+	events int
+}
+
+func newEventObserver(ctx context.Context, onEvent func(string)) *eventObserver {
+	return &eventObserver{ctx: ctx, onEvent: onEvent, nodes: make(map[string]*nodeSpan)}
+}
+
+// wrap returns the callback handed to the runner (nil stays nil)
+func (o *eventObserver) wrap() func(string) {
+	if o.onEvent == nil {
+		return nil
+	}
+	return func(eventJSON string) {
+		o.observe(eventJSON)
+		o.onEvent(eventJSON)
+	}
+}
+
+func (o *eventObserver) observe(eventJSON string) {
+	var evt struct {
+		Type string `json:"type"`
+		Node string `json:"node"`
+	}
+	_ = json.Unmarshal([]byte(eventJSON), &evt)
+	if evt.Type == "" {
+		evt.Type = "unknown"
+	}
+	if evt.Node == "" {
+		metrics.EngineEvents.WithLabelValues("none", evt.Type).Inc()
+		return
+	}
+	metrics.EngineEvents.WithLabelValues(evt.Node, evt.Type).Inc()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	n, ok := o.nodes[evt.Node]
+	if !ok {
+		_, span := otel.Tracer(tracerScope).Start(o.ctx, "agent.node "+evt.Node,
+			trace.WithAttributes(attribute.String("agent.node", evt.Node)))
+		n = &nodeSpan{span: span}
+		o.nodes[evt.Node] = n
+	}
+	n.last = time.Now()
+	n.events++
+}
+
+// finish ends every node span at the time of its last event
+func (o *eventObserver) finish() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, n := range o.nodes {
+		n.span.SetAttributes(attribute.Int("agent.node.events", n.events))
+		n.span.End(trace.WithTimestamp(n.last))
+	}
+	o.nodes = nil
+}
+
+func (e *Engine) run(ctx context.Context, agentPath string, input string, memory *MemoryConfig, onEvent func(string)) error {
 	if e.MockRun != nil {
 		return e.MockRun(agentPath, input, memory, onEvent)
 	}
@@ -121,7 +296,7 @@ func (e *Engine) Run(agentPath string, input string, memory *MemoryConfig, onEve
 
 	// Apply Memory Configuration
 	if memory != nil && memory.Enabled {
-		fmt.Printf("CLI: Memory Enabled (Store: %s)\n", memory.Store)
+		slog.DebugContext(ctx, "agent memory enabled", "store", memory.Store)
 		args = append(args, "--memory-enabled")
 		if memory.Store != "" {
 			args = append(args, "--memory-store="+memory.Store)
@@ -133,9 +308,18 @@ func (e *Engine) Run(agentPath string, input string, memory *MemoryConfig, onEve
 		args = append(args, "--memory-cache=inmemory") // Always use cache for speed
 	}
 
-	fmt.Printf("CLI: Executing %s %v\n", e.BinPath, args)
+	// args include the user's trip details, so only the agent is logged
+	slog.InfoContext(ctx, "starting fastgraph", "bin", e.BinPath, "agent", agentPath, "memory", memory != nil && memory.Enabled)
 
-	cmd := exec.Command(e.BinPath, args...) // #nosec G204
+	// Cancelling ctx stops the agent and the tools it spawned: SIGTERM to the
+	// process group, then SIGKILL once StopGrace has passed
+	cmd := exec.CommandContext(ctx, e.BinPath, args...) // #nosec G204
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		slog.WarnContext(ctx, "stopping fastgraph run", "agent", agentPath, "reason", context.Cause(ctx))
+		return terminateProcessGroup(cmd)
+	}
+	cmd.WaitDelay = e.stopGrace()
 
 	// Pass environment variables to the subprocess
 	env := os.Environ()
@@ -152,22 +336,14 @@ func (e *Engine) Run(agentPath string, input string, memory *MemoryConfig, onEve
 			env = append(env, "VERTEX_CORPUS_NAME="+memory.CorpusName)
 		}
 	}
-	cmd.Env = env
-
-	// DEBUG: Print Key Prefixes explicitly from cmd.Env to be sure
-	var debugMapKey string
-	for _, e := range cmd.Env {
-		if strings.HasPrefix(e, "GOOGLE_MAPS_KEY=") {
-			debugMapKey = strings.TrimPrefix(e, "GOOGLE_MAPS_KEY=")
-			break
-		}
-	}
-	if len(debugMapKey) > 10 {
-		fmt.Printf("DEBUG SUBPROCESS: GOOGLE_MAPS_KEY sent to agent: %s...\n", debugMapKey[:15])
-		fmt.Printf("DEBUG SUBPROCESS: Key Length: %d\n", len(debugMapKey))
+	// Report whether the agent's Maps key is present, never its value
+	if e.GoogleMapsKey != "" {
+		env = append(env, "GOOGLE_MAPS_KEY="+e.GoogleMapsKey)
 	} else {
-		fmt.Printf("DEBUG SUBPROCESS: GOOGLE_MAPS_KEY MISSING or TOO SHORT! (Val='%s')\n", debugMapKey)
+		slog.WarnContext(ctx, "GOOGLE_MAPS_KEY not set for agent subprocess")
 	}
+	// Agents that support tracing continue the run's trace from TRACEPARENT
+	cmd.Env = tracing.WithEnv(ctx, env)
 
 	// Create Pipes
 	stdout, err := cmd.StdoutPipe()
@@ -183,11 +359,11 @@ func (e *Engine) Run(agentPath string, input string, memory *MemoryConfig, onEve
 	if err := cmd.Start(); err != nil {
 		// Fallback for demo if binary missing:
 		if os.IsNotExist(err) {
-			fmt.Println("ERROR: fastgraph binary missing. Using fallback stub event.")
+			slog.ErrorContext(ctx, "fastgraph binary missing, sending stub event", "bin", e.BinPath)
 			if onEvent != nil {
 				onEvent(`{"type": "log", "message": "ERROR: fastgraph binary not found. Please ensure fastgraph is in the server root."}`)
 			}
-			return nil
+			return errBinaryMissing
 		}
 		return err
 	}
@@ -202,7 +378,7 @@ func (e *Engine) Run(agentPath string, input string, memory *MemoryConfig, onEve
 		scanner := bufio.NewScanner(stderr)
 		for scanner.Scan() {
 			line := scanner.Text()
-			fmt.Println("CLI LOG:", line)
+			slog.DebugContext(ctx, "fastgraph stderr", "line", line)
 			if onEvent != nil {
 				// Wrap as Log event
 				logEvent := map[string]string{"type": "log", "message": line}
@@ -240,27 +416,50 @@ func (e *Engine) Run(agentPath string, input string, memory *MemoryConfig, onEve
 				plainMode = false
 				dataJSON := strings.TrimPrefix(trim, "data: ")
 
-				// DEBUG: Log what we're parsing
-				fmt.Printf("DEBUG SSE: event=%s, data=%s\n", currentEvent, dataJSON)
+				slog.DebugContext(ctx, "fastgraph event", "event", currentEvent, "bytes", len(dataJSON))
 
 				if onEvent != nil {
 					// Parse the JSON data to extract node info
 					var data struct {
-						Node     string `json:"node"`
-						NodeName string `json:"node_name"`
-						Message  string `json:"message"` // FastGraph uses "message"
-						Text     string `json:"text"`    // Fallback for older formats
+						Node     string          `json:"node"`
+						NodeName string          `json:"node_name"`
+						Message  string          `json:"message"` // FastGraph uses "message"
+						Text     string          `json:"text"`    // Fallback for older formats
+						Model    string          `json:"model"`
+						Usage    json.RawMessage `json:"usage"`    // Token usage reported by LLM nodes
+						CostUSD  *float64        `json:"cost_usd"` // Optional cost reported alongside usage
 					}
 
 					if err := json.Unmarshal([]byte(dataJSON), &data); err == nil {
+						// Forward token usage as a separate event so the gateway can meter the run
+						if len(data.Usage) > 0 && string(data.Usage) != "null" {
+							usageEvent := map[string]interface{}{
+								"type":  "usage",
+								"model": data.Model,
+								"usage": data.Usage,
+							}
+							if data.Node != "" {
+								usageEvent["node"] = data.Node
+							}
+							if data.CostUSD != nil {
+								usageEvent["cost_usd"] = *data.CostUSD
+							}
+							if jsonBytes, err := json.Marshal(usageEvent); err == nil {
+								onEvent(string(jsonBytes))
+							}
+							if data.Message == "" && data.Text == "" {
+								currentEvent = ""
+								continue
+							}
+						}
+
 						// Use message if available, otherwise text
 						content := data.Message
 						if content == "" {
 							content = data.Text
 						}
 
-						// DEBUG: Log parsed data
-						fmt.Printf("DEBUG PARSED: node=%s, node_name=%s, content_len=%d\n", data.Node, data.NodeName, len(content))
+						slog.DebugContext(ctx, "fastgraph chunk", "node", data.Node, "node_name", data.NodeName, "content_len", len(content))
 
 						// Successfully parsed JSON - forward as chunk event with node metadata
 						chunkEvent := map[string]string{
@@ -276,12 +475,10 @@ func (e *Engine) Run(agentPath string, input string, memory *MemoryConfig, onEve
 						}
 
 						if jsonBytes, err := json.Marshal(chunkEvent); err == nil {
-							fmt.Printf("DEBUG FORWARDING: %s\n", string(jsonBytes))
 							onEvent(string(jsonBytes))
 						}
 					} else {
-						// DEBUG: Log parse error
-						fmt.Printf("DEBUG PARSE ERROR: %v\n", err)
+						slog.DebugContext(ctx, "fastgraph event is not JSON", "event", currentEvent, "error", err)
 
 						// Not JSON or done event - forward as-is for backward compatibility
 						if currentEvent == "done" {
@@ -327,12 +524,18 @@ func (e *Engine) Run(agentPath string, input string, memory *MemoryConfig, onEve
 			}
 		}
 		if err := scanner.Err(); err != nil {
-			fmt.Println("CLI: Error reading stdout:", err)
+			slog.ErrorContext(ctx, "failed to read fastgraph stdout", "error", err)
 		}
 	}()
 
 	// Wait for Command to Finish First
 	if err := cmd.Wait(); err != nil {
+		if ctx.Err() != nil {
+			// Don't leave tools that ignored SIGTERM behind
+			_ = killProcessGroup(cmd)
+			wg.Wait()
+			return fmt.Errorf("agent run stopped: %w", context.Cause(ctx))
+		}
 		// Even if command failed, we wait for streams to flush
 		wg.Wait()
 		return fmt.Errorf("agent execution finished with error: %v", err)
@@ -1,11 +1,18 @@
 package runtime
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+
+	"guardian-gateway/pkg/metrics"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 // getTestBinPath helps find the binary in a cross-platform way relative to this test file.
@@ -208,3 +215,92 @@ func TestSSEParsing(t *testing.T) {
 		})
 	}
 }
+
+func TestRunContext_Metrics(t *testing.T) {
+	e := &Engine{MockRun: func(agentPath, input string, memory *MemoryConfig, onEvent func(string)) error {
+		onEvent(`{"type":"chunk","node":"CheckWeather","message":"sunny"}`)
+		onEvent(`{"type":"log","message":"no node"}`)
+		return nil
+	}}
+	chunks := testutil.ToFloat64(metrics.EngineEvents.WithLabelValues("CheckWeather", "chunk"))
+	logs := testutil.ToFloat64(metrics.EngineEvents.WithLabelValues("none", "log"))
+	runs := testutil.ToFloat64(metrics.AgentRuns.WithLabelValues("metrics_agent.m", "ok"))
+
+	var got []string
+	err := e.RunContext(context.Background(), "./agents/metrics_agent.m", "input", nil, func(ev string) { got = append(got, ev) })
+	if err != nil {
+		t.Fatalf("RunContext: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected events to be forwarded, got %d", len(got))
+	}
+	if v := testutil.ToFloat64(metrics.EngineEvents.WithLabelValues("CheckWeather", "chunk")); v != chunks+1 {
+		t.Errorf("chunk events = %v, want %v", v, chunks+1)
+	}
+	if v := testutil.ToFloat64(metrics.EngineEvents.WithLabelValues("none", "log")); v != logs+1 {
+		t.Errorf("log events = %v, want %v", v, logs+1)
+	}
+	if v := testutil.ToFloat64(metrics.AgentRuns.WithLabelValues("metrics_agent.m", "ok")); v != runs+1 {
+		t.Errorf("agent runs = %v, want %v", v, runs+1)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	e := &Engine{BinPath: filepath.Join(t.TempDir(), "fastgraph")}
+	if _, err := e.Version(context.Background()); !IsBinaryMissing(err) {
+		t.Fatalf("expected binary missing error, got %v", err)
+	}
+
+	if err := os.WriteFile(e.BinPath, []byte("#!/bin/sh\necho 'fastgraph 0.4.1'\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	v, err := e.Version(context.Background())
+	if err != nil {
+		t.Skipf("cannot execute shell script here: %v", err)
+	}
+	if v != "fastgraph 0.4.1" {
+		t.Errorf("Version = %q, want %q", v, "fastgraph 0.4.1")
+	}
+}
+
+func TestRunContext_CancelStopsProcessGroup(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("needs /bin/sh")
+	}
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "fastgraph")
+	// The agent starts a tool and waits on it, like a node calling out to a CLI
+	script := "#!/bin/sh\necho 'data: {\"node\":\"CheckWeather\",\"message\":\"started\"}'\nsleep 30 &\nwait\n"
+	if err := os.WriteFile(bin, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	e := &Engine{BinPath: bin, StopGrace: time.Second}
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{}, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- e.RunContext(ctx, filepath.Join(dir, "agent.m"), "input", nil, func(string) {
+			select {
+			case started <- struct{}{}:
+			default:
+			}
+		})
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent produced no output")
+	}
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancelled run did not stop")
+	}
+}
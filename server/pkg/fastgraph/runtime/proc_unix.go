@@ -0,0 +1,32 @@
+//go:build !windows
+
+package runtime
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts the agent in its own process group so tools it
+// spawns can be signalled together with it
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// signalProcessGroup sends sig to the agent and everything it spawned
+func signalProcessGroup(cmd *exec.Cmd, sig syscall.Signal) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return syscall.Kill(-cmd.Process.Pid, sig)
+}
+
+// terminateProcessGroup asks the agent's process group to exit
+func terminateProcessGroup(cmd *exec.Cmd) error {
+	return signalProcessGroup(cmd, syscall.SIGTERM)
+}
+
+// killProcessGroup kills whatever is left of the agent's process group
+func killProcessGroup(cmd *exec.Cmd) error {
+	return signalProcessGroup(cmd, syscall.SIGKILL)
+}
@@ -0,0 +1,21 @@
+//go:build windows
+
+package runtime
+
+import "os/exec"
+
+// setProcessGroup is a no-op on Windows; only the agent process itself is stopped
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// terminateProcessGroup kills the agent; Windows has no SIGTERM to forward
+func terminateProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return cmd.Process.Kill()
+}
+
+// killProcessGroup kills the agent process
+func killProcessGroup(cmd *exec.Cmd) error {
+	return terminateProcessGroup(cmd)
+}
@@ -0,0 +1,251 @@
+package geocode
+
+import (
+	"context"
+	_ "embed"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// City is one gazetteer entry
+type City struct {
+	Name        string
+	CountryCode string
+	Lat, Lon    float64
+	Timezone    string
+	Population  int
+	Aliases     []string
+}
+
+// Gazetteer geocodes offline from a list of cities. Queries name a city,
+// optionally followed by a region and country ("Pasikuda, Sri Lanka"); a
+// country narrows the match, and the most populous matching city wins.
+type Gazetteer struct {
+	cities    []City
+	byName    map[string][]int // folded name or alias -> cities
+	countries map[string]string
+	byCountry map[string]string // folded country name, alias or code -> code
+}
+
+//go:embed cities.csv
+var citiesCSV string
+
+//go:embed countries.csv
+var countriesCSV string
+
+var bundled = mustLoad(citiesCSV, countriesCSV)
+
+// Bundled returns the gazetteer built into the binary: popular destination
+// cities and the countries they are in
+func Bundled() *Gazetteer { return bundled }
+
+func mustLoad(cities, countries string) *Gazetteer {
+	g, err := LoadGazetteer(strings.NewReader(cities), strings.NewReader(countries))
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+// LoadGazetteer reads a gazetteer from CSV with headers. cities has columns
+// name, country (ISO code), lat, lon, timezone, population and aliases
+// ("|"-separated); countries has code, name and aliases.
+func LoadGazetteer(cities, countries io.Reader) (*Gazetteer, error) {
+	g := &Gazetteer{
+		byName:    make(map[string][]int),
+		countries: make(map[string]string),
+		byCountry: make(map[string]string),
+	}
+
+	rows, err := readCSV(countries, 3)
+	if err != nil {
+		return nil, fmt.Errorf("countries: %w", err)
+	}
+	for _, row := range rows {
+		code := strings.ToUpper(row[0])
+		g.countries[code] = row[1]
+		for _, name := range append([]string{row[1]}, splitAliases(row[2])...) {
+			g.byCountry[fold(name)] = code
+		}
+	}
+
+	rows, err = readCSV(cities, 7)
+	if err != nil {
+		return nil, fmt.Errorf("cities: %w", err)
+	}
+	for i, row := range rows {
+		c := City{Name: row[0], CountryCode: strings.ToUpper(row[1]), Timezone: row[4], Aliases: splitAliases(row[6])}
+		if _, ok := g.countries[c.CountryCode]; !ok {
+			return nil, fmt.Errorf("cities line %d: unknown country %q", i+2, row[1])
+		}
+		if c.Lat, err = strconv.ParseFloat(row[2], 64); err != nil || c.Lat < -90 || c.Lat > 90 {
+			return nil, fmt.Errorf("cities line %d: invalid lat %q", i+2, row[2])
+		}
+		if c.Lon, err = strconv.ParseFloat(row[3], 64); err != nil || c.Lon < -180 || c.Lon > 180 {
+			return nil, fmt.Errorf("cities line %d: invalid lon %q", i+2, row[3])
+		}
+		if c.Population, err = strconv.Atoi(row[5]); err != nil {
+			return nil, fmt.Errorf("cities line %d: invalid population %q", i+2, row[5])
+		}
+		g.cities = append(g.cities, c)
+		for _, name := range append([]string{c.Name}, c.Aliases...) {
+			k := fold(name)
+			g.byName[k] = append(g.byName[k], len(g.cities)-1)
+		}
+	}
+	return g, nil
+}
+
+func readCSV(r io.Reader, columns int) ([][]string, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = columns
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("missing header")
+	}
+	return rows[1:], nil
+}
+
+func splitAliases(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "|")
+}
+
+// Geocode resolves query to a bundled city
+func (g *Gazetteer) Geocode(_ context.Context, query string) (*Place, error) {
+	var parts []string
+	for _, p := range strings.Split(query, ",") {
+		if p = fold(p); p != "" {
+			parts = append(parts, p)
+		}
+	}
+	if len(parts) == 0 {
+		return nil, ErrNotFound
+	}
+
+	// A trailing country narrows the match: "Valencia, Venezuela"
+	country := ""
+	if code, ok := g.byCountry[parts[len(parts)-1]]; ok && len(parts) > 1 {
+		country, parts = code, parts[:len(parts)-1]
+	}
+	for _, part := range parts {
+		var best *City
+		for _, i := range g.byName[part] {
+			c := &g.cities[i]
+			if country != "" && c.CountryCode != country {
+				continue
+			}
+			if best == nil || c.Population > best.Population {
+				best = c
+			}
+		}
+		if best != nil {
+			return g.place(best), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Country returns the country a place name ends in ("Ella, Sri Lanka" and
+// "Sri Lanka" are in Sri Lanka) or, failing that, the country of the city it
+// names
+func (g *Gazetteer) Country(query string) (name, code string, ok bool) {
+	parts := strings.Split(query, ",")
+	if code, ok := g.byCountry[fold(parts[len(parts)-1])]; ok {
+		return g.countries[code], code, true
+	}
+	if p, err := g.Geocode(context.Background(), query); err == nil {
+		return p.Country, p.CountryCode, true
+	}
+	return "", "", false
+}
+
+// CountryName returns the name of the country with an ISO 3166-1 alpha-2 code
+func (g *Gazetteer) CountryName(code string) (string, bool) {
+	name, ok := g.countries[strings.ToUpper(code)]
+	return name, ok
+}
+
+// Nearest returns the bundled city closest to lat/lon and its distance in km.
+// With countryCode set only that country's cities are considered, unless it
+// has none.
+func (g *Gazetteer) Nearest(lat, lon float64, countryCode string) (*City, float64) {
+	countryCode = strings.ToUpper(countryCode)
+	var best *City
+	bestKm := math.Inf(1)
+	for pass := 0; pass < 2 && best == nil; pass++ {
+		for i := range g.cities {
+			c := &g.cities[i]
+			if pass == 0 && countryCode != "" && c.CountryCode != countryCode {
+				continue
+			}
+			if km := distanceKm(lat, lon, c.Lat, c.Lon); km < bestKm {
+				best, bestKm = c, km
+			}
+		}
+		if countryCode == "" {
+			break
+		}
+	}
+	return best, bestKm
+}
+
+func (g *Gazetteer) place(c *City) *Place {
+	return &Place{
+		Name:        c.Name,
+		Country:     g.countries[c.CountryCode],
+		CountryCode: c.CountryCode,
+		Lat:         c.Lat,
+		Lon:         c.Lon,
+		Timezone:    c.Timezone,
+		Source:      "gazetteer",
+	}
+}
+
+// distanceKm is the great-circle distance between two coordinates
+func distanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusKm = 6371
+	rad := func(d float64) float64 { return d * math.Pi / 180 }
+	dLat, dLon := rad(lat2-lat1), rad(lon2-lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(rad(lat1))*math.Cos(rad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// fold normalizes a place name for matching: lower case, without accents or
+// punctuation, single spaces. "São Paulo" and "sao  paulo" fold together.
+func fold(s string) string {
+	if stripped, _, err := transform.String(transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn))), s); err == nil {
+		s = stripped
+	}
+	var b strings.Builder
+	space := false
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r == '\'' || r == '’':
+			// "Xi'an" folds like "Xian"
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			space = false
+			b.WriteRune(r)
+		default:
+			space = true
+		}
+	}
+	return b.String()
+}
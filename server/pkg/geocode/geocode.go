@@ -0,0 +1,154 @@
+// Package geocode resolves place names ("Lisbon, Portugal") to coordinates,
+// country and timezone: through a Nominatim-compatible service when one is
+// configured, and from a gazetteer of cities bundled with the binary when it
+// is not, or cannot answer.
+package geocode
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"guardian-gateway/pkg/logging"
+)
+
+// ErrNotFound is returned when no place matches a query
+var ErrNotFound = errors.New("place not found")
+
+// Place is a resolved place name
+type Place struct {
+	Name        string  `json:"name"` // e.g. "Lisbon"
+	Country     string  `json:"country,omitempty"`
+	CountryCode string  `json:"country_code,omitempty"` // ISO 3166-1 alpha-2
+	Lat         float64 `json:"lat"`
+	Lon         float64 `json:"lon"`
+	// Timezone is an IANA zone name, "" when unknown
+	Timezone string `json:"timezone,omitempty"`
+	Source   string `json:"source"` // nominatim | gazetteer
+}
+
+// Geocoder resolves a free-form place name
+type Geocoder interface {
+	Geocode(ctx context.Context, query string) (*Place, error)
+}
+
+// DefaultCacheTTL is how long a Resolver keeps an answer when TTL is 0
+const DefaultCacheTTL = 24 * time.Hour
+
+// maxCacheEntries bounds a Resolver's cache; it is emptied when full
+const maxCacheEntries = 4096
+
+// maxTimezoneDistance is how far (km) the nearest bundled city may be for its
+// timezone to be lent to a remote result
+const maxTimezoneDistance = 1500
+
+// Resolver asks Remote first and falls back to the gazetteer when Remote is
+// nil, fails or finds nothing. Remote results take their missing country name
+// and timezone from the nearest bundled city. Answers, including "not found",
+// are cached for TTL; failures are not, and neither are the gazetteer's
+// answers while Remote is failing, so the service is asked again once it
+// recovers.
+type Resolver struct {
+	Remote    Geocoder // Optional
+	Gazetteer *Gazetteer
+	TTL       time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedPlace
+}
+
+type cachedPlace struct {
+	place   *Place // nil: not found
+	expires time.Time
+}
+
+// NewResolver returns a Resolver over remote (may be nil) and the bundled
+// gazetteer
+func NewResolver(remote Geocoder) *Resolver {
+	return &Resolver{Remote: remote, Gazetteer: Bundled()}
+}
+
+// Geocode resolves query. The returned Place is the caller's to modify.
+func (r *Resolver) Geocode(ctx context.Context, query string) (*Place, error) {
+	key := fold(query)
+	if key == "" {
+		return nil, ErrNotFound
+	}
+	now := time.Now()
+	r.mu.Lock()
+	cached, ok := r.cache[key]
+	r.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return copyPlace(cached.place)
+	}
+
+	place, remoteFailed, err := r.resolve(ctx, query)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if remoteFailed {
+		return copyPlace(place)
+	}
+
+	ttl := r.TTL
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	r.mu.Lock()
+	if r.cache == nil || len(r.cache) >= maxCacheEntries {
+		r.cache = make(map[string]cachedPlace)
+	}
+	r.cache[key] = cachedPlace{place: place, expires: now.Add(ttl)}
+	r.mu.Unlock()
+	return copyPlace(place)
+}
+
+// resolve also reports whether Remote failed (rather than found nothing), in
+// which case the answer is only the gazetteer's stand-in
+func (r *Resolver) resolve(ctx context.Context, query string) (*Place, bool, error) {
+	remoteFailed := false
+	if r.Remote != nil {
+		place, err := r.Remote.Geocode(ctx, query)
+		switch {
+		case err == nil:
+			r.complete(place)
+			return place, false, nil
+		case errors.Is(err, ErrNotFound):
+		case ctx.Err() != nil:
+			return nil, false, err
+		default:
+			remoteFailed = true
+			slog.WarnContext(ctx, "geocoding failed, using the gazetteer", logging.Err(err))
+		}
+	}
+	if r.Gazetteer == nil {
+		return nil, remoteFailed, ErrNotFound
+	}
+	place, err := r.Gazetteer.Geocode(ctx, query)
+	return place, remoteFailed, err
+}
+
+// complete fills a remote result's country name and timezone from the gazetteer
+func (r *Resolver) complete(p *Place) {
+	if r.Gazetteer == nil {
+		return
+	}
+	if p.Country == "" && p.CountryCode != "" {
+		p.Country, _ = r.Gazetteer.CountryName(p.CountryCode)
+	}
+	if p.Timezone == "" {
+		if city, km := r.Gazetteer.Nearest(p.Lat, p.Lon, p.CountryCode); city != nil && km <= maxTimezoneDistance {
+			p.Timezone = city.Timezone
+		}
+	}
+}
+
+func copyPlace(p *Place) (*Place, error) {
+	if p == nil {
+		return nil, ErrNotFound
+	}
+	c := *p
+	return &c, nil
+}
@@ -0,0 +1,195 @@
+package geocode
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBundled(t *testing.T) {
+	g := Bundled()
+	require.NotEmpty(t, g.cities)
+	for _, c := range g.cities {
+		_, err := time.LoadLocation(c.Timezone)
+		assert.NoError(t, err, c.Name)
+	}
+}
+
+func TestGazetteer_Geocode(t *testing.T) {
+	g := Bundled()
+	ctx := context.Background()
+
+	p, err := g.Geocode(ctx, "Lisbon, Portugal")
+	require.NoError(t, err)
+	assert.Equal(t, &Place{Name: "Lisbon", Country: "Portugal", CountryCode: "PT", Lat: 38.7223, Lon: -9.1393,
+		Timezone: "Europe/Lisbon", Source: "gazetteer"}, p)
+
+	for query, want := range map[string]string{
+		"Pasikuda, Sri Lanka":            "Pasikuda",
+		"passikudah":                     "Pasikuda",
+		"SAO PAULO":                      "Sao Paulo",
+		"Bombay":                         "Mumbai",
+		"Xian":                           "Xi'an",
+		"Ubud, Bali, Indonesia":          "Denpasar",
+		"Manhattan, New York, USA":       "New York",
+		"Cartagena de Indias, Colombia.": "Cartagena",
+	} {
+		p, err := g.Geocode(ctx, query)
+		if assert.NoError(t, err, query) {
+			assert.Equal(t, want, p.Name, query)
+		}
+	}
+
+	p, err = g.Geocode(ctx, "Valencia, Spain")
+	require.NoError(t, err)
+	assert.Equal(t, "ES", p.CountryCode, "a trailing country narrows the match")
+	p, err = g.Geocode(ctx, "Hyderabad")
+	require.NoError(t, err)
+	assert.Equal(t, "IN", p.CountryCode, "the most populous city wins")
+
+	for _, query := range []string{"", "Atlantis", "Paris, Canada", "Sri Lanka"} {
+		_, err := g.Geocode(ctx, query)
+		assert.ErrorIs(t, err, ErrNotFound, query)
+	}
+}
+
+func TestGazetteer_Country(t *testing.T) {
+	g := Bundled()
+	for query, want := range map[string]string{
+		"Delhi, India":         "India",
+		"Nuwara Eliya, Ceylon": "Sri Lanka",
+		"Kyoto":                "Japan",
+		"Scotland":             "United Kingdom",
+	} {
+		name, _, ok := g.Country(query)
+		assert.True(t, ok, query)
+		assert.Equal(t, want, name, query)
+	}
+	_, _, ok := g.Country("Atlantis")
+	assert.False(t, ok)
+}
+
+func TestGazetteer_Nearest(t *testing.T) {
+	g := Bundled()
+	c, km := g.Nearest(38.70, -9.40, "") // Cascais
+	require.NotNil(t, c)
+	assert.Equal(t, "Lisbon", c.Name)
+	assert.InDelta(t, 23, km, 3)
+
+	c, _ = g.Nearest(42.0, 2.8, "ES") // Girona: nearer Marseille than Madrid, but in Spain
+	assert.Equal(t, "Barcelona", c.Name)
+	c, _ = g.Nearest(42.0, 2.8, "XX")
+	assert.Equal(t, "Barcelona", c.Name, "unknown countries fall back to every city")
+}
+
+func TestNominatim(t *testing.T) {
+	var got *http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		switch r.URL.Query().Get("q") {
+		case "Sintra":
+			w.Write([]byte(`[{"lat": "38.7987", "lon": "-9.3881", "name": "Sintra", "display_name": "Sintra, Lisboa, Portugal",
+				"address": {"country": "Portugal", "country_code": "pt"}}]`))
+		case "Nowhere":
+			w.Write([]byte(`[]`))
+		default:
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	n := &Nominatim{BaseURL: srv.URL + "/", UserAgent: "guardian-gateway-test", Email: "ops@example.com", MinInterval: time.Millisecond}
+	ctx := context.Background()
+
+	p, err := n.Geocode(ctx, "Sintra")
+	require.NoError(t, err)
+	assert.Equal(t, &Place{Name: "Sintra", Country: "Portugal", CountryCode: "PT", Lat: 38.7987, Lon: -9.3881, Source: "nominatim"}, p)
+	assert.Equal(t, "/search", got.URL.Path)
+	assert.Equal(t, "jsonv2", got.URL.Query().Get("format"))
+	assert.Equal(t, "ops@example.com", got.URL.Query().Get("email"))
+	assert.Equal(t, "guardian-gateway-test", got.UserAgent())
+
+	_, err = n.Geocode(ctx, "Nowhere")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = n.Geocode(ctx, "Lisbon")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrNotFound)
+}
+
+func TestNominatim_SpacesRequests(t *testing.T) {
+	n := &Nominatim{MinInterval: 50 * time.Millisecond}
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, n.wait(context.Background()))
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, n.wait(ctx), context.Canceled)
+}
+
+type fakeGeocoder struct {
+	calls int
+	place *Place
+	err   error
+}
+
+func (f *fakeGeocoder) Geocode(context.Context, string) (*Place, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	p := *f.place
+	return &p, nil
+}
+
+func TestResolver(t *testing.T) {
+	ctx := context.Background()
+	remote := &fakeGeocoder{place: &Place{Name: "Sintra", CountryCode: "PT", Lat: 38.7987, Lon: -9.3881, Source: "nominatim"}}
+	r := NewResolver(remote)
+
+	p, err := r.Geocode(ctx, "Sintra")
+	require.NoError(t, err)
+	assert.Equal(t, "Portugal", p.Country, "the country name comes from the gazetteer")
+	assert.Equal(t, "Europe/Lisbon", p.Timezone, "the timezone is the nearest bundled city's")
+	p.Name = "changed"
+	p, err = r.Geocode(ctx, " sintra ")
+	require.NoError(t, err)
+	assert.Equal(t, "Sintra", p.Name, "cached answers are copies")
+	assert.Equal(t, 1, remote.calls, "answers are cached")
+
+	remote.err = errors.New("connection refused")
+	p, err = r.Geocode(ctx, "Kandy")
+	require.NoError(t, err)
+	assert.Equal(t, "gazetteer", p.Source, "the gazetteer answers when the service fails")
+	_, err = r.Geocode(ctx, "Atlantis")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, 3, remote.calls)
+
+	// The fallback is not cached, so the service answers once it recovers
+	remote.err = nil
+	remote.place = &Place{Name: "Kandy", CountryCode: "LK", Lat: 7.2906, Lon: 80.6337, Source: "nominatim"}
+	p, err = r.Geocode(ctx, "Kandy")
+	require.NoError(t, err)
+	assert.Equal(t, "nominatim", p.Source)
+	assert.Equal(t, 4, remote.calls)
+
+	remote.err = ErrNotFound
+	_, err = r.Geocode(ctx, "Atlantis")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = r.Geocode(ctx, "Atlantis")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, 5, remote.calls, "not found is cached too")
+
+	offline := NewResolver(nil)
+	p, err = offline.Geocode(ctx, "Kyoto, Japan")
+	require.NoError(t, err)
+	assert.Equal(t, "Asia/Tokyo", p.Timezone)
+}
@@ -0,0 +1,140 @@
+package geocode
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMinInterval spaces Nominatim requests as the public service's usage
+// policy asks (at most one per second)
+const DefaultMinInterval = time.Second
+
+// Nominatim geocodes through a Nominatim-compatible /search endpoint
+// (nominatim.openstreetmap.org, a self-hosted instance, or a compatible
+// provider). It does not report timezones; Resolver fills them in.
+type Nominatim struct {
+	BaseURL string // e.g. https://nominatim.openstreetmap.org
+	// UserAgent identifies the application, as the public service requires
+	UserAgent string
+	Email     string // Optional contact sent with each request
+	Client    *http.Client
+	// MinInterval is the least time between requests; 0 uses DefaultMinInterval
+	MinInterval time.Duration
+
+	mu   sync.Mutex
+	next time.Time // earliest time of the next request
+}
+
+type nominatimResult struct {
+	Lat         string `json:"lat"`
+	Lon         string `json:"lon"`
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	Address     struct {
+		Country     string `json:"country"`
+		CountryCode string `json:"country_code"`
+	} `json:"address"`
+}
+
+// Geocode returns the best match for query
+func (n *Nominatim) Geocode(ctx context.Context, query string) (*Place, error) {
+	u, err := url.Parse(strings.TrimRight(n.BaseURL, "/") + "/search")
+	if err != nil {
+		return nil, fmt.Errorf("invalid nominatim URL: %w", err)
+	}
+	q := url.Values{}
+	q.Set("q", query)
+	q.Set("format", "jsonv2")
+	q.Set("addressdetails", "1")
+	q.Set("limit", "1")
+	q.Set("accept-language", "en")
+	if n.Email != "" {
+		q.Set("email", n.Email)
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if n.UserAgent != "" {
+		req.Header.Set("User-Agent", n.UserAgent)
+	}
+
+	if err := n.wait(ctx); err != nil {
+		return nil, err
+	}
+	client := n.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("nominatim request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("nominatim returned %s", resp.Status)
+	}
+
+	var results []nominatimResult
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return nil, fmt.Errorf("failed to decode nominatim response: %w", err)
+	}
+	if len(results) == 0 {
+		return nil, ErrNotFound
+	}
+	r := results[0]
+	lat, errLat := strconv.ParseFloat(r.Lat, 64)
+	lon, errLon := strconv.ParseFloat(r.Lon, 64)
+	if errLat != nil || errLon != nil {
+		return nil, fmt.Errorf("nominatim returned invalid coordinates %q, %q", r.Lat, r.Lon)
+	}
+	name := r.Name
+	if name == "" {
+		name, _, _ = strings.Cut(r.DisplayName, ",")
+	}
+	return &Place{
+		Name:        strings.TrimSpace(name),
+		Country:     r.Address.Country,
+		CountryCode: strings.ToUpper(r.Address.CountryCode),
+		Lat:         lat,
+		Lon:         lon,
+		Source:      "nominatim",
+	}, nil
+}
+
+// wait blocks until the next request is allowed
+func (n *Nominatim) wait(ctx context.Context) error {
+	interval := n.MinInterval
+	if interval <= 0 {
+		interval = DefaultMinInterval
+	}
+	n.mu.Lock()
+	now := time.Now()
+	at := n.next
+	if at.Before(now) {
+		at = now
+	}
+	n.next = at.Add(interval)
+	n.mu.Unlock()
+
+	if d := time.Until(at); d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+// Package health runs the dependency checks behind the readiness probe. Checks
+// run concurrently with their own timeouts; slow or rate-limited ones can cache
+// their result, and non-critical failures degrade the report without failing it.
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Status of a single check or of the whole report
+type Status string
+
+const (
+	StatusOK          Status = "ok"
+	StatusFail        Status = "fail"
+	StatusSkipped     Status = "skipped"     // check not applicable (e.g. feature disabled)
+	StatusDegraded    Status = "degraded"    // report: only non-critical checks failed
+	StatusUnavailable Status = "unavailable" // report: a critical check failed
+)
+
+// DefaultTimeout bounds a check that doesn't set its own
+const DefaultTimeout = 2 * time.Second
+
+// Detail carries check-specific facts (versions, counts, timestamps)
+type Detail map[string]any
+
+// CheckFunc probes one dependency. Return Skip(...) when the check does not apply.
+type CheckFunc func(ctx context.Context) (Detail, error)
+
+// Check is a named dependency probe
+type Check struct {
+	Name string
+	// Critical failures make the service unready; others only degrade it
+	Critical bool
+	Timeout  time.Duration
+	// CacheTTL reuses the last result for this long (0 runs the check every time)
+	CacheTTL time.Duration
+	Func     CheckFunc
+}
+
+// Result is the outcome of one check
+type Result struct {
+	Status    Status    `json:"status"`
+	Critical  bool      `json:"critical"`
+	Detail    Detail    `json:"detail,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	LatencyMS int64     `json:"latency_ms"`
+	CheckedAt time.Time `json:"checked_at"`
+	Cached    bool      `json:"cached,omitempty"`
+}
+
+// Report is the readiness response body
+type Report struct {
+	Status    Status            `json:"status"`
+	Checks    map[string]Result `json:"checks"`
+	CheckedAt time.Time         `json:"checked_at"`
+}
+
+// Ready reports whether the service should receive traffic
+func (r Report) Ready() bool {
+	return r.Status != StatusUnavailable
+}
+
+type skipError struct{ reason string }
+
+func (e skipError) Error() string { return e.reason }
+
+// Skip marks a check as not applicable, with a reason shown in the report
+func Skip(reason string) error {
+	return skipError{reason: reason}
+}
+
+// Checker runs a fixed set of checks
+type Checker struct {
+	checks []Check
+	now    func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]Result
+}
+
+// NewChecker returns a Checker for the given checks
+func NewChecker(checks ...Check) *Checker {
+	return &Checker{
+		checks: checks,
+		now:    time.Now,
+		cache:  make(map[string]Result),
+	}
+}
+
+// Run executes every check concurrently and aggregates the results
+func (c *Checker) Run(ctx context.Context) Report {
+	report := Report{
+		Status:    StatusOK,
+		Checks:    make(map[string]Result, len(c.checks)),
+		CheckedAt: c.now().UTC(),
+	}
+
+	results := make([]Result, len(c.checks))
+	var wg sync.WaitGroup
+	for i, check := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = c.run(ctx, check)
+		}()
+	}
+	wg.Wait()
+
+	for i, check := range c.checks {
+		res := results[i]
+		report.Checks[check.Name] = res
+		if res.Status != StatusFail {
+			continue
+		}
+		if check.Critical {
+			report.Status = StatusUnavailable
+		} else if report.Status == StatusOK {
+			report.Status = StatusDegraded
+		}
+	}
+	return report
+}
+
+// run executes one check, or returns its cached result while still fresh
+func (c *Checker) run(ctx context.Context, check Check) Result {
+	if check.CacheTTL > 0 {
+		c.mu.Lock()
+		cached, ok := c.cache[check.Name]
+		c.mu.Unlock()
+		if ok && c.now().Sub(cached.CheckedAt) < check.CacheTTL {
+			cached.Cached = true
+			return cached
+		}
+	}
+
+	timeout := check.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := c.now()
+	detail, err := check.Func(ctx)
+	res := Result{
+		Status:    StatusOK,
+		Critical:  check.Critical,
+		Detail:    detail,
+		LatencyMS: c.now().Sub(start).Milliseconds(),
+		CheckedAt: start.UTC(),
+	}
+	var skip skipError
+	switch {
+	case errors.As(err, &skip):
+		res.Status, res.Error = StatusSkipped, skip.reason
+	case err != nil:
+		res.Status, res.Error = StatusFail, err.Error()
+	}
+
+	if check.CacheTTL > 0 {
+		c.mu.Lock()
+		c.cache[check.Name] = res
+		c.mu.Unlock()
+	}
+	return res
+}
@@ -0,0 +1,85 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ok(context.Context) (Detail, error) { return Detail{"version": "1"}, nil }
+
+func fail(context.Context) (Detail, error) { return nil, errors.New("boom") }
+
+func TestRun_Aggregation(t *testing.T) {
+	tests := []struct {
+		name   string
+		checks []Check
+		want   Status
+	}{
+		{"all ok", []Check{{Name: "db", Critical: true, Func: ok}, {Name: "llm", Func: ok}}, StatusOK},
+		{"optional fails", []Check{{Name: "db", Critical: true, Func: ok}, {Name: "llm", Func: fail}}, StatusDegraded},
+		{"critical fails", []Check{{Name: "db", Critical: true, Func: fail}, {Name: "llm", Func: fail}}, StatusUnavailable},
+		{"skipped is not a failure", []Check{{Name: "scheduler", Critical: true, Func: func(context.Context) (Detail, error) {
+			return nil, Skip("disabled")
+		}}}, StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := NewChecker(tt.checks...).Run(context.Background())
+			assert.Equal(t, tt.want, report.Status)
+			assert.Equal(t, tt.want != StatusUnavailable, report.Ready())
+			assert.Len(t, report.Checks, len(tt.checks))
+		})
+	}
+}
+
+func TestRun_ResultDetail(t *testing.T) {
+	report := NewChecker(
+		Check{Name: "db", Critical: true, Func: fail},
+		Check{Name: "fastgraph", Func: ok},
+		Check{Name: "scheduler", Func: func(context.Context) (Detail, error) { return nil, Skip("disabled") }},
+	).Run(context.Background())
+
+	db := report.Checks["db"]
+	assert.Equal(t, StatusFail, db.Status)
+	assert.True(t, db.Critical)
+	assert.Equal(t, "boom", db.Error)
+
+	assert.Equal(t, Detail{"version": "1"}, report.Checks["fastgraph"].Detail)
+
+	sched := report.Checks["scheduler"]
+	assert.Equal(t, StatusSkipped, sched.Status)
+	assert.Equal(t, "disabled", sched.Error)
+}
+
+func TestRun_Timeout(t *testing.T) {
+	report := NewChecker(Check{Name: "slow", Critical: true, Timeout: 10 * time.Millisecond, Func: func(ctx context.Context) (Detail, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}}).Run(context.Background())
+	assert.Equal(t, StatusFail, report.Checks["slow"].Status)
+	assert.Contains(t, report.Checks["slow"].Error, "deadline exceeded")
+}
+
+func TestRun_Cache(t *testing.T) {
+	var calls atomic.Int32
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := NewChecker(Check{Name: "llm", CacheTTL: time.Minute, Func: func(context.Context) (Detail, error) {
+		calls.Add(1)
+		return nil, nil
+	}})
+	c.now = func() time.Time { return now }
+
+	assert.False(t, c.Run(context.Background()).Checks["llm"].Cached)
+	now = now.Add(30 * time.Second)
+	assert.True(t, c.Run(context.Background()).Checks["llm"].Cached)
+	assert.EqualValues(t, 1, calls.Load())
+
+	now = now.Add(time.Minute)
+	assert.False(t, c.Run(context.Background()).Checks["llm"].Cached)
+	assert.EqualValues(t, 2, calls.Load())
+}
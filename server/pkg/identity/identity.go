@@ -0,0 +1,163 @@
+// Package identity links anonymous devices to the users they log in as and
+// carries the device's feed and session over to the user.
+package identity
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"guardian-gateway/pkg/auth"
+	"guardian-gateway/pkg/session"
+	"guardian-gateway/pkg/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultRecheck is how long a merged device/user pair is trusted before the
+// store is asked again (catching cards written anonymously after a logout)
+const defaultRecheck = 10 * time.Minute
+
+// sweepThreshold bounds the merged cache; above it expired entries are dropped
+const sweepThreshold = 10000
+
+// LinkStore persists device links and moves cards. PostgresStore implements it.
+type LinkStore interface {
+	MergeDeviceIntoUser(ctx context.Context, deviceID, deviceOwner, userID string) (*store.MergeResult, error)
+}
+
+// SessionStore persists sessions across restarts. PostgresStore implements it.
+type SessionStore interface {
+	LoadSession(ctx context.Context, id string) (*store.SessionRecord, error)
+	SaveSession(ctx context.Context, rec *store.SessionRecord) error
+}
+
+// Service merges anonymous device data into authenticated users
+type Service struct {
+	Store    LinkStore
+	Sessions func() *session.SessionManager
+	// Saved, when set, holds the stored sessions: the user's is restored
+	// before merging (so it is not overwritten by the device's) and the
+	// merged session is written back
+	Saved   SessionStore
+	Recheck time.Duration
+
+	mu     sync.Mutex
+	merged map[string]time.Time // device|user -> last successful merge
+}
+
+// NewService creates an identity service
+func NewService(s LinkStore, sessions func() *session.SessionManager) *Service {
+	return &Service{
+		Store:    s,
+		Sessions: sessions,
+		Recheck:  defaultRecheck,
+		merged:   make(map[string]time.Time),
+	}
+}
+
+// Link merges the principal's device into its user. Anonymous principals and
+// users without a device ID are ignored. The store transaction runs first; the
+// in-memory session is only merged once the cards are committed.
+func (s *Service) Link(ctx context.Context, p *auth.Principal) (*store.MergeResult, error) {
+	if !p.Authenticated() || p.DeviceID == "" {
+		return nil, nil
+	}
+	key := p.DeviceID + "|" + p.UserID
+	if s.recentlyMerged(key) {
+		return nil, nil
+	}
+
+	deviceOwner := auth.AnonymousOwnerID(p.DeviceID)
+	res, err := s.Store.MergeDeviceIntoUser(ctx, p.DeviceID, deviceOwner, p.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if s.Sessions != nil {
+		if sm := s.Sessions(); sm != nil {
+			s.mergeSessions(ctx, sm, deviceOwner, p.UserID, res.DeviceSession)
+		}
+	}
+
+	s.mu.Lock()
+	if len(s.merged) >= sweepThreshold {
+		for k, at := range s.merged {
+			if time.Since(at) >= s.Recheck {
+				delete(s.merged, k)
+			}
+		}
+	}
+	s.merged[key] = time.Now()
+	s.mu.Unlock()
+	return res, nil
+}
+
+// mergeSessions merges the device's session into the user's. Sessions that
+// are only stored (after a restart, or on another replica) are restored first
+// with their stored time, so the one used last wins like two live sessions.
+func (s *Service) mergeSessions(ctx context.Context, sm *session.SessionManager, deviceOwner, userID string, device *store.SessionRecord) {
+	if s.Saved != nil && !sm.Has(userID) {
+		rec, err := s.Saved.LoadSession(ctx, userID)
+		switch {
+		case err == nil:
+			if _, err := sm.RestoreSeen(userID, rec.Data, rec.UpdatedAt); err != nil {
+				slog.WarnContext(ctx, "discarding unreadable stored session", "error", err)
+			}
+		case !errors.Is(err, store.ErrNotFound):
+			slog.WarnContext(ctx, "failed to load stored session", "error", err)
+		}
+	}
+	if device != nil && !sm.Has(deviceOwner) {
+		if _, err := sm.RestoreSeen(deviceOwner, device.Data, device.UpdatedAt); err != nil {
+			slog.WarnContext(ctx, "discarding unreadable device session", "error", err)
+		}
+	}
+	if !sm.Merge(deviceOwner, userID) || s.Saved == nil {
+		return
+	}
+
+	// The device's row is gone with the merge transaction, so save the
+	// merged session now rather than waiting for the next chat
+	data, err := sm.GetOrCreate(userID).Snapshot()
+	if err == nil {
+		err = s.Saved.SaveSession(ctx, &store.SessionRecord{ID: userID, Data: data})
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "failed to save merged session", "error", err)
+	}
+}
+
+// Middleware links devices on authenticated requests. It must run after the
+// auth middleware. Failures are logged and the request proceeds.
+func (s *Service) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if p, ok := auth.FromContext(c); ok {
+			res, err := s.Link(c.Request.Context(), p)
+			if err != nil {
+				slog.WarnContext(c.Request.Context(), "failed to merge device into user", "device_id", p.DeviceID, "error", err)
+			} else if res != nil && (res.FirstLink || res.CardsMoved > 0) {
+				slog.InfoContext(c.Request.Context(), "linked device to user", "device_id", p.DeviceID, "cards_moved", res.CardsMoved, "cards_dropped", res.CardsDropped)
+			}
+		}
+		c.Next()
+	}
+}
+
+func (s *Service) recentlyMerged(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.merged == nil {
+		s.merged = make(map[string]time.Time)
+	}
+	at, ok := s.merged[key]
+	if !ok {
+		return false
+	}
+	if time.Since(at) < s.Recheck {
+		return true
+	}
+	delete(s.merged, key)
+	return false
+}
@@ -0,0 +1,137 @@
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"guardian-gateway/pkg/auth"
+	"guardian-gateway/pkg/session"
+	"guardian-gateway/pkg/store"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeLinkStore struct {
+	calls  int
+	err    error
+	owner  string
+	device *store.SessionRecord
+}
+
+func (f *fakeLinkStore) MergeDeviceIntoUser(ctx context.Context, deviceID, deviceOwner, userID string) (*store.MergeResult, error) {
+	f.calls++
+	f.owner = deviceOwner
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &store.MergeResult{FirstLink: f.calls == 1, CardsMoved: 2, DeviceSession: f.device}, nil
+}
+
+type fakeSessionStore map[string]*store.SessionRecord
+
+func (f fakeSessionStore) LoadSession(ctx context.Context, id string) (*store.SessionRecord, error) {
+	rec, ok := f[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return rec, nil
+}
+
+func (f fakeSessionStore) SaveSession(ctx context.Context, rec *store.SessionRecord) error {
+	f[rec.ID] = rec
+	return nil
+}
+
+func newTestService(ls LinkStore) (*Service, *session.SessionManager) {
+	session.Init()
+	sm := session.GlobalManager
+	return NewService(ls, func() *session.SessionManager { return sm }), sm
+}
+
+func TestLink_MergesDeviceIntoUser(t *testing.T) {
+	ls := &fakeLinkStore{}
+	svc, sm := newTestService(ls)
+	sm.GetOrCreate(auth.AnonymousOwnerID("device-1234")).UpdateVariables(map[string]string{"destination": "Paris"})
+
+	p := &auth.Principal{Kind: auth.KindUser, UserID: "user-1", DeviceID: "device-1234"}
+	res, err := svc.Link(context.Background(), p)
+	require.NoError(t, err)
+	assert.True(t, res.FirstLink)
+	assert.Equal(t, "device:device-1234", ls.owner)
+	assert.Equal(t, "Paris", sm.GetOrCreate("user-1").GetVariables()["destination"])
+
+	// Subsequent requests skip the store until the recheck interval passes
+	res, err = svc.Link(context.Background(), p)
+	require.NoError(t, err)
+	assert.Nil(t, res)
+	assert.Equal(t, 1, ls.calls)
+}
+
+func TestLink_StoreFailureLeavesSessionAndRetries(t *testing.T) {
+	ls := &fakeLinkStore{err: errors.New("db down")}
+	svc, sm := newTestService(ls)
+	anon := sm.GetOrCreate(auth.AnonymousOwnerID("device-1234"))
+	anon.AppendMessage("user", "hello")
+
+	p := &auth.Principal{Kind: auth.KindUser, UserID: "user-1", DeviceID: "device-1234"}
+	_, err := svc.Link(context.Background(), p)
+	assert.Error(t, err)
+	assert.Empty(t, sm.GetOrCreate("user-1").GetHistory(), "session must not move without the cards")
+	assert.Len(t, anon.GetHistory(), 1)
+
+	ls.err = nil
+	_, err = svc.Link(context.Background(), p)
+	require.NoError(t, err)
+	assert.Equal(t, 2, ls.calls)
+	assert.Len(t, sm.GetOrCreate("user-1").GetHistory(), 1)
+}
+
+func TestLink_IgnoresAnonymousAndDeviceless(t *testing.T) {
+	ls := &fakeLinkStore{}
+	svc, _ := newTestService(ls)
+
+	for _, p := range []*auth.Principal{
+		{Kind: auth.KindAnonymous, DeviceID: "device-1234"},
+		{Kind: auth.KindUser, UserID: "user-1"},
+	} {
+		res, err := svc.Link(context.Background(), p)
+		assert.NoError(t, err)
+		assert.Nil(t, res)
+	}
+	assert.Zero(t, ls.calls)
+}
+
+func TestLink_MergesStoredSessions(t *testing.T) {
+	// After a restart neither session is live; both only exist in the store
+	ls := &fakeLinkStore{device: &store.SessionRecord{
+		ID:        "device:device-1234",
+		Data:      []byte(`{"variables": {"destination": "Lisbon"}, "history": [{"role": "user", "content": "new trip"}]}`),
+		UpdatedAt: time.Now(),
+	}}
+	saved := fakeSessionStore{"user-1": {
+		ID:        "user-1",
+		Data:      []byte(`{"variables": {"destination": "Porto", "budget": "2000"}, "history": [{"role": "user", "content": "old trip"}]}`),
+		UpdatedAt: time.Now().Add(-time.Hour),
+	}}
+	svc, sm := newTestService(ls)
+	svc.Saved = saved
+
+	_, err := svc.Link(context.Background(), &auth.Principal{Kind: auth.KindUser, UserID: "user-1", DeviceID: "device-1234"})
+	require.NoError(t, err)
+
+	sess := sm.GetOrCreate("user-1")
+	assert.Equal(t, map[string]string{"destination": "Lisbon", "budget": "2000"}, sess.GetVariables(), "the device was used last")
+	require.Len(t, sess.GetHistory(), 2)
+	assert.Equal(t, "old trip", sess.GetHistory()[0].Content)
+	assert.False(t, sm.Has("device:device-1234"))
+
+	var merged struct {
+		Variables map[string]string `json:"variables"`
+	}
+	require.NoError(t, json.Unmarshal(saved["user-1"].Data, &merged))
+	assert.Equal(t, "2000", merged.Variables["budget"], "the merged session is saved over the user's row")
+}
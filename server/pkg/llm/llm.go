@@ -2,16 +2,29 @@ package llm
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"net/http"
-	"os"
 	"strings"
+	"sync"
 	"time"
+
+	"guardian-gateway/pkg/metrics"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const tracerScope = "guardian-gateway/pkg/llm"
+
 // OpenAIResponse represents the response from OpenAI-compatible endpoints (LiteLLM proxy)
 type OpenAIResponse struct {
 	Choices []struct {
@@ -23,6 +36,38 @@ type OpenAIResponse struct {
 		Message string `json:"message"`
 		Type    string `json:"type"`
 	} `json:"error,omitempty"`
+	Model string      `json:"model,omitempty"`
+	Usage *TokenUsage `json:"usage,omitempty"`
+}
+
+// Settings configure the LiteLLM proxy connection
+type Settings struct {
+	ProxyURL string
+	APIKey   string // Fallback key when the caller has no per-user key
+	Model    string
+	Timeout  time.Duration
+}
+
+var (
+	settingsMu sync.RWMutex
+	settings   *Settings
+)
+
+// Configure sets the proxy connection used by GenerateContent, from the
+// gateway's LiteLLM config. Until it is called, no proxy is configured.
+func Configure(s Settings) {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	settings = &s
+}
+
+func currentSettings() Settings {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	if settings != nil {
+		return *settings
+	}
+	return Settings{}
 }
 
 // mapToUserFriendlyError converts technical backend errors to user-friendly messages
@@ -82,28 +127,47 @@ func mapToUserFriendlyError(err error, statusCode int) error {
 
 // GenerateContent calls the LiteLLM proxy with the conversation history
 // Production always uses LiteLLM proxy for billing tracking and rate limiting
-// If userApiKey is provided, it will be used instead of the environment variable
+// If userApiKey is provided, it will be used instead of the configured API key
 func GenerateContent(history []map[string]interface{}, systemPrompt string, userApiKey ...string) (string, error) {
-	// Use user-provided API key if available, otherwise fall back to environment variable
+	content, _, err := GenerateContentWithUsage(history, systemPrompt, userApiKey...)
+	return content, err
+}
+
+// GenerateContentWithUsage behaves like GenerateContent but also returns the
+// token usage and estimated cost reported by the proxy (nil if no response was received)
+func GenerateContentWithUsage(history []map[string]interface{}, systemPrompt string, userApiKey ...string) (string, *Usage, error) {
+	return GenerateContentContext(context.Background(), history, systemPrompt, userApiKey...)
+}
+
+// GenerateContentContext is GenerateContentWithUsage bound to ctx: the call is
+// cancelled with ctx and traced as a child of its span
+func GenerateContentContext(ctx context.Context, history []map[string]interface{}, systemPrompt string, userApiKey ...string) (string, *Usage, error) {
+	cfg := currentSettings()
+
+	// Use user-provided API key if available, otherwise fall back to the configured key
 	var apiKey string
 	if len(userApiKey) > 0 && userApiKey[0] != "" {
 		apiKey = userApiKey[0]
 	} else {
-		apiKey = os.Getenv("LITELLM_API_KEY")
+		apiKey = cfg.APIKey
 	}
 	if apiKey == "" {
-		return "", fmt.Errorf("LITELLM_API_KEY not set")
+		return "", nil, fmt.Errorf("LITELLM_API_KEY not set")
 	}
 
-	proxyURL := os.Getenv("LITELLM_PROXY_URL")
+	proxyURL := cfg.ProxyURL
 	if proxyURL == "" {
-		return "", fmt.Errorf("LITELLM_PROXY_URL not set")
+		return "", nil, fmt.Errorf("LITELLM_PROXY_URL not set")
 	}
 
-	model := os.Getenv("LITELLM_MODEL")
+	model := cfg.Model
 	if model == "" {
 		model = "gemini-2.0-flash" // Default model
 	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
 
 	url := proxyURL + "/v1/chat/completions"
 
@@ -142,52 +206,142 @@ func GenerateContent(history []map[string]interface{}, systemPrompt string, user
 
 	jsonData, err := json.Marshal(requestBody)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	ctx, span := otel.Tracer(tracerScope).Start(ctx, "llm.chat_completion",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("gen_ai.system", "litellm"),
+			attribute.String("gen_ai.operation.name", "chat"),
+			attribute.String("gen_ai.request.model", model),
+			attribute.Int("llm.messages", len(messages)),
+		),
+	)
+	defer span.End()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+apiKey)
+	// Let the proxy join the trace
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
 
 	client := &http.Client{
-		Timeout: 30 * time.Second,
+		Timeout: timeout,
 	}
+	start := time.Now()
+	observe := func(class string) {
+		metrics.LLMRequestDuration.WithLabelValues(class).Observe(time.Since(start).Seconds())
+		if class != "ok" {
+			metrics.LLMErrors.WithLabelValues(class).Inc()
+			span.SetStatus(codes.Error, class)
+		}
+		span.SetAttributes(attribute.String("llm.outcome", class))
+	}
+
 	resp, err := client.Do(req)
 	if err != nil {
 		// Log detailed error for debugging
-		fmt.Printf("LLM HTTP Error: %v (URL: %s)\n", err, url)
+		slog.Error("LLM request failed", "url", url, "error", err)
+		observe(ErrorClass(err, 0))
 		// Map to user-friendly error
-		return "", mapToUserFriendlyError(err, 0)
+		return "", nil, mapToUserFriendlyError(err, 0)
 	}
 	defer resp.Body.Close()
 
 	bodyBytes, _ := io.ReadAll(resp.Body)
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
 
 	if resp.StatusCode != http.StatusOK {
 		// Log detailed error for debugging
-		fmt.Printf("LLM Proxy Error: Status=%d, Body=%s\n", resp.StatusCode, string(bodyBytes))
+		slog.Error("LLM proxy error", "status", resp.StatusCode, "body", string(bodyBytes))
 		// Try to parse error from LiteLLM
+		technicalErr := fmt.Errorf("litellm proxy error (%d): %s", resp.StatusCode, string(bodyBytes))
 		var errResp OpenAIResponse
 		if json.Unmarshal(bodyBytes, &errResp) == nil && errResp.Error != nil {
-			technicalErr := fmt.Errorf("litellm proxy error (%d): %s", resp.StatusCode, errResp.Error.Message)
-			return "", mapToUserFriendlyError(technicalErr, resp.StatusCode)
+			technicalErr = fmt.Errorf("litellm proxy error (%d): %s", resp.StatusCode, errResp.Error.Message)
 		}
-		technicalErr := fmt.Errorf("litellm proxy error (%d): %s", resp.StatusCode, string(bodyBytes))
-		return "", mapToUserFriendlyError(technicalErr, resp.StatusCode)
+		observe(ErrorClass(technicalErr, resp.StatusCode))
+		return "", nil, mapToUserFriendlyError(technicalErr, resp.StatusCode)
 	}
 
 	var openaiResp OpenAIResponse
 	if err := json.Unmarshal(bodyBytes, &openaiResp); err != nil {
-		return "", fmt.Errorf("failed to parse litellm response: %w", err)
+		observe("bad_response")
+		return "", nil, fmt.Errorf("failed to parse litellm response: %w", err)
+	}
+
+	usage := newUsage(model, openaiResp, resp.Header.Get("x-litellm-response-cost"))
+	if usage != nil {
+		span.SetAttributes(
+			attribute.Int("gen_ai.usage.input_tokens", usage.PromptTokens),
+			attribute.Int("gen_ai.usage.output_tokens", usage.CompletionTokens),
+		)
 	}
 
 	if len(openaiResp.Choices) > 0 {
-		return openaiResp.Choices[0].Message.Content, nil
+		observe("ok")
+		return openaiResp.Choices[0].Message.Content, usage, nil
+	}
+
+	observe("empty_response")
+	return "", usage, fmt.Errorf("no content generated from litellm")
+}
+
+// ErrNotConfigured reports that no LiteLLM proxy URL is set
+var ErrNotConfigured = errors.New("LITELLM_PROXY_URL not set")
+
+// Ping checks that the LiteLLM proxy answers its unauthenticated liveness route
+func Ping(ctx context.Context) error {
+	proxyURL := currentSettings().ProxyURL
+	if proxyURL == "" {
+		return ErrNotConfigured
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(proxyURL, "/")+"/health/liveliness", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("litellm proxy unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("litellm proxy liveness returned %d", resp.StatusCode)
 	}
+	return nil
+}
 
-	return "", fmt.Errorf("no content generated from litellm")
+// ErrorClass buckets a failed call for metrics. statusCode is 0 when no
+// response was received.
+func ErrorClass(err error, statusCode int) string {
+	if statusCode == 0 {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return "timeout"
+		}
+		return "network"
+	}
+
+	msg := ""
+	if err != nil {
+		msg = strings.ToLower(err.Error())
+	}
+	switch {
+	case statusCode == http.StatusTooManyRequests:
+		return "rate_limited"
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return "auth"
+	case statusCode == http.StatusPaymentRequired || strings.Contains(msg, "budget"):
+		// LiteLLM reports an exhausted key budget as a 400 with a budget message
+		return "budget_exceeded"
+	case statusCode >= 500:
+		return "upstream_error"
+	}
+	return "client_error"
 }
@@ -1,14 +1,35 @@
 package llm
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"net"
 	"net/http"
 	"net/http/httptest"
-	"os"
+	"net/url"
 	"strings"
 	"testing"
+
+	"guardian-gateway/pkg/metrics"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
+// configure sets the package settings for one test
+func configure(t *testing.T, s Settings) {
+	t.Helper()
+	settingsMu.RLock()
+	prev := settings
+	settingsMu.RUnlock()
+	Configure(s)
+	t.Cleanup(func() {
+		settingsMu.Lock()
+		settings = prev
+		settingsMu.Unlock()
+	})
+}
+
 // TestGenerateContent_Success tests successful LiteLLM proxy response
 func TestGenerateContent_Success(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -36,10 +57,7 @@ func TestGenerateContent_Success(t *testing.T) {
 	}))
 	defer server.Close()
 
-	os.Setenv("LITELLM_PROXY_URL", server.URL)
-	os.Setenv("LITELLM_API_KEY", "test-key")
-	defer os.Unsetenv("LITELLM_PROXY_URL")
-	defer os.Unsetenv("LITELLM_API_KEY")
+	configure(t, Settings{ProxyURL: server.URL, APIKey: "test-key"})
 
 	history := []map[string]interface{}{
 		{"role": "user", "content": "I want to visit Paris"},
@@ -72,10 +90,7 @@ func TestGenerateContent_RateLimit(t *testing.T) {
 	}))
 	defer server.Close()
 
-	os.Setenv("LITELLM_PROXY_URL", server.URL)
-	os.Setenv("LITELLM_API_KEY", "test-key")
-	defer os.Unsetenv("LITELLM_PROXY_URL")
-	defer os.Unsetenv("LITELLM_API_KEY")
+	configure(t, Settings{ProxyURL: server.URL, APIKey: "test-key"})
 
 	history := []map[string]interface{}{
 		{"role": "user", "content": "Hello"},
@@ -89,13 +104,43 @@ func TestGenerateContent_RateLimit(t *testing.T) {
 	if !strings.Contains(err.Error(), "high traffic") {
 		t.Errorf("Expected user-friendly error, got: %v", err)
 	}
+	if v := testutil.ToFloat64(metrics.LLMErrors.WithLabelValues("rate_limited")); v < 1 {
+		t.Errorf("Expected rate_limited error to be counted, got %v", v)
+	}
+}
+
+// TestErrorClass checks the metric labels for failed calls
+func TestErrorClass(t *testing.T) {
+	cases := []struct {
+		err    error
+		status int
+		want   string
+	}{
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, 0, "network"},
+		{context.DeadlineExceeded, 0, "timeout"},
+		{&url.Error{Op: "Post", URL: "http://proxy", Err: timeoutErr{}}, 0, "timeout"},
+		{nil, http.StatusTooManyRequests, "rate_limited"},
+		{nil, http.StatusUnauthorized, "auth"},
+		{errors.New("litellm proxy error (400): Budget has been exceeded"), http.StatusBadRequest, "budget_exceeded"},
+		{errors.New("litellm proxy error (400): bad model"), http.StatusBadRequest, "client_error"},
+		{nil, http.StatusBadGateway, "upstream_error"},
+	}
+	for _, tc := range cases {
+		if got := ErrorClass(tc.err, tc.status); got != tc.want {
+			t.Errorf("ErrorClass(%v, %d) = %q, want %q", tc.err, tc.status, got, tc.want)
+		}
+	}
 }
 
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "i/o timeout" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
+
 // TestGenerateContent_MissingAPIKey tests validation
 func TestGenerateContent_MissingAPIKey(t *testing.T) {
-	os.Unsetenv("LITELLM_API_KEY")
-	os.Setenv("LITELLM_PROXY_URL", "https://example.com")
-	defer os.Unsetenv("LITELLM_PROXY_URL")
+	configure(t, Settings{ProxyURL: "https://example.com"})
 
 	history := []map[string]interface{}{
 		{"role": "user", "content": "Hello"},
@@ -113,9 +158,7 @@ func TestGenerateContent_MissingAPIKey(t *testing.T) {
 
 // TestGenerateContent_MissingProxyURL tests validation
 func TestGenerateContent_MissingProxyURL(t *testing.T) {
-	os.Setenv("LITELLM_API_KEY", "test-key")
-	os.Unsetenv("LITELLM_PROXY_URL")
-	defer os.Unsetenv("LITELLM_API_KEY")
+	configure(t, Settings{APIKey: "test-key"})
 
 	history := []map[string]interface{}{
 		{"role": "user", "content": "Hello"},
@@ -145,10 +188,7 @@ func TestGenerateContent_EmptyResponse(t *testing.T) {
 	}))
 	defer server.Close()
 
-	os.Setenv("LITELLM_PROXY_URL", server.URL)
-	os.Setenv("LITELLM_API_KEY", "test-key")
-	defer os.Unsetenv("LITELLM_PROXY_URL")
-	defer os.Unsetenv("LITELLM_API_KEY")
+	configure(t, Settings{ProxyURL: server.URL, APIKey: "test-key"})
 
 	history := []map[string]interface{}{
 		{"role": "user", "content": "Hello"},
@@ -192,10 +232,7 @@ func TestGenerateContent_RoleNormalization(t *testing.T) {
 	}))
 	defer server.Close()
 
-	os.Setenv("LITELLM_PROXY_URL", server.URL)
-	os.Setenv("LITELLM_API_KEY", "test-key")
-	defer os.Unsetenv("LITELLM_PROXY_URL")
-	defer os.Unsetenv("LITELLM_API_KEY")
+	configure(t, Settings{ProxyURL: server.URL, APIKey: "test-key"})
 
 	history := []map[string]interface{}{
 		{"role": "model", "content": "Hi"}, // "model" should become "assistant"
@@ -206,3 +243,89 @@ func TestGenerateContent_RoleNormalization(t *testing.T) {
 		t.Fatalf("Unexpected error: %v", err)
 	}
 }
+
+// TestGenerateContentWithUsage_ProxyCost tests usage parsing with the LiteLLM cost header
+func TestGenerateContentWithUsage_ProxyCost(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-litellm-response-cost", "0.0042")
+		_, _ = w.Write([]byte(`{"model":"gemini/gemini-2.0-flash","choices":[{"message":{"content":"Hi"}}],"usage":{"prompt_tokens":100,"completion_tokens":20,"total_tokens":120}}`))
+	}))
+	defer server.Close()
+
+	configure(t, Settings{ProxyURL: server.URL, APIKey: "test-key"})
+
+	content, usage, err := GenerateContentWithUsage([]map[string]interface{}{{"role": "user", "content": "Hello"}}, "System")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if content != "Hi" {
+		t.Errorf("Expected 'Hi', got: %s", content)
+	}
+	if usage == nil {
+		t.Fatal("Expected usage")
+	}
+	if usage.Model != "gemini/gemini-2.0-flash" || usage.PromptTokens != 100 || usage.CompletionTokens != 20 || usage.TotalTokens != 120 {
+		t.Errorf("Unexpected usage: %+v", usage)
+	}
+	if usage.CostUSD != 0.0042 || usage.CostEstimated {
+		t.Errorf("Expected proxy-reported cost, got: %+v", usage)
+	}
+}
+
+// TestGenerateContentWithUsage_EstimatedCost tests the local price table fallback
+func TestGenerateContentWithUsage_EstimatedCost(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"Hi"}}],"usage":{"prompt_tokens":1000000,"completion_tokens":1000000}}`))
+	}))
+	defer server.Close()
+
+	configure(t, Settings{ProxyURL: server.URL, APIKey: "test-key", Model: "gemini-2.0-flash"})
+
+	_, usage, err := GenerateContentWithUsage([]map[string]interface{}{{"role": "user", "content": "Hello"}}, "System")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if usage.TotalTokens != 2000000 {
+		t.Errorf("Expected total tokens to be derived, got %d", usage.TotalTokens)
+	}
+	if !usage.CostEstimated || usage.CostUSD < 0.4999 || usage.CostUSD > 0.5001 {
+		t.Errorf("Expected estimated cost 0.50, got: %+v", usage)
+	}
+}
+
+// TestEstimateCost tests prefix matching in the price table
+func TestEstimateCost(t *testing.T) {
+	if got := EstimateCost("gemini-2.0-flash-lite-001", 1000000, 0); got != 0.075 {
+		t.Errorf("Expected longest prefix match (0.075), got %v", got)
+	}
+	if got := EstimateCost("unknown-model", 1000, 1000); got != 0 {
+		t.Errorf("Expected zero cost for unknown model, got %v", got)
+	}
+}
+
+// TestPing checks the proxy liveness probe used by the readiness endpoint
+func TestPing(t *testing.T) {
+	configure(t, Settings{})
+	if err := Ping(context.Background()); !errors.Is(err, ErrNotConfigured) {
+		t.Fatalf("Expected ErrNotConfigured, got %v", err)
+	}
+
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health/liveliness" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	configure(t, Settings{ProxyURL: server.URL + "/"})
+
+	if err := Ping(context.Background()); err != nil {
+		t.Fatalf("Expected healthy proxy, got %v", err)
+	}
+	status = http.StatusServiceUnavailable
+	if err := Ping(context.Background()); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("Expected 503 error, got %v", err)
+	}
+}
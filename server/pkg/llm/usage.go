@@ -0,0 +1,87 @@
+package llm
+
+import (
+	"strconv"
+	"strings"
+)
+
+// TokenUsage mirrors the OpenAI-compatible "usage" block returned by LiteLLM
+type TokenUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Usage describes the spend of a single LLM call
+type Usage struct {
+	Model            string  `json:"model"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+	CostEstimated    bool    `json:"cost_estimated"` // true when computed from the local price table
+}
+
+// modelPrice is the USD price per 1M tokens
+type modelPrice struct {
+	Prompt     float64
+	Completion float64
+}
+
+// modelPrices is used when the proxy does not report x-litellm-response-cost.
+// Keys are matched as prefixes against the (provider-stripped) model name.
+var modelPrices = map[string]modelPrice{
+	"gemini-2.0-flash-lite": {Prompt: 0.075, Completion: 0.30},
+	"gemini-2.0-flash":      {Prompt: 0.10, Completion: 0.40},
+	"gemini-1.5-flash":      {Prompt: 0.075, Completion: 0.30},
+	"gemini-1.5-pro":        {Prompt: 1.25, Completion: 5.00},
+	"gpt-4o-mini":           {Prompt: 0.15, Completion: 0.60},
+	"gpt-4o":                {Prompt: 2.50, Completion: 10.00},
+}
+
+// EstimateCost returns the USD cost of a call from the local price table.
+// Unknown models are priced at zero.
+func EstimateCost(model string, promptTokens, completionTokens int) float64 {
+	name := model
+	if idx := strings.LastIndex(name, "/"); idx >= 0 {
+		name = name[idx+1:]
+	}
+
+	// Longest prefix wins so "gemini-2.0-flash-lite" is not priced as "gemini-2.0-flash"
+	var best string
+	for prefix := range modelPrices {
+		if strings.HasPrefix(name, prefix) && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	if best == "" {
+		return 0
+	}
+	price := modelPrices[best]
+	return (float64(promptTokens)*price.Prompt + float64(completionTokens)*price.Completion) / 1_000_000
+}
+
+// newUsage builds a Usage from a proxy response. costHeader is the value of
+// x-litellm-response-cost, which takes precedence over the local estimate.
+func newUsage(requestedModel string, resp OpenAIResponse, costHeader string) *Usage {
+	u := &Usage{Model: requestedModel}
+	if resp.Model != "" {
+		u.Model = resp.Model
+	}
+	if resp.Usage != nil {
+		u.PromptTokens = resp.Usage.PromptTokens
+		u.CompletionTokens = resp.Usage.CompletionTokens
+		u.TotalTokens = resp.Usage.TotalTokens
+		if u.TotalTokens == 0 {
+			u.TotalTokens = u.PromptTokens + u.CompletionTokens
+		}
+	}
+
+	if cost, err := strconv.ParseFloat(strings.TrimSpace(costHeader), 64); err == nil && cost >= 0 {
+		u.CostUSD = cost
+	} else {
+		u.CostUSD = EstimateCost(u.Model, u.PromptTokens, u.CompletionTokens)
+		u.CostEstimated = true
+	}
+	return u
+}
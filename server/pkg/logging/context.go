@@ -0,0 +1,82 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Attribute keys attached from context
+const (
+	RequestIDKey = "request_id"
+	RunIDKey     = "run_id"
+	OwnerIDKey   = "owner_id"
+	TraceIDKey   = "trace_id"
+	SpanIDKey    = "span_id"
+)
+
+type attrsCtxKey struct{}
+
+// With returns a copy of ctx whose log records carry attrs. An attribute with
+// the same key as an earlier one replaces it.
+func With(ctx context.Context, attrs ...slog.Attr) context.Context {
+	prev := attrsFrom(ctx)
+	merged := make([]slog.Attr, 0, len(prev)+len(attrs))
+	for _, a := range prev {
+		if !hasKey(attrs, a.Key) {
+			merged = append(merged, a)
+		}
+	}
+	merged = append(merged, attrs...)
+	return context.WithValue(ctx, attrsCtxKey{}, merged)
+}
+
+// WithRequestID tags ctx with the HTTP request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return With(ctx, slog.String(RequestIDKey, id))
+}
+
+// WithRunID tags ctx with an agent run ID
+func WithRunID(ctx context.Context, id string) context.Context {
+	return With(ctx, slog.String(RunIDKey, id))
+}
+
+// WithOwnerID tags ctx with the feed/session owner
+func WithOwnerID(ctx context.Context, id string) context.Context {
+	return With(ctx, slog.String(OwnerIDKey, id))
+}
+
+// RequestID returns the request ID carried by ctx, if any
+func RequestID(ctx context.Context) string {
+	return stringAttr(ctx, RequestIDKey)
+}
+
+// RunID returns the run ID carried by ctx, if any
+func RunID(ctx context.Context) string {
+	return stringAttr(ctx, RunIDKey)
+}
+
+func stringAttr(ctx context.Context, key string) string {
+	for _, a := range attrsFrom(ctx) {
+		if a.Key == key {
+			return a.Value.String()
+		}
+	}
+	return ""
+}
+
+func attrsFrom(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	attrs, _ := ctx.Value(attrsCtxKey{}).([]slog.Attr)
+	return attrs
+}
+
+func hasKey(attrs []slog.Attr, key string) bool {
+	for _, a := range attrs {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
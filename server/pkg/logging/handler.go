@@ -0,0 +1,51 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Handler decorates another slog.Handler: it adds the attributes carried by
+// the record's context (including the active trace and span IDs) and redacts
+// every message and attribute
+type Handler struct {
+	inner slog.Handler
+}
+
+// NewHandler wraps inner
+func NewHandler(inner slog.Handler) *Handler {
+	return &Handler{inner: inner}
+}
+
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, Redact(r.Message), r.PC)
+	for _, a := range attrsFrom(ctx) {
+		out.AddAttrs(RedactAttr(a))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		out.AddAttrs(slog.String(TraceIDKey, sc.TraceID().String()), slog.String(SpanIDKey, sc.SpanID().String()))
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(RedactAttr(a))
+		return true
+	})
+	return h.inner.Handle(ctx, out)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = RedactAttr(a)
+	}
+	return &Handler{inner: h.inner.WithAttrs(redacted)}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{inner: h.inner.WithGroup(name)}
+}
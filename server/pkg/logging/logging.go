@@ -0,0 +1,84 @@
+// Package logging builds the gateway's structured logger: log/slog with
+// levels, JSON or text output, IDs carried through context.Context and a
+// redaction layer that keeps credentials out of the logs.
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Options configures New
+type Options struct {
+	Level  string    // debug | info | warn | error (default info)
+	Format string    // json | text (default json)
+	Output io.Writer // default os.Stdout
+}
+
+// ParseLevel parses a level name; the empty string means info
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// New creates a logger that adds context IDs to every record and redacts
+// secrets before anything reaches the output
+func New(opts Options) (*slog.Logger, error) {
+	level, err := ParseLevel(opts.Level)
+	if err != nil {
+		return nil, err
+	}
+	out := opts.Output
+	if out == nil {
+		out = os.Stdout
+	}
+
+	hopts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch strings.ToLower(opts.Format) {
+	case "", "json":
+		h = slog.NewJSONHandler(out, hopts)
+	case "text":
+		h = slog.NewTextHandler(out, hopts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", opts.Format)
+	}
+	return slog.New(NewHandler(h)), nil
+}
+
+// Setup creates a logger with New and installs it as the slog default, which
+// also routes the standard library's log package through it
+func Setup(opts Options) error {
+	logger, err := New(opts)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+// NewID returns a random 16-byte hex identifier for requests and runs
+func NewID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Err is shorthand for the conventional error attribute
+func Err(err error) slog.Attr {
+	return slog.Any("error", err)
+}
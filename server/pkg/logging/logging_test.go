@@ -0,0 +1,149 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTestLogger(t *testing.T, level string) (*slog.Logger, *bytes.Buffer) {
+	t.Helper()
+	var buf bytes.Buffer
+	logger, err := New(Options{Level: level, Format: "json", Output: &buf})
+	require.NoError(t, err)
+	return logger, &buf
+}
+
+func decode(t *testing.T, buf *bytes.Buffer) map[string]interface{} {
+	t.Helper()
+	var rec map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &rec))
+	return rec
+}
+
+func TestNew_RejectsUnknownOptions(t *testing.T) {
+	_, err := New(Options{Level: "verbose"})
+	assert.Error(t, err)
+	_, err = New(Options{Format: "xml"})
+	assert.Error(t, err)
+}
+
+func TestHandler_ContextIDs(t *testing.T) {
+	logger, buf := newTestLogger(t, "info")
+	ctx := WithRequestID(context.Background(), "req-1")
+	ctx = WithRunID(ctx, "run-1")
+	ctx = WithOwnerID(ctx, "user-1")
+	ctx = WithOwnerID(ctx, "user-2") // later values replace earlier ones
+
+	logger.InfoContext(ctx, "agent run started")
+
+	rec := decode(t, buf)
+	assert.Equal(t, "agent run started", rec["msg"])
+	assert.Equal(t, "req-1", rec[RequestIDKey])
+	assert.Equal(t, "run-1", rec[RunIDKey])
+	assert.Equal(t, "user-2", rec[OwnerIDKey])
+	assert.Equal(t, "run-1", RunID(ctx))
+}
+
+func TestHandler_TraceIDs(t *testing.T) {
+	logger, buf := newTestLogger(t, "info")
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x01, 0x02},
+		SpanID:     trace.SpanID{0x03},
+		TraceFlags: trace.FlagsSampled,
+	})
+	logger.InfoContext(trace.ContextWithSpanContext(context.Background(), sc), "traced")
+
+	rec := decode(t, buf)
+	assert.Equal(t, sc.TraceID().String(), rec[TraceIDKey])
+	assert.Equal(t, sc.SpanID().String(), rec[SpanIDKey])
+}
+
+func TestHandler_Level(t *testing.T) {
+	logger, buf := newTestLogger(t, "warn")
+	logger.Info("hidden")
+	assert.Zero(t, buf.Len())
+	logger.Warn("shown")
+	assert.NotZero(t, buf.Len())
+}
+
+func TestRedact(t *testing.T) {
+	cases := map[string]string{
+		"Authorization: Bearer eyJhbGciOi.abc.def":          "Authorization: Bearer ***",
+		"using key sk-1234567890abcdef for user":            "using key sk-*** for user",
+		"GOOGLE_MAPS_KEY=AIzaSyA1234567890abcdefghijklmnop": "GOOGLE_MAPS_KEY=***",
+		"maps?key=AIzaSyA1234567890abcdefghijklmno&q=paris": "maps?key=***&q=paris",
+		`{"api_key":"abc123","model":"gemini"}`:             `{"api_key":"***","model":"gemini"}`,
+		"postgres://app:hunter2@db:5432/guardian":           "postgres://app:***@db:5432/guardian",
+		"prompt_tokens=12 destination=Paris":                "prompt_tokens=12 destination=Paris",
+	}
+	for in, want := range cases {
+		assert.Equal(t, want, Redact(in), in)
+	}
+}
+
+func TestHandler_RedactsAttributes(t *testing.T) {
+	logger, buf := newTestLogger(t, "debug")
+	header := http.Header{"Authorization": {"Bearer secret"}, "Accept": {"text/event-stream"}}
+
+	logger.With("litellm_key", "sk-abcdefghijkl").Info("call failed with sk-abcdefghijkl",
+		"api_key", "plain",
+		"error", errors.New("proxy rejected Bearer abc.def.ghi"),
+		"headers", header,
+		slog.Group("req", "Authorization", "Basic dXNlcjpwYXNz", "path", "/chat"),
+	)
+
+	out := buf.String()
+	for _, leaked := range []string{"sk-abcdefghijkl", "plain", "abc.def.ghi", "dXNlcjpwYXNz", "Bearer secret"} {
+		assert.NotContains(t, out, leaked)
+	}
+	rec := decode(t, buf)
+	assert.Equal(t, Mask, rec["api_key"])
+	assert.Equal(t, "text/event-stream", rec["headers"].(map[string]interface{})["Accept"].([]interface{})[0])
+	assert.Equal(t, "/chat", rec["req"].(map[string]interface{})["path"])
+}
+
+func TestMiddleware_RequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger, buf := newTestLogger(t, "info")
+	prev := slog.Default()
+	slog.SetDefault(logger)
+	defer slog.SetDefault(prev)
+
+	var seen string
+	r := gin.New()
+	r.Use(Middleware())
+	r.GET("/ping", func(c *gin.Context) {
+		seen = RequestID(c.Request.Context())
+		c.Status(http.StatusNoContent)
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+	req.Header.Set(RequestIDHeader, "abc-123")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, "abc-123", seen)
+	assert.Equal(t, "abc-123", w.Header().Get(RequestIDHeader))
+
+	rec := decode(t, buf)
+	assert.Equal(t, "/ping", rec["route"])
+	assert.Equal(t, float64(http.StatusNoContent), rec["status"])
+	assert.Equal(t, "abc-123", rec[RequestIDKey])
+
+	// Junk IDs are replaced rather than echoed into logs
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/ping", nil)
+	req.Header.Set(RequestIDHeader, "bad id\n")
+	r.ServeHTTP(w, req)
+	assert.Len(t, w.Header().Get(RequestIDHeader), 32)
+}
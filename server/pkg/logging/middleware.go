@@ -0,0 +1,54 @@
+package logging
+
+import (
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader carries the request ID in and out of the gateway
+const RequestIDHeader = "X-Request-ID"
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,128}$`)
+
+// Middleware tags each request's context with a request ID (taken from
+// X-Request-ID when the caller sent a sane one), echoes it back and writes
+// one access log line per request
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = NewID()
+		}
+		c.Header(RequestIDHeader, id)
+		c.Request = c.Request.WithContext(WithRequestID(c.Request.Context(), id))
+
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		}
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		// c.Request may have been replaced downstream (e.g. with the owner ID)
+		slog.Default().LogAttrs(c.Request.Context(), level, "http request",
+			slog.String("method", c.Request.Method),
+			slog.String("route", route),
+			slog.Int("status", status),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("client_ip", c.ClientIP()),
+			slog.Int("bytes", c.Writer.Size()),
+		)
+	}
+}
@@ -0,0 +1,119 @@
+package logging
+
+import (
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Mask replaces redacted values
+const Mask = "***"
+
+// sensitiveKeys are attribute and header names whose values are never logged
+var sensitiveKeys = map[string]bool{
+	"authorization":       true,
+	"proxy_authorization": true,
+	"cookie":              true,
+	"set_cookie":          true,
+	"password":            true,
+	"secret":              true,
+	"token":               true,
+	"key":                 true,
+	"apikey":              true,
+	"api_key":             true,
+	"access_key":          true,
+	"master_key":          true,
+	"litellm_key":         true,
+	"x_api_key":           true,
+	"x_litellm_key":       true,
+}
+
+// IsSensitiveKey reports whether values under this attribute or header name
+// must be masked
+func IsSensitiveKey(name string) bool {
+	k := strings.ToLower(strings.ReplaceAll(name, "-", "_"))
+	if sensitiveKeys[k] {
+		return true
+	}
+	for _, suffix := range []string{"_key", "_token", "_secret", "_password"} {
+		if strings.HasSuffix(k, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+var redactPatterns = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	// Authorization header values
+	{regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9._~+/=-]+`), "$1 " + Mask},
+	// LiteLLM / OpenAI style virtual keys
+	{regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{6,}`), "sk-" + Mask},
+	// Google API keys (Maps, Gemini)
+	{regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{20,}`), "AIza" + Mask},
+	// Credentials embedded in URLs
+	{regexp.MustCompile(`(\b[a-zA-Z][a-zA-Z0-9+.-]*://[^:/\s@]+:)[^@\s/]+@`), "${1}" + Mask + "@"},
+	// key=value, key: value and "key":"value" pairs (query strings, env, JSON)
+	{regexp.MustCompile(`(?i)(\b(?:[a-z0-9]+_)*(?:api_?key|access_?key|master_?key|client_id|key|token|secret|password|authorization)["']?\s*[=:]\s*["']?(?:bearer\s+|basic\s+)?)[^\s&"',}]+`), "${1}" + Mask},
+}
+
+// Redact masks credentials that appear inside free text
+func Redact(s string) string {
+	for _, p := range redactPatterns {
+		s = p.re.ReplaceAllString(s, p.repl)
+	}
+	return s
+}
+
+// RedactAttr masks an attribute by name, or the secrets inside its value
+func RedactAttr(a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+	if IsSensitiveKey(a.Key) {
+		if a.Value.Kind() == slog.KindString && a.Value.String() == "" {
+			return a
+		}
+		return slog.String(a.Key, Mask)
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, Redact(a.Value.String()))
+	case slog.KindGroup:
+		group := a.Value.Group()
+		redacted := make([]slog.Attr, len(group))
+		for i, g := range group {
+			redacted[i] = RedactAttr(g)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(redacted...)}
+	case slog.KindAny:
+		switch v := a.Value.Any().(type) {
+		case error:
+			return slog.String(a.Key, Redact(v.Error()))
+		case http.Header:
+			return slog.Any(a.Key, RedactHeader(v))
+		case []string:
+			out := make([]string, len(v))
+			for i, s := range v {
+				out[i] = Redact(s)
+			}
+			return slog.Any(a.Key, out)
+		}
+	}
+	return a
+}
+
+// RedactHeader returns a copy of h with credential headers masked
+func RedactHeader(h http.Header) http.Header {
+	out := make(http.Header, len(h))
+	for k, vs := range h {
+		if IsSensitiveKey(k) {
+			out[k] = []string{Mask}
+			continue
+		}
+		out[k] = append([]string(nil), vs...)
+	}
+	return out
+}
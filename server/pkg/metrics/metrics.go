@@ -0,0 +1,132 @@
+// Package metrics defines the gateway's Prometheus metrics and the /metrics
+// handler. Metrics live in a dedicated registry so tests and embedded uses
+// don't collide with the global default registry.
+package metrics
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "guardian"
+
+// Registry holds every gateway metric plus the Go runtime and process collectors
+var Registry = prometheus.NewRegistry()
+
+// Buckets for calls that stream for a long time (SSE chat, agent runs, LLM)
+var longBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+var (
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route template and status code.",
+	}, []string{"method", "route", "status"})
+
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method and route template, including streamed responses.",
+		Buckets:   longBuckets,
+	}, []string{"method", "route"})
+
+	AgentRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "agent_runs_total",
+		Help:      "FastGraph agent runs by agent and outcome (ok, error, binary_missing).",
+	}, []string{"agent", "outcome"})
+
+	AgentRunDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "agent_run_duration_seconds",
+		Help:      "FastGraph agent run duration by agent and outcome.",
+		Buckets:   longBuckets,
+	}, []string{"agent", "outcome"})
+
+	EngineEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "engine_events_total",
+		Help:      "Events streamed from agent runs by node and event type.",
+	}, []string{"node", "type"})
+
+	LLMRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "llm_request_duration_seconds",
+		Help:      "LiteLLM chat completion latency by outcome (ok or an error class).",
+		Buckets:   longBuckets,
+	}, []string{"outcome"})
+
+	LLMErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "llm_errors_total",
+		Help:      "Failed LiteLLM calls by error class.",
+	}, []string{"class"})
+
+	DBQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "PostgresStore operation latency by operation and outcome.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "outcome"})
+
+	SchedulerTicks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "scheduler_ticks_total",
+		Help:      "Proactive scheduler ticks by agent and outcome.",
+	}, []string{"agent", "outcome"})
+
+	UnsplashFetches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "unsplash_fetches_total",
+		Help:      "Unsplash image lookups by outcome.",
+	}, []string{"outcome"})
+)
+
+// activeSessions is the source for the active sessions gauge; see SetActiveSessionsFunc
+var activeSessions atomic.Pointer[func() float64]
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests, HTTPRequestDuration,
+		AgentRuns, AgentRunDuration, EngineEvents,
+		LLMRequestDuration, LLMErrors,
+		DBQueryDuration,
+		SchedulerTicks,
+		UnsplashFetches,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "sessions_active",
+			Help:      "Chat sessions seen recently by the session manager.",
+		}, func() float64 {
+			if f := activeSessions.Load(); f != nil {
+				return (*f)()
+			}
+			return 0
+		}),
+	)
+}
+
+// SetActiveSessionsFunc sets the callback read on every scrape of the
+// sessions_active gauge
+func SetActiveSessionsFunc(f func() float64) {
+	activeSessions.Store(&f)
+}
+
+// Handler serves the registry in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// Outcome maps an error to the ok/error label used by most metrics
+func Outcome(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
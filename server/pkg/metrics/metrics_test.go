@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware_RecordsRouteTemplate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware())
+	r.GET("/api/feed/:id", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	before := testutil.ToFloat64(HTTPRequests.WithLabelValues("GET", "/api/feed/:id", "204"))
+	unmatched := testutil.ToFloat64(HTTPRequests.WithLabelValues("GET", "unmatched", "404"))
+	for _, path := range []string{"/api/feed/1", "/api/feed/2", "/nope"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	assert.Equal(t, before+2, testutil.ToFloat64(HTTPRequests.WithLabelValues("GET", "/api/feed/:id", "204")))
+	assert.Equal(t, unmatched+1, testutil.ToFloat64(HTTPRequests.WithLabelValues("GET", "unmatched", "404")))
+}
+
+func TestGinHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	SetActiveSessionsFunc(func() float64 { return 3 })
+	UnsplashFetches.WithLabelValues("ok").Inc()
+
+	r := gin.New()
+	r.GET("/metrics", GinHandler("s3cret"))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, "guardian_sessions_active 3")
+	assert.Contains(t, body, `guardian_unsplash_fetches_total{outcome="ok"}`)
+	assert.True(t, strings.Contains(body, "go_goroutines"), "runtime collectors registered")
+}
+
+func TestOutcome(t *testing.T) {
+	assert.Equal(t, "ok", Outcome(nil))
+	assert.Equal(t, "error", Outcome(errors.New("boom")))
+}
@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Middleware records request counts and latency per route template. Requests
+// that match no route share one label so probes can't inflate cardinality.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		HTTPRequests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		HTTPRequestDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// GinHandler serves /metrics, requiring "Authorization: Bearer <token>" when
+// token is not empty
+func GinHandler(token string) gin.HandlerFunc {
+	h := Handler()
+	return func(c *gin.Context) {
+		if token != "" {
+			got := c.GetHeader("Authorization")
+			if subtle.ConstantTimeCompare([]byte(got), []byte("Bearer "+token)) != 1 {
+				c.AbortWithStatus(http.StatusUnauthorized)
+				return
+			}
+		}
+		h.ServeHTTP(c.Writer, c.Request)
+	}
+}
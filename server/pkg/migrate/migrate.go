@@ -0,0 +1,352 @@
+// Package migrate applies numbered SQL migrations to Postgres. Applied versions
+// and the checksums of their SQL are recorded in schema_migrations, and every
+// command holds a session advisory lock so replicas starting together apply
+// each migration exactly once.
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lockID is the pg_advisory_lock key serialising migration runs
+const lockID int64 = 0x67756172646d6967 // "guardmig"
+
+const createTable = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		name TEXT NOT NULL,
+		checksum CHAR(64) NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		duration_ms INTEGER NOT NULL DEFAULT 0
+	)
+`
+
+// ErrChecksumMismatch reports an applied migration whose SQL has since changed
+var ErrChecksumMismatch = errors.New("applied migration was modified")
+
+// Migration is one numbered schema change
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string // empty when the migration cannot be reverted
+	Checksum string // SHA-256 of Up, hex
+}
+
+var fileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+?)(\.down)?\.sql$`)
+
+// Load reads NNN_name.sql (up) and NNN_name.down.sql (down) files from the
+// root of fsys, ordered by version
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list migrations: %w", err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	downs := make(map[int64]string)
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".sql") {
+			continue
+		}
+		m := fileName.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("migration %q must be named NNN_description[.down].sql", e.Name())
+		}
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %q has an invalid version", e.Name())
+		}
+		body, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %q: %w", e.Name(), err)
+		}
+
+		if m[3] != "" {
+			if _, dup := downs[version]; dup {
+				return nil, fmt.Errorf("duplicate down migration for version %d", version)
+			}
+			downs[version] = string(body)
+			continue
+		}
+		if prev, dup := byVersion[version]; dup {
+			return nil, fmt.Errorf("duplicate migration version %d (%s, %s)", version, prev.Name, m[2])
+		}
+		byVersion[version] = &Migration{Version: version, Name: m[2], Up: string(body), Checksum: Checksum(string(body))}
+	}
+
+	for version, down := range downs {
+		mig, ok := byVersion[version]
+		if !ok {
+			return nil, fmt.Errorf("down migration for version %d has no up migration", version)
+		}
+		mig.Down = down
+	}
+
+	out := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		out = append(out, *mig)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// Checksum is the hex SHA-256 recorded for a migration's up SQL
+func Checksum(sql string) string {
+	sum := sha256.Sum256([]byte(sql))
+	return hex.EncodeToString(sum[:])
+}
+
+// Applied is a schema_migrations row
+type Applied struct {
+	Version   int64
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+// Status describes one migration, known to this build or only to the database
+type Status struct {
+	Version    int64      `json:"version"`
+	Name       string     `json:"name"`
+	Applied    bool       `json:"applied"`
+	AppliedAt  *time.Time `json:"applied_at,omitempty"`
+	Modified   bool       `json:"modified,omitempty"` // applied checksum differs from the file
+	Unknown    bool       `json:"unknown,omitempty"`  // applied but not in this build (newer release)
+	Reversible bool       `json:"reversible"`
+}
+
+// Migrator applies migrations to one database
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// New returns a Migrator for migrations, as returned by Load
+func New(db *sql.DB, migrations []Migration) *Migrator {
+	return &Migrator{db: db, migrations: migrations}
+}
+
+// Up applies every pending migration in order, each in its own transaction.
+// Migrations recorded by a newer build are left alone; a changed applied
+// migration stops the run with ErrChecksumMismatch.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+	err := m.locked(ctx, func(conn *sql.Conn, done map[int64]Applied) error {
+		if err := verify(m.migrations, done); err != nil {
+			return err
+		}
+		if newer := unknown(m.migrations, done); len(newer) > 0 {
+			// A newer release migrated first (rolling deploy); its changes must stay compatible
+			slog.WarnContext(ctx, "database has migrations this build does not know", "versions", newer)
+		}
+		for _, mig := range pending(m.migrations, done) {
+			start := time.Now()
+			err := inTx(ctx, conn, func(tx *sql.Tx) error {
+				if _, err := tx.ExecContext(ctx, mig.Up); err != nil {
+					return err
+				}
+				_, err := tx.ExecContext(ctx,
+					`INSERT INTO schema_migrations (version, name, checksum, duration_ms) VALUES ($1, $2, $3, $4)`,
+					mig.Version, mig.Name, mig.Checksum, time.Since(start).Milliseconds())
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("migration %03d_%s failed: %w", mig.Version, mig.Name, err)
+			}
+			slog.InfoContext(ctx, "applied migration", "version", mig.Version, "name", mig.Name, "duration", time.Since(start))
+			applied = append(applied, mig)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts the last steps applied migrations, newest first
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	if steps <= 0 {
+		return nil, fmt.Errorf("steps must be positive, got %d", steps)
+	}
+	known := make(map[int64]Migration, len(m.migrations))
+	for _, mig := range m.migrations {
+		known[mig.Version] = mig
+	}
+
+	var reverted []Migration
+	err := m.locked(ctx, func(conn *sql.Conn, done map[int64]Applied) error {
+		versions := make([]int64, 0, len(done))
+		for v := range done {
+			versions = append(versions, v)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+		if steps > len(versions) {
+			steps = len(versions)
+		}
+
+		for _, v := range versions[:steps] {
+			mig, ok := known[v]
+			if !ok {
+				return fmt.Errorf("migration %d was applied by a newer build and cannot be reverted by this one", v)
+			}
+			if mig.Down == "" {
+				return fmt.Errorf("migration %03d_%s has no down migration", mig.Version, mig.Name)
+			}
+			err := inTx(ctx, conn, func(tx *sql.Tx) error {
+				if _, err := tx.ExecContext(ctx, mig.Down); err != nil {
+					return err
+				}
+				_, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, mig.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("reverting migration %03d_%s failed: %w", mig.Version, mig.Name, err)
+			}
+			slog.InfoContext(ctx, "reverted migration", "version", mig.Version, "name", mig.Name)
+			reverted = append(reverted, mig)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Status lists every known migration and any applied ones this build lacks
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var out []Status
+	err := m.locked(ctx, func(_ *sql.Conn, done map[int64]Applied) error {
+		out = status(m.migrations, done)
+		return nil
+	})
+	return out, err
+}
+
+// locked runs fn on one connection holding the migration advisory lock, with
+// schema_migrations created and its rows loaded
+func (m *Migrator) locked(ctx context.Context, fn func(*sql.Conn, map[int64]Applied) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockID); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	defer func() {
+		// Unlock even if ctx was cancelled; closing the connection would release it too
+		unlockCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		if _, err := conn.ExecContext(unlockCtx, `SELECT pg_advisory_unlock($1)`, lockID); err != nil {
+			slog.WarnContext(ctx, "failed to release migration lock", "error", err)
+		}
+	}()
+
+	if _, err := conn.ExecContext(ctx, createTable); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	done, err := loadApplied(ctx, conn)
+	if err != nil {
+		return err
+	}
+	return fn(conn, done)
+}
+
+func loadApplied(ctx context.Context, conn *sql.Conn) (map[int64]Applied, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, name, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	done := make(map[int64]Applied)
+	for rows.Next() {
+		var a Applied
+		if err := rows.Scan(&a.Version, &a.Name, &a.Checksum, &a.AppliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan schema_migrations: %w", err)
+		}
+		done[a.Version] = a
+	}
+	return done, rows.Err()
+}
+
+func inTx(ctx context.Context, conn *sql.Conn, fn func(*sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// pending returns the migrations not yet applied, in order
+func pending(migrations []Migration, done map[int64]Applied) []Migration {
+	var out []Migration
+	for _, mig := range migrations {
+		if _, ok := done[mig.Version]; !ok {
+			out = append(out, mig)
+		}
+	}
+	return out
+}
+
+// verify fails when an applied migration's SQL no longer matches its checksum
+func verify(migrations []Migration, done map[int64]Applied) error {
+	var modified []string
+	for _, mig := range migrations {
+		if a, ok := done[mig.Version]; ok && a.Checksum != mig.Checksum {
+			modified = append(modified, fmt.Sprintf("%03d_%s", mig.Version, mig.Name))
+		}
+	}
+	if len(modified) > 0 {
+		return fmt.Errorf("%w: %s", ErrChecksumMismatch, strings.Join(modified, ", "))
+	}
+	return nil
+}
+
+// unknown returns applied versions missing from migrations
+func unknown(migrations []Migration, done map[int64]Applied) []int64 {
+	known := make(map[int64]bool, len(migrations))
+	for _, mig := range migrations {
+		known[mig.Version] = true
+	}
+	var out []int64
+	for v := range done {
+		if !known[v] {
+			out = append(out, v)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+func status(migrations []Migration, done map[int64]Applied) []Status {
+	out := make([]Status, 0, len(migrations))
+	for _, mig := range migrations {
+		s := Status{Version: mig.Version, Name: mig.Name, Reversible: mig.Down != ""}
+		if a, ok := done[mig.Version]; ok {
+			at := a.AppliedAt
+			s.Applied, s.AppliedAt, s.Modified = true, &at, a.Checksum != mig.Checksum
+		}
+		out = append(out, s)
+	}
+	for _, v := range unknown(migrations, done) {
+		at := done[v].AppliedAt
+		out = append(out, Status{Version: v, Name: done[v].Name, Applied: true, AppliedAt: &at, Unknown: true})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out
+}
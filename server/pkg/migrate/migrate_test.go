@@ -0,0 +1,151 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"guardian-gateway/migrations"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"010_add_index.sql":      {Data: []byte("CREATE INDEX i ON t(c);")},
+		"002_create_t.sql":       {Data: []byte("CREATE TABLE t (c INT);")},
+		"002_create_t.down.sql":  {Data: []byte("DROP TABLE t;")},
+		"migrations.go":          {Data: []byte("package migrations")},
+		"README.md":              {Data: []byte("not sql")},
+		"subdir/099_ignored.sql": {Data: []byte("SELECT 1;")},
+	}
+	got, err := Load(fsys)
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+
+	assert.Equal(t, int64(2), got[0].Version)
+	assert.Equal(t, "create_t", got[0].Name)
+	assert.Equal(t, "DROP TABLE t;", got[0].Down)
+	assert.Equal(t, Checksum("CREATE TABLE t (c INT);"), got[0].Checksum)
+
+	assert.Equal(t, int64(10), got[1].Version)
+	assert.Empty(t, got[1].Down)
+}
+
+func TestLoad_Errors(t *testing.T) {
+	tests := map[string]fstest.MapFS{
+		"bad name":        {"create_t.sql": {Data: []byte("x")}},
+		"duplicate":       {"001_a.sql": {Data: []byte("x")}, "1_b.sql": {Data: []byte("y")}},
+		"orphan down":     {"001_a.down.sql": {Data: []byte("x")}},
+		"zero version":    {"000_a.sql": {Data: []byte("x")}},
+		"upper case name": {"001_Create.sql": {Data: []byte("x")}},
+	}
+	for name, fsys := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Load(fsys)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoad_Embedded(t *testing.T) {
+	all, err := Load(migrations.FS)
+	require.NoError(t, err)
+	require.NotEmpty(t, all)
+	assert.Equal(t, "create_cards", all[0].Name)
+	for i := 1; i < len(all); i++ {
+		assert.Greater(t, all[i].Version, all[i-1].Version)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	all := []Migration{
+		{Version: 1, Name: "a", Checksum: Checksum("a")},
+		{Version: 2, Name: "b", Checksum: Checksum("b"), Down: "drop b"},
+		{Version: 3, Name: "c", Checksum: Checksum("c")},
+	}
+	at := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	done := map[int64]Applied{
+		1: {Version: 1, Name: "a", Checksum: Checksum("a"), AppliedAt: at},
+		2: {Version: 2, Name: "b", Checksum: Checksum("b"), AppliedAt: at},
+		9: {Version: 9, Name: "from_newer_build", Checksum: Checksum("z"), AppliedAt: at},
+	}
+
+	pend := pending(all, done)
+	require.Len(t, pend, 1)
+	assert.Equal(t, int64(3), pend[0].Version)
+	assert.NoError(t, verify(all, done))
+	assert.Equal(t, []int64{9}, unknown(all, done))
+
+	st := status(all, done)
+	require.Len(t, st, 4)
+	assert.True(t, st[0].Applied)
+	assert.True(t, st[1].Reversible)
+	assert.False(t, st[2].Applied)
+	assert.True(t, st[3].Unknown)
+
+	// Editing a shipped migration is caught before anything runs
+	done[2] = Applied{Version: 2, Name: "b", Checksum: Checksum("b, edited")}
+	err := verify(all, done)
+	assert.True(t, errors.Is(err, ErrChecksumMismatch))
+	assert.Contains(t, err.Error(), "002_b")
+	assert.True(t, status(all, done)[1].Modified)
+}
+
+// TestMigrator_Postgres runs up/status/down against TEST_DATABASE_URL (a
+// disposable database; its schema is dropped and recreated)
+func TestMigrator_Postgres(t *testing.T) {
+	connStr := os.Getenv("TEST_DATABASE_URL")
+	if connStr == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	ctx := context.Background()
+	db, err := sql.Open("pgx", connStr)
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.ExecContext(ctx, `DROP SCHEMA public CASCADE; CREATE SCHEMA public;`)
+	require.NoError(t, err)
+
+	all, err := Load(migrations.FS)
+	require.NoError(t, err)
+	m := New(db, all)
+
+	applied, err := m.Up(ctx)
+	require.NoError(t, err)
+	assert.Len(t, applied, len(all))
+
+	// Replicas starting together serialise on the lock and apply nothing twice
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			applied, err := m.Up(ctx)
+			if err == nil && len(applied) > 0 {
+				err = errors.New("re-applied migrations")
+			}
+			errs <- err
+		}()
+	}
+	require.NoError(t, <-errs)
+	require.NoError(t, <-errs)
+
+	st, err := m.Status(ctx)
+	require.NoError(t, err)
+	for _, s := range st {
+		assert.True(t, s.Applied, s.Name)
+	}
+
+	reverted, err := m.Down(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, reverted, 1)
+	assert.Equal(t, all[len(all)-1].Version, reverted[0].Version)
+
+	applied, err = m.Up(ctx)
+	require.NoError(t, err)
+	assert.Len(t, applied, 1)
+}
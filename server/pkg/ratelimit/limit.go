@@ -0,0 +1,111 @@
+// Package ratelimit implements token-bucket rate limiting with in-memory and
+// Postgres backends, and gin middleware that enforces per-owner and per-IP limits.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit allows Capacity requests per Period, refilled continuously, with
+// bursts of up to Capacity. The zero Limit is disabled.
+type Limit struct {
+	Capacity int
+	Period   time.Duration
+}
+
+// Enabled reports whether the limit should be enforced
+func (l Limit) Enabled() bool {
+	return l.Capacity > 0 && l.Period > 0
+}
+
+// rate returns the refill rate in tokens per second
+func (l Limit) rate() float64 {
+	return float64(l.Capacity) / l.Period.Seconds()
+}
+
+// String formats the limit in the form ParseLimit accepts
+func (l Limit) String() string {
+	if !l.Enabled() {
+		return "off"
+	}
+	return fmt.Sprintf("%d/%s", l.Capacity, l.Period)
+}
+
+// ParseLimit parses "N/unit" where unit is s, m, h or a Go duration
+// ("20/m", "100/h", "5/30s"). "off" and "0" disable the limit.
+func ParseLimit(spec string) (Limit, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" || spec == "off" || spec == "0" {
+		return Limit{}, nil
+	}
+	parts := strings.SplitN(spec, "/", 2)
+	if len(parts) != 2 {
+		return Limit{}, fmt.Errorf("rate limit %q must be N/period", spec)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || n < 0 {
+		return Limit{}, fmt.Errorf("rate limit %q: invalid count", spec)
+	}
+
+	unit := strings.TrimSpace(parts[1])
+	var period time.Duration
+	switch unit {
+	case "s":
+		period = time.Second
+	case "m":
+		period = time.Minute
+	case "h":
+		period = time.Hour
+	default:
+		period, err = time.ParseDuration(unit)
+		if err != nil || period <= 0 {
+			return Limit{}, fmt.Errorf("rate limit %q: invalid period", spec)
+		}
+	}
+	return Limit{Capacity: n, Period: period}, nil
+}
+
+// Result is the outcome of taking a token from a bucket
+type Result struct {
+	Allowed    bool
+	Limit      Limit
+	Remaining  int
+	RetryAfter time.Duration // Until the request would be allowed; zero when allowed
+	Reset      time.Duration // Until the bucket is full again
+}
+
+// resultFor builds a Result from the tokens left after a take
+func resultFor(l Limit, tokens float64, allowed bool, n int) Result {
+	rate := l.rate()
+	res := Result{
+		Allowed:   allowed,
+		Limit:     l,
+		Remaining: int(math.Max(0, math.Floor(tokens))),
+		Reset:     secondsToDuration((float64(l.Capacity) - tokens) / rate),
+	}
+	if !allowed {
+		res.RetryAfter = secondsToDuration((float64(n) - tokens) / rate)
+	}
+	return res
+}
+
+func secondsToDuration(s float64) time.Duration {
+	if s <= 0 {
+		return 0
+	}
+	return time.Duration(s * float64(time.Second))
+}
+
+// Backend stores token buckets
+type Backend interface {
+	// Take removes n tokens from the bucket named key if available. With n
+	// 0 it only reports the bucket's state.
+	Take(ctx context.Context, key string, l Limit, n int) (Result, error)
+	// Refund returns n tokens taken from the bucket, up to its capacity
+	Refund(ctx context.Context, key string, l Limit, n int) error
+}
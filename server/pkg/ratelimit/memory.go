@@ -0,0 +1,74 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// memorySweepThreshold is the bucket count above which idle buckets are dropped
+const memorySweepThreshold = 10000
+
+// MemoryBackend keeps buckets in process. Limits are per replica.
+type MemoryBackend struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	period  time.Duration
+}
+
+// NewMemoryBackend creates an empty in-memory backend
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{buckets: make(map[string]*bucket), now: time.Now}
+}
+
+// Take implements Backend
+func (m *MemoryBackend) Take(_ context.Context, key string, l Limit, n int) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	if len(m.buckets) >= memorySweepThreshold {
+		m.sweep(now)
+	}
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.Capacity), updated: now}
+		m.buckets[key] = b
+	}
+	b.period = l.Period
+	b.tokens = math.Min(float64(l.Capacity), b.tokens+now.Sub(b.updated).Seconds()*l.rate())
+	b.updated = now
+
+	allowed := b.tokens >= float64(n)
+	if allowed {
+		b.tokens -= float64(n)
+	}
+	return resultFor(l, b.tokens, allowed, n), nil
+}
+
+// Refund implements Backend
+func (m *MemoryBackend) Refund(_ context.Context, key string, l Limit, n int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if b, ok := m.buckets[key]; ok {
+		b.tokens = math.Min(float64(l.Capacity), b.tokens+float64(n))
+	}
+	return nil
+}
+
+// sweep drops buckets idle long enough to have refilled completely
+func (m *MemoryBackend) sweep(now time.Time) {
+	for k, b := range m.buckets {
+		if now.Sub(b.updated) >= b.period {
+			delete(m.buckets, k)
+		}
+	}
+}
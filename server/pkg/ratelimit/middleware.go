@@ -0,0 +1,139 @@
+package ratelimit
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"guardian-gateway/pkg/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Policy is a named pair of limits. Both apply: the per-owner bucket tracks
+// the principal, the per-IP bucket stops clients that rotate device IDs.
+type Policy struct {
+	Name     string // Bucket namespace, e.g. "chat", "agent", "feed"
+	PerOwner Limit
+	PerIP    Limit
+}
+
+// Limiter enforces policies against a backend
+type Limiter struct {
+	Backend Backend
+}
+
+// NewLimiter creates a limiter on backend
+func NewLimiter(backend Backend) *Limiter {
+	return &Limiter{Backend: backend}
+}
+
+// Middleware enforces p before the handler runs
+func (l *Limiter) Middleware(p Policy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !l.Allow(c, p) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// Allow takes a token from each of p's buckets and sets the RateLimit-*
+// headers. When a limit is exceeded it refunds the tokens already taken (a
+// client over its own limit must not drain the bucket it shares an IP with),
+// writes a 429 with Retry-After, aborts the request and returns false. A nil
+// Limiter allows everything; backend errors fail open so a database outage
+// doesn't take the API down.
+func (l *Limiter) Allow(c *gin.Context, p Policy) bool {
+	if l == nil || l.Backend == nil {
+		return true
+	}
+
+	ctx := c.Request.Context()
+	checks := l.checks(c, p)
+	var tightest *Result
+	for i, ch := range checks {
+		res, err := l.Backend.Take(ctx, ch.key, ch.limit, 1)
+		if err != nil {
+			slog.WarnContext(ctx, "rate limit check failed", "policy", p.Name, "error", err)
+			checks[i].failed = true
+			continue
+		}
+		if !res.Allowed {
+			for _, taken := range checks[:i] {
+				if taken.failed {
+					continue
+				}
+				if err := l.Backend.Refund(ctx, taken.key, taken.limit, 1); err != nil {
+					slog.WarnContext(ctx, "rate limit refund failed", "policy", p.Name, "error", err)
+				}
+			}
+			writeHeaders(c, res)
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"error":       "Rate limit exceeded",
+				"limit":       p.Name,
+				"retry_after": ceilSeconds(res.RetryAfter),
+			})
+			return false
+		}
+		if tightest == nil || res.Remaining < tightest.Remaining {
+			r := res
+			tightest = &r
+		}
+	}
+	if tightest != nil {
+		writeHeaders(c, *tightest)
+	}
+	return true
+}
+
+// Available reports whether every one of p's buckets has a token left,
+// without taking any. Like Allow, a nil Limiter and backend errors allow.
+func (l *Limiter) Available(c *gin.Context, p Policy) bool {
+	if l == nil || l.Backend == nil {
+		return true
+	}
+	for _, ch := range l.checks(c, p) {
+		res, err := l.Backend.Take(c.Request.Context(), ch.key, ch.limit, 0)
+		if err == nil && res.Remaining < 1 {
+			return false
+		}
+	}
+	return true
+}
+
+type check struct {
+	key    string
+	limit  Limit
+	failed bool // The backend could not take the token
+}
+
+// checks lists the buckets p applies to the request
+func (l *Limiter) checks(c *gin.Context, p Policy) []check {
+	var checks []check
+	if p.PerIP.Enabled() {
+		checks = append(checks, check{key: p.Name + ":ip:" + c.ClientIP(), limit: p.PerIP})
+	}
+	if p.PerOwner.Enabled() {
+		if principal, ok := auth.FromContext(c); ok && principal.OwnerID() != "" {
+			checks = append(checks, check{key: p.Name + ":owner:" + principal.OwnerID(), limit: p.PerOwner})
+		}
+	}
+	return checks
+}
+
+// writeHeaders sets the IETF RateLimit header fields
+func writeHeaders(c *gin.Context, res Result) {
+	c.Header("RateLimit-Limit", strconv.Itoa(res.Limit.Capacity))
+	c.Header("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+	c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d", res.Limit.Capacity, ceilSeconds(res.Limit.Period)))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,138 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"guardian-gateway/pkg/auth"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    Limit
+		wantErr bool
+	}{
+		{"20/m", Limit{20, time.Minute}, false},
+		{"100/h", Limit{100, time.Hour}, false},
+		{"5/30s", Limit{5, 30 * time.Second}, false},
+		{"off", Limit{}, false},
+		{"0", Limit{}, false},
+		{"", Limit{}, false},
+		{"20", Limit{}, true},
+		{"x/m", Limit{}, true},
+		{"5/fortnight", Limit{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseLimit(tt.spec)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMemoryBackend_TokenBucket(t *testing.T) {
+	m := NewMemoryBackend()
+	now := time.Unix(1700000000, 0)
+	m.now = func() time.Time { return now }
+	l := Limit{Capacity: 3, Period: 3 * time.Second} // 1 token/s
+	ctx := context.Background()
+
+	for i := 2; i >= 0; i-- {
+		res, err := m.Take(ctx, "k", l, 1)
+		require.NoError(t, err)
+		assert.True(t, res.Allowed)
+		assert.Equal(t, i, res.Remaining)
+	}
+
+	res, _ := m.Take(ctx, "k", l, 1)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, time.Second, res.RetryAfter)
+	assert.Equal(t, 3*time.Second, res.Reset)
+
+	// Other keys are independent
+	res, _ = m.Take(ctx, "other", l, 1)
+	assert.True(t, res.Allowed)
+
+	// Refills continuously, capped at capacity
+	now = now.Add(1500 * time.Millisecond)
+	res, _ = m.Take(ctx, "k", l, 1)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+
+	now = now.Add(time.Hour)
+	res, _ = m.Take(ctx, "k", l, 1)
+	assert.Equal(t, 2, res.Remaining)
+}
+
+type failingBackend struct{}
+
+func (failingBackend) Take(context.Context, string, Limit, int) (Result, error) {
+	return Result{}, errors.New("db down")
+}
+
+func (failingBackend) Refund(context.Context, string, Limit, int) error {
+	return errors.New("db down")
+}
+
+func TestLimiter_Middleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	policy := Policy{Name: "chat", PerOwner: Limit{2, time.Minute}, PerIP: Limit{3, time.Minute}}
+
+	newRouter := func(l *Limiter) *gin.Engine {
+		r := gin.New()
+		r.GET("/", func(c *gin.Context) {
+			if dev := c.GetHeader("X-Device-ID"); dev != "" {
+				auth.SetPrincipal(c, &auth.Principal{Kind: auth.KindAnonymous, DeviceID: dev})
+			}
+		}, l.Middleware(policy), func(c *gin.Context) { c.Status(http.StatusOK) })
+		return r
+	}
+	do := func(r *gin.Engine, device string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("X-Device-ID", device)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	r := newRouter(NewLimiter(NewMemoryBackend()))
+
+	w := do(r, "device-a")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"), "tightest bucket is reported")
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "2;w=60", w.Header().Get("RateLimit-Policy"))
+
+	assert.Equal(t, http.StatusOK, do(r, "device-a").Code)
+
+	// Per-owner limit
+	w = do(r, "device-a")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+
+	// The rejected request did not drain the per-IP bucket, but rotating the
+	// device ID still hits the per-IP limit
+	assert.Equal(t, http.StatusOK, do(r, "device-b").Code)
+	assert.Equal(t, http.StatusTooManyRequests, do(r, "device-c").Code)
+
+	// Backend errors fail open; a nil limiter allows everything
+	assert.Equal(t, http.StatusOK, do(newRouter(NewLimiter(failingBackend{})), "device-a").Code)
+	var nilLimiter *Limiter
+	assert.Equal(t, http.StatusOK, do(newRouter(nilLimiter), "device-a").Code)
+}
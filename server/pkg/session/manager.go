@@ -30,12 +30,16 @@ type Message struct {
 
 // Session holds the state of a single user conversation
 type Session struct {
-	ID        string
-	State     SessionState
-	Variables map[string]string // Extracted data (e.g. "destination": "Paris")
-	History   []Message
-	LastSeen  time.Time
+	ID        string            `json:"id"`
+	State     SessionState      `json:"state"`
+	Variables map[string]string `json:"variables"` // Extracted data (e.g. "destination": "Paris")
+	History   []Message         `json:"history"`
+	Summary   string            `json:"summary,omitempty"` // Rolling summary of turns trimmed from History
+	TripID    string            `json:"trip_id,omitempty"` // Trip being planned (see pkg/trip)
+	LastSeen  time.Time         `json:"last_seen"`
 	mu        sync.Mutex
+	// generation changes whenever History is truncated, so in-flight summaries can detect it
+	generation int
 }
 
 // SessionManager handles session lifecycle
@@ -73,6 +77,20 @@ func (sm *SessionManager) GetOrCreate(id string) *Session {
 	return newSess
 }
 
+// ActiveSince counts sessions seen at or after cutoff
+func (sm *SessionManager) ActiveSince(cutoff time.Time) int {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	n := 0
+	for _, sess := range sm.sessions {
+		if !sess.LastSeen.Before(cutoff) {
+			n++
+		}
+	}
+	return n
+}
+
 // AppendMessage safely adds a message to history
 func (s *Session) AppendMessage(role, content string) {
 	s.mu.Lock()
@@ -110,11 +128,42 @@ func (s *Session) GetVariables() map[string]string {
 	return copied
 }
 
+// SetTripID links the session to the trip it plans
+func (s *Session) SetTripID(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.TripID = id
+}
+
+// SwitchTrip moves the session to another trip: its variables are replaced by
+// the trip's and, as that trip has no report in this conversation yet, the
+// state goes back to idle. The history is kept.
+func (s *Session) SwitchTrip(id string, vars map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.TripID = id
+	s.Variables = make(map[string]string, len(vars))
+	for k, v := range vars {
+		s.Variables[k] = v
+	}
+	s.State = StateIdle
+}
+
+// GetTripID returns the session's trip, or ""
+func (s *Session) GetTripID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.TripID
+}
+
 // Reset clears history but keeps ID
 func (s *Session) Reset() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.History = make([]Message, 0)
+	s.Summary = ""
+	s.generation++
 	s.Variables = make(map[string]string)
+	s.TripID = ""
 	s.State = StateIdle
 }
@@ -1,8 +1,12 @@
 package session
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -70,3 +74,194 @@ func TestConcurrentAccess(t *testing.T) {
 	wg.Wait()
 	assert.Len(t, s.GetHistory(), 100)
 }
+
+func TestSummarize(t *testing.T) {
+	Init()
+	s := GlobalManager.GetOrCreate("summary_user")
+	s.UpdateVariables(map[string]string{"Destination": "Paris"})
+	for i := 0; i < 6; i++ {
+		s.AppendMessage("user", fmt.Sprintf("msg %d", i))
+	}
+	policy := SummaryPolicy{MaxMessages: 4, KeepRecent: 2}
+
+	var gotPrevious string
+	var gotOlder []Message
+	summarizer := func(previous string, older []Message) (string, error) {
+		gotPrevious, gotOlder = previous, older
+		return "summary v1", nil
+	}
+
+	compacted, err := s.Summarize(policy, summarizer)
+	assert.NoError(t, err)
+	assert.True(t, compacted)
+	assert.Empty(t, gotPrevious)
+	assert.Len(t, gotOlder, 4)
+	assert.Equal(t, "msg 0", gotOlder[0].Content)
+
+	// Recent turns kept verbatim, variables untouched
+	hist := s.GetHistory()
+	assert.Len(t, hist, 2)
+	assert.Equal(t, "msg 4", hist[0].Content)
+	assert.Equal(t, "summary v1", s.GetSummary())
+	assert.Equal(t, "Paris", s.GetVariables()["Destination"])
+
+	// Context leads with the summary message
+	ctx := s.GetContext()
+	assert.Len(t, ctx, 3)
+	assert.Equal(t, RoleSummary, ctx[0].Role)
+	assert.Contains(t, ctx[0].Content, "summary v1")
+
+	// Below threshold: no-op
+	compacted, err = s.Summarize(policy, summarizer)
+	assert.NoError(t, err)
+	assert.False(t, compacted)
+
+	// Rolling: previous summary is passed back in
+	for i := 0; i < 3; i++ {
+		s.AppendMessage("model", "reply")
+	}
+	_, err = s.Summarize(policy, func(previous string, older []Message) (string, error) {
+		gotPrevious = previous
+		return "summary v2", nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "summary v1", gotPrevious)
+	assert.Equal(t, "summary v2", s.GetSummary())
+	assert.Len(t, s.GetHistory(), 2)
+}
+
+func TestSummarize_ErrorKeepsHistory(t *testing.T) {
+	Init()
+	s := GlobalManager.GetOrCreate("summary_err_user")
+	for i := 0; i < 5; i++ {
+		s.AppendMessage("user", "hi")
+	}
+
+	compacted, err := s.Summarize(SummaryPolicy{MaxMessages: 2, KeepRecent: 1}, func(string, []Message) (string, error) {
+		return "", errors.New("llm down")
+	})
+	assert.Error(t, err)
+	assert.False(t, compacted)
+	assert.Len(t, s.GetHistory(), 5)
+	assert.Empty(t, s.GetSummary())
+}
+
+func TestSummarize_ResetDuringSummary(t *testing.T) {
+	Init()
+	s := GlobalManager.GetOrCreate("summary_reset_user")
+	for i := 0; i < 5; i++ {
+		s.AppendMessage("user", "hi")
+	}
+
+	compacted, err := s.Summarize(SummaryPolicy{MaxMessages: 2, KeepRecent: 1}, func(string, []Message) (string, error) {
+		s.Reset()
+		return "stale", nil
+	})
+	assert.NoError(t, err)
+	assert.False(t, compacted)
+	assert.Empty(t, s.GetSummary())
+	assert.Empty(t, s.GetHistory())
+}
+
+func TestSessionJSONRoundTrip(t *testing.T) {
+	Init()
+	s := GlobalManager.GetOrCreate("json_user")
+	s.AppendMessage("user", "hi")
+	s.Summary = "earlier chat"
+
+	raw, err := json.Marshal(s)
+	assert.NoError(t, err)
+
+	var restored Session
+	assert.NoError(t, json.Unmarshal(raw, &restored))
+	assert.Equal(t, "earlier chat", restored.Summary)
+	assert.Len(t, restored.History, 1)
+}
+
+func TestMerge_MovesSession(t *testing.T) {
+	Init()
+	anon := GlobalManager.GetOrCreate("device:abc")
+	anon.AppendMessage("user", "Trip to Paris")
+	anon.UpdateVariables(map[string]string{"destination": "Paris"})
+
+	assert.True(t, GlobalManager.Merge("device:abc", "user-1"))
+	assert.False(t, GlobalManager.Merge("device:abc", "user-1"), "nothing left to merge")
+
+	s := GlobalManager.GetOrCreate("user-1")
+	assert.Same(t, anon, s)
+	assert.Equal(t, "user-1", s.ID)
+	assert.Equal(t, "Paris", s.GetVariables()["destination"])
+	assert.Len(t, s.GetHistory(), 1)
+}
+
+func TestMerge_BothExist(t *testing.T) {
+	Init()
+	user := GlobalManager.GetOrCreate("user-1")
+	user.AppendMessage("user", "old question")
+	user.UpdateVariables(map[string]string{"destination": "Tokyo", "budget": "2000"})
+	user.Summary = "earlier trip planning"
+	user.SetTripID("trip-tokyo")
+	user.LastSeen = time.Now().Add(-time.Hour)
+
+	anon := GlobalManager.GetOrCreate("device:abc")
+	anon.AppendMessage("user", "new question")
+	anon.UpdateVariables(map[string]string{"destination": "Paris"})
+	anon.SetState(StateReady)
+
+	assert.True(t, GlobalManager.Merge("device:abc", "user-1"))
+
+	s := GlobalManager.GetOrCreate("user-1")
+	assert.Same(t, user, s)
+	vars := s.GetVariables()
+	assert.Equal(t, "Paris", vars["destination"], "most recent session wins")
+	assert.Equal(t, "2000", vars["budget"], "missing values are filled in")
+	assert.Equal(t, StateReady, s.State)
+	history := s.GetHistory()
+	assert.Equal(t, []string{"old question", "new question"}, []string{history[0].Content, history[1].Content})
+	assert.Equal(t, "earlier trip planning", s.GetSummary())
+	assert.Equal(t, "trip-tokyo", s.GetTripID(), "a missing trip is filled in")
+}
+
+func TestActiveSince(t *testing.T) {
+	Init()
+	GlobalManager.GetOrCreate("recent")
+	old := GlobalManager.GetOrCreate("old")
+	old.LastSeen = time.Now().Add(-time.Hour)
+
+	assert.Equal(t, 1, GlobalManager.ActiveSince(time.Now().Add(-30*time.Minute)))
+	assert.Equal(t, 2, GlobalManager.ActiveSince(time.Now().Add(-2*time.Hour)))
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	Init()
+	s := GlobalManager.GetOrCreate("device:d1")
+	s.SetState(StateCollecting)
+	s.UpdateVariables(map[string]string{"destination": "Lisbon"})
+	s.AppendMessage("user", "Lisbon in May")
+	data, err := s.Snapshot()
+	assert.NoError(t, err)
+
+	// A restarted gateway restores it on first use
+	Init()
+	assert.False(t, GlobalManager.Has("device:d1"))
+	restored, err := GlobalManager.Restore("device:d1", data)
+	assert.NoError(t, err)
+	assert.True(t, GlobalManager.Has("device:d1"))
+	assert.Equal(t, StateCollecting, restored.State)
+	assert.Equal(t, "Lisbon", restored.GetVariables()["destination"])
+	assert.Equal(t, []Message{{Role: "user", Content: "Lisbon in May"}}, restored.GetHistory())
+	assert.Same(t, restored, GlobalManager.GetOrCreate("device:d1"))
+
+	// A session that went live meanwhile is not overwritten
+	again, err := GlobalManager.Restore("device:d1", []byte(`{"state": "READY"}`))
+	assert.NoError(t, err)
+	assert.Same(t, restored, again)
+
+	// Snapshots taken under another key adopt the new one
+	moved, err := GlobalManager.Restore("user:u1", data)
+	assert.NoError(t, err)
+	assert.Equal(t, "user:u1", moved.ID)
+
+	_, err = GlobalManager.Restore("x", []byte("not json"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,75 @@
+package session
+
+import "strings"
+
+// Merge moves the session stored under fromID into toID, e.g. when an
+// anonymous device logs in. It returns false if there was nothing to move.
+//
+// When both sessions exist the more recently seen one wins for variables,
+// state and trip (it reflects the conversation in progress), missing
+// variables and trip are filled from the other, and histories and summaries
+// are concatenated oldest first so rolling summarization can compact them.
+func (sm *SessionManager) Merge(fromID, toID string) bool {
+	if fromID == "" || toID == "" || fromID == toID {
+		return false
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	from, ok := sm.sessions[fromID]
+	if !ok {
+		return false
+	}
+	delete(sm.sessions, fromID)
+
+	to, ok := sm.sessions[toID]
+	if !ok {
+		from.mu.Lock()
+		from.ID = toID
+		from.generation++
+		from.mu.Unlock()
+		sm.sessions[toID] = from
+		return true
+	}
+
+	from.mu.Lock()
+	defer from.mu.Unlock()
+	to.mu.Lock()
+	defer to.mu.Unlock()
+
+	older, newer := to, from
+	if to.LastSeen.After(from.LastSeen) {
+		older, newer = from, to
+	}
+
+	vars := make(map[string]string, len(older.Variables)+len(newer.Variables))
+	for k, v := range older.Variables {
+		vars[k] = v
+	}
+	for k, v := range newer.Variables {
+		vars[k] = v
+	}
+
+	history := make([]Message, 0, len(older.History)+len(newer.History))
+	history = append(history, older.History...)
+	history = append(history, newer.History...)
+
+	var summaries []string
+	for _, s := range []string{older.Summary, newer.Summary} {
+		if s != "" {
+			summaries = append(summaries, s)
+		}
+	}
+
+	to.Variables = vars
+	to.History = history
+	to.Summary = strings.Join(summaries, "\n\n")
+	to.State = newer.State
+	if newer.TripID != "" {
+		to.TripID = newer.TripID
+	}
+	to.LastSeen = newer.LastSeen
+	to.generation++
+	return true
+}
@@ -0,0 +1,56 @@
+package session
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Snapshot returns the session's JSON encoding for persistence
+func (s *Session) Snapshot() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return json.Marshal(s)
+}
+
+// Has reports whether a session is live in memory
+func (sm *SessionManager) Has(id string) bool {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	_, ok := sm.sessions[id]
+	return ok
+}
+
+// Restore makes a session decoded from Snapshot data live under id and
+// returns it. If id went live in the meantime, that session wins.
+func (sm *SessionManager) Restore(id string, data []byte) (*Session, error) {
+	return sm.RestoreSeen(id, data, time.Now())
+}
+
+// RestoreSeen is Restore with the session last seen at seen, e.g. when it was
+// stored, so that Merge picks the session that was actually used last
+func (sm *SessionManager) RestoreSeen(id string, data []byte, seen time.Time) (*Session, error) {
+	sess := &Session{}
+	if err := json.Unmarshal(data, sess); err != nil {
+		return nil, err
+	}
+	// The snapshot may have been taken under another key (e.g. before a merge)
+	sess.ID = id
+	if sess.State == "" {
+		sess.State = StateIdle
+	}
+	if sess.Variables == nil {
+		sess.Variables = make(map[string]string)
+	}
+	if sess.History == nil {
+		sess.History = make([]Message, 0)
+	}
+	sess.LastSeen = seen
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if live, ok := sm.sessions[id]; ok {
+		return live, nil
+	}
+	sm.sessions[id] = sess
+	return sess, nil
+}
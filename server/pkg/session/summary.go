@@ -0,0 +1,89 @@
+package session
+
+import "fmt"
+
+// RoleSummary marks the synthetic message that carries the rolling summary
+const RoleSummary = "system"
+
+// Summarizer condenses older messages into a new rolling summary.
+// previous is the existing summary (may be empty) and must be folded into the result.
+type Summarizer func(previous string, older []Message) (string, error)
+
+// SummaryPolicy controls when older turns are condensed into the summary
+type SummaryPolicy struct {
+	MaxMessages int // Summarize once History grows beyond this many messages
+	KeepRecent  int // Most recent messages that always stay verbatim
+}
+
+// DefaultSummaryPolicy keeps the last 8 messages once history exceeds 20
+var DefaultSummaryPolicy = SummaryPolicy{MaxMessages: 20, KeepRecent: 8}
+
+// Enabled reports whether the policy can ever trigger summarization
+func (p SummaryPolicy) Enabled() bool {
+	return p.MaxMessages > 0 && p.KeepRecent >= 0 && p.KeepRecent < p.MaxMessages
+}
+
+// GetSummary returns the current rolling summary
+func (s *Session) GetSummary() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Summary
+}
+
+// GetContext returns the history to send to the LLM: the rolling summary
+// (if any) as a leading message, followed by the verbatim recent turns.
+func (s *Session) GetContext() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ctx := make([]Message, 0, len(s.History)+1)
+	if s.Summary != "" {
+		ctx = append(ctx, Message{
+			Role:    RoleSummary,
+			Content: "Summary of the earlier conversation (Known Variables take precedence over anything stated here):\n" + s.Summary,
+		})
+	}
+	return append(ctx, s.History...)
+}
+
+// Summarize condenses the oldest turns into the rolling summary when the
+// policy threshold is exceeded. It returns true if history was compacted.
+// The summarizer runs without holding the session lock so other requests are
+// not blocked on the LLM call; if the session is reset meanwhile the result is dropped.
+func (s *Session) Summarize(policy SummaryPolicy, summarize Summarizer) (bool, error) {
+	if !policy.Enabled() || summarize == nil {
+		return false, nil
+	}
+
+	s.mu.Lock()
+	if len(s.History) <= policy.MaxMessages {
+		s.mu.Unlock()
+		return false, nil
+	}
+	cut := len(s.History) - policy.KeepRecent
+	older := make([]Message, cut)
+	copy(older, s.History[:cut])
+	previous := s.Summary
+	generation := s.generation
+	s.mu.Unlock()
+
+	summary, err := summarize(previous, older)
+	if err != nil {
+		return false, fmt.Errorf("failed to summarize history: %w", err)
+	}
+	if summary == "" {
+		return false, fmt.Errorf("failed to summarize history: empty summary")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// History was reset (or already compacted by a concurrent call) while summarizing
+	if s.generation != generation || len(s.History) < cut {
+		return false, nil
+	}
+	remaining := make([]Message, len(s.History)-cut)
+	copy(remaining, s.History[cut:])
+	s.History = remaining
+	s.Summary = summary
+	s.generation++
+	return true, nil
+}
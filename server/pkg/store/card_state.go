@@ -0,0 +1,106 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+func pgPlaceholder(n int) string { return fmt.Sprintf("$%d", n) }
+
+// scanCard reads a row of cardColumns. When the content is not JSON the card
+// is returned without Data, along with errBadContent.
+func scanCard(row rowScanner) (*Card, error) {
+	var c Card
+	var content []byte
+	var readAt, dismissedAt, expiresAt sql.NullTime
+	if err := row.Scan(&c.ID, &c.RunID, &c.TripID, &c.CardType, &c.Priority, &c.SourceNode, &content, &c.updatedAt,
+		&c.Pinned, &readAt, &dismissedAt, &expiresAt); err != nil {
+		return nil, err
+	}
+	c.ReadAt, c.DismissedAt, c.ExpiresAt = nullTimePtr(readAt), nullTimePtr(dismissedAt), nullTimePtr(expiresAt)
+	c.Timestamp = c.updatedAt.Format(time.RFC3339)
+	if err := json.Unmarshal(content, &c.Data); err != nil {
+		c.Data = nil
+		return &c, errBadContent
+	}
+	return &c, nil
+}
+
+// isUUID reports whether id has the canonical UUID shape. Card IDs are UUID
+// columns, so anything else cannot name a card.
+func isUUID(id string) bool {
+	if len(id) != 36 {
+		return false
+	}
+	for i, r := range id {
+		switch {
+		case i == 8 || i == 13 || i == 18 || i == 23:
+			if r != '-' {
+				return false
+			}
+		case (r < '0' || r > '9') && (r < 'a' || r > 'f') && (r < 'A' || r > 'F'):
+			return false
+		}
+	}
+	return true
+}
+
+// UpdateCard applies patch to one of the owner's cards and returns it
+func (s *PostgresStore) UpdateCard(ctx context.Context, ownerID, id string, patch CardPatch) (_ *Card, err error) {
+	ctx, finish := startQuery(ctx, "update_card")
+	defer finish(&err)
+	if !isUUID(id) {
+		return nil, ErrNotFound
+	}
+	set, args := cardPatchSet(patch, s.now(), pgPlaceholder, func(t time.Time) interface{} { return t })
+	args = append(args, ownerID, id)
+	query := fmt.Sprintf(`UPDATE cards SET %s WHERE owner_id = $%d AND id = $%d RETURNING %s`,
+		set, len(args)-1, len(args), cardColumns)
+
+	card, err := scanCard(s.DB.QueryRowContext(ctx, query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil && !errors.Is(err, errBadContent) {
+		return nil, fmt.Errorf("failed to update card: %w", err)
+	}
+	return card, nil
+}
+
+// DeleteCard removes one of the owner's cards
+func (s *PostgresStore) DeleteCard(ctx context.Context, ownerID, id string) (err error) {
+	ctx, finish := startQuery(ctx, "delete_card")
+	defer finish(&err)
+	if !isUUID(id) {
+		return ErrNotFound
+	}
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM cards WHERE owner_id = $1 AND id = $2`, ownerID, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete card: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// PurgeCards deletes expired unpinned cards and cards dismissed before dismissedBefore
+func (s *PostgresStore) PurgeCards(ctx context.Context, dismissedBefore time.Time) (_ int, err error) {
+	ctx, finish := startQuery(ctx, "purge_cards")
+	defer finish(&err)
+	res, err := s.DB.ExecContext(ctx, `
+		DELETE FROM cards
+		WHERE (NOT pinned AND expires_at <= $1) OR dismissed_at < $2
+	`, s.now(), dismissedBefore)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge cards: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	slog.DebugContext(ctx, "purged cards", "cards", n)
+	return int(n), nil
+}
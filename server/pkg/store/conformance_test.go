@@ -0,0 +1,820 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"guardian-gateway/migrations"
+	"guardian-gateway/pkg/migrate"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// backend opens an empty FeedStore and can set its clock, so every
+// implementation runs the same conformance suite
+type backend struct {
+	open func(t *testing.T) FeedStore
+	// setClock replaces the clock the store keys sticky cards by
+	setClock func(s FeedStore, now func() time.Time)
+}
+
+func TestMemoryStore_Conformance(t *testing.T) {
+	testFeedStore(t, backend{
+		open:     func(t *testing.T) FeedStore { return NewMemoryStore() },
+		setClock: func(s FeedStore, now func() time.Time) { s.(*MemoryStore).now = now },
+	})
+}
+
+func TestSQLiteStore_Conformance(t *testing.T) {
+	testFeedStore(t, backend{
+		open: func(t *testing.T) FeedStore {
+			s, err := NewSQLiteStore(context.Background(), filepath.Join(t.TempDir(), "feed.db"))
+			require.NoError(t, err)
+			t.Cleanup(func() { s.Close() })
+			s.AllowPlaintextKeys = true
+			return s
+		},
+		setClock: func(s FeedStore, now func() time.Time) { s.(*SQLiteStore).now = now },
+	})
+}
+
+// TestPostgresStore_Conformance runs against TEST_DATABASE_URL (a disposable
+// database; its schema is dropped and recreated)
+func TestPostgresStore_Conformance(t *testing.T) {
+	connStr := os.Getenv("TEST_DATABASE_URL")
+	if connStr == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	ctx := context.Background()
+	s, err := NewPostgresStore(ctx, connStr)
+	require.NoError(t, err)
+	defer s.Close()
+	s.AllowPlaintextKeys = true
+	_, err = s.DB.ExecContext(ctx, `DROP SCHEMA public CASCADE; CREATE SCHEMA public;`)
+	require.NoError(t, err)
+	all, err := migrate.Load(migrations.FS)
+	require.NoError(t, err)
+	_, err = migrate.New(s.DB, all).Up(ctx)
+	require.NoError(t, err)
+
+	testFeedStore(t, backend{
+		open: func(t *testing.T) FeedStore {
+			_, err := s.DB.Exec(`TRUNCATE cards, card_revisions, user_litellm_keys, usage_events, device_user_links, sessions, agent_runs, trips`)
+			require.NoError(t, err)
+			s.now = time.Now
+			return s
+		},
+		setClock: func(fs FeedStore, now func() time.Time) { s.now = now },
+	})
+}
+
+func testFeedStore(t *testing.T, b backend) {
+	ctx := context.Background()
+	card := func(node, text string) *Card {
+		return &Card{CardType: "article", Priority: "normal", SourceNode: node, Data: map[string]interface{}{"text": text}}
+	}
+	feedOf := func(s FeedStore, ownerID string, limit int) ([]*Card, error) {
+		page, err := s.GetFeed(ctx, ownerID, FeedQuery{Limit: limit})
+		if err != nil {
+			return nil, err
+		}
+		return page.Cards, nil
+	}
+
+	t.Run("sticky cards", func(t *testing.T) {
+		s := b.open(t)
+		// A ticking clock keeps updated_at distinct, inside one sticky window
+		now := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+		b.setClock(s, func() time.Time { now = now.Add(time.Second); return now })
+
+		require.NoError(t, s.UpsertCard(ctx, "alice", card("Weather", "sunny")))
+		require.NoError(t, s.UpsertCard(ctx, "alice", card("Flights", "on time")))
+		require.NoError(t, s.UpsertCard(ctx, "alice", card("Weather", "rain")))
+		require.NoError(t, s.UpsertCard(ctx, "bob", card("Weather", "snow")))
+
+		feed, err := feedOf(s, "alice", 10)
+		require.NoError(t, err)
+		require.Len(t, feed, 2, "an update within the window replaces the node's card")
+		assert.Equal(t, "Weather", feed[0].SourceNode, "most recently updated first")
+		assert.Equal(t, "rain", feed[0].Data["text"])
+		assert.Equal(t, "Flights", feed[1].SourceNode)
+		assert.NotEmpty(t, feed[0].ID)
+		_, err = time.Parse(time.RFC3339, feed[0].Timestamp)
+		assert.NoError(t, err)
+
+		feed, err = feedOf(s, "alice", 1)
+		require.NoError(t, err)
+		assert.Len(t, feed, 1)
+
+		// The next window starts a new card
+		now = now.Add(DefaultStickyWindow)
+		require.NoError(t, s.UpsertCard(ctx, "alice", card("Weather", "clearing")))
+		feed, err = feedOf(s, "alice", 10)
+		require.NoError(t, err)
+		require.Len(t, feed, 3)
+		assert.Equal(t, "clearing", feed[0].Data["text"])
+
+		require.NoError(t, s.DeleteFeed(ctx, "alice"))
+		feed, err = feedOf(s, "alice", 10)
+		require.NoError(t, err)
+		assert.NotNil(t, feed)
+		assert.Empty(t, feed)
+
+		feed, err = feedOf(s, "bob", 10)
+		require.NoError(t, err)
+		assert.Len(t, feed, 1, "other owners are untouched")
+	})
+
+	t.Run("run cards", func(t *testing.T) {
+		s := b.open(t)
+		first := card("Weather", "sunny")
+		first.RunID = "run-1"
+		require.NoError(t, s.UpsertCard(ctx, "alice", first))
+		require.NotEmpty(t, first.ID)
+
+		other := card("Weather", "rain")
+		other.RunID = "run-2"
+		require.NoError(t, s.UpsertCard(ctx, "alice", other))
+		assert.NotEqual(t, first.ID, other.ID, "each run gets its own card")
+
+		again := card("Weather", "clearing")
+		again.RunID = "run-1"
+		require.NoError(t, s.UpsertCard(ctx, "alice", again))
+		assert.Equal(t, first.ID, again.ID, "a run keeps updating its card")
+
+		feed, err := feedOf(s, "alice", 10)
+		require.NoError(t, err)
+		require.Len(t, feed, 2)
+		assert.Equal(t, "run-1", feed[0].RunID)
+		assert.Equal(t, "clearing", feed[0].Data["text"])
+	})
+
+	t.Run("concurrent upserts", func(t *testing.T) {
+		s := b.open(t)
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				c := card("Summary", fmt.Sprintf("chunk %d", i))
+				c.RunID = "run-1"
+				assert.NoError(t, s.UpsertCard(ctx, "alice", c))
+			}(i)
+		}
+		wg.Wait()
+		feed, err := feedOf(s, "alice", 10)
+		require.NoError(t, err)
+		assert.Len(t, feed, 1, "chunks of one node never duplicate its card")
+	})
+
+	t.Run("feed pages and filters", func(t *testing.T) {
+		s := b.open(t)
+		base := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+		now := base
+		b.setClock(s, func() time.Time { return now })
+		for i, node := range []string{"Weather", "NewsAlert", "GeniusLoci", "GenerateReport", "Flights"} {
+			c := card(node, node)
+			c.RunID = fmt.Sprintf("run-%d", i)
+			if node == "NewsAlert" {
+				c.CardType, c.Priority = "safe_alert", "high"
+			}
+			require.NoError(t, s.UpsertCard(ctx, "alice", c))
+		}
+
+		var nodes []string
+		q := FeedQuery{Limit: 2}
+		for pages := 0; ; pages++ {
+			require.Less(t, pages, 3)
+			page, err := s.GetFeed(ctx, "alice", q)
+			require.NoError(t, err)
+			for _, c := range page.Cards {
+				nodes = append(nodes, c.SourceNode)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			q.Cursor = page.NextCursor
+		}
+		assert.Len(t, nodes, 5, "pages cover the feed without repeats")
+		assert.ElementsMatch(t, []string{"Weather", "NewsAlert", "GeniusLoci", "GenerateReport", "Flights"}, nodes)
+
+		_, err := s.GetFeed(ctx, "alice", FeedQuery{Limit: 2, Cursor: "not-a-cursor"})
+		assert.ErrorIs(t, err, ErrInvalidCursor)
+		_, err = s.GetFeed(ctx, "alice", FeedQuery{Limit: 2, Cursor: encodeCursor(false, time.Now(), "not-a-uuid")})
+		assert.ErrorIs(t, err, ErrInvalidCursor, "a tampered id")
+
+		page, err := s.GetFeed(ctx, "alice", FeedQuery{Limit: 10, Priorities: []string{"high"}})
+		require.NoError(t, err)
+		require.Len(t, page.Cards, 1)
+		assert.Equal(t, "NewsAlert", page.Cards[0].SourceNode)
+
+		page, err = s.GetFeed(ctx, "alice", FeedQuery{Limit: 10, CardTypes: []string{"article"}, SourceNodes: []string{"Weather", "Flights", "NewsAlert"}})
+		require.NoError(t, err)
+		assert.Len(t, page.Cards, 2)
+	})
+
+	t.Run("feed since", func(t *testing.T) {
+		s := b.open(t)
+		weather := card("Weather", "sunny")
+		require.NoError(t, s.UpsertCard(ctx, "alice", weather))
+		page, err := s.GetFeed(ctx, "alice", FeedQuery{Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, page.Cards, 1)
+		synced := time.Now()
+
+		time.Sleep(10 * time.Millisecond)
+		require.NoError(t, s.UpsertCard(ctx, "alice", card("Flights", "delayed")))
+		page, err = s.GetFeed(ctx, "alice", FeedQuery{Limit: 10, Since: synced})
+		require.NoError(t, err)
+		require.Len(t, page.Cards, 1, "only cards updated after since")
+		assert.Equal(t, "Flights", page.Cards[0].SourceNode)
+
+		page, err = s.GetFeed(ctx, "alice", FeedQuery{Limit: 10, To: synced})
+		require.NoError(t, err)
+		require.Len(t, page.Cards, 1)
+		assert.Equal(t, "Weather", page.Cards[0].SourceNode)
+		page, err = s.GetFeed(ctx, "alice", FeedQuery{Limit: 10, From: synced})
+		require.NoError(t, err)
+		assert.Len(t, page.Cards, 1)
+
+		// Lifecycle changes sync too; a dismissed card comes back flagged
+		synced = time.Now()
+		time.Sleep(10 * time.Millisecond)
+		yes := true
+		_, err = s.UpdateCard(ctx, "alice", weather.ID, CardPatch{Read: &yes})
+		require.NoError(t, err)
+		page, err = s.GetFeed(ctx, "alice", FeedQuery{Limit: 10, Since: synced})
+		require.NoError(t, err)
+		require.Len(t, page.Cards, 1, "a read card is an update")
+		assert.Equal(t, weather.ID, page.Cards[0].ID)
+		assert.NotNil(t, page.Cards[0].ReadAt)
+
+		synced = time.Now()
+		time.Sleep(10 * time.Millisecond)
+		_, err = s.UpdateCard(ctx, "alice", weather.ID, CardPatch{Dismissed: &yes})
+		require.NoError(t, err)
+		page, err = s.GetFeed(ctx, "alice", FeedQuery{Limit: 10, Since: synced})
+		require.NoError(t, err)
+		require.Len(t, page.Cards, 1)
+		assert.NotNil(t, page.Cards[0].DismissedAt, "dismissed cards are returned as tombstones")
+		all, err := feedOf(s, "alice", 10)
+		require.NoError(t, err)
+		assert.Len(t, all, 1, "but stay hidden from the feed")
+	})
+
+	t.Run("card lifecycle", func(t *testing.T) {
+		s := b.open(t)
+		now := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+		b.setClock(s, func() time.Time { now = now.Add(time.Second); return now })
+		cards := map[string]*Card{}
+		for i, node := range []string{"Report", "Weather", "NewsAlert", "Flights"} {
+			c := card(node, node)
+			c.RunID = fmt.Sprintf("run-%d", i)
+			require.NoError(t, s.UpsertCard(ctx, "alice", c))
+			cards[node] = c
+		}
+		yes, no := true, false
+
+		pinned, err := s.UpdateCard(ctx, "alice", cards["Report"].ID, CardPatch{Pinned: &yes, Read: &yes})
+		require.NoError(t, err)
+		assert.True(t, pinned.Pinned)
+		require.NotNil(t, pinned.ReadAt)
+		assert.Equal(t, "Report", pinned.Data["text"])
+		read, err := s.UpdateCard(ctx, "alice", cards["Report"].ID, CardPatch{Read: &yes})
+		require.NoError(t, err)
+		assert.True(t, read.ReadAt.Equal(*pinned.ReadAt), "reading again keeps the first read time")
+
+		_, err = s.UpdateCard(ctx, "alice", cards["NewsAlert"].ID, CardPatch{Dismissed: &yes})
+		require.NoError(t, err)
+		expiry := now.Add(time.Minute)
+		_, err = s.UpdateCard(ctx, "alice", cards["Weather"].ID, CardPatch{ExpiresAt: &expiry})
+		require.NoError(t, err)
+
+		var nodes []string
+		q := FeedQuery{Limit: 1}
+		for pages := 0; ; pages++ {
+			require.Less(t, pages, 4)
+			page, err := s.GetFeed(ctx, "alice", q)
+			require.NoError(t, err)
+			for _, c := range page.Cards {
+				nodes = append(nodes, c.SourceNode)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			q.Cursor = page.NextCursor
+		}
+		assert.Equal(t, []string{"Report", "Weather", "Flights"}, nodes, "pinned first, patches count as updates; dismissed hidden")
+
+		// Past its expiry Weather is hidden; a pinned card never expires
+		now = expiry.Add(time.Second)
+		past := now.Add(-time.Hour)
+		_, err = s.UpdateCard(ctx, "alice", cards["Report"].ID, CardPatch{ExpiresAt: &past})
+		require.NoError(t, err)
+		feed, err := feedOf(s, "alice", 10)
+		require.NoError(t, err)
+		require.Len(t, feed, 2)
+		assert.Equal(t, "Report", feed[0].SourceNode)
+		assert.Equal(t, "Flights", feed[1].SourceNode)
+
+		_, err = s.UpdateCard(ctx, "alice", cards["Report"].ID, CardPatch{ExpiresAt: &time.Time{}, Read: &no})
+		require.NoError(t, err)
+		undismissed, err := s.UpdateCard(ctx, "alice", cards["NewsAlert"].ID, CardPatch{Dismissed: &no})
+		require.NoError(t, err)
+		assert.Nil(t, undismissed.DismissedAt)
+		feed, err = feedOf(s, "alice", 10)
+		require.NoError(t, err)
+		assert.Len(t, feed, 3)
+		assert.Nil(t, feed[0].ExpiresAt)
+		assert.Nil(t, feed[0].ReadAt)
+
+		_, err = s.UpdateCard(ctx, "bob", cards["Flights"].ID, CardPatch{Pinned: &yes})
+		assert.ErrorIs(t, err, ErrNotFound, "other owners' cards")
+		_, err = s.UpdateCard(ctx, "alice", "nope", CardPatch{Pinned: &yes})
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		assert.ErrorIs(t, s.DeleteCard(ctx, "bob", cards["Flights"].ID), ErrNotFound)
+		require.NoError(t, s.DeleteCard(ctx, "alice", cards["Flights"].ID))
+		assert.ErrorIs(t, s.DeleteCard(ctx, "alice", cards["Flights"].ID), ErrNotFound)
+		feed, err = feedOf(s, "alice", 10)
+		require.NoError(t, err)
+		assert.Len(t, feed, 2)
+	})
+
+	t.Run("card revisions", func(t *testing.T) {
+		s := b.open(t)
+		standing := func(runID, text string) *Card {
+			c := card("NewsAlert", text)
+			c.RunID, c.StickyKey, c.AgentVersion = runID, "schedule:guardian", "guardian@"+runID
+			return c
+		}
+
+		first := standing("run-1", "calm")
+		require.NoError(t, s.UpsertCard(ctx, "alice", first))
+		require.NoError(t, s.UpsertCard(ctx, "alice", standing("run-1", "calm so far")))
+		second := standing("run-2", "protests downtown")
+		require.NoError(t, s.UpsertCard(ctx, "alice", second))
+		assert.Equal(t, first.ID, second.ID, "a sticky key spans runs")
+		require.NoError(t, s.UpsertCard(ctx, "alice", standing("run-3", "protests downtown")))
+
+		history, err := s.CardHistory(ctx, "alice", first.ID, 10)
+		require.NoError(t, err)
+		require.Len(t, history, 2, "one revision per run; an unchanged run adds none")
+		assert.Equal(t, "run-2", history[0].RunID)
+		assert.Equal(t, "protests downtown", history[0].Data["text"])
+		assert.Equal(t, "guardian@run-2", history[0].AgentVersion)
+		assert.Equal(t, "run-1", history[1].RunID)
+		assert.Equal(t, "calm so far", history[1].Data["text"], "a run's revision holds its latest content")
+		assert.Equal(t, first.ID, history[1].CardID)
+		assert.Greater(t, history[0].ID, history[1].ID)
+		assert.False(t, history[1].CreatedAt.IsZero())
+
+		feed, err := feedOf(s, "alice", 10)
+		require.NoError(t, err)
+		require.Len(t, feed, 1)
+		assert.Equal(t, "run-3", feed[0].RunID, "the card shows its latest run")
+
+		history, err = s.CardHistory(ctx, "alice", first.ID, 1)
+		require.NoError(t, err)
+		assert.Len(t, history, 1)
+
+		for i := 0; i < maxCardRevisions+5; i++ {
+			require.NoError(t, s.UpsertCard(ctx, "alice", standing(fmt.Sprintf("run-x%d", i), fmt.Sprintf("update %d", i))))
+		}
+		history, err = s.CardHistory(ctx, "alice", first.ID, 100)
+		require.NoError(t, err)
+		assert.Len(t, history, maxCardRevisions, "old revisions are pruned")
+
+		_, err = s.CardHistory(ctx, "bob", first.ID, 10)
+		assert.ErrorIs(t, err, ErrNotFound)
+		require.NoError(t, s.DeleteCard(ctx, "alice", first.ID))
+		_, err = s.CardHistory(ctx, "alice", first.ID, 10)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("purge cards", func(t *testing.T) {
+		s := b.open(t)
+		now := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+		b.setClock(s, func() time.Time { return now })
+		ids := map[string]string{}
+		for i, node := range []string{"Expired", "PinnedExpired", "Dismissed", "RecentlyDismissed", "Kept"} {
+			c := card(node, node)
+			c.RunID = fmt.Sprintf("run-%d", i)
+			require.NoError(t, s.UpsertCard(ctx, "alice", c))
+			ids[node] = c.ID
+		}
+		yes := true
+		expiry := now.Add(time.Minute)
+		for _, node := range []string{"Expired", "PinnedExpired"} {
+			_, err := s.UpdateCard(ctx, "alice", ids[node], CardPatch{ExpiresAt: &expiry, Pinned: &yes})
+			require.NoError(t, err)
+		}
+		no := false
+		_, err := s.UpdateCard(ctx, "alice", ids["Expired"], CardPatch{Pinned: &no})
+		require.NoError(t, err)
+		_, err = s.UpdateCard(ctx, "alice", ids["Dismissed"], CardPatch{Dismissed: &yes})
+		require.NoError(t, err)
+		now = now.Add(time.Hour)
+		_, err = s.UpdateCard(ctx, "alice", ids["RecentlyDismissed"], CardPatch{Dismissed: &yes})
+		require.NoError(t, err)
+
+		n, err := s.PurgeCards(ctx, now.Add(-time.Minute))
+		require.NoError(t, err)
+		assert.Equal(t, 2, n, "the expired unpinned card and the long dismissed one")
+		for node, want := range map[string]error{"Expired": ErrNotFound, "Dismissed": ErrNotFound, "PinnedExpired": nil, "RecentlyDismissed": nil, "Kept": nil} {
+			_, err := s.UpdateCard(ctx, "alice", ids[node], CardPatch{})
+			assert.ErrorIs(t, err, want, node)
+		}
+	})
+
+	t.Run("merge device into user", func(t *testing.T) {
+		s := b.open(t)
+		runCard := func(node, text string) *Card {
+			c := card(node, text)
+			c.RunID = "run-1"
+			return c
+		}
+		require.NoError(t, s.UpsertCard(ctx, "user:u1", runCard("Flights", "user copy")))
+		require.NoError(t, s.UpsertCard(ctx, "device:d1", runCard("Flights", "device copy")))
+		require.NoError(t, s.UpsertCard(ctx, "device:d1", runCard("Hotels", "booked")))
+		require.NoError(t, s.UpsertCard(ctx, "user:u1", card("Hotels", "from another run")))
+
+		res, err := s.MergeDeviceIntoUser(ctx, "d1", "device:d1", "user:u1")
+		require.NoError(t, err)
+		assert.Equal(t, &MergeResult{FirstLink: true, CardsMoved: 2, CardsDropped: 1}, res)
+
+		feed, err := feedOf(s, "user:u1", 10)
+		require.NoError(t, err)
+		require.Len(t, feed, 3)
+		var texts []interface{}
+		for _, c := range feed {
+			texts = append(texts, c.Data["text"])
+		}
+		assert.ElementsMatch(t, []interface{}{"device copy", "booked", "from another run"}, texts, "the newer sticky card wins")
+
+		res, err = s.MergeDeviceIntoUser(ctx, "d1", "device:d1", "user:u1")
+		require.NoError(t, err)
+		assert.Equal(t, &MergeResult{}, res)
+	})
+
+	t.Run("keys", func(t *testing.T) {
+		s := b.open(t)
+		rec, err := s.GetUserLiteLLMKeyRecord(ctx, "u1")
+		require.NoError(t, err)
+		assert.Nil(t, rec)
+		key, err := s.GetUserLiteLLMKey(ctx, "u1")
+		require.NoError(t, err)
+		assert.Empty(t, key)
+
+		require.NoError(t, s.StoreUserLiteLLMKey(ctx, "u1", "sk-1", KeyName("u1"), 10))
+		require.NoError(t, s.StoreUserLiteLLMKey(ctx, "u2", "sk-2", KeyName("u2"), 5))
+		key, err = s.GetUserLiteLLMKey(ctx, "u1")
+		require.NoError(t, err)
+		assert.Equal(t, "sk-1", key)
+
+		rec, err = s.GetUserLiteLLMKeyRecord(ctx, "u1")
+		require.NoError(t, err)
+		require.NotNil(t, rec)
+		assert.Equal(t, KeyStatusActive, rec.Status)
+		assert.Equal(t, KeyName("u1"), rec.KeyName)
+		assert.Equal(t, 10.0, rec.MaxBudget)
+		assert.NotNil(t, rec.LastUsedAt, "reading the key touches it")
+		assert.False(t, rec.CreatedAt.IsZero())
+
+		require.NoError(t, s.UpdateUserLiteLLMKeyValue(ctx, "u1", "sk-1b"))
+		require.NoError(t, s.SetUserLiteLLMKeyBudget(ctx, "u1", 25))
+		require.NoError(t, s.MarkUserLiteLLMKeyReconciled(ctx, "u1"))
+		rec, err = s.GetUserLiteLLMKeyRecord(ctx, "u1")
+		require.NoError(t, err)
+		assert.Equal(t, "sk-1b", rec.LiteLLMKey)
+		assert.Equal(t, 25.0, rec.MaxBudget)
+		assert.NotNil(t, rec.RotatedAt)
+		assert.NotNil(t, rec.ReconciledAt)
+
+		require.NoError(t, s.SetUserLiteLLMKeyStatus(ctx, "u2", KeyStatusMissing))
+		require.NoError(t, s.SetUserLiteLLMKeyStatus(ctx, "u1", KeyStatusRevoked))
+		key, err = s.GetUserLiteLLMKey(ctx, "u1")
+		require.NoError(t, err)
+		assert.Empty(t, key, "revoked keys are not handed out")
+		rec, err = s.GetUserLiteLLMKeyRecord(ctx, "u1")
+		require.NoError(t, err)
+		assert.Equal(t, KeyStatusRevoked, rec.Status)
+		assert.NotNil(t, rec.RevokedAt)
+
+		missing, err := s.ListUserLiteLLMKeys(ctx, KeyStatusMissing)
+		require.NoError(t, err)
+		require.Len(t, missing, 1)
+		assert.Equal(t, "u2", missing[0].UserID)
+		assert.Equal(t, "sk-2", missing[0].LiteLLMKey)
+
+		// Storing again reactivates a missing key but not a revoked one
+		assert.ErrorIs(t, s.StoreUserLiteLLMKey(ctx, "u1", "sk-1c", KeyName("u1"), 10), ErrKeyRevoked)
+		rec, err = s.GetUserLiteLLMKeyRecord(ctx, "u1")
+		require.NoError(t, err)
+		assert.Equal(t, KeyStatusRevoked, rec.Status)
+		assert.Equal(t, "sk-1b", rec.LiteLLMKey)
+		require.NoError(t, s.StoreUserLiteLLMKey(ctx, "u2", "sk-2c", KeyName("u2"), 5))
+		active, err := s.ListUserLiteLLMKeys(ctx, KeyStatusActive)
+		require.NoError(t, err)
+		require.Len(t, active, 1)
+		assert.Equal(t, "sk-2c", active[0].LiteLLMKey)
+
+		// Replacing the value (an admin rotation) lifts the revocation
+		require.NoError(t, s.UpdateUserLiteLLMKeyValue(ctx, "u1", "sk-1d"))
+		rec, err = s.GetUserLiteLLMKeyRecord(ctx, "u1")
+		require.NoError(t, err)
+		assert.Equal(t, KeyStatusActive, rec.Status)
+		assert.Nil(t, rec.RevokedAt)
+		assert.Equal(t, "sk-1d", rec.LiteLLMKey)
+	})
+
+	t.Run("key lock", func(t *testing.T) {
+		s := b.open(t)
+		var holders, maxHolders int32
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := s.WithUserKeyLock(ctx, "u1", func(ctx context.Context) error {
+					n := atomic.AddInt32(&holders, 1)
+					for {
+						m := atomic.LoadInt32(&maxHolders)
+						if n <= m || atomic.CompareAndSwapInt32(&maxHolders, m, n) {
+							break
+						}
+					}
+					time.Sleep(5 * time.Millisecond)
+					atomic.AddInt32(&holders, -1)
+					return nil
+				})
+				assert.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int32(1), maxHolders)
+	})
+
+	t.Run("usage", func(t *testing.T) {
+		s := b.open(t)
+		require.NoError(t, s.StoreUserLiteLLMKey(ctx, "u1", "sk-1", KeyName("u1"), 10))
+		for _, ev := range []*UsageEvent{
+			{UserID: "u1", Source: "chat", Model: "gemini", PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15, CostUSD: 0.5},
+			{UserID: "u1", Source: "agent_run", Model: "gemini", TotalTokens: 100, CostUSD: 1.5, CostEstimated: true},
+			{UserID: "u1", Source: "chat", Model: "claude", TotalTokens: 20, CostUSD: 1},
+			{UserID: "u2", Source: "chat", Model: "gemini", TotalTokens: 1, CostUSD: 0.25},
+		} {
+			require.NoError(t, s.RecordUsage(ctx, ev))
+		}
+
+		since := time.Now().Add(-time.Hour)
+		summary, err := s.GetUserUsage(ctx, "u1", since)
+		require.NoError(t, err)
+		assert.Equal(t, 3, summary.Requests)
+		assert.Equal(t, 135, summary.TotalTokens)
+		assert.InDelta(t, 3.0, summary.CostUSD, 1e-9)
+		assert.Equal(t, 10.0, summary.MaxBudget)
+		require.Len(t, summary.ByModel, 2)
+		assert.Equal(t, "claude", summary.ByModel[0].Model)
+		assert.Equal(t, ModelUsage{Model: "gemini", Requests: 2, PromptTokens: 10, CompletionTokens: 5, TotalTokens: 115, CostUSD: 2}, summary.ByModel[1])
+
+		summary, err = s.GetUserUsage(ctx, "u1", time.Now().Add(time.Hour))
+		require.NoError(t, err)
+		assert.Zero(t, summary.Requests)
+		assert.NotNil(t, summary.ByModel)
+
+		report, err := s.GetUsageAggregate(ctx, since, 10)
+		require.NoError(t, err)
+		require.Len(t, report, 2)
+		assert.Equal(t, "u1", report[0].UserID, "highest spend first")
+		assert.Equal(t, 10.0, report[0].MaxBudget)
+		assert.Equal(t, UserUsage{UserID: "u2", Requests: 1, TotalTokens: 1, CostUSD: 0.25}, report[1])
+
+		report, err = s.GetUsageAggregate(ctx, since, 1)
+		require.NoError(t, err)
+		assert.Len(t, report, 1)
+	})
+
+	t.Run("sessions", func(t *testing.T) {
+		s := b.open(t)
+		_, err := s.LoadSession(ctx, "device:d1")
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		data := json.RawMessage(`{"id": "device:d1", "state": "COLLECTING", "variables": {"destination": "Lisbon"}}`)
+		require.NoError(t, s.SaveSession(ctx, &SessionRecord{ID: "device:d1", Data: data}))
+		rec, err := s.LoadSession(ctx, "device:d1")
+		require.NoError(t, err)
+		assert.Equal(t, "device:d1", rec.ID)
+		assert.JSONEq(t, string(data), string(rec.Data))
+		assert.False(t, rec.UpdatedAt.IsZero())
+
+		data = json.RawMessage(`{"id": "device:d1", "state": "READY"}`)
+		require.NoError(t, s.SaveSession(ctx, &SessionRecord{ID: "device:d1", Data: data}))
+		rec, err = s.LoadSession(ctx, "device:d1")
+		require.NoError(t, err)
+		assert.JSONEq(t, string(data), string(rec.Data))
+
+		require.NoError(t, s.DeleteSession(ctx, "device:d1"))
+		_, err = s.LoadSession(ctx, "device:d1")
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.NoError(t, s.DeleteSession(ctx, "device:d1"), "deleting a missing session is not an error")
+	})
+
+	t.Run("merge takes the device session", func(t *testing.T) {
+		s := b.open(t)
+		device := json.RawMessage(`{"id": "device:d1", "variables": {"destination": "Lisbon"}}`)
+		user := json.RawMessage(`{"id": "user:u1", "variables": {"destination": "Porto"}}`)
+		require.NoError(t, s.SaveSession(ctx, &SessionRecord{ID: "device:d1", Data: device}))
+		require.NoError(t, s.SaveSession(ctx, &SessionRecord{ID: "user:u1", Data: user}))
+
+		res, err := s.MergeDeviceIntoUser(ctx, "d1", "device:d1", "user:u1")
+		require.NoError(t, err)
+		require.NotNil(t, res.DeviceSession)
+		assert.Equal(t, "device:d1", res.DeviceSession.ID)
+		assert.JSONEq(t, string(device), string(res.DeviceSession.Data))
+		assert.False(t, res.DeviceSession.UpdatedAt.IsZero())
+
+		_, err = s.LoadSession(ctx, "device:d1")
+		assert.ErrorIs(t, err, ErrNotFound, "the device row must not be orphaned")
+		rec, err := s.LoadSession(ctx, "user:u1")
+		require.NoError(t, err)
+		assert.JSONEq(t, string(user), string(rec.Data), "the user's row is left for the session manager to merge into")
+
+		res, err = s.MergeDeviceIntoUser(ctx, "d1", "device:d1", "user:u1")
+		require.NoError(t, err)
+		assert.Nil(t, res.DeviceSession)
+	})
+
+	t.Run("runs", func(t *testing.T) {
+		s := b.open(t)
+		assert.Error(t, s.StartRun(ctx, &AgentRun{OwnerID: "u1"}), "a run needs an id")
+
+		first := &AgentRun{ID: "run-1", OwnerID: "u1", Agent: "trip_guardian", Source: "chat"}
+		require.NoError(t, s.StartRun(ctx, first))
+		assert.Equal(t, RunStatusRunning, first.Status)
+		assert.False(t, first.StartedAt.IsZero())
+		second := &AgentRun{ID: "run-2", OwnerID: "u1", Agent: "trip_guardian", Source: "chat", TripID: "trip-1"}
+		require.NoError(t, s.StartRun(ctx, second))
+		require.NoError(t, s.StartRun(ctx, &AgentRun{ID: "run-3", OwnerID: "system_broadcast", Agent: "trip_guardian", Source: "schedule"}))
+
+		first.Status, first.Error, first.TotalTokens = RunStatusFailed, "exit status 1", 42
+		require.NoError(t, s.FinishRun(ctx, first))
+		assert.NotNil(t, first.FinishedAt)
+		assert.ErrorIs(t, s.FinishRun(ctx, &AgentRun{ID: "nope", Status: RunStatusSucceeded}), ErrNotFound)
+
+		runs, err := s.ListRuns(ctx, "u1", 10)
+		require.NoError(t, err)
+		require.Len(t, runs, 2)
+		assert.Equal(t, "run-2", runs[0].ID, "most recent first")
+		assert.Equal(t, "trip-1", runs[0].TripID)
+		assert.Equal(t, RunStatusRunning, runs[0].Status)
+		assert.Nil(t, runs[0].FinishedAt)
+		assert.Equal(t, RunStatusFailed, runs[1].Status)
+		assert.Equal(t, "exit status 1", runs[1].Error)
+		assert.Equal(t, 42, runs[1].TotalTokens)
+		assert.NotNil(t, runs[1].FinishedAt)
+
+		runs, err = s.ListRuns(ctx, "u1", 1)
+		require.NoError(t, err)
+		assert.Len(t, runs, 1)
+	})
+
+	t.Run("trips", func(t *testing.T) {
+		s := b.open(t)
+		lisbon := &TripRecord{OwnerID: "alice", Data: json.RawMessage(`{"destination":{"name":"Lisbon"}}`)}
+		require.NoError(t, s.CreateTrip(ctx, lisbon))
+		assert.NotEmpty(t, lisbon.ID)
+		assert.False(t, lisbon.CreatedAt.IsZero())
+		time.Sleep(10 * time.Millisecond)
+		porto := &TripRecord{OwnerID: "alice", Data: json.RawMessage(`{"destination":{"name":"Porto"}}`)}
+		require.NoError(t, s.CreateTrip(ctx, porto))
+
+		got, err := s.LoadTrip(ctx, "alice", lisbon.ID)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"destination":{"name":"Lisbon"}}`, string(got.Data))
+		_, err = s.LoadTrip(ctx, "bob", lisbon.ID)
+		assert.ErrorIs(t, err, ErrNotFound, "another owner's trip")
+		_, err = s.LoadTrip(ctx, "alice", "not-a-trip")
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		time.Sleep(10 * time.Millisecond)
+		lisbon.Data = json.RawMessage(`{"destination":{"name":"Lisbon"},"travellers":2}`)
+		require.NoError(t, s.UpdateTrip(ctx, lisbon))
+		assert.True(t, lisbon.UpdatedAt.After(lisbon.CreatedAt))
+		assert.ErrorIs(t, s.UpdateTrip(ctx, &TripRecord{ID: lisbon.ID, OwnerID: "bob", Data: lisbon.Data}), ErrNotFound)
+
+		trips, err := s.ListTrips(ctx, "alice", 10)
+		require.NoError(t, err)
+		require.Len(t, trips, 2)
+		assert.Equal(t, lisbon.ID, trips[0].ID, "most recently updated first")
+		assert.JSONEq(t, `{"destination":{"name":"Lisbon"},"travellers":2}`, string(trips[0].Data))
+		trips, err = s.ListTrips(ctx, "alice", 1)
+		require.NoError(t, err)
+		assert.Len(t, trips, 1)
+
+		// Cards of a trip are filtered by it and deleted with it
+		tripCard := card("Weather", "sunny")
+		tripCard.TripID = lisbon.ID
+		require.NoError(t, s.UpsertCard(ctx, "alice", tripCard))
+		require.NoError(t, s.UpsertCard(ctx, "alice", card("Weather", "cloudy")))
+		page, err := s.GetFeed(ctx, "alice", FeedQuery{Limit: 10, TripIDs: []string{lisbon.ID}})
+		require.NoError(t, err)
+		require.Len(t, page.Cards, 1, "outside a run, a trip's card does not share the owner's window")
+		assert.Equal(t, lisbon.ID, page.Cards[0].TripID)
+		assert.Equal(t, "sunny", page.Cards[0].Data["text"])
+
+		require.NoError(t, s.DeleteTrip(ctx, "alice", lisbon.ID))
+		assert.ErrorIs(t, s.DeleteTrip(ctx, "alice", lisbon.ID), ErrNotFound)
+		_, err = s.LoadTrip(ctx, "alice", lisbon.ID)
+		assert.ErrorIs(t, err, ErrNotFound)
+		cards, err := feedOf(s, "alice", 10)
+		require.NoError(t, err)
+		require.Len(t, cards, 1)
+		assert.Equal(t, "cloudy", cards[0].Data["text"])
+
+		// Trips follow a device's cards to the user it links to
+		_, err = s.MergeDeviceIntoUser(ctx, "d1", "alice", "user:alice")
+		require.NoError(t, err)
+		_, err = s.LoadTrip(ctx, "user:alice", porto.ID)
+		assert.NoError(t, err)
+	})
+
+	t.Run("ping", func(t *testing.T) {
+		assert.NoError(t, b.open(t).Ping(ctx))
+	})
+}
+
+func TestSQLiteStore_Reopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "feed.db")
+	kr, err := ParseKeyring("v1:" + testKEK(1))
+	require.NoError(t, err)
+
+	s, err := NewSQLiteStore(ctx, path)
+	require.NoError(t, err)
+	assert.ErrorIs(t, s.StoreUserLiteLLMKey(ctx, "u1", "sk-legacy", KeyName("u1"), 10), ErrNoKeyring, "no silent plaintext")
+	s.AllowPlaintextKeys = true
+	require.NoError(t, s.StoreUserLiteLLMKey(ctx, "u1", "sk-legacy", KeyName("u1"), 10))
+	s.Keyring = kr
+	require.NoError(t, s.StoreUserLiteLLMKey(ctx, "u2", "sk-sealed", KeyName("u2"), 10))
+	require.NoError(t, s.Close())
+
+	// Schema is not re-applied and keys survive; legacy plaintext rows are sealed on demand
+	s, err = NewSQLiteStore(ctx, path)
+	require.NoError(t, err)
+	defer s.Close()
+	s.Keyring = kr
+	// A row sealed under a KEK that is gone, and one with no key at all
+	_, err = s.DB.Exec(`INSERT INTO user_litellm_keys (user_id, litellm_key_ciphertext, kek_id, key_name, created_at) VALUES ('u3', 'bogus', 'v0', 'user_u3', 1)`)
+	require.NoError(t, err)
+	_, err = s.DB.Exec(`INSERT INTO user_litellm_keys (user_id, key_name, created_at) VALUES ('u4', 'user_u4', 1)`)
+	require.NoError(t, err)
+	n, failed, err := s.ReencryptLiteLLMKeys(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n, "the failing row does not stop the pass")
+	assert.Equal(t, 1, failed, "rows without a key are not candidates")
+
+	var plaintext sql.NullString
+	require.NoError(t, s.DB.QueryRow(`SELECT litellm_key FROM user_litellm_keys WHERE user_id = 'u1'`).Scan(&plaintext))
+	assert.False(t, plaintext.Valid, "no plaintext left at rest")
+	key, err := s.GetUserLiteLLMKey(ctx, "u1")
+	require.NoError(t, err)
+	assert.Equal(t, "sk-legacy", key)
+
+	n, _, err = s.ReencryptLiteLLMKeys(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, n, "re-encryption is idempotent")
+}
+
+func TestSQLiteStore_UpgradeKeepsCards(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "feed.db")
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	_, err = db.Exec(sqliteSchema[0] + `PRAGMA user_version = 1;`)
+	require.NoError(t, err)
+	// Two versions of one node's card, as update-then-insert could leave behind
+	for _, id := range []string{"c1", "c2"} {
+		_, err = db.Exec(`INSERT INTO cards (id, owner_id, card_type, priority, source_node, content, created_at, updated_at)
+			VALUES (?, 'alice', 'article', 'normal', 'Weather', '{}', 1, 1)`, id)
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.Close())
+
+	s, err := NewSQLiteStore(ctx, path)
+	require.NoError(t, err)
+	defer s.Close()
+	require.NoError(t, s.UpsertCard(ctx, "alice", &Card{CardType: "article", Priority: "normal", SourceNode: "Weather"}))
+	page, err := s.GetFeed(ctx, "alice", FeedQuery{Limit: 10})
+	require.NoError(t, err)
+	assert.Len(t, page.Cards, 3, "legacy cards are kept and never absorb new updates")
+}
@@ -0,0 +1,194 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ErrUnknownKEK is returned when a row was sealed with a KEK that is not in the keyring
+var ErrUnknownKEK = errors.New("unknown key-encryption key id")
+
+// ErrNoKeyring is returned when a key would be stored without a keyring and
+// plaintext keys are not allowed
+var ErrNoKeyring = errors.New("no keyring configured to encrypt LiteLLM keys")
+
+// Keyring holds the key-encryption keys (KEKs) used for envelope encryption of
+// LiteLLM keys. New values are always sealed with the primary KEK; older KEKs
+// are kept only to open rows until they are re-encrypted.
+type Keyring struct {
+	primary string
+	keks    map[string][]byte
+}
+
+// NewKeyring creates a keyring. Every KEK must be 32 bytes (AES-256).
+func NewKeyring(primaryID string, keks map[string][]byte) (*Keyring, error) {
+	if primaryID == "" {
+		return nil, fmt.Errorf("keyring: primary KEK id is required")
+	}
+	if _, ok := keks[primaryID]; !ok {
+		return nil, fmt.Errorf("keyring: primary KEK %q not found", primaryID)
+	}
+	copied := make(map[string][]byte, len(keks))
+	for id, k := range keks {
+		if id == "" || strings.ContainsAny(id, ":,. \n") {
+			return nil, fmt.Errorf("keyring: invalid KEK id %q", id)
+		}
+		if len(k) != 32 {
+			return nil, fmt.Errorf("keyring: KEK %q must be 32 bytes, got %d", id, len(k))
+		}
+		copied[id] = append([]byte(nil), k...)
+	}
+	return &Keyring{primary: primaryID, keks: copied}, nil
+}
+
+// ParseKeyring parses "id:base64key" entries separated by commas or newlines.
+// The first entry is the primary KEK; blank lines and "#" comments are ignored.
+func ParseKeyring(spec string) (*Keyring, error) {
+	keks := make(map[string][]byte)
+	primary := ""
+	for _, entry := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == '\n' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("keyring: entry must be id:base64key")
+		}
+		id := strings.TrimSpace(parts[0])
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("keyring: KEK %q is not valid base64: %w", id, err)
+		}
+		if _, dup := keks[id]; dup {
+			return nil, fmt.Errorf("keyring: duplicate KEK id %q", id)
+		}
+		keks[id] = key
+		if primary == "" {
+			primary = id
+		}
+	}
+	if primary == "" {
+		return nil, fmt.Errorf("keyring: no KEKs configured")
+	}
+	return NewKeyring(primary, keks)
+}
+
+// LoadKeyring parses spec, or the file at path when spec is empty. It returns
+// nil (and no error) when both are empty.
+func LoadKeyring(spec, path string) (*Keyring, error) {
+	if spec != "" {
+		return ParseKeyring(spec)
+	}
+	if path != "" {
+		data, err := os.ReadFile(path) // #nosec G304 -- operator-provided path
+		if err != nil {
+			return nil, fmt.Errorf("keyring: failed to read %s: %w", path, err)
+		}
+		return ParseKeyring(string(data))
+	}
+	return nil, nil
+}
+
+// PrimaryID returns the id of the KEK used for new ciphertexts
+func (k *Keyring) PrimaryID() string {
+	return k.primary
+}
+
+// Seal encrypts plaintext with a fresh data key, wraps the data key with the
+// primary KEK and returns the KEK id and the encoded envelope
+// ("base64(nonce|wrapped DEK).base64(nonce|ciphertext)").
+func (k *Keyring) Seal(plaintext string) (string, string, error) {
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return "", "", fmt.Errorf("failed to generate data key: %w", err)
+	}
+	wrapped, err := gcmSeal(k.keks[k.primary], dek, []byte("dek:"+k.primary))
+	if err != nil {
+		return "", "", err
+	}
+	data, err := gcmSeal(dek, []byte(plaintext), []byte("litellm_key"))
+	if err != nil {
+		return "", "", err
+	}
+	enc := base64.StdEncoding
+	return k.primary, enc.EncodeToString(wrapped) + "." + enc.EncodeToString(data), nil
+}
+
+// Open reverses Seal
+func (k *Keyring) Open(kekID, envelope string) (string, error) {
+	kek, ok := k.keks[kekID]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownKEK, kekID)
+	}
+	parts := strings.SplitN(envelope, ".", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("malformed key envelope")
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", fmt.Errorf("malformed key envelope: %w", err)
+	}
+	data, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("malformed key envelope: %w", err)
+	}
+
+	dek, err := gcmOpen(kek, wrapped, []byte("dek:"+kekID))
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	plaintext, err := gcmOpen(dek, data, []byte("litellm_key"))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt key: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// KeyLookupHash returns the indexable hash of a LiteLLM key. Virtual keys are
+// high-entropy random tokens, so an unsalted SHA-256 is not reversible and stays
+// stable across KEK rotations.
+func KeyLookupHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func gcmSeal(key, plaintext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func gcmOpen(key, sealed, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ct := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ct, aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
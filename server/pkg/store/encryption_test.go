@@ -0,0 +1,149 @@
+package store
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testKEK(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+func TestKeyring_SealOpen(t *testing.T) {
+	kr, err := ParseKeyring("v1:" + testKEK(1))
+	require.NoError(t, err)
+
+	kekID, envelope, err := kr.Seal("sk-secret")
+	require.NoError(t, err)
+	assert.Equal(t, "v1", kekID)
+	assert.NotContains(t, envelope, "sk-secret")
+
+	// Fresh data key and nonces every time
+	_, envelope2, err := kr.Seal("sk-secret")
+	require.NoError(t, err)
+	assert.NotEqual(t, envelope, envelope2)
+
+	plain, err := kr.Open(kekID, envelope)
+	require.NoError(t, err)
+	assert.Equal(t, "sk-secret", plain)
+}
+
+func TestKeyring_Rotation(t *testing.T) {
+	old, err := ParseKeyring("v1:" + testKEK(1))
+	require.NoError(t, err)
+	kekID, envelope, err := old.Seal("sk-secret")
+	require.NoError(t, err)
+
+	// v2 is primary, v1 kept for reading
+	rotated, err := ParseKeyring("v2:" + testKEK(2) + "\nv1:" + testKEK(1))
+	require.NoError(t, err)
+	assert.Equal(t, "v2", rotated.PrimaryID())
+
+	plain, err := rotated.Open(kekID, envelope)
+	require.NoError(t, err)
+	assert.Equal(t, "sk-secret", plain)
+
+	newID, _, err := rotated.Seal("sk-secret")
+	require.NoError(t, err)
+	assert.Equal(t, "v2", newID)
+
+	// Once v1 is dropped, old rows can no longer be opened
+	dropped, err := ParseKeyring("v2:" + testKEK(2))
+	require.NoError(t, err)
+	_, err = dropped.Open(kekID, envelope)
+	assert.ErrorIs(t, err, ErrUnknownKEK)
+}
+
+func TestKeyring_Tamper(t *testing.T) {
+	kr, err := ParseKeyring("v1:" + testKEK(1))
+	require.NoError(t, err)
+	_, envelope, err := kr.Seal("sk-secret")
+	require.NoError(t, err)
+
+	parts := strings.SplitN(envelope, ".", 2)
+	data, _ := base64.StdEncoding.DecodeString(parts[1])
+	data[len(data)-1] ^= 0xff
+	tampered := parts[0] + "." + base64.StdEncoding.EncodeToString(data)
+
+	_, err = kr.Open("v1", tampered)
+	assert.Error(t, err)
+	_, err = kr.Open("v1", "garbage")
+	assert.Error(t, err)
+}
+
+func TestParseKeyring_Invalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"# only a comment",
+		"v1",
+		"v1:not-base64!!",
+		"v1:" + base64.StdEncoding.EncodeToString([]byte("short")),
+		"v1:" + testKEK(1) + ",v1:" + testKEK(2),
+	} {
+		_, err := ParseKeyring(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	kr, err := LoadKeyring("", "")
+	assert.NoError(t, err)
+	assert.Nil(t, kr)
+
+	path := filepath.Join(t.TempDir(), "keyring")
+	require.NoError(t, os.WriteFile(path, []byte("# primary first\nv3:"+testKEK(3)+"\n"), 0o600))
+	kr, err = LoadKeyring("", path)
+	require.NoError(t, err)
+	assert.Equal(t, "v3", kr.PrimaryID())
+
+	kr, err = LoadKeyring("v4:"+testKEK(4), path)
+	require.NoError(t, err)
+	assert.Equal(t, "v4", kr.PrimaryID(), "the inline spec wins")
+}
+
+func TestStoreSealOpenKey(t *testing.T) {
+	// Without a keyring, plaintext needs an explicit opt-in
+	_, _, _, err := sealKey(nil, false, "sk-1")
+	assert.ErrorIs(t, err, ErrNoKeyring)
+	plain, sealed, kekID, err := sealKey(nil, true, "sk-1")
+	require.NoError(t, err)
+	assert.Equal(t, sql.NullString{String: "sk-1", Valid: true}, plain)
+	assert.False(t, sealed.Valid)
+	key, err := openKey(nil, plain, sealed, kekID)
+	require.NoError(t, err)
+	assert.Equal(t, "sk-1", key)
+
+	// Encrypted mode never writes plaintext, and still reads legacy plaintext rows
+	kr, err := ParseKeyring("v1:" + testKEK(1))
+	require.NoError(t, err)
+	plain, sealed, kekID, err = sealKey(kr, false, "sk-2")
+	require.NoError(t, err)
+	assert.False(t, plain.Valid)
+	assert.Equal(t, "v1", kekID.String)
+	key, err = openKey(kr, plain, sealed, kekID)
+	require.NoError(t, err)
+	assert.Equal(t, "sk-2", key)
+
+	key, err = openKey(kr, sql.NullString{String: "sk-legacy", Valid: true}, sql.NullString{}, sql.NullString{})
+	require.NoError(t, err)
+	assert.Equal(t, "sk-legacy", key)
+
+	// Ciphertext without keyring is an error, not a silent empty key
+	_, err = openKey(nil, plain, sealed, kekID)
+	assert.Error(t, err)
+}
+
+func TestKeyLookupHash(t *testing.T) {
+	h := KeyLookupHash("sk-1")
+	assert.Len(t, h, 64)
+	assert.Equal(t, h, KeyLookupHash("sk-1"))
+	assert.NotEqual(t, h, KeyLookupHash("sk-2"))
+}
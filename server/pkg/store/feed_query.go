@@ -0,0 +1,191 @@
+package store
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCursor reports a FeedQuery.Cursor that was not issued by GetFeed
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// FeedQuery selects a page of an owner's feed, pinned then newest first.
+// Empty filters match every card; several values in one filter match any of
+// them.
+type FeedQuery struct {
+	Limit int
+	// Cursor continues after the last card of a previous page (FeedPage.NextCursor)
+	Cursor string
+
+	CardTypes   []string
+	Priorities  []string
+	SourceNodes []string
+	TripIDs     []string
+	// From and To bound the cards' last update: From <= updated_at < To
+	From, To time.Time
+	// Since returns only cards updated after it, for incremental sync. Any
+	// change to a card, lifecycle patches included, counts as an update, and
+	// cards dismissed since then are returned too (with DismissedAt set) so
+	// the client can drop them.
+	Since time.Time
+}
+
+// FeedPage is one page of a feed
+type FeedPage struct {
+	Cards []*Card
+	// NextCursor fetches the following (older) page; empty on the last page
+	NextCursor string
+}
+
+// Pages are ordered by (pinned, updated_at, id), pinned and newest first. A
+// card updated while a client pages moves to the front, so it may be skipped
+// by that traversal; Since catches it on the next sync.
+func encodeCursor(pinned bool, updatedAt time.Time, id string) string {
+	raw := fmt.Sprintf("%t|%d|%s", pinned, updatedAt.UnixNano(), id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (pinned bool, updatedAt time.Time, id string, err error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return false, time.Time{}, "", ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(raw), "|", 3)
+	// Card IDs are UUIDs in every store; Postgres would fail comparing anything else
+	if len(parts) != 3 || !isUUID(parts[2]) {
+		return false, time.Time{}, "", ErrInvalidCursor
+	}
+	pinned, err = strconv.ParseBool(parts[0])
+	if err != nil {
+		return false, time.Time{}, "", ErrInvalidCursor
+	}
+	n, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return false, time.Time{}, "", ErrInvalidCursor
+	}
+	return pinned, time.Unix(0, n).UTC(), parts[2], nil
+}
+
+// feedWhere builds the WHERE clause of a feed query for the SQL stores,
+// hiding cards that are dismissed (unless syncing since a time) or expired at
+// now. placeholder renders the nth bind parameter and ts converts times to the
+// store's column representation.
+func feedWhere(ownerID string, q FeedQuery, now time.Time, placeholder func(n int) string, ts func(time.Time) interface{}) (string, []interface{}, error) {
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return placeholder(len(args))
+	}
+	in := func(column string, values []string) string {
+		ps := make([]string, len(values))
+		for i, v := range values {
+			ps[i] = arg(v)
+		}
+		return fmt.Sprintf("%s IN (%s)", column, strings.Join(ps, ", "))
+	}
+
+	conds := []string{"owner_id = " + arg(ownerID)}
+	live := "pinned OR expires_at IS NULL OR expires_at > " + arg(ts(now))
+	if q.Since.IsZero() {
+		conds = append(conds, "dismissed_at IS NULL", "("+live+")")
+	} else {
+		// Dismissed cards are the sync's tombstones
+		conds = append(conds, "(dismissed_at IS NOT NULL OR "+live+")")
+	}
+	if len(q.CardTypes) > 0 {
+		conds = append(conds, in("card_type", q.CardTypes))
+	}
+	if len(q.Priorities) > 0 {
+		conds = append(conds, in("priority", q.Priorities))
+	}
+	if len(q.SourceNodes) > 0 {
+		conds = append(conds, in("source_node", q.SourceNodes))
+	}
+	if len(q.TripIDs) > 0 {
+		conds = append(conds, in("trip_id", q.TripIDs))
+	}
+	if !q.From.IsZero() {
+		conds = append(conds, "updated_at >= "+arg(ts(q.From)))
+	}
+	if !q.To.IsZero() {
+		conds = append(conds, "updated_at < "+arg(ts(q.To)))
+	}
+	if !q.Since.IsZero() {
+		conds = append(conds, "updated_at > "+arg(ts(q.Since)))
+	}
+	if q.Cursor != "" {
+		pinned, at, id, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return "", nil, err
+		}
+		older := fmt.Sprintf("(updated_at < %s OR (updated_at = %s AND id < %s))", arg(ts(at)), arg(ts(at)), arg(id))
+		if pinned {
+			conds = append(conds, "(NOT pinned OR "+older+")")
+		} else {
+			conds = append(conds, "NOT pinned AND "+older)
+		}
+	}
+	return strings.Join(conds, " AND "), args, nil
+}
+
+// pageOf trims cards fetched with limit+1 rows to one page
+func pageOf(cards []*Card, limit int) *FeedPage {
+	page := &FeedPage{Cards: cards}
+	if len(cards) > limit {
+		page.Cards = cards[:limit]
+		last := page.Cards[limit-1]
+		page.NextCursor = encodeCursor(last.Pinned, last.updatedAt, last.ID)
+	}
+	return page
+}
+
+// cardColumns are the columns scanCard and scanSQLiteCard read
+const cardColumns = "id, run_id, trip_id, card_type, priority, source_node, content, updated_at, pinned, read_at, dismissed_at, expires_at"
+
+// errBadContent reports a card whose content is not valid JSON
+var errBadContent = errors.New("bad card content")
+
+// cardPatchSet builds the SET clause of UpdateCard for the SQL stores, with
+// the same placeholder and ts conventions as feedWhere. A non-empty patch
+// bumps updated_at so that incremental sync (FeedQuery.Since) sees it.
+func cardPatchSet(p CardPatch, now time.Time, placeholder func(n int) string, ts func(time.Time) interface{}) (string, []interface{}) {
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return placeholder(len(args))
+	}
+	stamp := func(column string, set bool) string {
+		if !set {
+			return column + " = NULL"
+		}
+		return fmt.Sprintf("%s = COALESCE(%s, %s)", column, column, arg(ts(now)))
+	}
+
+	var sets []string
+	if p.Read != nil {
+		sets = append(sets, stamp("read_at", *p.Read))
+	}
+	if p.Dismissed != nil {
+		sets = append(sets, stamp("dismissed_at", *p.Dismissed))
+	}
+	if p.Pinned != nil {
+		sets = append(sets, "pinned = "+arg(*p.Pinned))
+	}
+	if p.ExpiresAt != nil {
+		if p.ExpiresAt.IsZero() {
+			sets = append(sets, "expires_at = NULL")
+		} else {
+			sets = append(sets, "expires_at = "+arg(ts(*p.ExpiresAt)))
+		}
+	}
+	if len(sets) == 0 {
+		// Nothing to change; the update still returns the card
+		sets = append(sets, "id = id")
+	} else {
+		sets = append(sets, "updated_at = "+arg(ts(now)))
+	}
+	return strings.Join(sets, ", "), args
+}
@@ -0,0 +1,113 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// MergeResult describes what MergeDeviceIntoUser did
+type MergeResult struct {
+	FirstLink    bool `json:"first_link"`    // The device had never been linked to this user
+	CardsMoved   int  `json:"cards_moved"`   // Device cards reassigned to the user
+	CardsDropped int  `json:"cards_dropped"` // Cards discarded because a newer sticky card replaced them
+	// DeviceSession is the device's stored session, removed by the merge for
+	// the caller to merge into the user's; nil when there was none
+	DeviceSession *SessionRecord `json:"-"`
+}
+
+// MergeDeviceIntoUser links deviceID to userID and moves every card and trip
+// owned by deviceOwner to userID in one transaction. The device's stored
+// session is deleted and returned in the result, since merging two sessions
+// is up to the session manager.
+//
+// Conflict rule: when the device and the user both hold a card with the same
+// source node and sticky key, they are two versions of the same sticky card,
+// so only the most recently updated one is kept (the user's on a tie).
+func (s *PostgresStore) MergeDeviceIntoUser(ctx context.Context, deviceID, deviceOwner, userID string) (_ *MergeResult, err error) {
+	ctx, finish := startQuery(ctx, "merge_device_into_user")
+	defer finish(&err)
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin merge: %w", err)
+	}
+	defer tx.Rollback() // No-op after commit
+
+	// Serialize merges (and concurrent first requests) per user
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, "identity:"+userID); err != nil {
+		return nil, fmt.Errorf("failed to lock user for merge: %w", err)
+	}
+
+	res := &MergeResult{}
+
+	// Device cards superseded by a newer (or equally new) user card
+	dropped, err := execCount(ctx, tx, `
+		DELETE FROM cards d USING cards u
+		WHERE d.owner_id = $1 AND u.owner_id = $2
+		AND u.source_node = d.source_node AND u.sticky_key = d.sticky_key
+		AND u.updated_at >= d.updated_at
+	`, deviceOwner, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve device card conflicts: %w", err)
+	}
+	res.CardsDropped += dropped
+
+	// User cards superseded by a newer device card
+	dropped, err = execCount(ctx, tx, `
+		DELETE FROM cards u USING cards d
+		WHERE u.owner_id = $1 AND d.owner_id = $2
+		AND d.source_node = u.source_node AND d.sticky_key = u.sticky_key
+		AND d.updated_at > u.updated_at
+	`, userID, deviceOwner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve user card conflicts: %w", err)
+	}
+	res.CardsDropped += dropped
+
+	res.CardsMoved, err = execCount(ctx, tx, `UPDATE cards SET owner_id = $1 WHERE owner_id = $2`, userID, deviceOwner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to move device cards: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE trips SET owner_id = $1 WHERE owner_id = $2`, userID, deviceOwner); err != nil {
+		return nil, fmt.Errorf("failed to move device trips: %w", err)
+	}
+	dev := &SessionRecord{ID: deviceOwner}
+	err = tx.QueryRowContext(ctx, `DELETE FROM sessions WHERE id = $1 RETURNING data, updated_at`, deviceOwner).Scan(&dev.Data, &dev.UpdatedAt)
+	switch {
+	case err == nil:
+		res.DeviceSession = dev
+	case errors.Is(err, sql.ErrNoRows):
+	default:
+		return nil, fmt.Errorf("failed to take device session: %w", err)
+	}
+
+	var inserted bool
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO device_user_links (device_id, user_id, cards_moved, cards_dropped)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (device_id, user_id) DO UPDATE
+		SET last_merged_at = NOW(),
+			cards_moved = device_user_links.cards_moved + EXCLUDED.cards_moved,
+			cards_dropped = device_user_links.cards_dropped + EXCLUDED.cards_dropped
+		RETURNING (xmax = 0)
+	`, deviceID, userID, res.CardsMoved, res.CardsDropped).Scan(&inserted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record device link: %w", err)
+	}
+	res.FirstLink = inserted
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit merge: %w", err)
+	}
+	return res, nil
+}
+
+func execCount(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) (int, error) {
+	res, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,362 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrKeyNotFound is returned when the LiteLLM proxy does not know a key
+var ErrKeyNotFound = errors.New("litellm key not found on proxy")
+
+// ErrNoUserKey is returned when a user has no key record in the database
+var ErrNoUserKey = errors.New("user has no litellm key")
+
+// ErrKeyRevoked is returned when the user's key was revoked by an admin.
+// No new key is provisioned until an admin rotates it.
+var ErrKeyRevoked = errors.New("user litellm key revoked")
+
+// KeyBudget is the live spend/budget state of a key as reported by /key/info
+type KeyBudget struct {
+	Spend         float64    `json:"spend"`
+	MaxBudget     float64    `json:"max_budget"`
+	Remaining     float64    `json:"remaining"`
+	BudgetResetAt *time.Time `json:"budget_reset_at,omitempty"`
+}
+
+// ReconcileReport summarizes a reconciliation pass
+type ReconcileReport struct {
+	Checked int      `json:"checked"`
+	Missing []string `json:"missing"` // user IDs whose keys no longer exist on the proxy
+	Errors  int      `json:"errors"`
+}
+
+// KeyManager manages the lifecycle of per-user LiteLLM virtual keys,
+// keeping user_litellm_keys in sync with the proxy
+type KeyManager struct {
+	Store      KeyStore
+	ProxyURL   string
+	MasterKey  string
+	HTTPClient *http.Client
+}
+
+// NewKeyManager creates a key manager for the given proxy
+func NewKeyManager(s KeyStore, proxyURL, masterKey string) *KeyManager {
+	return &KeyManager{
+		Store:      s,
+		ProxyURL:   strings.TrimRight(proxyURL, "/"),
+		MasterKey:  masterKey,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Rotate regenerates the user's key on the proxy and stores the new value.
+// A revoked or missing key has nothing left to regenerate, so a new one is
+// generated instead; this is the only way to lift a revocation.
+func (m *KeyManager) Rotate(ctx context.Context, userID string) (*UserLiteLLMKey, error) {
+	rec, err := m.Store.GetUserLiteLLMKeyRecord(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		return nil, ErrNoUserKey
+	}
+
+	var newKey string
+	if rec.Status == KeyStatusActive {
+		newKey, err = m.RegenerateKey(ctx, rec.LiteLLMKey)
+	} else {
+		newKey, _, err = m.GenerateKey(ctx, userID, rec.MaxBudget)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.Store.UpdateUserLiteLLMKeyValue(ctx, userID, newKey); err != nil {
+		return nil, err
+	}
+	return m.Store.GetUserLiteLLMKeyRecord(ctx, userID)
+}
+
+// Revoke deletes the user's key on the proxy and marks it revoked.
+// A key already gone from the proxy is still marked revoked.
+func (m *KeyManager) Revoke(ctx context.Context, userID string) error {
+	rec, err := m.Store.GetUserLiteLLMKeyRecord(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if rec == nil {
+		return ErrNoUserKey
+	}
+
+	if rec.Status != KeyStatusRevoked {
+		if err := m.DeleteKey(ctx, rec.LiteLLMKey); err != nil && !errors.Is(err, ErrKeyNotFound) {
+			return err
+		}
+	}
+
+	return m.Store.SetUserLiteLLMKeyStatus(ctx, userID, KeyStatusRevoked)
+}
+
+// UpdateBudget changes the user's max budget on the proxy and in the database
+func (m *KeyManager) UpdateBudget(ctx context.Context, userID string, maxBudget float64) error {
+	if maxBudget < 0 {
+		return fmt.Errorf("max budget must not be negative")
+	}
+	rec, err := m.activeKey(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := m.UpdateKeyBudget(ctx, rec.LiteLLMKey, maxBudget); err != nil {
+		return err
+	}
+
+	return m.Store.SetUserLiteLLMKeyBudget(ctx, userID, maxBudget)
+}
+
+// Budget fetches the live spend and remaining budget of the user's key
+func (m *KeyManager) Budget(ctx context.Context, userID string) (*KeyBudget, error) {
+	rec, err := m.activeKey(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	budget, err := m.KeyInfo(ctx, rec.LiteLLMKey)
+	if errors.Is(err, ErrKeyNotFound) {
+		if serr := m.Store.SetUserLiteLLMKeyStatus(ctx, userID, KeyStatusMissing); serr != nil {
+			slog.WarnContext(ctx, "failed to mark key missing", "error", serr)
+		}
+	}
+	return budget, err
+}
+
+// Reconcile checks every active key against the proxy and marks keys that
+// were deleted on the proxy side as missing
+func (m *KeyManager) Reconcile(ctx context.Context) (*ReconcileReport, error) {
+	keys, err := m.Store.ListUserLiteLLMKeys(ctx, KeyStatusActive)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReconcileReport{Missing: []string{}}
+	for _, rec := range keys {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		report.Checked++
+
+		_, err := m.KeyInfo(ctx, rec.LiteLLMKey)
+		switch {
+		case errors.Is(err, ErrKeyNotFound):
+			report.Missing = append(report.Missing, rec.UserID)
+			if err := m.Store.SetUserLiteLLMKeyStatus(ctx, rec.UserID, KeyStatusMissing); err != nil {
+				report.Errors++
+				slog.WarnContext(ctx, "failed to mark key missing", "error", err)
+			}
+		case err != nil:
+			report.Errors++
+			slog.WarnContext(ctx, "key reconciliation failed", "user_id", rec.UserID, "error", err)
+		default:
+			if err := m.Store.MarkUserLiteLLMKeyReconciled(ctx, rec.UserID); err != nil {
+				report.Errors++
+				slog.WarnContext(ctx, "failed to mark key reconciled", "user_id", rec.UserID, "error", err)
+			}
+		}
+	}
+	return report, nil
+}
+
+// KeyName returns the key alias used for a user's virtual key
+func KeyName(userID string) string {
+	return fmt.Sprintf("user_%s", userID)
+}
+
+// GenerateKey calls /key/generate to mint a new virtual key for a user and
+// returns the key and its alias
+func (m *KeyManager) GenerateKey(ctx context.Context, userID string, maxBudget float64) (string, string, error) {
+	keyName := KeyName(userID)
+	payload := map[string]interface{}{
+		"user_id":         userID,
+		"key_alias":       keyName,
+		"max_budget":      maxBudget,
+		"budget_duration": "monthly",
+		"metadata": map[string]string{
+			"user_id": userID,
+		},
+	}
+
+	var result struct {
+		Key string `json:"key"`
+	}
+	if err := m.call(ctx, http.MethodPost, "/key/generate", payload, &result); err != nil {
+		return "", "", err
+	}
+	if result.Key == "" {
+		return "", "", fmt.Errorf("LiteLLM /key/generate returned no key")
+	}
+	return result.Key, keyName, nil
+}
+
+// ListUserKeyTokens calls /key/list and returns the hashed tokens of every key
+// the proxy holds for the user. LiteLLM identifies keys by the SHA-256 of the
+// key value, which matches KeyLookupHash.
+func (m *KeyManager) ListUserKeyTokens(ctx context.Context, userID string) ([]string, error) {
+	var result struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	path := "/key/list?return_full_object=true&user_id=" + url.QueryEscape(userID)
+	if err := m.call(ctx, http.MethodGet, path, nil, &result); err != nil {
+		return nil, err
+	}
+
+	tokens := make([]string, 0, len(result.Keys))
+	for _, raw := range result.Keys {
+		// Older proxies return bare token strings, newer ones full objects
+		var token string
+		if err := json.Unmarshal(raw, &token); err != nil {
+			var obj struct {
+				Token string `json:"token"`
+			}
+			if err := json.Unmarshal(raw, &obj); err != nil {
+				continue
+			}
+			token = obj.Token
+		}
+		if token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, nil
+}
+
+// RegenerateKey calls /key/regenerate and returns the new key value
+func (m *KeyManager) RegenerateKey(ctx context.Context, key string) (string, error) {
+	var result struct {
+		Key string `json:"key"`
+	}
+	if err := m.call(ctx, http.MethodPost, "/key/regenerate", map[string]string{"key": key}, &result); err != nil {
+		return "", err
+	}
+	if result.Key == "" {
+		return "", fmt.Errorf("LiteLLM /key/regenerate returned no key")
+	}
+	return result.Key, nil
+}
+
+// DeleteKey calls /key/delete for a single key
+func (m *KeyManager) DeleteKey(ctx context.Context, key string) error {
+	return m.call(ctx, http.MethodPost, "/key/delete", map[string][]string{"keys": {key}}, nil)
+}
+
+// UpdateKeyBudget calls /key/update to change a key's max budget
+func (m *KeyManager) UpdateKeyBudget(ctx context.Context, key string, maxBudget float64) error {
+	payload := map[string]interface{}{"key": key, "max_budget": maxBudget}
+	return m.call(ctx, http.MethodPost, "/key/update", payload, nil)
+}
+
+// KeyInfo calls /key/info and returns the key's spend and budget
+func (m *KeyManager) KeyInfo(ctx context.Context, key string) (*KeyBudget, error) {
+	var result struct {
+		Info struct {
+			Spend         float64    `json:"spend"`
+			MaxBudget     *float64   `json:"max_budget"`
+			BudgetResetAt *time.Time `json:"budget_reset_at"`
+		} `json:"info"`
+	}
+	if err := m.call(ctx, http.MethodGet, "/key/info?key="+url.QueryEscape(key), nil, &result); err != nil {
+		return nil, err
+	}
+
+	budget := &KeyBudget{Spend: result.Info.Spend, BudgetResetAt: result.Info.BudgetResetAt}
+	if result.Info.MaxBudget != nil {
+		budget.MaxBudget = *result.Info.MaxBudget
+		budget.Remaining = budget.MaxBudget - budget.Spend
+		if budget.Remaining < 0 {
+			budget.Remaining = 0
+		}
+	}
+	return budget, nil
+}
+
+func (m *KeyManager) activeKey(ctx context.Context, userID string) (*UserLiteLLMKey, error) {
+	rec, err := m.Store.GetUserLiteLLMKeyRecord(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil || rec.Status != KeyStatusActive {
+		return nil, ErrNoUserKey
+	}
+	return rec, nil
+}
+
+// call performs an authenticated request against the LiteLLM admin API
+func (m *KeyManager) call(ctx context.Context, method, path string, payload, out interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, m.ProxyURL+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+m.MasterKey)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := m.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call LiteLLM API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if keyNotFound(resp.StatusCode, respBody) {
+		return ErrKeyNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("LiteLLM API %s returned status %d", strings.SplitN(path, "?", 2)[0], resp.StatusCode)
+	}
+
+	if out != nil {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+	return nil
+}
+
+// keyNotFound reports whether a failed admin API response means the proxy does
+// not know the key: a 404, or the 400 LiteLLM answers with an error message
+// such as "Key not found in database". Other errors that mention something
+// not being found (a team, a route) are not about the key.
+func keyNotFound(status int, body []byte) bool {
+	if status == http.StatusNotFound {
+		return true
+	}
+	if status != http.StatusBadRequest {
+		return false
+	}
+	var e struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &e); err != nil {
+		return false
+	}
+	return strings.HasPrefix(strings.ToLower(e.Error.Message), "key not found")
+}
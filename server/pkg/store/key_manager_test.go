@@ -0,0 +1,120 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLiteLLM serves the subset of the LiteLLM key admin API used by KeyManager
+func fakeLiteLLM(t *testing.T, keys map[string]float64) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer master" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+
+		switch r.URL.Path {
+		case "/key/regenerate":
+			old, _ := body["key"].(string)
+			if _, ok := keys[old]; !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			keys["sk-new"] = keys[old]
+			delete(keys, old)
+			_ = json.NewEncoder(w).Encode(map[string]string{"key": "sk-new"})
+		case "/key/delete":
+			for _, k := range body["keys"].([]interface{}) {
+				delete(keys, k.(string))
+			}
+			_, _ = w.Write([]byte(`{"deleted_keys": []}`))
+		case "/key/update":
+			keys[body["key"].(string)] = body["max_budget"].(float64)
+			_, _ = w.Write([]byte(`{}`))
+		case "/key/info":
+			budget, ok := keys[r.URL.Query().Get("key")]
+			if !ok {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"error": {"message": "Key not found in database"}}`))
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"info": map[string]interface{}{"spend": 2.5, "max_budget": budget},
+			})
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+}
+
+func TestKeyManager_ProxyCalls(t *testing.T) {
+	keys := map[string]float64{"sk-old": 10}
+	srv := fakeLiteLLM(t, keys)
+	defer srv.Close()
+
+	m := NewKeyManager(nil, srv.URL+"/", "master")
+	ctx := context.Background()
+
+	newKey, err := m.RegenerateKey(ctx, "sk-old")
+	require.NoError(t, err)
+	assert.Equal(t, "sk-new", newKey)
+
+	require.NoError(t, m.UpdateKeyBudget(ctx, "sk-new", 25))
+	assert.Equal(t, 25.0, keys["sk-new"])
+
+	budget, err := m.KeyInfo(ctx, "sk-new")
+	require.NoError(t, err)
+	assert.Equal(t, 2.5, budget.Spend)
+	assert.Equal(t, 25.0, budget.MaxBudget)
+	assert.Equal(t, 22.5, budget.Remaining)
+
+	require.NoError(t, m.DeleteKey(ctx, "sk-new"))
+	_, err = m.KeyInfo(ctx, "sk-new")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	_, err = m.RegenerateKey(ctx, "sk-unknown")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func TestKeyManager_ProxyErrors(t *testing.T) {
+	srv := fakeLiteLLM(t, map[string]float64{})
+	defer srv.Close()
+
+	m := NewKeyManager(nil, srv.URL, "wrong-master")
+	err := m.UpdateKeyBudget(context.Background(), "sk-x", 5)
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrKeyNotFound)
+	assert.Contains(t, err.Error(), "401")
+
+	// Only a 404 or LiteLLM's key-not-found error means the key is gone
+	for _, tt := range []struct {
+		status  int
+		body    string
+		missing bool
+	}{
+		{http.StatusNotFound, ``, true},
+		{http.StatusBadRequest, `{"error": {"message": "Key not found in database"}}`, true},
+		{http.StatusInternalServerError, `{"error": {"message": "Team not found"}}`, false},
+		{http.StatusBadRequest, `{"error": {"message": "Route not found"}}`, false},
+		{http.StatusUnauthorized, `{"error": {"message": "Key not found in database"}}`, false},
+	} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			_, _ = w.Write([]byte(tt.body))
+		}))
+		_, err := NewKeyManager(nil, srv.URL, "master").KeyInfo(context.Background(), "sk-x")
+		srv.Close()
+		require.Error(t, err)
+		assert.Equal(t, tt.missing, errors.Is(err, ErrKeyNotFound), "%d %s", tt.status, tt.body)
+	}
+}
@@ -0,0 +1,724 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a FeedStore that keeps everything in process. It is meant for
+// tests and throwaway local development: nothing survives a restart, and keys
+// are held in plaintext since nothing is written to disk.
+type MemoryStore struct {
+	// Sticky decides which card UpsertCard updates
+	Sticky StickyPolicy
+
+	mu       sync.Mutex
+	now      func() time.Time
+	cards    []*memoryCard
+	keys     map[string]*UserLiteLLMKey
+	usage    []UsageEvent
+	links    map[[2]string]bool
+	sessions map[string]SessionRecord
+	runs     map[string]*AgentRun
+	trips    map[string]*TripRecord
+	keyLocks keyedMutex
+	// revisionSeq numbers card revisions, like the SQL stores' serial IDs
+	revisionSeq int64
+}
+
+type memoryCard struct {
+	card      Card
+	ownerID   string
+	stickyKey string
+	content   []byte
+	createdAt time.Time
+	updatedAt time.Time
+	revisions []*CardRevision // oldest first
+}
+
+// NewMemoryStore returns an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		now:      time.Now,
+		keys:     make(map[string]*UserLiteLLMKey),
+		links:    make(map[[2]string]bool),
+		sessions: make(map[string]SessionRecord),
+		runs:     make(map[string]*AgentRun),
+		trips:    make(map[string]*TripRecord),
+	}
+}
+
+// Ping always succeeds
+func (s *MemoryStore) Ping(ctx context.Context) error { return nil }
+
+// Close is a no-op
+func (s *MemoryStore) Close() error { return nil }
+
+// UpsertCard inserts a card or updates the owner's card with the same source
+// node and sticky key
+func (s *MemoryStore) UpsertCard(ctx context.Context, ownerID string, card *Card) error {
+	content, err := json.Marshal(card.Data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal content: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	key := s.Sticky.cardKey(card, now)
+
+	for _, c := range s.cards {
+		if c.ownerID == ownerID && c.stickyKey == key && c.card.SourceNode == card.SourceNode {
+			c.card.CardType, c.card.Priority, c.card.RunID, c.card.TripID = card.CardType, card.Priority, card.RunID, card.TripID
+			c.content, c.updatedAt = content, now
+			card.ID = c.card.ID
+			s.recordRevision(c, card, content, now)
+			return nil
+		}
+	}
+	card.ID = newUUID()
+	c := &memoryCard{
+		card:      Card{ID: card.ID, RunID: card.RunID, TripID: card.TripID, CardType: card.CardType, Priority: card.Priority, SourceNode: card.SourceNode},
+		ownerID:   ownerID,
+		stickyKey: key,
+		content:   content,
+		createdAt: now,
+		updatedAt: now,
+	}
+	s.cards = append(s.cards, c)
+	s.recordRevision(c, card, content, now)
+	return nil
+}
+
+// recordRevision is the in-memory version of recordRevision; s.mu must be held
+func (s *MemoryStore) recordRevision(c *memoryCard, card *Card, content []byte, now time.Time) {
+	var data map[string]interface{}
+	_ = json.Unmarshal(content, &data)
+	if n := len(c.revisions); n > 0 {
+		latest := c.revisions[n-1]
+		latestContent, _ := json.Marshal(latest.Data)
+		switch {
+		case latest.RunID == card.RunID:
+			latest.Data, latest.CardType, latest.Priority = data, card.CardType, card.Priority
+			latest.AgentVersion, latest.UpdatedAt = card.AgentVersion, now
+			return
+		case latest.CardType == card.CardType && latest.Priority == card.Priority && sameContent(latestContent, content):
+			return // The run left the card as it was
+		}
+	}
+	s.revisionSeq++
+	c.revisions = append(c.revisions, &CardRevision{
+		ID:           s.revisionSeq,
+		CardID:       c.card.ID,
+		RunID:        card.RunID,
+		AgentVersion: card.AgentVersion,
+		CardType:     card.CardType,
+		Priority:     card.Priority,
+		Data:         data,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	})
+	if len(c.revisions) > maxCardRevisions {
+		c.revisions = append([]*CardRevision(nil), c.revisions[len(c.revisions)-maxCardRevisions:]...)
+	}
+}
+
+// CardHistory returns up to limit revisions of one of the owner's cards, newest first
+func (s *MemoryStore) CardHistory(ctx context.Context, ownerID, cardID string, limit int) ([]*CardRevision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.cards {
+		if c.ownerID != ownerID || c.card.ID != cardID {
+			continue
+		}
+		revisions := []*CardRevision{}
+		for i := len(c.revisions) - 1; i >= 0 && len(revisions) < limit; i-- {
+			r := *c.revisions[i]
+			revisions = append(revisions, &r)
+		}
+		return revisions, nil
+	}
+	return nil, ErrNotFound
+}
+
+// GetFeed returns a page of the owner's visible cards, pinned then newest first
+func (s *MemoryStore) GetFeed(ctx context.Context, ownerID string, q FeedQuery) (*FeedPage, error) {
+	var after *memoryCard
+	if q.Cursor != "" {
+		pinned, at, id, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		after = &memoryCard{card: Card{ID: id, Pinned: pinned}, updatedAt: at}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+
+	var matched []*memoryCard
+	for _, c := range s.cards {
+		// Dismissed cards are the sync's tombstones
+		tombstone := !q.Since.IsZero() && c.card.DismissedAt != nil
+		if c.ownerID == ownerID && (c.visible(now) || tombstone) && c.matches(q) && (after == nil || newerCard(after, c)) {
+			matched = append(matched, c)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return newerCard(matched[i], matched[j]) })
+
+	feed := []*Card{}
+	for _, c := range matched {
+		if len(feed) > q.Limit {
+			break
+		}
+		card := c.card
+		if err := json.Unmarshal(c.content, &card.Data); err != nil {
+			continue
+		}
+		card.updatedAt = c.updatedAt
+		card.Timestamp = c.updatedAt.Format(time.RFC3339)
+		feed = append(feed, &card)
+	}
+	return pageOf(feed, q.Limit), nil
+}
+
+// visible reports whether the card is shown in the feed at now: neither
+// dismissed nor, unless pinned, expired
+func (c *memoryCard) visible(now time.Time) bool {
+	return c.card.DismissedAt == nil && (c.card.Pinned || c.card.ExpiresAt == nil || c.card.ExpiresAt.After(now))
+}
+
+func (c *memoryCard) matches(q FeedQuery) bool {
+	oneOf := func(v string, values []string) bool {
+		if len(values) == 0 {
+			return true
+		}
+		for _, want := range values {
+			if v == want {
+				return true
+			}
+		}
+		return false
+	}
+	return oneOf(c.card.CardType, q.CardTypes) && oneOf(c.card.Priority, q.Priorities) &&
+		oneOf(c.card.SourceNode, q.SourceNodes) && oneOf(c.card.TripID, q.TripIDs) &&
+		(q.From.IsZero() || !c.updatedAt.Before(q.From)) &&
+		(q.To.IsZero() || c.updatedAt.Before(q.To)) &&
+		(q.Since.IsZero() || c.updatedAt.After(q.Since))
+}
+
+// DeleteFeed removes all cards for the owner
+func (s *MemoryStore) DeleteFeed(ctx context.Context, ownerID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cards = s.removeCards(func(c *memoryCard) bool { return c.ownerID == ownerID })
+	return nil
+}
+
+// UpdateCard applies patch to one of the owner's cards and returns it,
+// bumping its update time unless the patch is empty
+func (s *MemoryStore) UpdateCard(ctx context.Context, ownerID, id string, patch CardPatch) (*Card, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.cards {
+		if c.ownerID != ownerID || c.card.ID != id {
+			continue
+		}
+		now := s.now()
+		stamp := func(t *time.Time, set bool) *time.Time {
+			switch {
+			case !set:
+				return nil
+			case t != nil:
+				return t
+			}
+			return &now
+		}
+		if patch.Read != nil {
+			c.card.ReadAt = stamp(c.card.ReadAt, *patch.Read)
+		}
+		if patch.Dismissed != nil {
+			c.card.DismissedAt = stamp(c.card.DismissedAt, *patch.Dismissed)
+		}
+		if patch.Pinned != nil {
+			c.card.Pinned = *patch.Pinned
+		}
+		if patch.ExpiresAt != nil {
+			c.card.ExpiresAt = nil
+			if !patch.ExpiresAt.IsZero() {
+				at := *patch.ExpiresAt
+				c.card.ExpiresAt = &at
+			}
+		}
+		if patch != (CardPatch{}) {
+			c.updatedAt = now
+		}
+
+		card := c.card
+		_ = json.Unmarshal(c.content, &card.Data)
+		card.updatedAt = c.updatedAt
+		card.Timestamp = c.updatedAt.Format(time.RFC3339)
+		return &card, nil
+	}
+	return nil, ErrNotFound
+}
+
+// DeleteCard removes one of the owner's cards
+func (s *MemoryStore) DeleteCard(ctx context.Context, ownerID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	before := len(s.cards)
+	s.cards = s.removeCards(func(c *memoryCard) bool { return c.ownerID == ownerID && c.card.ID == id })
+	if len(s.cards) == before {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// PurgeCards deletes expired unpinned cards and cards dismissed before dismissedBefore
+func (s *MemoryStore) PurgeCards(ctx context.Context, dismissedBefore time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	before := len(s.cards)
+	s.cards = s.removeCards(func(c *memoryCard) bool {
+		expired := !c.card.Pinned && c.card.ExpiresAt != nil && !c.card.ExpiresAt.After(now)
+		dismissed := c.card.DismissedAt != nil && c.card.DismissedAt.Before(dismissedBefore)
+		return expired || dismissed
+	})
+	return before - len(s.cards), nil
+}
+
+// MergeDeviceIntoUser links the device, moves its cards and trips and takes its
+// session, resolving sticky card conflicts like PostgresStore.MergeDeviceIntoUser
+func (s *MemoryStore) MergeDeviceIntoUser(ctx context.Context, deviceID, deviceOwner, userID string) (*MergeResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := &MergeResult{}
+
+	// supersedes reports whether b (owned by loser) is replaced by a newer
+	// version of the same sticky card owned by winner
+	supersedes := func(winner, loser string, orEqual bool) func(*memoryCard) bool {
+		return func(b *memoryCard) bool {
+			if b.ownerID != loser {
+				return false
+			}
+			for _, a := range s.cards {
+				if a.ownerID != winner || a.stickyKey != b.stickyKey || a.card.SourceNode != b.card.SourceNode {
+					continue
+				}
+				if a.updatedAt.After(b.updatedAt) || (orEqual && a.updatedAt.Equal(b.updatedAt)) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	before := len(s.cards)
+	s.cards = s.removeCards(supersedes(userID, deviceOwner, true))
+	s.cards = s.removeCards(supersedes(deviceOwner, userID, false))
+	res.CardsDropped = before - len(s.cards)
+
+	for _, c := range s.cards {
+		if c.ownerID == deviceOwner {
+			c.ownerID = userID
+			res.CardsMoved++
+		}
+	}
+	for _, t := range s.trips {
+		if t.OwnerID == deviceOwner {
+			t.OwnerID = userID
+		}
+	}
+	if rec, ok := s.sessions[deviceOwner]; ok {
+		res.DeviceSession = &rec
+		delete(s.sessions, deviceOwner)
+	}
+
+	link := [2]string{deviceID, userID}
+	res.FirstLink = !s.links[link]
+	s.links[link] = true
+	return res, nil
+}
+
+func (s *MemoryStore) removeCards(drop func(*memoryCard) bool) []*memoryCard {
+	kept := s.cards[:0]
+	for _, c := range s.cards {
+		if !drop(c) {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
+
+// newerCard orders cards like the SQL stores: pinned first, then by
+// updated_at, then id
+func newerCard(a, b *memoryCard) bool {
+	if a.card.Pinned != b.card.Pinned {
+		return a.card.Pinned
+	}
+	if !a.updatedAt.Equal(b.updatedAt) {
+		return a.updatedAt.After(b.updatedAt)
+	}
+	return a.card.ID > b.card.ID
+}
+
+// GetUserLiteLLMKey returns the user's active key, or "" if there is none
+func (s *MemoryStore) GetUserLiteLLMKey(ctx context.Context, userID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.keys[userID]
+	if !ok || rec.Status != KeyStatusActive {
+		return "", nil
+	}
+	now := s.now()
+	if rec.LastUsedAt == nil || now.Sub(*rec.LastUsedAt) > touchInterval {
+		rec.LastUsedAt = &now
+	}
+	return rec.LiteLLMKey, nil
+}
+
+// TouchUserLiteLLMKey records that the user's key was used
+func (s *MemoryStore) TouchUserLiteLLMKey(ctx context.Context, userID string) error {
+	return s.updateKey(userID, func(rec *UserLiteLLMKey, now time.Time) { rec.LastUsedAt = &now })
+}
+
+// GetUserLiteLLMKeyRecord returns a copy of the key record (nil if none)
+func (s *MemoryStore) GetUserLiteLLMKeyRecord(ctx context.Context, userID string) (*UserLiteLLMKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.keys[userID]
+	if !ok {
+		return nil, nil
+	}
+	cp := *rec
+	return &cp, nil
+}
+
+// ListUserLiteLLMKeys returns all key records with the given status, by user ID
+func (s *MemoryStore) ListUserLiteLLMKeys(ctx context.Context, status string) ([]*UserLiteLLMKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := []*UserLiteLLMKey{}
+	for _, rec := range s.keys {
+		if rec.Status == status {
+			cp := *rec
+			keys = append(keys, &cp)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].UserID < keys[j].UserID })
+	return keys, nil
+}
+
+// StoreUserLiteLLMKey saves (or reactivates) the user's key, leaving a revoked one alone
+func (s *MemoryStore) StoreUserLiteLLMKey(ctx context.Context, userID, key, keyName string, maxBudget float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.keys[userID]
+	if !ok {
+		rec = &UserLiteLLMKey{UserID: userID, CreatedAt: s.now()}
+		s.keys[userID] = rec
+	}
+	if rec.Status == KeyStatusRevoked {
+		return ErrKeyRevoked
+	}
+	rec.LiteLLMKey, rec.KeyName, rec.MaxBudget = key, keyName, maxBudget
+	rec.Status = KeyStatusActive
+	return nil
+}
+
+// UpdateUserLiteLLMKeyValue replaces the key value of an existing record and reactivates it
+func (s *MemoryStore) UpdateUserLiteLLMKeyValue(ctx context.Context, userID, key string) error {
+	return s.updateKey(userID, func(rec *UserLiteLLMKey, now time.Time) {
+		rec.LiteLLMKey, rec.RotatedAt = key, &now
+		rec.Status, rec.RevokedAt = KeyStatusActive, nil
+	})
+}
+
+// SetUserLiteLLMKeyBudget stores the user's max budget
+func (s *MemoryStore) SetUserLiteLLMKeyBudget(ctx context.Context, userID string, maxBudget float64) error {
+	return s.updateKey(userID, func(rec *UserLiteLLMKey, _ time.Time) { rec.MaxBudget = maxBudget })
+}
+
+// SetUserLiteLLMKeyStatus changes the key's lifecycle status
+func (s *MemoryStore) SetUserLiteLLMKeyStatus(ctx context.Context, userID, status string) error {
+	return s.updateKey(userID, func(rec *UserLiteLLMKey, now time.Time) {
+		rec.Status = status
+		if status == KeyStatusRevoked {
+			rec.RevokedAt = &now
+		}
+	})
+}
+
+// MarkUserLiteLLMKeyReconciled records that the key was found on the proxy
+func (s *MemoryStore) MarkUserLiteLLMKeyReconciled(ctx context.Context, userID string) error {
+	return s.updateKey(userID, func(rec *UserLiteLLMKey, now time.Time) { rec.ReconciledAt = &now })
+}
+
+// ReencryptLiteLLMKeys is a no-op: the memory store never writes keys to disk
+func (s *MemoryStore) ReencryptLiteLLMKeys(ctx context.Context) (int, int, error) {
+	return 0, 0, nil
+}
+
+// WithUserKeyLock serializes key provisioning for userID within this process
+func (s *MemoryStore) WithUserKeyLock(ctx context.Context, userID string, fn func(ctx context.Context) error) error {
+	unlock := s.keyLocks.lock(userID)
+	defer unlock()
+	return fn(ctx)
+}
+
+// updateKey applies fn to the user's key record; like an UPDATE, a missing record is not an error
+func (s *MemoryStore) updateKey(userID string, fn func(rec *UserLiteLLMKey, now time.Time)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rec, ok := s.keys[userID]; ok {
+		fn(rec, s.now())
+	}
+	return nil
+}
+
+// RecordUsage appends a usage event
+func (s *MemoryStore) RecordUsage(ctx context.Context, ev *UsageEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := *ev
+	e.CreatedAt = s.now()
+	s.usage = append(s.usage, e)
+	return nil
+}
+
+// GetUserUsage summarizes a user's usage since the given time, broken down by model
+func (s *MemoryStore) GetUserUsage(ctx context.Context, userID string, since time.Time) (*UsageSummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	byModel := make(map[string]*ModelUsage)
+	for _, ev := range s.usage {
+		if ev.UserID != userID || ev.CreatedAt.Before(since) {
+			continue
+		}
+		m, ok := byModel[ev.Model]
+		if !ok {
+			m = &ModelUsage{Model: ev.Model}
+			byModel[ev.Model] = m
+		}
+		m.Requests++
+		m.PromptTokens += ev.PromptTokens
+		m.CompletionTokens += ev.CompletionTokens
+		m.TotalTokens += ev.TotalTokens
+		m.CostUSD += ev.CostUSD
+	}
+
+	summary := &UsageSummary{UserID: userID, Since: since, ByModel: []ModelUsage{}}
+	for _, m := range byModel {
+		summary.Requests += m.Requests
+		summary.TotalTokens += m.TotalTokens
+		summary.CostUSD += m.CostUSD
+		summary.ByModel = append(summary.ByModel, *m)
+	}
+	sort.Slice(summary.ByModel, func(i, j int) bool { return summary.ByModel[i].Model < summary.ByModel[j].Model })
+	if rec, ok := s.keys[userID]; ok {
+		summary.MaxBudget = rec.MaxBudget
+	}
+	return summary, nil
+}
+
+// GetUsageAggregate returns per-user usage since the given time, highest spend first
+func (s *MemoryStore) GetUsageAggregate(ctx context.Context, since time.Time, limit int) ([]UserUsage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	byUser := make(map[string]*UserUsage)
+	for _, ev := range s.usage {
+		if ev.CreatedAt.Before(since) {
+			continue
+		}
+		u, ok := byUser[ev.UserID]
+		if !ok {
+			u = &UserUsage{UserID: ev.UserID}
+			if rec, ok := s.keys[ev.UserID]; ok {
+				u.MaxBudget = rec.MaxBudget
+			}
+			byUser[ev.UserID] = u
+		}
+		u.Requests++
+		u.TotalTokens += ev.TotalTokens
+		u.CostUSD += ev.CostUSD
+	}
+
+	report := []UserUsage{}
+	for _, u := range byUser {
+		report = append(report, *u)
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].CostUSD > report[j].CostUSD })
+	if len(report) > limit {
+		report = report[:limit]
+	}
+	return report, nil
+}
+
+// SaveSession stores or replaces the session snapshot
+func (s *MemoryStore) SaveSession(ctx context.Context, rec *SessionRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := append(json.RawMessage(nil), rec.Data...)
+	s.sessions[rec.ID] = SessionRecord{ID: rec.ID, Data: data, UpdatedAt: s.now()}
+	return nil
+}
+
+// LoadSession returns the stored session snapshot
+func (s *MemoryStore) LoadSession(ctx context.Context, id string) (*SessionRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.sessions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	rec.Data = append(json.RawMessage(nil), rec.Data...)
+	return &rec, nil
+}
+
+// DeleteSession removes the session snapshot, if any
+func (s *MemoryStore) DeleteSession(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+	return nil
+}
+
+// StartRun records run as running
+func (s *MemoryStore) StartRun(ctx context.Context, run *AgentRun) error {
+	if run.ID == "" {
+		return fmt.Errorf("run id is required")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, dup := s.runs[run.ID]; dup {
+		return fmt.Errorf("failed to record run: duplicate id %q", run.ID)
+	}
+	run.Status, run.StartedAt = RunStatusRunning, s.now()
+	cp := *run
+	s.runs[run.ID] = &cp
+	return nil
+}
+
+// FinishRun stores the run's outcome
+func (s *MemoryStore) FinishRun(ctx context.Context, run *AgentRun) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.runs[run.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	now := s.now()
+	stored.Status, stored.Error, stored.TotalTokens, stored.FinishedAt = run.Status, run.Error, run.TotalTokens, &now
+	run.FinishedAt = &now
+	return nil
+}
+
+// ListRuns returns the owner's most recent runs first
+func (s *MemoryStore) ListRuns(ctx context.Context, ownerID string, limit int) ([]*AgentRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	runs := []*AgentRun{}
+	for _, run := range s.runs {
+		if run.OwnerID == ownerID {
+			cp := *run
+			runs = append(runs, &cp)
+		}
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].StartedAt.After(runs[j].StartedAt) })
+	if len(runs) > limit {
+		runs = runs[:limit]
+	}
+	return runs, nil
+}
+
+// CreateTrip stores a new trip
+func (s *MemoryStore) CreateTrip(ctx context.Context, rec *TripRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec.ID = newUUID()
+	rec.CreatedAt = s.now()
+	rec.UpdatedAt = rec.CreatedAt
+	cp := *rec
+	cp.Data = append(json.RawMessage(nil), rec.Data...)
+	s.trips[rec.ID] = &cp
+	return nil
+}
+
+// UpdateTrip replaces one of the owner's trips
+func (s *MemoryStore) UpdateTrip(ctx context.Context, rec *TripRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.trips[rec.ID]
+	if !ok || stored.OwnerID != rec.OwnerID {
+		return ErrNotFound
+	}
+	stored.Data = append(json.RawMessage(nil), rec.Data...)
+	stored.UpdatedAt = s.now()
+	rec.CreatedAt, rec.UpdatedAt = stored.CreatedAt, stored.UpdatedAt
+	return nil
+}
+
+// LoadTrip returns one of the owner's trips
+func (s *MemoryStore) LoadTrip(ctx context.Context, ownerID, id string) (*TripRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.trips[id]
+	if !ok || stored.OwnerID != ownerID {
+		return nil, ErrNotFound
+	}
+	cp := *stored
+	cp.Data = append(json.RawMessage(nil), stored.Data...)
+	return &cp, nil
+}
+
+// ListTrips returns the owner's most recently updated trips first
+func (s *MemoryStore) ListTrips(ctx context.Context, ownerID string, limit int) ([]*TripRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	trips := []*TripRecord{}
+	for _, t := range s.trips {
+		if t.OwnerID == ownerID {
+			cp := *t
+			cp.Data = append(json.RawMessage(nil), t.Data...)
+			trips = append(trips, &cp)
+		}
+	}
+	sort.Slice(trips, func(i, j int) bool {
+		if !trips[i].UpdatedAt.Equal(trips[j].UpdatedAt) {
+			return trips[i].UpdatedAt.After(trips[j].UpdatedAt)
+		}
+		return trips[i].ID > trips[j].ID
+	})
+	if len(trips) > limit {
+		trips = trips[:limit]
+	}
+	return trips, nil
+}
+
+// DeleteTrip removes one of the owner's trips and its cards
+func (s *MemoryStore) DeleteTrip(ctx context.Context, ownerID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.trips[id]
+	if !ok || stored.OwnerID != ownerID {
+		return ErrNotFound
+	}
+	delete(s.trips, id)
+	s.cards = s.removeCards(func(c *memoryCard) bool { return c.ownerID == ownerID && c.card.TripID == id })
+	return nil
+}
+
+// newUUID returns a random (version 4) UUID, matching the ids Postgres generates for cards
+func newUUID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -4,25 +4,63 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"strconv"
 	"time"
 
+	"guardian-gateway/pkg/metrics"
+
 	_ "github.com/jackc/pgx/v5/stdlib"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const tracerScope = "guardian-gateway/pkg/store"
+
 // PostgresStore implements the persistence layer for the Insight Stream
 type PostgresStore struct {
 	DB *sql.DB
+	// Keyring encrypts LiteLLM keys at rest. When nil, storing a key fails with
+	// ErrNoKeyring unless AllowPlaintextKeys is set.
+	Keyring            *Keyring
+	AllowPlaintextKeys bool
+	// Sticky decides which card UpsertCard updates
+	Sticky StickyPolicy
+
+	now func() time.Time
 }
 
 type Card struct {
 	ID         string                 `json:"id"`
-	OwnerID    string                 `json:"-"` // Internal use
+	OwnerID    string                 `json:"-"`                 // Internal use
+	RunID      string                 `json:"run_id,omitempty"`  // Agent run that produced the card
+	TripID     string                 `json:"trip_id,omitempty"` // Trip the card is about
 	CardType   string                 `json:"card_type"`
 	Priority   string                 `json:"priority"`
 	Timestamp  string                 `json:"timestamp"`
 	SourceNode string                 `json:"source_node"`
 	Data       map[string]interface{} `json:"data"`
+
+	// StickyKey, when set, overrides StickyPolicy: updates with the same key
+	// and source node land on one card, across runs (e.g. a scheduled agent's
+	// standing cards). Callers prefix it with their own namespace.
+	StickyKey string `json:"-"`
+	// AgentVersion identifies the agent build that wrote this content; it is
+	// recorded on the card's revision
+	AgentVersion string `json:"-"`
+
+	// Lifecycle (see CardPatch): pinned cards lead the feed and never
+	// expire; dismissed and expired cards are hidden, then purged
+	Pinned      bool       `json:"pinned"`
+	ReadAt      *time.Time `json:"read_at,omitempty"`
+	DismissedAt *time.Time `json:"dismissed_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+
+	updatedAt time.Time // exact Timestamp, for cursors
 }
 
 func NewPostgresStore(ctx context.Context, connStr string) (*PostgresStore, error) {
@@ -33,69 +71,75 @@ func NewPostgresStore(ctx context.Context, connStr string) (*PostgresStore, erro
 	if err := db.PingContext(ctx); err != nil {
 		return nil, fmt.Errorf("failed to ping db: %w", err)
 	}
-	return &PostgresStore{DB: db}, nil
+	return &PostgresStore{DB: db, now: time.Now}, nil
+}
+
+// Ping checks that the database is reachable
+func (s *PostgresStore) Ping(ctx context.Context) error {
+	return s.DB.PingContext(ctx)
 }
 
-// UpsertCard inserts a new card or updates an existing "sticky" card from the same node
-func (s *PostgresStore) UpsertCard(ctx context.Context, ownerID string, card *Card) error {
-	// 1. Serialization
+// Close closes the connection pool
+func (s *PostgresStore) Close() error {
+	return s.DB.Close()
+}
+
+// UpsertCard inserts a card or updates the owner's card with the same source
+// node and sticky key, and records the content as the run's revision. The
+// upsert is a single statement, so concurrent updates for one node cannot
+// create duplicate cards, and its row lock orders the card's revisions.
+func (s *PostgresStore) UpsertCard(ctx context.Context, ownerID string, card *Card) (err error) {
+	ctx, finish := startQuery(ctx, "upsert_card")
+	defer finish(&err)
 	contentJSON, err := json.Marshal(card.Data)
 	if err != nil {
 		return fmt.Errorf("failed to marshal content: %w", err)
 	}
 
-	// 2. Sticky Logic: Check for a recent card from this Node/Owner
-	// We update if < 1 hour old (session window), otherwise we treat as new.
-	// This matches the "resetThreshold" logic but persistent.
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin upsert: %w", err)
+	}
+	defer tx.Rollback() // No-op after commit
+
 	query := `
-		INSERT INTO cards (owner_id, card_type, priority, source_node, content, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, NOW(), NOW())
-		ON CONFLICT (id) DO NOTHING 
-		RETURNING id; 
-	`
-	// Note: We are NOT using ON CONFLICT (node) because we want multiple cards over time.
-	// Instead, we try to UPDATE first.
-
-	updateQuery := `
-		UPDATE cards 
-		SET content = $1, updated_at = NOW(), priority = $2, card_type = $3
-		WHERE id = (
-			SELECT id FROM cards 
-			WHERE owner_id = $4 AND source_node = $5 
-			AND updated_at > NOW() - INTERVAL '60 minutes'
-			ORDER BY updated_at DESC
-			LIMIT 1
-		)
-		RETURNING id;
+		INSERT INTO cards (owner_id, run_id, trip_id, sticky_key, card_type, priority, source_node, content, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW(), NOW())
+		ON CONFLICT (owner_id, sticky_key, source_node) DO UPDATE
+		SET content = EXCLUDED.content, priority = EXCLUDED.priority, card_type = EXCLUDED.card_type,
+		    run_id = EXCLUDED.run_id, trip_id = EXCLUDED.trip_id, updated_at = EXCLUDED.updated_at
+		RETURNING id
 	`
-
-	var id string
-	// Try Update First
-	err = s.DB.QueryRowContext(ctx, updateQuery, contentJSON, card.Priority, card.CardType, ownerID, card.SourceNode).Scan(&id)
-
-	if err == sql.ErrNoRows {
-		// No recent card found -> Insert New
-		_, err = s.DB.ExecContext(ctx, query, ownerID, card.CardType, card.Priority, card.SourceNode, contentJSON)
-		if err != nil {
-			return fmt.Errorf("failed to insert card: %w", err)
-		}
-	} else if err != nil {
-		return fmt.Errorf("failed to update card: %w", err)
+	key := s.Sticky.cardKey(card, s.now())
+	err = tx.QueryRowContext(ctx, query, ownerID, card.RunID, card.TripID, key, card.CardType, card.Priority, card.SourceNode, contentJSON).Scan(&card.ID)
+	if err != nil {
+		return fmt.Errorf("failed to upsert card: %w", err)
+	}
+	if err := recordRevision(ctx, tx, card, contentJSON); err != nil {
+		return fmt.Errorf("failed to record card revision: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit card: %w", err)
 	}
-
 	return nil
 }
 
-func (s *PostgresStore) GetFeed(ctx context.Context, ownerID string, limit int) ([]*Card, error) {
+// GetFeed returns a page of the owner's visible cards, pinned then newest first
+func (s *PostgresStore) GetFeed(ctx context.Context, ownerID string, q FeedQuery) (_ *FeedPage, err error) {
+	ctx, finish := startQuery(ctx, "get_feed")
+	defer finish(&err)
+	where, args, err := feedWhere(ownerID, q, s.now(), pgPlaceholder, func(t time.Time) interface{} { return t })
+	if err != nil {
+		return nil, err
+	}
 	query := `
-		SELECT id, card_type, priority, source_node, content, updated_at
+		SELECT ` + cardColumns + `
 		FROM cards
-		WHERE owner_id = $1
-		ORDER BY updated_at DESC
-		LIMIT $2
-	`
+		WHERE ` + where + `
+		ORDER BY pinned DESC, updated_at DESC, id DESC
+		LIMIT ` + strconv.Itoa(q.Limit+1)
 
-	rows, err := s.DB.QueryContext(ctx, query, ownerID, limit)
+	rows, err := s.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -104,33 +148,56 @@ func (s *PostgresStore) GetFeed(ctx context.Context, ownerID string, limit int)
 	// Initialize as empty slice so JSON is [] not null
 	feed := []*Card{}
 	for rows.Next() {
-		var c Card
-		var contentBytes []byte
-		var ts time.Time
-
-		if err := rows.Scan(&c.ID, &c.CardType, &c.Priority, &c.SourceNode, &contentBytes, &ts); err != nil {
-			return nil, err
-		}
-
-		if err := json.Unmarshal(contentBytes, &c.Data); err != nil {
+		c, err := scanCard(rows)
+		if errors.Is(err, errBadContent) {
 			continue // Skip bad data
 		}
-		c.Timestamp = ts.Format(time.RFC3339)
-		feed = append(feed, &c)
+		if err != nil {
+			return nil, err
+		}
+		feed = append(feed, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
-	fmt.Printf("DEBUG: GetFeed found %d cards for %s\n", len(feed), ownerID)
-	return feed, nil
+	slog.DebugContext(ctx, "loaded feed", "cards", len(feed))
+	return pageOf(feed, q.Limit), nil
 }
 
 // DeleteFeed removes all cards for a specific user/device
-func (s *PostgresStore) DeleteFeed(ctx context.Context, ownerID string) error {
+func (s *PostgresStore) DeleteFeed(ctx context.Context, ownerID string) (err error) {
+	ctx, finish := startQuery(ctx, "delete_feed")
+	defer finish(&err)
 	query := `DELETE FROM cards WHERE owner_id = $1`
 	res, err := s.DB.ExecContext(ctx, query, ownerID)
 	if err != nil {
 		return err
 	}
 	rows, _ := res.RowsAffected()
-	fmt.Printf("DEBUG: DeleteFeed removed %d rows for %s\n", rows, ownerID)
+	slog.DebugContext(ctx, "deleted feed", "cards", rows)
 	return nil
 }
+
+// startQuery traces and times a PostgresStore operation:
+//
+//	ctx, finish := startQuery(ctx, "get_feed")
+//	defer finish(&err)
+func startQuery(ctx context.Context, operation string) (context.Context, func(*error)) {
+	start := time.Now()
+	ctx, span := otel.Tracer(tracerScope).Start(ctx, "db "+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system.name", "postgresql"),
+			attribute.String("db.operation.name", operation),
+		),
+	)
+	return ctx, func(err *error) {
+		metrics.DBQueryDuration.WithLabelValues(operation, metrics.Outcome(*err)).Observe(time.Since(start).Seconds())
+		if *err != nil {
+			span.RecordError(*err)
+			span.SetStatus(codes.Error, "query failed")
+		}
+		span.End()
+	}
+}
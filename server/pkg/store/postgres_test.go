@@ -8,9 +8,6 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-// Note: These tests require a test database or mock
-// For now, we'll test the logic without actual DB calls
-
 func TestCard_Structure(t *testing.T) {
 	card := &Card{
 		CardType:   "article",
@@ -38,60 +35,8 @@ func TestNewPostgresStore_EmptyConnectionString(t *testing.T) {
 	assert.Error(t, err)
 }
 
-// Integration tests (require actual database)
-func TestUpsertCard_Integration(t *testing.T) {
-	t.Skip("Requires test database setup")
-
-	// This would be a real integration test with a test database
-	// connStr := os.Getenv("TEST_DATABASE_URL")
-	// if connStr == "" {
-	// 	t.Skip("TEST_DATABASE_URL not set")
-	// }
-
-	// store, err := NewPostgresStore(connStr)
-	// require.NoError(t, err)
-	// defer store.Close()
-
-	// ctx := context.Background()
-	// card := &Card{
-	// 	CardType:   "test",
-	// 	Priority:   "low",
-	// 	SourceNode: "TestNode",
-	// 	Data:       map[string]interface{}{"test": "data"},
-	// }
-
-	// err = store.UpsertCard(ctx, "test-device", card)
-	// assert.NoError(t, err)
-}
-
-func TestGetFeed_Integration(t *testing.T) {
-	t.Skip("Requires test database setup")
-}
-
-func TestDeleteFeed_Integration(t *testing.T) {
-	t.Skip("Requires test database setup")
-}
-
-// Mock-based tests for business logic
-func TestUpsertCard_DuplicateHandling(t *testing.T) {
-	// Test that upserting same node twice updates rather than duplicates
-	t.Skip("Requires database mock")
-}
-
-func TestGetFeed_Limit(t *testing.T) {
-	// Test that limit parameter works correctly
-	t.Skip("Requires database mock")
-}
-
-func TestGetFeed_EmptyResult(t *testing.T) {
-	// Test behavior when no cards exist
-	t.Skip("Requires database mock")
-}
-
-func TestDeleteFeed_NonExistentDevice(t *testing.T) {
-	// Test deleting feed for device that doesn't exist
-	t.Skip("Requires database mock")
-}
+// Card persistence (sticky upserts, limits, empty feeds, deletes) is covered
+// for every backend by the conformance suite in conformance_test.go
 
 // Test data marshaling/unmarshaling
 func TestCardDataSerialization(t *testing.T) {
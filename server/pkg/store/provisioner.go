@@ -0,0 +1,220 @@
+package store
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// DefaultUserBudget is the monthly USD budget given to newly provisioned keys
+const DefaultUserBudget = 10.00
+
+// KeyRepository is the persistence a KeyProvisioner needs. PostgresStore implements it.
+type KeyRepository interface {
+	GetUserLiteLLMKey(ctx context.Context, userID string) (string, error)
+	GetUserLiteLLMKeyRecord(ctx context.Context, userID string) (*UserLiteLLMKey, error)
+	StoreUserLiteLLMKey(ctx context.Context, userID, key, keyName string, maxBudget float64) error
+	// WithUserKeyLock runs fn while holding a lock that serializes key
+	// provisioning for userID across all gateway replicas
+	WithUserKeyLock(ctx context.Context, userID string, fn func(ctx context.Context) error) error
+}
+
+// KeyProvisioner hands out per-user LiteLLM keys, minting at most one key per
+// user even when several requests for a new user arrive at once
+type KeyProvisioner struct {
+	Repo      KeyRepository
+	Keys      *KeyManager
+	MaxBudget float64
+
+	locks keyedMutex
+
+	sweptMu sync.Mutex
+	swept   map[string]bool // users whose orphaned keys were cleaned up by this process
+}
+
+// userLock is a refcounted per-user mutex so the map does not grow without bound
+type userLock struct {
+	sync.Mutex
+	refs int
+}
+
+// NewKeyProvisioner creates a provisioner with the default budget
+func NewKeyProvisioner(repo KeyRepository, keys *KeyManager) *KeyProvisioner {
+	return &KeyProvisioner{
+		Repo:      repo,
+		Keys:      keys,
+		MaxBudget: DefaultUserBudget,
+	}
+}
+
+// Provision returns the user's active key, generating and storing one if needed.
+// Requests are serialized per user: in-process with a mutex (so concurrent
+// chats don't each hold a DB connection), across replicas with the repository lock.
+// A revoked key returns ErrKeyRevoked rather than being replaced.
+func (p *KeyProvisioner) Provision(ctx context.Context, userID string) (string, error) {
+	if userID == "" {
+		return "", fmt.Errorf("user id is required")
+	}
+
+	// Fast path: most requests come from users who already have a key
+	key, err := p.Repo.GetUserLiteLLMKey(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if key != "" {
+		// Users provisioned before the lock existed may still have orphans
+		if !p.wasSwept(userID) {
+			p.sweep(ctx, userID, key)
+		}
+		return key, nil
+	}
+
+	unlock := p.locks.lock(userID)
+	defer unlock()
+
+	err = p.Repo.WithUserKeyLock(ctx, userID, func(ctx context.Context) error {
+		// Another request (here or on another replica) may have won the race
+		rec, err := p.Repo.GetUserLiteLLMKeyRecord(ctx, userID)
+		if err != nil {
+			return err
+		}
+		switch {
+		case rec == nil:
+		case rec.Status == KeyStatusActive:
+			key = rec.LiteLLMKey
+			return nil
+		case rec.Status == KeyStatusRevoked:
+			return ErrKeyRevoked
+		}
+
+		newKey, keyName, err := p.Keys.GenerateKey(ctx, userID, p.MaxBudget)
+		if err != nil {
+			return fmt.Errorf("failed to generate key: %w", err)
+		}
+
+		if err := p.Repo.StoreUserLiteLLMKey(ctx, userID, newKey, keyName, p.MaxBudget); err != nil {
+			// Don't leave a spendable key on the proxy that nothing references
+			if derr := p.Keys.DeleteKey(context.WithoutCancel(ctx), newKey); derr != nil && !errors.Is(derr, ErrKeyNotFound) {
+				slog.WarnContext(ctx, "failed to delete orphaned key", "user_id", userID, "error", derr)
+			}
+			return err
+		}
+		key = newKey
+		slog.InfoContext(ctx, "provisioned LiteLLM key", "key_alias", keyName)
+
+		// Clean up keys orphaned by earlier, unserialized provisioning
+		p.sweep(ctx, userID, newKey)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// sweep runs CleanupOrphans for the user, remembering success so it runs
+// once per user and process. Failures are logged and retried next time.
+func (p *KeyProvisioner) sweep(ctx context.Context, userID, activeKey string) {
+	n, err := p.CleanupOrphans(ctx, userID, activeKey)
+	if err != nil {
+		slog.WarnContext(ctx, "orphan key cleanup failed", "user_id", userID, "error", err)
+		return
+	}
+	if n > 0 {
+		slog.InfoContext(ctx, "deleted orphaned LiteLLM keys", "user_id", userID, "count", n)
+	}
+	p.sweptMu.Lock()
+	defer p.sweptMu.Unlock()
+	if p.swept == nil {
+		p.swept = make(map[string]bool)
+	}
+	p.swept[userID] = true
+}
+
+func (p *KeyProvisioner) wasSwept(userID string) bool {
+	p.sweptMu.Lock()
+	defer p.sweptMu.Unlock()
+	return p.swept[userID]
+}
+
+// CleanupOrphans deletes every proxy key belonging to userID except activeKey.
+// It returns the number of keys deleted.
+func (p *KeyProvisioner) CleanupOrphans(ctx context.Context, userID, activeKey string) (int, error) {
+	tokens, err := p.Keys.ListUserKeyTokens(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	active := KeyLookupHash(activeKey)
+	deleted := 0
+	for _, token := range tokens {
+		if token == active || token == activeKey {
+			continue
+		}
+		if err := p.Keys.DeleteKey(ctx, token); err != nil && !errors.Is(err, ErrKeyNotFound) {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// keyedMutex is a set of per-key mutexes. KeyProvisioner and the single-node
+// stores' WithUserKeyLock use it.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*userLock
+}
+
+func (k *keyedMutex) lock(key string) func() {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = make(map[string]*userLock)
+	}
+	l, ok := k.locks[key]
+	if !ok {
+		l = &userLock{}
+		k.locks[key] = l
+	}
+	l.refs++
+	k.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		k.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
+
+// WithUserKeyLock holds a Postgres session advisory lock keyed on the user
+// while fn runs, serializing key provisioning across replicas
+func (s *PostgresStore) WithUserKeyLock(ctx context.Context, userID string, fn func(ctx context.Context) error) error {
+	conn, err := s.DB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection for key lock: %w", err)
+	}
+	defer conn.Close()
+
+	lockKey := "litellm_key:" + userID
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock(hashtext($1))`, lockKey); err != nil {
+		return fmt.Errorf("failed to acquire key lock: %w", err)
+	}
+	defer func() {
+		// Unlock even if ctx was cancelled, otherwise the lock lives as long as the pooled connection
+		if _, err := conn.ExecContext(context.WithoutCancel(ctx), `SELECT pg_advisory_unlock(hashtext($1))`, lockKey); err != nil {
+			slog.WarnContext(ctx, "failed to release key lock", "user_id", userID, "error", err)
+			// Discard the connection so the session (and its lock) ends
+			_ = conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		}
+	}()
+
+	return fn(ctx)
+}
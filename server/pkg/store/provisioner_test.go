@@ -0,0 +1,242 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeProxy is a minimal LiteLLM proxy that tracks generated keys per user
+type fakeProxy struct {
+	mu        sync.Mutex
+	generated int
+	keys      map[string]string // key -> user_id
+}
+
+func (f *fakeProxy) userKeys(userID string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []string
+	for k, u := range f.keys {
+		if u == userID {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+func newFakeProxy(t *testing.T) (*fakeProxy, *httptest.Server) {
+	t.Helper()
+	f := &fakeProxy{keys: map[string]string{}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+
+		switch r.URL.Path {
+		case "/key/generate":
+			time.Sleep(10 * time.Millisecond) // widen the race window
+			f.mu.Lock()
+			f.generated++
+			key := fmt.Sprintf("sk-%d", f.generated)
+			f.keys[key] = body["user_id"].(string)
+			f.mu.Unlock()
+			_ = json.NewEncoder(w).Encode(map[string]string{"key": key})
+		case "/key/list":
+			user := r.URL.Query().Get("user_id")
+			var objs []map[string]string
+			for _, k := range f.userKeys(user) {
+				objs = append(objs, map[string]string{"token": KeyLookupHash(k)})
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": objs})
+		case "/key/delete":
+			f.mu.Lock()
+			for _, k := range body["keys"].([]interface{}) {
+				for key := range f.keys {
+					if key == k.(string) || KeyLookupHash(key) == k.(string) {
+						delete(f.keys, key)
+					}
+				}
+			}
+			f.mu.Unlock()
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return f, srv
+}
+
+// memKeyRepo is an in-memory KeyRepository whose lock mimics the advisory lock
+type memKeyRepo struct {
+	mu       sync.Mutex
+	lockMu   sync.Mutex
+	keys     map[string]string
+	storeErr error
+}
+
+func (r *memKeyRepo) GetUserLiteLLMKey(ctx context.Context, userID string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.keys[userID], nil
+}
+
+func (r *memKeyRepo) GetUserLiteLLMKeyRecord(ctx context.Context, userID string) (*UserLiteLLMKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key, ok := r.keys[userID]
+	if !ok {
+		return nil, nil
+	}
+	return &UserLiteLLMKey{UserID: userID, LiteLLMKey: key, Status: KeyStatusActive}, nil
+}
+
+func (r *memKeyRepo) StoreUserLiteLLMKey(ctx context.Context, userID, key, keyName string, maxBudget float64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.storeErr != nil {
+		return r.storeErr
+	}
+	r.keys[userID] = key
+	return nil
+}
+
+func (r *memKeyRepo) WithUserKeyLock(ctx context.Context, userID string, fn func(ctx context.Context) error) error {
+	r.lockMu.Lock()
+	defer r.lockMu.Unlock()
+	return fn(ctx)
+}
+
+func TestKeyProvisioner_ConcurrentFirstChats(t *testing.T) {
+	proxy, srv := newFakeProxy(t)
+	defer srv.Close()
+	repo := &memKeyRepo{keys: map[string]string{}}
+	p := NewKeyProvisioner(repo, NewKeyManager(nil, srv.URL, "master"))
+
+	var wg sync.WaitGroup
+	results := make([]string, 20)
+	errs := make([]error, 20)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = p.Provision(context.Background(), "new-user")
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range results {
+		require.NoError(t, errs[i])
+		assert.Equal(t, results[0], results[i])
+	}
+	assert.Equal(t, 1, proxy.generated)
+	assert.Equal(t, []string{results[0]}, proxy.userKeys("new-user"))
+	assert.Empty(t, p.locks.locks, "per-user locks must be released")
+}
+
+func TestKeyProvisioner_ExistingKey(t *testing.T) {
+	proxy, srv := newFakeProxy(t)
+	defer srv.Close()
+	repo := &memKeyRepo{keys: map[string]string{"u1": "sk-existing"}}
+	p := NewKeyProvisioner(repo, NewKeyManager(nil, srv.URL, "master"))
+
+	key, err := p.Provision(context.Background(), "u1")
+	require.NoError(t, err)
+	assert.Equal(t, "sk-existing", key)
+	assert.Equal(t, 0, proxy.generated)
+}
+
+func TestKeyProvisioner_CleansUpOrphansOfExistingKey(t *testing.T) {
+	proxy, srv := newFakeProxy(t)
+	defer srv.Close()
+	// A user provisioned by the old racy handler: one stored key, two orphans
+	proxy.keys["sk-existing"] = "u1"
+	proxy.keys["sk-orphan-a"] = "u1"
+	proxy.keys["sk-orphan-b"] = "u1"
+	proxy.keys["sk-other-user"] = "u2"
+	repo := &memKeyRepo{keys: map[string]string{"u1": "sk-existing"}}
+	p := NewKeyProvisioner(repo, NewKeyManager(nil, srv.URL, "master"))
+
+	key, err := p.Provision(context.Background(), "u1")
+	require.NoError(t, err)
+	assert.Equal(t, "sk-existing", key)
+	assert.Equal(t, []string{"sk-existing"}, proxy.userKeys("u1"))
+	assert.Equal(t, []string{"sk-other-user"}, proxy.userKeys("u2"))
+	assert.Equal(t, 0, proxy.generated)
+
+	// Swept once per user: a later orphan is left for the next process
+	proxy.keys["sk-orphan-c"] = "u1"
+	_, err = p.Provision(context.Background(), "u1")
+	require.NoError(t, err)
+	assert.Len(t, proxy.userKeys("u1"), 2)
+}
+
+func TestKeyProvisioner_StoreFailureDeletesProxyKey(t *testing.T) {
+	proxy, srv := newFakeProxy(t)
+	defer srv.Close()
+	repo := &memKeyRepo{keys: map[string]string{}, storeErr: errors.New("db down")}
+	p := NewKeyProvisioner(repo, NewKeyManager(nil, srv.URL, "master"))
+
+	_, err := p.Provision(context.Background(), "u1")
+	assert.Error(t, err)
+	assert.Equal(t, 1, proxy.generated)
+	assert.Empty(t, proxy.userKeys("u1"), "generated key must not be orphaned")
+}
+
+func TestKeyProvisioner_CleansUpPreviousOrphans(t *testing.T) {
+	proxy, srv := newFakeProxy(t)
+	defer srv.Close()
+	// Left behind by the old racy handler
+	proxy.keys["sk-orphan-a"] = "u1"
+	proxy.keys["sk-orphan-b"] = "u1"
+	proxy.keys["sk-other-user"] = "u2"
+
+	repo := &memKeyRepo{keys: map[string]string{}}
+	p := NewKeyProvisioner(repo, NewKeyManager(nil, srv.URL, "master"))
+
+	key, err := p.Provision(context.Background(), "u1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{key}, proxy.userKeys("u1"))
+	assert.Equal(t, []string{"sk-other-user"}, proxy.userKeys("u2"))
+}
+
+func TestKeyProvisioner_RevokedKeyIsNotReplaced(t *testing.T) {
+	proxy, srv := newFakeProxy(t)
+	defer srv.Close()
+	s := NewMemoryStore()
+	km := NewKeyManager(s, srv.URL, "master")
+	p := NewKeyProvisioner(s, km)
+
+	key, err := p.Provision(context.Background(), "u1")
+	require.NoError(t, err)
+	require.NoError(t, km.Revoke(context.Background(), "u1"))
+	assert.Empty(t, proxy.userKeys("u1"))
+
+	_, err = p.Provision(context.Background(), "u1")
+	assert.ErrorIs(t, err, ErrKeyRevoked)
+	assert.Equal(t, 1, proxy.generated, "a revoked user must not get a new key")
+
+	// Only an admin rotation lifts the revocation
+	rec, err := km.Rotate(context.Background(), "u1")
+	require.NoError(t, err)
+	assert.Equal(t, KeyStatusActive, rec.Status)
+	assert.NotEqual(t, key, rec.LiteLLMKey)
+	got, err := p.Provision(context.Background(), "u1")
+	require.NoError(t, err)
+	assert.Equal(t, rec.LiteLLMKey, got)
+	assert.Equal(t, 2, proxy.generated)
+}
+
+func TestKeyProvisioner_RequiresUserID(t *testing.T) {
+	p := NewKeyProvisioner(&memKeyRepo{keys: map[string]string{}}, nil)
+	_, err := p.Provision(context.Background(), "")
+	assert.Error(t, err)
+}
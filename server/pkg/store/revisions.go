@@ -0,0 +1,98 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// recordRevision stores card's content (just upserted in tx) as the revision
+// of its run: the run's revision is updated in place, and a new run adds one
+// only when it changes what the card shows
+func recordRevision(ctx context.Context, tx *sql.Tx, card *Card, content []byte) error {
+	var (
+		latestID                  int64
+		runID, cardType, priority string
+		latestContent             []byte
+	)
+	err := tx.QueryRowContext(ctx, `
+		SELECT id, run_id, card_type, priority, content FROM card_revisions
+		WHERE card_id = $1 ORDER BY id DESC LIMIT 1
+	`, card.ID).Scan(&latestID, &runID, &cardType, &priority, &latestContent)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return err
+	case runID == card.RunID:
+		_, err := tx.ExecContext(ctx, `
+			UPDATE card_revisions
+			SET content = $2, card_type = $3, priority = $4, agent_version = $5, updated_at = NOW()
+			WHERE id = $1
+		`, latestID, content, card.CardType, card.Priority, card.AgentVersion)
+		return err
+	case cardType == card.CardType && priority == card.Priority && sameContent(latestContent, content):
+		return nil // The run left the card as it was
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO card_revisions (card_id, run_id, agent_version, card_type, priority, content)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, card.ID, card.RunID, card.AgentVersion, card.CardType, card.Priority, content); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM card_revisions
+		WHERE card_id = $1 AND id <= (
+			SELECT id FROM card_revisions WHERE card_id = $1 ORDER BY id DESC OFFSET $2 LIMIT 1
+		)
+	`, card.ID, maxCardRevisions)
+	return err
+}
+
+// CardHistory returns up to limit revisions of one of the owner's cards, newest first
+func (s *PostgresStore) CardHistory(ctx context.Context, ownerID, cardID string, limit int) (_ []*CardRevision, err error) {
+	ctx, finish := startQuery(ctx, "card_history")
+	defer finish(&err)
+	if !isUUID(cardID) {
+		return nil, ErrNotFound
+	}
+	var exists bool
+	err = s.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM cards WHERE owner_id = $1 AND id = $2)`, ownerID, cardID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, card_id, run_id, agent_version, card_type, priority, content, created_at, updated_at
+		FROM card_revisions
+		WHERE card_id = $1
+		ORDER BY id DESC
+		LIMIT $2
+	`, cardID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []*CardRevision{}
+	for rows.Next() {
+		var r CardRevision
+		var content []byte
+		if err := rows.Scan(&r.ID, &r.CardID, &r.RunID, &r.AgentVersion, &r.CardType, &r.Priority, &content, &r.CreatedAt, &r.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(content, &r.Data); err != nil {
+			continue // Skip bad data
+		}
+		revisions = append(revisions, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read card history: %w", err)
+	}
+	return revisions, nil
+}
@@ -0,0 +1,87 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// StartRun records run as running
+func (s *PostgresStore) StartRun(ctx context.Context, run *AgentRun) (err error) {
+	ctx, finish := startQuery(ctx, "start_run")
+	defer finish(&err)
+	if run.ID == "" {
+		return fmt.Errorf("run id is required")
+	}
+	run.Status = RunStatusRunning
+	query := `
+		INSERT INTO agent_runs (id, owner_id, agent, source, trip_id, status, started_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW())
+		RETURNING started_at
+	`
+	if err := s.DB.QueryRowContext(ctx, query, run.ID, run.OwnerID, run.Agent, run.Source, run.TripID, run.Status).Scan(&run.StartedAt); err != nil {
+		return fmt.Errorf("failed to record run: %w", err)
+	}
+	return nil
+}
+
+// FinishRun stores the run's outcome
+func (s *PostgresStore) FinishRun(ctx context.Context, run *AgentRun) (err error) {
+	ctx, finish := startQuery(ctx, "finish_run")
+	defer finish(&err)
+	var finishedAt time.Time
+	query := `
+		UPDATE agent_runs SET status = $1, error = $2, total_tokens = $3, finished_at = NOW()
+		WHERE id = $4
+		RETURNING finished_at
+	`
+	err = s.DB.QueryRowContext(ctx, query, run.Status, run.Error, run.TotalTokens, run.ID).Scan(&finishedAt)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to finish run: %w", err)
+	}
+	run.FinishedAt = &finishedAt
+	return nil
+}
+
+// ListRuns returns the owner's most recent runs first
+func (s *PostgresStore) ListRuns(ctx context.Context, ownerID string, limit int) (_ []*AgentRun, err error) {
+	ctx, finish := startQuery(ctx, "list_runs")
+	defer finish(&err)
+	query := `
+		SELECT id, owner_id, agent, source, trip_id, status, error, total_tokens, started_at, finished_at
+		FROM agent_runs
+		WHERE owner_id = $1
+		ORDER BY started_at DESC
+		LIMIT $2
+	`
+	rows, err := s.DB.QueryContext(ctx, query, ownerID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list runs: %w", err)
+	}
+	defer rows.Close()
+
+	runs := []*AgentRun{}
+	for rows.Next() {
+		run, err := scanRun(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan run: %w", err)
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+func scanRun(row rowScanner) (*AgentRun, error) {
+	var run AgentRun
+	var finished sql.NullTime
+	if err := row.Scan(&run.ID, &run.OwnerID, &run.Agent, &run.Source, &run.TripID, &run.Status, &run.Error,
+		&run.TotalTokens, &run.StartedAt, &finished); err != nil {
+		return nil, err
+	}
+	run.FinishedAt = nullTimePtr(finished)
+	return &run, nil
+}
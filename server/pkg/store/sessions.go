@@ -0,0 +1,46 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// SaveSession stores or replaces the session snapshot
+func (s *PostgresStore) SaveSession(ctx context.Context, rec *SessionRecord) (err error) {
+	ctx, finish := startQuery(ctx, "save_session")
+	defer finish(&err)
+	query := `
+		INSERT INTO sessions (id, data, updated_at) VALUES ($1, $2, NOW())
+		ON CONFLICT (id) DO UPDATE SET data = EXCLUDED.data, updated_at = EXCLUDED.updated_at
+	`
+	if _, err := s.DB.ExecContext(ctx, query, rec.ID, []byte(rec.Data)); err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+	return nil
+}
+
+// LoadSession returns the stored session snapshot
+func (s *PostgresStore) LoadSession(ctx context.Context, id string) (_ *SessionRecord, err error) {
+	ctx, finish := startQuery(ctx, "load_session")
+	defer finish(&err)
+	rec := &SessionRecord{ID: id}
+	err = s.DB.QueryRowContext(ctx, `SELECT data, updated_at FROM sessions WHERE id = $1`, id).Scan(&rec.Data, &rec.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session: %w", err)
+	}
+	return rec, nil
+}
+
+// DeleteSession removes the session snapshot, if any
+func (s *PostgresStore) DeleteSession(ctx context.Context, id string) (err error) {
+	ctx, finish := startQuery(ctx, "delete_session")
+	defer finish(&err)
+	if _, err := s.DB.ExecContext(ctx, `DELETE FROM sessions WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,1064 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSchema holds the SQLite schema versions, applied in order and tracked
+// in PRAGMA user_version. Like migrations/, never edit a shipped entry; append.
+var sqliteSchema = []string{
+	// 1: cards, keys, usage, device links, sessions and agent runs.
+	// Timestamps are Unix nanoseconds (UTC).
+	`
+	CREATE TABLE cards (
+		id TEXT PRIMARY KEY,
+		owner_id TEXT NOT NULL,
+		card_type TEXT NOT NULL,
+		priority TEXT NOT NULL,
+		source_node TEXT NOT NULL,
+		content TEXT NOT NULL DEFAULT '{}',
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
+	CREATE INDEX idx_cards_owner_updated ON cards (owner_id, updated_at DESC);
+	CREATE INDEX idx_cards_owner_node ON cards (owner_id, source_node);
+
+	CREATE TABLE user_litellm_keys (
+		user_id TEXT PRIMARY KEY,
+		litellm_key TEXT,
+		litellm_key_ciphertext TEXT,
+		kek_id TEXT,
+		litellm_key_hash TEXT UNIQUE,
+		key_name TEXT NOT NULL UNIQUE,
+		status TEXT NOT NULL DEFAULT 'active',
+		created_at INTEGER NOT NULL,
+		last_used_at INTEGER,
+		rotated_at INTEGER,
+		revoked_at INTEGER,
+		reconciled_at INTEGER,
+		max_budget REAL NOT NULL DEFAULT 10.00
+	);
+	CREATE INDEX idx_user_litellm_keys_status ON user_litellm_keys (status);
+
+	CREATE TABLE usage_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id TEXT NOT NULL,
+		source TEXT NOT NULL,
+		model TEXT NOT NULL DEFAULT '',
+		prompt_tokens INTEGER NOT NULL DEFAULT 0,
+		completion_tokens INTEGER NOT NULL DEFAULT 0,
+		total_tokens INTEGER NOT NULL DEFAULT 0,
+		cost_usd REAL NOT NULL DEFAULT 0,
+		cost_estimated INTEGER NOT NULL DEFAULT 0,
+		created_at INTEGER NOT NULL
+	);
+	CREATE INDEX idx_usage_events_user_created ON usage_events (user_id, created_at DESC);
+	CREATE INDEX idx_usage_events_created ON usage_events (created_at);
+
+	CREATE TABLE device_user_links (
+		device_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		linked_at INTEGER NOT NULL,
+		last_merged_at INTEGER NOT NULL,
+		cards_moved INTEGER NOT NULL DEFAULT 0,
+		cards_dropped INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (device_id, user_id)
+	);
+
+	CREATE TABLE sessions (
+		id TEXT PRIMARY KEY,
+		data TEXT NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
+
+	CREATE TABLE agent_runs (
+		id TEXT PRIMARY KEY,
+		owner_id TEXT NOT NULL,
+		agent TEXT NOT NULL,
+		source TEXT NOT NULL,
+		status TEXT NOT NULL,
+		error TEXT NOT NULL DEFAULT '',
+		total_tokens INTEGER NOT NULL DEFAULT 0,
+		started_at INTEGER NOT NULL,
+		finished_at INTEGER
+	);
+	CREATE INDEX idx_agent_runs_owner_started ON agent_runs (owner_id, started_at DESC);
+	`,
+	// 2: stable sticky card keys (see StickyPolicy); existing cards keep a key of their own
+	`
+	ALTER TABLE cards ADD COLUMN run_id TEXT NOT NULL DEFAULT '';
+	ALTER TABLE cards ADD COLUMN sticky_key TEXT NOT NULL DEFAULT '';
+	UPDATE cards SET sticky_key = 'legacy:' || id;
+	CREATE UNIQUE INDEX idx_cards_sticky ON cards (owner_id, sticky_key, source_node);
+	`,
+	// 3: feed pages are ordered by (updated_at, id)
+	`
+	DROP INDEX idx_cards_owner_updated;
+	CREATE INDEX idx_cards_owner_updated ON cards (owner_id, updated_at DESC, id DESC);
+	`,
+	// 4: card lifecycle (see CardPatch); pinned cards lead the feed
+	`
+	ALTER TABLE cards ADD COLUMN read_at INTEGER;
+	ALTER TABLE cards ADD COLUMN dismissed_at INTEGER;
+	ALTER TABLE cards ADD COLUMN pinned INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE cards ADD COLUMN expires_at INTEGER;
+	DROP INDEX idx_cards_owner_updated;
+	CREATE INDEX idx_cards_owner_feed ON cards (owner_id, pinned DESC, updated_at DESC, id DESC);
+	CREATE INDEX idx_cards_expires ON cards (expires_at) WHERE expires_at IS NOT NULL;
+	CREATE INDEX idx_cards_dismissed ON cards (dismissed_at) WHERE dismissed_at IS NOT NULL;
+	`,
+	// 5: card revisions (see CardRevision), deleted with their card
+	`
+	CREATE TABLE card_revisions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		card_id TEXT NOT NULL,
+		run_id TEXT NOT NULL DEFAULT '',
+		agent_version TEXT NOT NULL DEFAULT '',
+		card_type TEXT NOT NULL,
+		priority TEXT NOT NULL,
+		content TEXT NOT NULL DEFAULT '{}',
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
+	CREATE INDEX idx_card_revisions_card ON card_revisions (card_id, id DESC);
+	CREATE TRIGGER cards_delete_revisions AFTER DELETE ON cards BEGIN
+		DELETE FROM card_revisions WHERE card_id = old.id;
+	END;
+	`,
+	// 6: trips (see TripRecord), referenced by cards and agent runs
+	`
+	CREATE TABLE trips (
+		id TEXT PRIMARY KEY,
+		owner_id TEXT NOT NULL,
+		data TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
+	CREATE INDEX idx_trips_owner_updated ON trips (owner_id, updated_at DESC);
+	ALTER TABLE cards ADD COLUMN trip_id TEXT NOT NULL DEFAULT '';
+	CREATE INDEX idx_cards_owner_trip ON cards (owner_id, trip_id);
+	ALTER TABLE agent_runs ADD COLUMN trip_id TEXT NOT NULL DEFAULT '';
+	`,
+}
+
+// SQLiteStore is a FeedStore in a single SQLite file, for single-node and
+// offline deployments. The schema is created and upgraded when it is opened.
+// Key provisioning locks only serialize within this process, so a database
+// file must not be shared by several gateways.
+type SQLiteStore struct {
+	DB *sql.DB
+	// Keyring encrypts LiteLLM keys at rest. When nil, storing a key fails with
+	// ErrNoKeyring unless AllowPlaintextKeys is set.
+	Keyring            *Keyring
+	AllowPlaintextKeys bool
+	// Sticky decides which card UpsertCard updates
+	Sticky StickyPolicy
+
+	now      func() time.Time
+	keyLocks keyedMutex
+}
+
+// NewSQLiteStore opens (creating if needed) the database at path; ":memory:"
+// gives a private in-memory database
+func NewSQLiteStore(ctx context.Context, path string) (*SQLiteStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("sqlite path is required")
+	}
+	dsn := path
+	if path != ":memory:" {
+		q := url.Values{}
+		q.Add("_pragma", "busy_timeout(5000)")
+		q.Add("_pragma", "journal_mode(WAL)")
+		q.Add("_pragma", "synchronous(NORMAL)")
+		dsn = "file:" + path + "?" + q.Encode()
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite db: %w", err)
+	}
+	// SQLite has a single writer; one connection also keeps ":memory:" a single database
+	db.SetMaxOpenConns(1)
+
+	s := &SQLiteStore{DB: db, now: time.Now}
+	if err := s.migrate(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// migrate applies the sqliteSchema versions the database does not have yet
+func (s *SQLiteStore) migrate(ctx context.Context) error {
+	var version int
+	if err := s.DB.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("failed to read sqlite schema version: %w", err)
+	}
+	if version > len(sqliteSchema) {
+		return fmt.Errorf("sqlite schema version %d is newer than this build (%d)", version, len(sqliteSchema))
+	}
+	for v := version + 1; v <= len(sqliteSchema); v++ {
+		err := s.inTx(ctx, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, sqliteSchema[v-1]); err != nil {
+				return err
+			}
+			// PRAGMA takes no bind parameters
+			_, err := tx.ExecContext(ctx, fmt.Sprintf(`PRAGMA user_version = %d`, v))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply sqlite schema version %d: %w", v, err)
+		}
+		slog.InfoContext(ctx, "applied sqlite schema", "version", v)
+	}
+	return nil
+}
+
+// Ping checks that the database is reachable
+func (s *SQLiteStore) Ping(ctx context.Context) error {
+	return s.DB.PingContext(ctx)
+}
+
+// Close closes the database
+func (s *SQLiteStore) Close() error {
+	return s.DB.Close()
+}
+
+// UpsertCard inserts a card or updates the owner's card with the same source
+// node and sticky key, in a single statement, and records the content as the
+// run's revision like PostgresStore.UpsertCard
+func (s *SQLiteStore) UpsertCard(ctx context.Context, ownerID string, card *Card) error {
+	contentJSON, err := json.Marshal(card.Data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal content: %w", err)
+	}
+	now := s.now()
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO cards (id, owner_id, run_id, trip_id, sticky_key, card_type, priority, source_node, content, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (owner_id, sticky_key, source_node) DO UPDATE
+			SET content = excluded.content, priority = excluded.priority, card_type = excluded.card_type,
+			    run_id = excluded.run_id, trip_id = excluded.trip_id, updated_at = excluded.updated_at
+			RETURNING id
+		`, newUUID(), ownerID, card.RunID, card.TripID, s.Sticky.cardKey(card, now), card.CardType, card.Priority, card.SourceNode,
+			string(contentJSON), nanos(now), nanos(now)).Scan(&card.ID)
+		if err != nil {
+			return fmt.Errorf("failed to upsert card: %w", err)
+		}
+		if err := s.recordRevision(ctx, tx, card, contentJSON, now); err != nil {
+			return fmt.Errorf("failed to record card revision: %w", err)
+		}
+		return nil
+	})
+}
+
+// recordRevision is the SQLite version of recordRevision
+func (s *SQLiteStore) recordRevision(ctx context.Context, tx *sql.Tx, card *Card, content []byte, now time.Time) error {
+	var (
+		latestID                  int64
+		runID, cardType, priority string
+		latestContent             string
+	)
+	err := tx.QueryRowContext(ctx, `
+		SELECT id, run_id, card_type, priority, content FROM card_revisions
+		WHERE card_id = ? ORDER BY id DESC LIMIT 1
+	`, card.ID).Scan(&latestID, &runID, &cardType, &priority, &latestContent)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return err
+	case runID == card.RunID:
+		_, err := tx.ExecContext(ctx, `
+			UPDATE card_revisions
+			SET content = ?, card_type = ?, priority = ?, agent_version = ?, updated_at = ?
+			WHERE id = ?
+		`, string(content), card.CardType, card.Priority, card.AgentVersion, nanos(now), latestID)
+		return err
+	case cardType == card.CardType && priority == card.Priority && sameContent([]byte(latestContent), content):
+		return nil // The run left the card as it was
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO card_revisions (card_id, run_id, agent_version, card_type, priority, content, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, card.ID, card.RunID, card.AgentVersion, card.CardType, card.Priority, string(content), nanos(now), nanos(now)); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM card_revisions
+		WHERE card_id = ? AND id <= (
+			SELECT id FROM card_revisions WHERE card_id = ? ORDER BY id DESC LIMIT 1 OFFSET ?
+		)
+	`, card.ID, card.ID, maxCardRevisions)
+	return err
+}
+
+// CardHistory returns up to limit revisions of one of the owner's cards, newest first
+func (s *SQLiteStore) CardHistory(ctx context.Context, ownerID, cardID string, limit int) ([]*CardRevision, error) {
+	var exists bool
+	err := s.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM cards WHERE owner_id = ? AND id = ?)`, ownerID, cardID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, card_id, run_id, agent_version, card_type, priority, content, created_at, updated_at
+		FROM card_revisions
+		WHERE card_id = ?
+		ORDER BY id DESC
+		LIMIT ?
+	`, cardID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []*CardRevision{}
+	for rows.Next() {
+		var r CardRevision
+		var content string
+		var created, updated int64
+		if err := rows.Scan(&r.ID, &r.CardID, &r.RunID, &r.AgentVersion, &r.CardType, &r.Priority, &content, &created, &updated); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(content), &r.Data); err != nil {
+			continue // Skip bad data
+		}
+		r.CreatedAt, r.UpdatedAt = fromNanos(created), fromNanos(updated)
+		revisions = append(revisions, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read card history: %w", err)
+	}
+	return revisions, nil
+}
+
+// GetFeed returns a page of the owner's visible cards, pinned then newest first
+func (s *SQLiteStore) GetFeed(ctx context.Context, ownerID string, q FeedQuery) (*FeedPage, error) {
+	where, args, err := feedWhere(ownerID, q, s.now(), sqlitePlaceholder, sqliteTime)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT `+cardColumns+`
+		FROM cards
+		WHERE `+where+`
+		ORDER BY pinned DESC, updated_at DESC, id DESC
+		LIMIT `+strconv.Itoa(q.Limit+1), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	feed := []*Card{}
+	for rows.Next() {
+		c, err := scanSQLiteCard(rows)
+		if errors.Is(err, errBadContent) {
+			continue // Skip bad data
+		}
+		if err != nil {
+			return nil, err
+		}
+		feed = append(feed, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return pageOf(feed, q.Limit), nil
+}
+
+// DeleteFeed removes all cards for the owner
+func (s *SQLiteStore) DeleteFeed(ctx context.Context, ownerID string) error {
+	_, err := s.DB.ExecContext(ctx, `DELETE FROM cards WHERE owner_id = ?`, ownerID)
+	return err
+}
+
+// UpdateCard applies patch to one of the owner's cards and returns it
+func (s *SQLiteStore) UpdateCard(ctx context.Context, ownerID, id string, patch CardPatch) (*Card, error) {
+	set, args := cardPatchSet(patch, s.now(), sqlitePlaceholder, sqliteTime)
+	card, err := scanSQLiteCard(s.DB.QueryRowContext(ctx,
+		`UPDATE cards SET `+set+` WHERE owner_id = ? AND id = ? RETURNING `+cardColumns,
+		append(args, ownerID, id)...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil && !errors.Is(err, errBadContent) {
+		return nil, fmt.Errorf("failed to update card: %w", err)
+	}
+	return card, nil
+}
+
+// DeleteCard removes one of the owner's cards
+func (s *SQLiteStore) DeleteCard(ctx context.Context, ownerID, id string) error {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM cards WHERE owner_id = ? AND id = ?`, ownerID, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete card: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// PurgeCards deletes expired unpinned cards and cards dismissed before dismissedBefore
+func (s *SQLiteStore) PurgeCards(ctx context.Context, dismissedBefore time.Time) (int, error) {
+	res, err := s.DB.ExecContext(ctx, `
+		DELETE FROM cards
+		WHERE (NOT pinned AND expires_at <= ?) OR dismissed_at < ?
+	`, nanos(s.now()), nanos(dismissedBefore))
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge cards: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+// scanSQLiteCard is scanCard for SQLite's column types
+func scanSQLiteCard(row rowScanner) (*Card, error) {
+	var c Card
+	var content string
+	var updatedAt int64
+	var readAt, dismissedAt, expiresAt sql.NullInt64
+	if err := row.Scan(&c.ID, &c.RunID, &c.TripID, &c.CardType, &c.Priority, &c.SourceNode, &content, &updatedAt,
+		&c.Pinned, &readAt, &dismissedAt, &expiresAt); err != nil {
+		return nil, err
+	}
+	c.updatedAt = fromNanos(updatedAt)
+	c.Timestamp = c.updatedAt.Format(time.RFC3339)
+	c.ReadAt, c.DismissedAt, c.ExpiresAt = nullNanosPtr(readAt), nullNanosPtr(dismissedAt), nullNanosPtr(expiresAt)
+	if err := json.Unmarshal([]byte(content), &c.Data); err != nil {
+		c.Data = nil
+		return &c, errBadContent
+	}
+	return &c, nil
+}
+
+// MergeDeviceIntoUser links the device, moves its cards and trips and takes its
+// session, resolving sticky card conflicts like PostgresStore.MergeDeviceIntoUser
+func (s *SQLiteStore) MergeDeviceIntoUser(ctx context.Context, deviceID, deviceOwner, userID string) (*MergeResult, error) {
+	res := &MergeResult{}
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		// Device cards superseded by a newer (or equally new) user card
+		dropped, err := execCount(ctx, tx, `
+			DELETE FROM cards
+			WHERE owner_id = ? AND EXISTS (
+				SELECT 1 FROM cards u
+				WHERE u.owner_id = ? AND u.source_node = cards.source_node AND u.sticky_key = cards.sticky_key
+				AND u.updated_at >= cards.updated_at
+			)
+		`, deviceOwner, userID)
+		if err != nil {
+			return fmt.Errorf("failed to resolve device card conflicts: %w", err)
+		}
+		res.CardsDropped += dropped
+
+		// User cards superseded by a newer device card
+		dropped, err = execCount(ctx, tx, `
+			DELETE FROM cards
+			WHERE owner_id = ? AND EXISTS (
+				SELECT 1 FROM cards d
+				WHERE d.owner_id = ? AND d.source_node = cards.source_node AND d.sticky_key = cards.sticky_key
+				AND d.updated_at > cards.updated_at
+			)
+		`, userID, deviceOwner)
+		if err != nil {
+			return fmt.Errorf("failed to resolve user card conflicts: %w", err)
+		}
+		res.CardsDropped += dropped
+
+		res.CardsMoved, err = execCount(ctx, tx, `UPDATE cards SET owner_id = ? WHERE owner_id = ?`, userID, deviceOwner)
+		if err != nil {
+			return fmt.Errorf("failed to move device cards: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE trips SET owner_id = ? WHERE owner_id = ?`, userID, deviceOwner); err != nil {
+			return fmt.Errorf("failed to move device trips: %w", err)
+		}
+		var data string
+		var updated int64
+		err = tx.QueryRowContext(ctx, `DELETE FROM sessions WHERE id = ? RETURNING data, updated_at`, deviceOwner).Scan(&data, &updated)
+		switch {
+		case err == nil:
+			res.DeviceSession = &SessionRecord{ID: deviceOwner, Data: json.RawMessage(data), UpdatedAt: fromNanos(updated)}
+		case errors.Is(err, sql.ErrNoRows):
+		default:
+			return fmt.Errorf("failed to take device session: %w", err)
+		}
+
+		now := nanos(s.now())
+		linked, err := execCount(ctx, tx, `
+			UPDATE device_user_links
+			SET last_merged_at = ?, cards_moved = cards_moved + ?, cards_dropped = cards_dropped + ?
+			WHERE device_id = ? AND user_id = ?
+		`, now, res.CardsMoved, res.CardsDropped, deviceID, userID)
+		if err != nil {
+			return fmt.Errorf("failed to record device link: %w", err)
+		}
+		if linked == 0 {
+			res.FirstLink = true
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO device_user_links (device_id, user_id, linked_at, last_merged_at, cards_moved, cards_dropped)
+				VALUES (?, ?, ?, ?, ?, ?)
+			`, deviceID, userID, now, now, res.CardsMoved, res.CardsDropped)
+			if err != nil {
+				return fmt.Errorf("failed to record device link: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+const sqliteKeyColumns = `user_id, litellm_key, litellm_key_ciphertext, kek_id, key_name, status, created_at,
+	last_used_at, rotated_at, revoked_at, reconciled_at, max_budget`
+
+// GetUserLiteLLMKey returns the user's active key, or "" if there is none
+func (s *SQLiteStore) GetUserLiteLLMKey(ctx context.Context, userID string) (string, error) {
+	var plain, sealed, kekID sql.NullString
+	var lastUsedAt sql.NullInt64
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT litellm_key, litellm_key_ciphertext, kek_id, last_used_at FROM user_litellm_keys WHERE user_id = ? AND status = ?
+	`, userID, KeyStatusActive).Scan(&plain, &sealed, &kekID, &lastUsedAt)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to query user key: %w", err)
+	}
+	key, err := openKey(s.Keyring, plain, sealed, kekID)
+	if err != nil {
+		return "", fmt.Errorf("failed to read user key: %w", err)
+	}
+	if !lastUsedAt.Valid || s.now().Sub(fromNanos(lastUsedAt.Int64)) > touchInterval {
+		if err := s.TouchUserLiteLLMKey(ctx, userID); err != nil {
+			slog.WarnContext(ctx, "failed to touch LiteLLM key", "error", err)
+		}
+	}
+	return key, nil
+}
+
+// TouchUserLiteLLMKey records that the user's key was used
+func (s *SQLiteStore) TouchUserLiteLLMKey(ctx context.Context, userID string) error {
+	if _, err := s.DB.ExecContext(ctx, `UPDATE user_litellm_keys SET last_used_at = ? WHERE user_id = ?`, nanos(s.now()), userID); err != nil {
+		return fmt.Errorf("failed to update key last_used_at: %w", err)
+	}
+	return nil
+}
+
+// GetUserLiteLLMKeyRecord returns the full key record regardless of status (nil if none)
+func (s *SQLiteStore) GetUserLiteLLMKeyRecord(ctx context.Context, userID string) (*UserLiteLLMKey, error) {
+	row := s.DB.QueryRowContext(ctx, `SELECT `+sqliteKeyColumns+` FROM user_litellm_keys WHERE user_id = ?`, userID)
+	rec, err := s.scanKeyRecord(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user key: %w", err)
+	}
+	return rec, nil
+}
+
+// ListUserLiteLLMKeys returns all key records with the given status
+func (s *SQLiteStore) ListUserLiteLLMKeys(ctx context.Context, status string) ([]*UserLiteLLMKey, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT `+sqliteKeyColumns+` FROM user_litellm_keys WHERE status = ? ORDER BY user_id`, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list user keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []*UserLiteLLMKey{}
+	for rows.Next() {
+		rec, err := s.scanKeyRecord(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user key: %w", err)
+		}
+		keys = append(keys, rec)
+	}
+	return keys, rows.Err()
+}
+
+func (s *SQLiteStore) scanKeyRecord(row rowScanner) (*UserLiteLLMKey, error) {
+	var rec UserLiteLLMKey
+	var plain, sealed, kekID sql.NullString
+	var created int64
+	var lastUsed, rotated, revoked, reconciled sql.NullInt64
+	if err := row.Scan(&rec.UserID, &plain, &sealed, &kekID, &rec.KeyName, &rec.Status, &created,
+		&lastUsed, &rotated, &revoked, &reconciled, &rec.MaxBudget); err != nil {
+		return nil, err
+	}
+	key, err := openKey(s.Keyring, plain, sealed, kekID)
+	if err != nil {
+		return nil, err
+	}
+	rec.LiteLLMKey = key
+	rec.CreatedAt = fromNanos(created)
+	rec.LastUsedAt = nullNanosPtr(lastUsed)
+	rec.RotatedAt = nullNanosPtr(rotated)
+	rec.RevokedAt = nullNanosPtr(revoked)
+	rec.ReconciledAt = nullNanosPtr(reconciled)
+	return &rec, nil
+}
+
+// StoreUserLiteLLMKey saves (or reactivates) the user's key, leaving a revoked one alone
+func (s *SQLiteStore) StoreUserLiteLLMKey(ctx context.Context, userID, key, keyName string, maxBudget float64) error {
+	plain, sealed, kekID, err := sealKey(s.Keyring, s.AllowPlaintextKeys, key)
+	if err != nil {
+		return fmt.Errorf("failed to store user key: %w", err)
+	}
+	res, err := s.DB.ExecContext(ctx, `
+		INSERT INTO user_litellm_keys (user_id, litellm_key, litellm_key_ciphertext, kek_id, litellm_key_hash, key_name, max_budget, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE
+		SET litellm_key = excluded.litellm_key, litellm_key_ciphertext = excluded.litellm_key_ciphertext,
+		    kek_id = excluded.kek_id, litellm_key_hash = excluded.litellm_key_hash,
+		    key_name = excluded.key_name, max_budget = excluded.max_budget,
+		    status = 'active'
+		WHERE user_litellm_keys.status <> 'revoked'
+	`, userID, plain, sealed, kekID, KeyLookupHash(key), keyName, maxBudget, nanos(s.now()))
+	if err != nil {
+		return fmt.Errorf("failed to store user key: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrKeyRevoked
+	}
+	return nil
+}
+
+// UpdateUserLiteLLMKeyValue replaces the key value of an existing record and reactivates it
+func (s *SQLiteStore) UpdateUserLiteLLMKeyValue(ctx context.Context, userID, key string) error {
+	plain, sealed, kekID, err := sealKey(s.Keyring, s.AllowPlaintextKeys, key)
+	if err != nil {
+		return fmt.Errorf("failed to update user key: %w", err)
+	}
+	_, err = s.DB.ExecContext(ctx, `
+		UPDATE user_litellm_keys
+		SET litellm_key = ?, litellm_key_ciphertext = ?, kek_id = ?, litellm_key_hash = ?, rotated_at = ?,
+		    status = 'active', revoked_at = NULL
+		WHERE user_id = ?
+	`, plain, sealed, kekID, KeyLookupHash(key), nanos(s.now()), userID)
+	if err != nil {
+		return fmt.Errorf("failed to update user key: %w", err)
+	}
+	return nil
+}
+
+// SetUserLiteLLMKeyBudget stores the user's max budget
+func (s *SQLiteStore) SetUserLiteLLMKeyBudget(ctx context.Context, userID string, maxBudget float64) error {
+	if _, err := s.DB.ExecContext(ctx, `UPDATE user_litellm_keys SET max_budget = ? WHERE user_id = ?`, maxBudget, userID); err != nil {
+		return fmt.Errorf("failed to store budget: %w", err)
+	}
+	return nil
+}
+
+// SetUserLiteLLMKeyStatus changes the key's lifecycle status, stamping revoked_at on revocation
+func (s *SQLiteStore) SetUserLiteLLMKeyStatus(ctx context.Context, userID, status string) error {
+	query := `UPDATE user_litellm_keys SET status = ? WHERE user_id = ?`
+	args := []interface{}{status, userID}
+	if status == KeyStatusRevoked {
+		query = `UPDATE user_litellm_keys SET status = ?, revoked_at = ? WHERE user_id = ?`
+		args = []interface{}{status, nanos(s.now()), userID}
+	}
+	if _, err := s.DB.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("failed to mark key %s for %s: %w", status, userID, err)
+	}
+	return nil
+}
+
+// MarkUserLiteLLMKeyReconciled records that the key was found on the proxy
+func (s *SQLiteStore) MarkUserLiteLLMKeyReconciled(ctx context.Context, userID string) error {
+	if _, err := s.DB.ExecContext(ctx, `UPDATE user_litellm_keys SET reconciled_at = ? WHERE user_id = ?`, nanos(s.now()), userID); err != nil {
+		return fmt.Errorf("failed to mark key reconciled: %w", err)
+	}
+	return nil
+}
+
+// ReencryptLiteLLMKeys seals plaintext rows and re-wraps rows sealed with a
+// non-primary KEK, like PostgresStore.ReencryptLiteLLMKeys
+func (s *SQLiteStore) ReencryptLiteLLMKeys(ctx context.Context) (int, int, error) {
+	if s.Keyring == nil {
+		return 0, 0, fmt.Errorf("no keyring configured")
+	}
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT user_id, litellm_key, litellm_key_ciphertext, kek_id FROM user_litellm_keys
+		WHERE litellm_key IS NOT NULL OR (litellm_key_ciphertext IS NOT NULL AND kek_id IS NOT ?)
+	`, s.Keyring.PrimaryID())
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to list keys to re-encrypt: %w", err)
+	}
+	type row struct {
+		userID               string
+		plain, sealed, kekID sql.NullString
+	}
+	var stale []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.userID, &r.plain, &r.sealed, &r.kekID); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		stale = append(stale, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	rewrite := func(r row) error {
+		key, err := openKey(s.Keyring, r.plain, r.sealed, r.kekID)
+		if err != nil {
+			return err
+		}
+		_, sealed, kekID, err := sealKey(s.Keyring, s.AllowPlaintextKeys, key)
+		if err != nil {
+			return err
+		}
+		_, err = s.DB.ExecContext(ctx, `
+			UPDATE user_litellm_keys
+			SET litellm_key = NULL, litellm_key_ciphertext = ?, kek_id = ?, litellm_key_hash = ?
+			WHERE user_id = ?
+		`, sealed, kekID, KeyLookupHash(key), r.userID)
+		return err
+	}
+	rewritten, failed := 0, 0
+	for _, r := range stale {
+		if err := ctx.Err(); err != nil {
+			return rewritten, failed, err
+		}
+		if err := rewrite(r); err != nil {
+			failed++
+			slog.WarnContext(ctx, "failed to re-encrypt key", "user_id", r.userID, "error", err)
+			continue
+		}
+		rewritten++
+	}
+	return rewritten, failed, nil
+}
+
+// WithUserKeyLock serializes key provisioning for userID within this process
+func (s *SQLiteStore) WithUserKeyLock(ctx context.Context, userID string, fn func(ctx context.Context) error) error {
+	unlock := s.keyLocks.lock(userID)
+	defer unlock()
+	return fn(ctx)
+}
+
+// RecordUsage appends a usage event
+func (s *SQLiteStore) RecordUsage(ctx context.Context, ev *UsageEvent) error {
+	_, err := s.DB.ExecContext(ctx, `
+		INSERT INTO usage_events (user_id, source, model, prompt_tokens, completion_tokens, total_tokens, cost_usd, cost_estimated, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, ev.UserID, ev.Source, ev.Model, ev.PromptTokens, ev.CompletionTokens, ev.TotalTokens, ev.CostUSD, ev.CostEstimated, nanos(s.now()))
+	if err != nil {
+		return fmt.Errorf("failed to record usage: %w", err)
+	}
+	return nil
+}
+
+// GetUserUsage summarizes a user's usage since the given time, broken down by model
+func (s *SQLiteStore) GetUserUsage(ctx context.Context, userID string, since time.Time) (*UsageSummary, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT model, COUNT(*), COALESCE(SUM(prompt_tokens), 0), COALESCE(SUM(completion_tokens), 0),
+		       COALESCE(SUM(total_tokens), 0), COALESCE(SUM(cost_usd), 0.0)
+		FROM usage_events
+		WHERE user_id = ? AND created_at >= ?
+		GROUP BY model
+		ORDER BY model
+	`, userID, nanos(since))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query usage: %w", err)
+	}
+	defer rows.Close()
+
+	summary := &UsageSummary{UserID: userID, Since: since, ByModel: []ModelUsage{}}
+	for rows.Next() {
+		var m ModelUsage
+		if err := rows.Scan(&m.Model, &m.Requests, &m.PromptTokens, &m.CompletionTokens, &m.TotalTokens, &m.CostUSD); err != nil {
+			return nil, fmt.Errorf("failed to scan usage: %w", err)
+		}
+		summary.Requests += m.Requests
+		summary.TotalTokens += m.TotalTokens
+		summary.CostUSD += m.CostUSD
+		summary.ByModel = append(summary.ByModel, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read usage: %w", err)
+	}
+	rows.Close()
+
+	var budget sql.NullFloat64
+	err = s.DB.QueryRowContext(ctx, `SELECT max_budget FROM user_litellm_keys WHERE user_id = ?`, userID).Scan(&budget)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to query budget: %w", err)
+	}
+	summary.MaxBudget = budget.Float64
+	return summary, nil
+}
+
+// GetUsageAggregate returns per-user usage since the given time, highest spend first
+func (s *SQLiteStore) GetUsageAggregate(ctx context.Context, since time.Time, limit int) ([]UserUsage, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT u.user_id, COUNT(*), COALESCE(SUM(u.total_tokens), 0), COALESCE(SUM(u.cost_usd), 0.0),
+		       COALESCE(MAX(k.max_budget), 0.0)
+		FROM usage_events u
+		LEFT JOIN user_litellm_keys k ON k.user_id = u.user_id
+		WHERE u.created_at >= ?
+		GROUP BY u.user_id
+		ORDER BY 4 DESC
+		LIMIT ?
+	`, nanos(since), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query usage aggregate: %w", err)
+	}
+	defer rows.Close()
+
+	report := []UserUsage{}
+	for rows.Next() {
+		var u UserUsage
+		if err := rows.Scan(&u.UserID, &u.Requests, &u.TotalTokens, &u.CostUSD, &u.MaxBudget); err != nil {
+			return nil, fmt.Errorf("failed to scan usage aggregate: %w", err)
+		}
+		report = append(report, u)
+	}
+	return report, rows.Err()
+}
+
+// SaveSession stores or replaces the session snapshot
+func (s *SQLiteStore) SaveSession(ctx context.Context, rec *SessionRecord) error {
+	_, err := s.DB.ExecContext(ctx, `
+		INSERT INTO sessions (id, data, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET data = excluded.data, updated_at = excluded.updated_at
+	`, rec.ID, string(rec.Data), nanos(s.now()))
+	if err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+	return nil
+}
+
+// LoadSession returns the stored session snapshot
+func (s *SQLiteStore) LoadSession(ctx context.Context, id string) (*SessionRecord, error) {
+	var data string
+	var updated int64
+	err := s.DB.QueryRowContext(ctx, `SELECT data, updated_at FROM sessions WHERE id = ?`, id).Scan(&data, &updated)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session: %w", err)
+	}
+	return &SessionRecord{ID: id, Data: json.RawMessage(data), UpdatedAt: fromNanos(updated)}, nil
+}
+
+// DeleteSession removes the session snapshot, if any
+func (s *SQLiteStore) DeleteSession(ctx context.Context, id string) error {
+	if _, err := s.DB.ExecContext(ctx, `DELETE FROM sessions WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	return nil
+}
+
+// StartRun records run as running
+func (s *SQLiteStore) StartRun(ctx context.Context, run *AgentRun) error {
+	if run.ID == "" {
+		return fmt.Errorf("run id is required")
+	}
+	run.Status, run.StartedAt = RunStatusRunning, s.now()
+	_, err := s.DB.ExecContext(ctx, `
+		INSERT INTO agent_runs (id, owner_id, agent, source, trip_id, status, started_at) VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, run.ID, run.OwnerID, run.Agent, run.Source, run.TripID, run.Status, nanos(run.StartedAt))
+	if err != nil {
+		return fmt.Errorf("failed to record run: %w", err)
+	}
+	return nil
+}
+
+// FinishRun stores the run's outcome
+func (s *SQLiteStore) FinishRun(ctx context.Context, run *AgentRun) error {
+	now := s.now()
+	res, err := s.DB.ExecContext(ctx, `
+		UPDATE agent_runs SET status = ?, error = ?, total_tokens = ?, finished_at = ? WHERE id = ?
+	`, run.Status, run.Error, run.TotalTokens, nanos(now), run.ID)
+	if err != nil {
+		return fmt.Errorf("failed to finish run: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	run.FinishedAt = &now
+	return nil
+}
+
+// ListRuns returns the owner's most recent runs first
+func (s *SQLiteStore) ListRuns(ctx context.Context, ownerID string, limit int) ([]*AgentRun, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, owner_id, agent, source, trip_id, status, error, total_tokens, started_at, finished_at
+		FROM agent_runs
+		WHERE owner_id = ?
+		ORDER BY started_at DESC, rowid DESC
+		LIMIT ?
+	`, ownerID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list runs: %w", err)
+	}
+	defer rows.Close()
+
+	runs := []*AgentRun{}
+	for rows.Next() {
+		var run AgentRun
+		var started int64
+		var finished sql.NullInt64
+		if err := rows.Scan(&run.ID, &run.OwnerID, &run.Agent, &run.Source, &run.TripID, &run.Status, &run.Error,
+			&run.TotalTokens, &started, &finished); err != nil {
+			return nil, fmt.Errorf("failed to scan run: %w", err)
+		}
+		run.StartedAt = fromNanos(started)
+		run.FinishedAt = nullNanosPtr(finished)
+		runs = append(runs, &run)
+	}
+	return runs, rows.Err()
+}
+
+// CreateTrip stores a new trip
+func (s *SQLiteStore) CreateTrip(ctx context.Context, rec *TripRecord) error {
+	rec.ID = newUUID()
+	rec.CreatedAt = s.now()
+	rec.UpdatedAt = rec.CreatedAt
+	_, err := s.DB.ExecContext(ctx, `
+		INSERT INTO trips (id, owner_id, data, created_at, updated_at) VALUES (?, ?, ?, ?, ?)
+	`, rec.ID, rec.OwnerID, string(rec.Data), nanos(rec.CreatedAt), nanos(rec.UpdatedAt))
+	if err != nil {
+		return fmt.Errorf("failed to create trip: %w", err)
+	}
+	return nil
+}
+
+// UpdateTrip replaces one of the owner's trips
+func (s *SQLiteStore) UpdateTrip(ctx context.Context, rec *TripRecord) error {
+	now := s.now()
+	var created int64
+	err := s.DB.QueryRowContext(ctx, `
+		UPDATE trips SET data = ?, updated_at = ? WHERE owner_id = ? AND id = ? RETURNING created_at
+	`, string(rec.Data), nanos(now), rec.OwnerID, rec.ID).Scan(&created)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update trip: %w", err)
+	}
+	rec.CreatedAt, rec.UpdatedAt = fromNanos(created), now
+	return nil
+}
+
+// LoadTrip returns one of the owner's trips
+func (s *SQLiteStore) LoadTrip(ctx context.Context, ownerID, id string) (*TripRecord, error) {
+	rec, err := scanSQLiteTrip(s.DB.QueryRowContext(ctx, `
+		SELECT id, owner_id, data, created_at, updated_at FROM trips WHERE owner_id = ? AND id = ?
+	`, ownerID, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load trip: %w", err)
+	}
+	return rec, nil
+}
+
+// ListTrips returns the owner's most recently updated trips first
+func (s *SQLiteStore) ListTrips(ctx context.Context, ownerID string, limit int) ([]*TripRecord, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, owner_id, data, created_at, updated_at
+		FROM trips
+		WHERE owner_id = ?
+		ORDER BY updated_at DESC, id DESC
+		LIMIT ?
+	`, ownerID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list trips: %w", err)
+	}
+	defer rows.Close()
+
+	trips := []*TripRecord{}
+	for rows.Next() {
+		rec, err := scanSQLiteTrip(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan trip: %w", err)
+		}
+		trips = append(trips, rec)
+	}
+	return trips, rows.Err()
+}
+
+// DeleteTrip removes one of the owner's trips and its cards
+func (s *SQLiteStore) DeleteTrip(ctx context.Context, ownerID, id string) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `DELETE FROM trips WHERE owner_id = ? AND id = ?`, ownerID, id)
+		if err != nil {
+			return fmt.Errorf("failed to delete trip: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrNotFound
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM cards WHERE owner_id = ? AND trip_id = ?`, ownerID, id); err != nil {
+			return fmt.Errorf("failed to delete trip cards: %w", err)
+		}
+		return nil
+	})
+}
+
+func scanSQLiteTrip(row rowScanner) (*TripRecord, error) {
+	var rec TripRecord
+	var data string
+	var created, updated int64
+	if err := row.Scan(&rec.ID, &rec.OwnerID, &data, &created, &updated); err != nil {
+		return nil, err
+	}
+	rec.Data = json.RawMessage(data)
+	rec.CreatedAt, rec.UpdatedAt = fromNanos(created), fromNanos(updated)
+	return &rec, nil
+}
+
+func (s *SQLiteStore) inTx(ctx context.Context, fn func(*sql.Tx) error) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// nanos is how SQLiteStore stores timestamps
+func sqlitePlaceholder(int) string { return "?" }
+
+func sqliteTime(t time.Time) interface{} { return nanos(t) }
+
+func nanos(t time.Time) int64 {
+	return t.UnixNano()
+}
+
+func fromNanos(n int64) time.Time {
+	return time.Unix(0, n).UTC()
+}
+
+func nullNanosPtr(n sql.NullInt64) *time.Time {
+	if !n.Valid {
+		return nil
+	}
+	t := fromNanos(n.Int64)
+	return &t
+}
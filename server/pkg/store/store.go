@@ -0,0 +1,204 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"time"
+)
+
+// DefaultStickyWindow is the StickyPolicy window used when none is configured
+const DefaultStickyWindow = 60 * time.Minute
+
+// StickyPolicy decides which card an update lands on. Every card has a sticky
+// key, unique per owner and source node, and an update with an existing key
+// replaces that card instead of adding one. Updates from an agent run share
+// the run's key, so a run keeps one card per node; updates without a run ID
+// share a key per Window, in fixed windows as cut by time.Time.Truncate. A
+// card's own StickyKey overrides both. Cards of a trip outside a run get
+// windows of their own.
+type StickyPolicy struct {
+	Window time.Duration
+}
+
+// cardKey returns the sticky key an update of card at t lands on
+func (p StickyPolicy) cardKey(card *Card, t time.Time) string {
+	if card.StickyKey != "" {
+		return card.StickyKey
+	}
+	if card.TripID != "" && card.RunID == "" {
+		return "trip:" + card.TripID + ":" + p.Key("", t)
+	}
+	return p.Key(card.RunID, t)
+}
+
+// Key returns the sticky key for an update from runID (may be empty) at t
+func (p StickyPolicy) Key(runID string, t time.Time) string {
+	if runID != "" {
+		return "run:" + runID
+	}
+	window := p.Window
+	if window <= 0 {
+		window = DefaultStickyWindow
+	}
+	return "window:" + t.UTC().Truncate(window).Format(time.RFC3339)
+}
+
+// ErrNotFound reports a missing session, run, card or trip
+var ErrNotFound = errors.New("not found")
+
+// CardPatch changes a card's lifecycle state; nil fields are left as they are.
+// Read and Dismissed stamp the current time (keeping an earlier stamp) or clear
+// it. A zero ExpiresAt clears the expiry.
+type CardPatch struct {
+	Read      *bool
+	Dismissed *bool
+	Pinned    *bool
+	ExpiresAt *time.Time
+}
+
+// FeedStore is the gateway's persistence: feed cards, per-user LiteLLM keys,
+// usage, device links, conversation sessions and agent runs. PostgresStore is
+// the production backend, SQLiteStore serves single-node and offline use and
+// MemoryStore keeps everything in process (tests, throwaway development).
+type FeedStore interface {
+	// UpsertCard inserts a card or atomically updates the owner's card with
+	// the same source node and sticky key (see StickyPolicy). card.ID is set.
+	UpsertCard(ctx context.Context, ownerID string, card *Card) error
+	// GetFeed returns a page of the owner's cards, pinned then newest first,
+	// leaving out expired cards and, unless q.Since is set, dismissed ones.
+	// q.Limit must be positive; a cursor that GetFeed did not issue gives
+	// ErrInvalidCursor.
+	GetFeed(ctx context.Context, ownerID string, q FeedQuery) (*FeedPage, error)
+	DeleteFeed(ctx context.Context, ownerID string) error
+	// CardHistory returns up to limit revisions of one of the owner's cards,
+	// newest first; ErrNotFound when the owner has no such card
+	CardHistory(ctx context.Context, ownerID, cardID string, limit int) ([]*CardRevision, error)
+	// UpdateCard applies patch to one of the owner's cards, bumping its
+	// update time, and returns it; ErrNotFound when the owner has no such card
+	UpdateCard(ctx context.Context, ownerID, id string, patch CardPatch) (*Card, error)
+	// DeleteCard removes one of the owner's cards; ErrNotFound when there is none
+	DeleteCard(ctx context.Context, ownerID, id string) error
+	// PurgeCards deletes expired unpinned cards and cards dismissed before
+	// dismissedBefore, returning how many were removed
+	PurgeCards(ctx context.Context, dismissedBefore time.Time) (int, error)
+	MergeDeviceIntoUser(ctx context.Context, deviceID, deviceOwner, userID string) (*MergeResult, error)
+
+	KeyStore
+
+	RecordUsage(ctx context.Context, ev *UsageEvent) error
+	GetUserUsage(ctx context.Context, userID string, since time.Time) (*UsageSummary, error)
+	GetUsageAggregate(ctx context.Context, since time.Time, limit int) ([]UserUsage, error)
+
+	SaveSession(ctx context.Context, rec *SessionRecord) error
+	// LoadSession returns ErrNotFound when no session is stored under id
+	LoadSession(ctx context.Context, id string) (*SessionRecord, error)
+	DeleteSession(ctx context.Context, id string) error
+
+	// StartRun records run as running; run.ID must be set
+	StartRun(ctx context.Context, run *AgentRun) error
+	// FinishRun stores run's final status, error and token count
+	FinishRun(ctx context.Context, run *AgentRun) error
+	// ListRuns returns the owner's most recent runs first
+	ListRuns(ctx context.Context, ownerID string, limit int) ([]*AgentRun, error)
+
+	// CreateTrip stores a new trip of rec.OwnerID; rec.ID and its timestamps are set
+	CreateTrip(ctx context.Context, rec *TripRecord) error
+	// UpdateTrip replaces one of rec.OwnerID's trips; ErrNotFound when there is none
+	UpdateTrip(ctx context.Context, rec *TripRecord) error
+	// LoadTrip returns one of the owner's trips; ErrNotFound when there is none
+	LoadTrip(ctx context.Context, ownerID, id string) (*TripRecord, error)
+	// ListTrips returns the owner's most recently updated trips first
+	ListTrips(ctx context.Context, ownerID string, limit int) ([]*TripRecord, error)
+	// DeleteTrip removes one of the owner's trips and its cards; ErrNotFound
+	// when there is none
+	DeleteTrip(ctx context.Context, ownerID, id string) error
+
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// KeyStore persists per-user LiteLLM keys. KeyManager and KeyProvisioner use it.
+type KeyStore interface {
+	KeyRepository
+	TouchUserLiteLLMKey(ctx context.Context, userID string) error
+	// GetUserLiteLLMKeyRecord returns the full record regardless of status (nil if none)
+	GetUserLiteLLMKeyRecord(ctx context.Context, userID string) (*UserLiteLLMKey, error)
+	ListUserLiteLLMKeys(ctx context.Context, status string) ([]*UserLiteLLMKey, error)
+	UpdateUserLiteLLMKeyValue(ctx context.Context, userID, key string) error
+	SetUserLiteLLMKeyBudget(ctx context.Context, userID string, maxBudget float64) error
+	SetUserLiteLLMKeyStatus(ctx context.Context, userID, status string) error
+	MarkUserLiteLLMKeyReconciled(ctx context.Context, userID string) error
+	// ReencryptLiteLLMKeys seals stored keys with the primary KEK and returns
+	// the number of rows rewritten and of rows that failed; a failing row does
+	// not stop the pass
+	ReencryptLiteLLMKeys(ctx context.Context) (rewritten, failed int, err error)
+}
+
+// SessionRecord is a persisted conversation session. Data is the session's
+// JSON encoding (see session.Session.Snapshot); stores treat it as opaque.
+type SessionRecord struct {
+	ID        string          `json:"id"`
+	Data      json.RawMessage `json:"data"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// TripRecord is a persisted trip. Data is the trip's JSON encoding (see
+// trip.Trip); stores treat it as opaque.
+type TripRecord struct {
+	ID        string          `json:"id"`
+	OwnerID   string          `json:"owner_id"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// Agent run states stored in agent_runs.status
+const (
+	RunStatusRunning   = "running"
+	RunStatusSucceeded = "succeeded"
+	RunStatusFailed    = "failed"
+	RunStatusCancelled = "cancelled"
+)
+
+// maxCardRevisions is how many revisions are kept per card
+const maxCardRevisions = 50
+
+// CardRevision is one version of a card: the content an agent run left it
+// showing (or, outside runs, the last update in its sticky window). UpsertCard
+// keeps one revision per run and adds one only when a run changes the content.
+type CardRevision struct {
+	ID           int64                  `json:"id"`
+	CardID       string                 `json:"card_id"`
+	RunID        string                 `json:"run_id,omitempty"`
+	AgentVersion string                 `json:"agent_version,omitempty"`
+	CardType     string                 `json:"card_type"`
+	Priority     string                 `json:"priority"`
+	Data         map[string]interface{} `json:"data"`
+	CreatedAt    time.Time              `json:"created_at"`
+	UpdatedAt    time.Time              `json:"updated_at"`
+}
+
+// sameContent reports whether two JSON documents hold the same value
+func sameContent(a, b []byte) bool {
+	var va, vb interface{}
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+// AgentRun is one execution of a fastgraph agent
+type AgentRun struct {
+	ID          string     `json:"id"`
+	OwnerID     string     `json:"owner_id"`
+	Agent       string     `json:"agent"`
+	Source      string     `json:"source"` // chat | schedule
+	TripID      string     `json:"trip_id,omitempty"`
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	TotalTokens int        `json:"total_tokens"`
+	StartedAt   time.Time  `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
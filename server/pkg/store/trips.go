@@ -0,0 +1,130 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// CreateTrip stores a new trip
+func (s *PostgresStore) CreateTrip(ctx context.Context, rec *TripRecord) (err error) {
+	ctx, finish := startQuery(ctx, "create_trip")
+	defer finish(&err)
+	query := `
+		INSERT INTO trips (owner_id, data, created_at, updated_at)
+		VALUES ($1, $2, NOW(), NOW())
+		RETURNING id, created_at, updated_at
+	`
+	if err := s.DB.QueryRowContext(ctx, query, rec.OwnerID, []byte(rec.Data)).Scan(&rec.ID, &rec.CreatedAt, &rec.UpdatedAt); err != nil {
+		return fmt.Errorf("failed to create trip: %w", err)
+	}
+	return nil
+}
+
+// UpdateTrip replaces one of the owner's trips
+func (s *PostgresStore) UpdateTrip(ctx context.Context, rec *TripRecord) (err error) {
+	ctx, finish := startQuery(ctx, "update_trip")
+	defer finish(&err)
+	if !isUUID(rec.ID) {
+		return ErrNotFound
+	}
+	query := `
+		UPDATE trips SET data = $1, updated_at = NOW()
+		WHERE owner_id = $2 AND id = $3
+		RETURNING created_at, updated_at
+	`
+	err = s.DB.QueryRowContext(ctx, query, []byte(rec.Data), rec.OwnerID, rec.ID).Scan(&rec.CreatedAt, &rec.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update trip: %w", err)
+	}
+	return nil
+}
+
+// LoadTrip returns one of the owner's trips
+func (s *PostgresStore) LoadTrip(ctx context.Context, ownerID, id string) (_ *TripRecord, err error) {
+	ctx, finish := startQuery(ctx, "load_trip")
+	defer finish(&err)
+	if !isUUID(id) {
+		return nil, ErrNotFound
+	}
+	rec, err := scanTrip(s.DB.QueryRowContext(ctx, `
+		SELECT id, owner_id, data, created_at, updated_at FROM trips WHERE owner_id = $1 AND id = $2
+	`, ownerID, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load trip: %w", err)
+	}
+	return rec, nil
+}
+
+// ListTrips returns the owner's most recently updated trips first
+func (s *PostgresStore) ListTrips(ctx context.Context, ownerID string, limit int) (_ []*TripRecord, err error) {
+	ctx, finish := startQuery(ctx, "list_trips")
+	defer finish(&err)
+	query := `
+		SELECT id, owner_id, data, created_at, updated_at
+		FROM trips
+		WHERE owner_id = $1
+		ORDER BY updated_at DESC, id DESC
+		LIMIT $2
+	`
+	rows, err := s.DB.QueryContext(ctx, query, ownerID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list trips: %w", err)
+	}
+	defer rows.Close()
+
+	trips := []*TripRecord{}
+	for rows.Next() {
+		rec, err := scanTrip(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan trip: %w", err)
+		}
+		trips = append(trips, rec)
+	}
+	return trips, rows.Err()
+}
+
+// DeleteTrip removes one of the owner's trips and its cards
+func (s *PostgresStore) DeleteTrip(ctx context.Context, ownerID, id string) (err error) {
+	ctx, finish := startQuery(ctx, "delete_trip")
+	defer finish(&err)
+	if !isUUID(id) {
+		return ErrNotFound
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin trip delete: %w", err)
+	}
+	defer tx.Rollback() // No-op after commit
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM trips WHERE owner_id = $1 AND id = $2`, ownerID, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete trip: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM cards WHERE owner_id = $1 AND trip_id = $2`, ownerID, id); err != nil {
+		return fmt.Errorf("failed to delete trip cards: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit trip delete: %w", err)
+	}
+	return nil
+}
+
+func scanTrip(row rowScanner) (*TripRecord, error) {
+	var rec TripRecord
+	var data []byte
+	if err := row.Scan(&rec.ID, &rec.OwnerID, &data, &rec.CreatedAt, &rec.UpdatedAt); err != nil {
+		return nil, err
+	}
+	rec.Data = data
+	return &rec, nil
+}
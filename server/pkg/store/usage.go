@@ -0,0 +1,147 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// UsageEvent is a single metered LLM call or agent run
+type UsageEvent struct {
+	UserID           string    `json:"user_id"`
+	Source           string    `json:"source"` // chat | summary | agent_run
+	Model            string    `json:"model"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	TotalTokens      int       `json:"total_tokens"`
+	CostUSD          float64   `json:"cost_usd"`
+	CostEstimated    bool      `json:"cost_estimated"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// ModelUsage aggregates usage for a single model
+type ModelUsage struct {
+	Model            string  `json:"model"`
+	Requests         int     `json:"requests"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+}
+
+// UsageSummary aggregates a user's usage since a point in time
+type UsageSummary struct {
+	UserID      string       `json:"user_id"`
+	Since       time.Time    `json:"since"`
+	Requests    int          `json:"requests"`
+	TotalTokens int          `json:"total_tokens"`
+	CostUSD     float64      `json:"cost_usd"`
+	MaxBudget   float64      `json:"max_budget"` // 0 when the user has no LiteLLM key
+	ByModel     []ModelUsage `json:"by_model"`
+}
+
+// UserUsage is one row of the admin aggregate report
+type UserUsage struct {
+	UserID      string  `json:"user_id"`
+	Requests    int     `json:"requests"`
+	TotalTokens int     `json:"total_tokens"`
+	CostUSD     float64 `json:"cost_usd"`
+	MaxBudget   float64 `json:"max_budget"`
+}
+
+// BudgetPeriodStart returns the start of the current monthly budget window (UTC),
+// matching the "monthly" budget_duration set on generated LiteLLM keys
+func BudgetPeriodStart(now time.Time) time.Time {
+	now = now.UTC()
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// RecordUsage appends a usage event
+func (s *PostgresStore) RecordUsage(ctx context.Context, ev *UsageEvent) (err error) {
+	ctx, finish := startQuery(ctx, "record_usage")
+	defer finish(&err)
+	query := `
+		INSERT INTO usage_events (user_id, source, model, prompt_tokens, completion_tokens, total_tokens, cost_usd, cost_estimated, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW())
+	`
+	_, err = s.DB.ExecContext(ctx, query, ev.UserID, ev.Source, ev.Model,
+		ev.PromptTokens, ev.CompletionTokens, ev.TotalTokens, ev.CostUSD, ev.CostEstimated)
+	if err != nil {
+		return fmt.Errorf("failed to record usage: %w", err)
+	}
+	return nil
+}
+
+// GetUserUsage summarizes a user's usage since the given time, broken down by model
+func (s *PostgresStore) GetUserUsage(ctx context.Context, userID string, since time.Time) (_ *UsageSummary, err error) {
+	ctx, finish := startQuery(ctx, "get_user_usage")
+	defer finish(&err)
+	query := `
+		SELECT model, COUNT(*), COALESCE(SUM(prompt_tokens), 0), COALESCE(SUM(completion_tokens), 0),
+		       COALESCE(SUM(total_tokens), 0), COALESCE(SUM(cost_usd), 0)::float8
+		FROM usage_events
+		WHERE user_id = $1 AND created_at >= $2
+		GROUP BY model
+		ORDER BY model
+	`
+	rows, err := s.DB.QueryContext(ctx, query, userID, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query usage: %w", err)
+	}
+	defer rows.Close()
+
+	summary := &UsageSummary{UserID: userID, Since: since, ByModel: []ModelUsage{}}
+	for rows.Next() {
+		var m ModelUsage
+		if err := rows.Scan(&m.Model, &m.Requests, &m.PromptTokens, &m.CompletionTokens, &m.TotalTokens, &m.CostUSD); err != nil {
+			return nil, fmt.Errorf("failed to scan usage: %w", err)
+		}
+		summary.Requests += m.Requests
+		summary.TotalTokens += m.TotalTokens
+		summary.CostUSD += m.CostUSD
+		summary.ByModel = append(summary.ByModel, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read usage: %w", err)
+	}
+
+	var budget sql.NullFloat64
+	err = s.DB.QueryRowContext(ctx, `SELECT max_budget::float8 FROM user_litellm_keys WHERE user_id = $1`, userID).Scan(&budget)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to query budget: %w", err)
+	}
+	summary.MaxBudget = budget.Float64
+	return summary, nil
+}
+
+// GetUsageAggregate returns per-user usage since the given time, highest spend first
+func (s *PostgresStore) GetUsageAggregate(ctx context.Context, since time.Time, limit int) (_ []UserUsage, err error) {
+	ctx, finish := startQuery(ctx, "get_usage_aggregate")
+	defer finish(&err)
+	query := `
+		SELECT u.user_id, COUNT(*), COALESCE(SUM(u.total_tokens), 0), COALESCE(SUM(u.cost_usd), 0)::float8,
+		       COALESCE(MAX(k.max_budget), 0)::float8
+		FROM usage_events u
+		LEFT JOIN user_litellm_keys k ON k.user_id = u.user_id
+		WHERE u.created_at >= $1
+		GROUP BY u.user_id
+		ORDER BY 4 DESC
+		LIMIT $2
+	`
+	rows, err := s.DB.QueryContext(ctx, query, since, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query usage aggregate: %w", err)
+	}
+	defer rows.Close()
+
+	report := []UserUsage{}
+	for rows.Next() {
+		var u UserUsage
+		if err := rows.Scan(&u.UserID, &u.Requests, &u.TotalTokens, &u.CostUSD, &u.MaxBudget); err != nil {
+			return nil, fmt.Errorf("failed to scan usage aggregate: %w", err)
+		}
+		report = append(report, u)
+	}
+	return report, rows.Err()
+}
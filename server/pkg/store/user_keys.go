@@ -1,32 +1,47 @@
 package store
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
-	"encoding/json"
 	"fmt"
-	"net/http"
+	"log/slog"
 	"time"
 )
 
+// Key lifecycle states stored in user_litellm_keys.status
+const (
+	KeyStatusActive  = "active"
+	KeyStatusRevoked = "revoked"
+	KeyStatusMissing = "missing" // Deleted on the proxy side, detected by reconciliation
+)
+
+// touchInterval throttles last_used_at writes so hot users don't update the row on every chat
+const touchInterval = 5 * time.Minute
+
 // UserLiteLLMKey represents the mapping between a user and their LiteLLM virtual key
 type UserLiteLLMKey struct {
-	UserID     string
-	LiteLLMKey string
-	KeyName    string
-	CreatedAt  time.Time
-	LastUsedAt *time.Time
-	MaxBudget  float64
+	UserID       string     `json:"user_id"`
+	LiteLLMKey   string     `json:"-"`
+	KeyName      string     `json:"key_name"`
+	Status       string     `json:"status"`
+	CreatedAt    time.Time  `json:"created_at"`
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty"`
+	RotatedAt    *time.Time `json:"rotated_at,omitempty"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"`
+	ReconciledAt *time.Time `json:"reconciled_at,omitempty"`
+	MaxBudget    float64    `json:"max_budget"`
 }
 
-// GetUserLiteLLMKey retrieves a user's LiteLLM key from the database
-func (s *PostgresStore) GetUserLiteLLMKey(ctx context.Context, userID string) (string, error) {
-	var key string
+// GetUserLiteLLMKey retrieves a user's active LiteLLM key from the database.
+// Revoked or missing keys are reported as not found so the caller provisions a new one.
+func (s *PostgresStore) GetUserLiteLLMKey(ctx context.Context, userID string) (_ string, err error) {
+	ctx, finish := startQuery(ctx, "get_user_litellm_key")
+	defer finish(&err)
+	var plain, sealed, kekID sql.NullString
 	var lastUsedAt sql.NullTime
 
-	query := `SELECT litellm_key, last_used_at FROM user_litellm_keys WHERE user_id = $1`
-	err := s.DB.QueryRowContext(ctx, query, userID).Scan(&key, &lastUsedAt)
+	query := `SELECT litellm_key, litellm_key_ciphertext, kek_id, last_used_at FROM user_litellm_keys WHERE user_id = $1 AND status = $2`
+	err = s.DB.QueryRowContext(ctx, query, userID, KeyStatusActive).Scan(&plain, &sealed, &kekID, &lastUsedAt)
 
 	if err == sql.ErrNoRows {
 		return "", nil // Key not found
@@ -34,72 +49,303 @@ func (s *PostgresStore) GetUserLiteLLMKey(ctx context.Context, userID string) (s
 	if err != nil {
 		return "", fmt.Errorf("failed to query user key: %w", err)
 	}
+	key, err := openKey(s.Keyring, plain, sealed, kekID)
+	if err != nil {
+		return "", fmt.Errorf("failed to read user key: %w", err)
+	}
 
-	// Update last_used_at
-	_, _ = s.DB.ExecContext(ctx, `UPDATE user_litellm_keys SET last_used_at = NOW() WHERE user_id = $1`, userID)
+	if !lastUsedAt.Valid || time.Since(lastUsedAt.Time) > touchInterval {
+		if err := s.TouchUserLiteLLMKey(ctx, userID); err != nil {
+			slog.WarnContext(ctx, "failed to touch LiteLLM key", "error", err)
+		}
+	}
 
 	return key, nil
 }
 
-// StoreUserLiteLLMKey saves a user's LiteLLM key to the database
-func (s *PostgresStore) StoreUserLiteLLMKey(ctx context.Context, userID, key, keyName string, maxBudget float64) error {
+// TouchUserLiteLLMKey records that the user's key was used
+func (s *PostgresStore) TouchUserLiteLLMKey(ctx context.Context, userID string) (err error) {
+	ctx, finish := startQuery(ctx, "touch_user_litellm_key")
+	defer finish(&err)
+	_, err = s.DB.ExecContext(ctx, `UPDATE user_litellm_keys SET last_used_at = NOW() WHERE user_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update key last_used_at: %w", err)
+	}
+	return nil
+}
+
+// GetUserLiteLLMKeyRecord returns the full key record regardless of status (nil if none)
+func (s *PostgresStore) GetUserLiteLLMKeyRecord(ctx context.Context, userID string) (_ *UserLiteLLMKey, err error) {
+	ctx, finish := startQuery(ctx, "get_user_litellm_key_record")
+	defer finish(&err)
 	query := `
-		INSERT INTO user_litellm_keys (user_id, litellm_key, key_name, max_budget, created_at)
-		VALUES ($1, $2, $3, $4, NOW())
+		SELECT user_id, litellm_key, litellm_key_ciphertext, kek_id, key_name, status, created_at,
+		       last_used_at, rotated_at, revoked_at, reconciled_at, max_budget::float8
+		FROM user_litellm_keys WHERE user_id = $1
+	`
+	rec, err := scanKeyRecord(s.Keyring, s.DB.QueryRowContext(ctx, query, userID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user key: %w", err)
+	}
+	return rec, nil
+}
+
+// ListUserLiteLLMKeys returns all key records with the given status
+func (s *PostgresStore) ListUserLiteLLMKeys(ctx context.Context, status string) (_ []*UserLiteLLMKey, err error) {
+	ctx, finish := startQuery(ctx, "list_user_litellm_keys")
+	defer finish(&err)
+	query := `
+		SELECT user_id, litellm_key, litellm_key_ciphertext, kek_id, key_name, status, created_at,
+		       last_used_at, rotated_at, revoked_at, reconciled_at, max_budget::float8
+		FROM user_litellm_keys WHERE status = $1 ORDER BY user_id
+	`
+	rows, err := s.DB.QueryContext(ctx, query, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list user keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []*UserLiteLLMKey{}
+	for rows.Next() {
+		rec, err := scanKeyRecord(s.Keyring, rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user key: %w", err)
+		}
+		keys = append(keys, rec)
+	}
+	return keys, rows.Err()
+}
+
+// rowScanner is satisfied by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanKeyRecord(keyring *Keyring, row rowScanner) (*UserLiteLLMKey, error) {
+	var rec UserLiteLLMKey
+	var plain, sealed, kekID sql.NullString
+	var lastUsed, rotated, revoked, reconciled sql.NullTime
+	if err := row.Scan(&rec.UserID, &plain, &sealed, &kekID, &rec.KeyName, &rec.Status, &rec.CreatedAt,
+		&lastUsed, &rotated, &revoked, &reconciled, &rec.MaxBudget); err != nil {
+		return nil, err
+	}
+	key, err := openKey(keyring, plain, sealed, kekID)
+	if err != nil {
+		return nil, err
+	}
+	rec.LiteLLMKey = key
+	rec.LastUsedAt = nullTimePtr(lastUsed)
+	rec.RotatedAt = nullTimePtr(rotated)
+	rec.RevokedAt = nullTimePtr(revoked)
+	rec.ReconciledAt = nullTimePtr(reconciled)
+	return &rec, nil
+}
+
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// StoreUserLiteLLMKey saves a user's LiteLLM key to the database, reactivating
+// a missing key. A revoked key is left alone and ErrKeyRevoked returned.
+func (s *PostgresStore) StoreUserLiteLLMKey(ctx context.Context, userID, key, keyName string, maxBudget float64) (err error) {
+	ctx, finish := startQuery(ctx, "store_user_litellm_key")
+	defer finish(&err)
+	plain, sealed, kekID, err := sealKey(s.Keyring, s.AllowPlaintextKeys, key)
+	if err != nil {
+		return fmt.Errorf("failed to store user key: %w", err)
+	}
+	query := `
+		INSERT INTO user_litellm_keys (user_id, litellm_key, litellm_key_ciphertext, kek_id, litellm_key_hash, key_name, max_budget, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
 		ON CONFLICT (user_id) DO UPDATE 
-		SET litellm_key = EXCLUDED.litellm_key, key_name = EXCLUDED.key_name
+		SET litellm_key = EXCLUDED.litellm_key, litellm_key_ciphertext = EXCLUDED.litellm_key_ciphertext,
+		    kek_id = EXCLUDED.kek_id, litellm_key_hash = EXCLUDED.litellm_key_hash,
+		    key_name = EXCLUDED.key_name, max_budget = EXCLUDED.max_budget,
+		    status = 'active'
+		WHERE user_litellm_keys.status <> 'revoked'
 	`
-	_, err := s.DB.ExecContext(ctx, query, userID, key, keyName, maxBudget)
+	res, err := s.DB.ExecContext(ctx, query, userID, plain, sealed, kekID, KeyLookupHash(key), keyName, maxBudget)
 	if err != nil {
 		return fmt.Errorf("failed to store user key: %w", err)
 	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrKeyRevoked
+	}
 	return nil
 }
 
-// GenerateLiteLLMKey calls the LiteLLM proxy to generate a new virtual key for a user
-func GenerateLiteLLMKey(proxyURL, masterKey, userID string, maxBudget float64) (string, string, error) {
-	keyName := fmt.Sprintf("user_%s", userID)
+// UpdateUserLiteLLMKeyValue replaces the key value of an existing record (e.g.
+// after rotation) and reactivates it
+func (s *PostgresStore) UpdateUserLiteLLMKeyValue(ctx context.Context, userID, key string) (err error) {
+	ctx, finish := startQuery(ctx, "update_user_litellm_key_value")
+	defer finish(&err)
+	plain, sealed, kekID, err := sealKey(s.Keyring, s.AllowPlaintextKeys, key)
+	if err != nil {
+		return fmt.Errorf("failed to update user key: %w", err)
+	}
+	query := `
+		UPDATE user_litellm_keys
+		SET litellm_key = $1, litellm_key_ciphertext = $2, kek_id = $3, litellm_key_hash = $4, rotated_at = NOW(),
+		    status = 'active', revoked_at = NULL
+		WHERE user_id = $5
+	`
+	if _, err := s.DB.ExecContext(ctx, query, plain, sealed, kekID, KeyLookupHash(key), userID); err != nil {
+		return fmt.Errorf("failed to update user key: %w", err)
+	}
+	return nil
+}
+
+// SetUserLiteLLMKeyBudget stores the user's max budget
+func (s *PostgresStore) SetUserLiteLLMKeyBudget(ctx context.Context, userID string, maxBudget float64) (err error) {
+	ctx, finish := startQuery(ctx, "set_user_litellm_key_budget")
+	defer finish(&err)
+	if _, err := s.DB.ExecContext(ctx, `UPDATE user_litellm_keys SET max_budget = $1 WHERE user_id = $2`, maxBudget, userID); err != nil {
+		return fmt.Errorf("failed to store budget: %w", err)
+	}
+	return nil
+}
 
-	payload := map[string]interface{}{
-		"user_id":         userID,
-		"key_alias":       keyName,
-		"max_budget":      maxBudget,
-		"budget_duration": "monthly",
-		"metadata": map[string]string{
-			"user_id": userID,
-		},
+// SetUserLiteLLMKeyStatus changes the key's lifecycle status, stamping revoked_at on revocation
+func (s *PostgresStore) SetUserLiteLLMKeyStatus(ctx context.Context, userID, status string) (err error) {
+	ctx, finish := startQuery(ctx, "set_user_litellm_key_status")
+	defer finish(&err)
+	query := `UPDATE user_litellm_keys SET status = $1 WHERE user_id = $2`
+	if status == KeyStatusRevoked {
+		query = `UPDATE user_litellm_keys SET status = $1, revoked_at = NOW() WHERE user_id = $2`
+	}
+	if _, err := s.DB.ExecContext(ctx, query, status, userID); err != nil {
+		return fmt.Errorf("failed to mark key %s for %s: %w", status, userID, err)
 	}
+	return nil
+}
 
-	body, err := json.Marshal(payload)
-	if err != nil {
-		return "", "", fmt.Errorf("failed to marshal request: %w", err)
+// MarkUserLiteLLMKeyReconciled records that the key was found on the proxy
+func (s *PostgresStore) MarkUserLiteLLMKeyReconciled(ctx context.Context, userID string) (err error) {
+	ctx, finish := startQuery(ctx, "mark_user_litellm_key_reconciled")
+	defer finish(&err)
+	if _, err := s.DB.ExecContext(ctx, `UPDATE user_litellm_keys SET reconciled_at = NOW() WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to mark key reconciled: %w", err)
 	}
+	return nil
+}
 
-	req, err := http.NewRequest("POST", proxyURL+"/key/generate", bytes.NewBuffer(body))
+// ReencryptLiteLLMKeys seals plaintext rows and re-wraps rows sealed with a
+// non-primary KEK. It is idempotent and returns the number of rows rewritten
+// and of rows that could not be (logged and left as they are).
+func (s *PostgresStore) ReencryptLiteLLMKeys(ctx context.Context) (_, _ int, err error) {
+	ctx, finish := startQuery(ctx, "reencrypt_litellm_keys")
+	defer finish(&err)
+	if s.Keyring == nil {
+		return 0, 0, fmt.Errorf("no keyring configured")
+	}
+	query := `
+		SELECT user_id FROM user_litellm_keys
+		WHERE litellm_key IS NOT NULL OR (litellm_key_ciphertext IS NOT NULL AND kek_id IS DISTINCT FROM $1)
+	`
+	rows, err := s.DB.QueryContext(ctx, query, s.Keyring.PrimaryID())
 	if err != nil {
-		return "", "", fmt.Errorf("failed to create request: %w", err)
+		return 0, 0, fmt.Errorf("failed to list keys to re-encrypt: %w", err)
+	}
+	var userIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		userIDs = append(userIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	rewritten, failed := 0, 0
+	for _, userID := range userIDs {
+		if err := ctx.Err(); err != nil {
+			return rewritten, failed, err
+		}
+		if err := s.reencryptRow(ctx, userID); err != nil {
+			failed++
+			slog.WarnContext(ctx, "failed to re-encrypt key", "user_id", userID, "error", err)
+			continue
+		}
+		rewritten++
 	}
+	return rewritten, failed, nil
+}
 
-	req.Header.Set("Authorization", "Bearer "+masterKey)
-	req.Header.Set("Content-Type", "application/json")
+func (s *PostgresStore) reencryptRow(ctx context.Context, userID string) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+	var plain, sealed, kekID sql.NullString
+	err = tx.QueryRowContext(ctx, `
+		SELECT litellm_key, litellm_key_ciphertext, kek_id FROM user_litellm_keys WHERE user_id = $1 FOR UPDATE
+	`, userID).Scan(&plain, &sealed, &kekID)
+	if err != nil {
+		return err
+	}
+	key, err := openKey(s.Keyring, plain, sealed, kekID)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to call LiteLLM API: %w", err)
+		return err
 	}
-	defer resp.Body.Close()
+	_, newSealed, newKekID, err := sealKey(s.Keyring, s.AllowPlaintextKeys, key)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE user_litellm_keys
+		SET litellm_key = NULL, litellm_key_ciphertext = $1, kek_id = $2, litellm_key_hash = $3
+		WHERE user_id = $4
+	`, newSealed, newKekID, KeyLookupHash(key), userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return "", "", fmt.Errorf("LiteLLM API returned status %d", resp.StatusCode)
+// sealKey returns the column values for storing key: ciphertext when a keyring
+// is configured, plaintext only when allowPlaintext opts in, ErrNoKeyring otherwise
+func sealKey(keyring *Keyring, allowPlaintext bool, key string) (plain, sealed, kekID sql.NullString, err error) {
+	if keyring == nil {
+		if !allowPlaintext {
+			return plain, sealed, kekID, ErrNoKeyring
+		}
+		return sql.NullString{String: key, Valid: true}, sql.NullString{}, sql.NullString{}, nil
 	}
+	id, envelope, err := keyring.Seal(key)
+	if err != nil {
+		return plain, sealed, kekID, err
+	}
+	return sql.NullString{}, sql.NullString{String: envelope, Valid: true}, sql.NullString{String: id, Valid: true}, nil
+}
 
-	var result struct {
-		Key string `json:"key"`
+// openKey returns the key value from its column values, preferring the ciphertext
+func openKey(keyring *Keyring, plain, sealed, kekID sql.NullString) (string, error) {
+	if sealed.Valid && sealed.String != "" {
+		if keyring == nil {
+			return "", fmt.Errorf("key is encrypted but no keyring is configured")
+		}
+		return keyring.Open(kekID.String, sealed.String)
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", "", fmt.Errorf("failed to decode response: %w", err)
+	if plain.Valid {
+		return plain.String, nil
 	}
+	return "", fmt.Errorf("key record has no value")
+}
 
-	return result.Key, keyName, nil
+// GenerateLiteLLMKey calls the LiteLLM proxy to generate a new virtual key for a user.
+// Prefer KeyProvisioner.Provision, which serializes provisioning per user.
+func GenerateLiteLLMKey(proxyURL, masterKey, userID string, maxBudget float64) (string, string, error) {
+	return NewKeyManager(nil, proxyURL, masterKey).GenerateKey(context.Background(), userID, maxBudget)
 }
@@ -0,0 +1,100 @@
+// Package tracing sets up OpenTelemetry tracing for the gateway: the tracer
+// provider and exporter (OTLP/HTTP or stdout), W3C propagation over HTTP and
+// into agent subprocesses through the TRACEPARENT environment variable.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+)
+
+// Options configures Setup
+type Options struct {
+	Exporter    string  // none | otlp | stdout
+	Endpoint    string  // OTLP/HTTP endpoint URL; empty uses the OTEL_EXPORTER_OTLP_* env defaults
+	ServiceName string  // service.name resource attribute
+	SampleRatio float64 // fraction of new traces sampled; parent decisions are always honoured
+}
+
+// Setup installs the global tracer provider and propagator and returns a
+// function that flushes and stops the exporter. With Exporter "none" only the
+// propagator is installed, so incoming trace context still flows to the
+// agent subprocess.
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch strings.ToLower(opts.Exporter) {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		var httpOpts []otlptracehttp.Option
+		if opts.Endpoint != "" {
+			httpOpts = append(httpOpts, otlptracehttp.WithEndpointURL(opts.Endpoint))
+		}
+		exporter, err = otlptracehttp.New(ctx, httpOpts...)
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", opts.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s trace exporter: %w", opts.Exporter, err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(opts.ServiceName),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build trace resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// Env returns ctx's trace context as environment entries ("TRACEPARENT=...",
+// "TRACESTATE=...") for a child process, following the OTel env carrier spec
+func Env(ctx context.Context) []string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+
+	var env []string
+	for _, key := range carrier.Keys() {
+		env = append(env, strings.ToUpper(key)+"="+carrier.Get(key))
+	}
+	return env
+}
+
+// WithEnv returns base without any inherited trace variables, plus ctx's trace context
+func WithEnv(ctx context.Context, base []string) []string {
+	out := make([]string, 0, len(base)+2)
+	for _, kv := range base {
+		name, _, _ := strings.Cut(kv, "=")
+		switch strings.ToUpper(name) {
+		case "TRACEPARENT", "TRACESTATE", "BAGGAGE":
+			continue
+		}
+		out = append(out, kv)
+	}
+	return append(out, Env(ctx)...)
+}
@@ -0,0 +1,59 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func spanContext(t *testing.T) context.Context {
+	t.Helper()
+	traceID, err := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	require.NoError(t, err)
+	spanID, err := trace.SpanIDFromHex("00f067aa0ba902b7")
+	require.NoError(t, err)
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	})
+	return trace.ContextWithSpanContext(context.Background(), sc)
+}
+
+func TestSetup_None(t *testing.T) {
+	shutdown, err := Setup(context.Background(), Options{Exporter: "none"})
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+
+	_, err = Setup(context.Background(), Options{Exporter: "jaeger"})
+	assert.Error(t, err)
+}
+
+func TestEnv(t *testing.T) {
+	_, err := Setup(context.Background(), Options{Exporter: "none"})
+	require.NoError(t, err)
+
+	assert.Empty(t, Env(context.Background()))
+	assert.Equal(t,
+		[]string{"TRACEPARENT=00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		Env(spanContext(t)))
+}
+
+func TestWithEnv_ReplacesInheritedContext(t *testing.T) {
+	_, err := Setup(context.Background(), Options{Exporter: "none"})
+	require.NoError(t, err)
+
+	base := []string{"PATH=/usr/bin", "TRACEPARENT=00-stale", "tracestate=x=y", "HOME=/root"}
+	env := WithEnv(spanContext(t), base)
+	assert.Equal(t, []string{
+		"PATH=/usr/bin",
+		"HOME=/root",
+		"TRACEPARENT=00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	}, env)
+
+	// Without an active span, inherited variables are still dropped
+	assert.Equal(t, []string{"PATH=/usr/bin", "HOME=/root"}, WithEnv(context.Background(), base))
+}
@@ -0,0 +1,74 @@
+package trip
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// dateLayout is the ISO 8601 calendar date Date reads and writes
+const dateLayout = "2006-01-02"
+
+// Date is a calendar day with no timezone of its own; a trip's dates are
+// days in its destination's timezone
+type Date struct {
+	Year  int
+	Month time.Month
+	Day   int
+}
+
+// ParseDate reads an ISO 8601 calendar date ("2026-03-14")
+func ParseDate(s string) (Date, error) {
+	t, err := time.Parse(dateLayout, s)
+	if err != nil {
+		return Date{}, fmt.Errorf("invalid date %q (expected YYYY-MM-DD)", s)
+	}
+	return DateOf(t), nil
+}
+
+// DateOf returns the calendar day of t in t's location
+func DateOf(t time.Time) Date {
+	y, m, d := t.Date()
+	return Date{Year: y, Month: m, Day: d}
+}
+
+// In returns the start of the day in loc
+func (d Date) In(loc *time.Location) time.Time {
+	return time.Date(d.Year, d.Month, d.Day, 0, 0, 0, 0, loc)
+}
+
+// AddDays returns the date n days later (earlier when n is negative)
+func (d Date) AddDays(n int) Date {
+	return DateOf(d.In(time.UTC).AddDate(0, 0, n))
+}
+
+// Sub returns the number of days from u to d
+func (d Date) Sub(u Date) int {
+	return int(d.In(time.UTC).Sub(u.In(time.UTC)).Hours() / 24)
+}
+
+// Before reports whether d is an earlier day than u
+func (d Date) Before(u Date) bool {
+	return d.Sub(u) < 0
+}
+
+func (d Date) String() string {
+	return d.In(time.UTC).Format(dateLayout)
+}
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Date) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := ParseDate(s)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
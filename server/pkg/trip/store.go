@@ -0,0 +1,92 @@
+package trip
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"guardian-gateway/pkg/store"
+)
+
+// Store persists trips; store.FeedStore is one
+type Store interface {
+	CreateTrip(ctx context.Context, rec *store.TripRecord) error
+	UpdateTrip(ctx context.Context, rec *store.TripRecord) error
+	LoadTrip(ctx context.Context, ownerID, id string) (*store.TripRecord, error)
+	ListTrips(ctx context.Context, ownerID string, limit int) ([]*store.TripRecord, error)
+	DeleteTrip(ctx context.Context, ownerID, id string) error
+}
+
+// Create validates t and stores it as a new trip of t.OwnerID, setting its ID
+// and timestamps
+func Create(ctx context.Context, s Store, t *Trip) error {
+	rec, err := record(t)
+	if err != nil {
+		return err
+	}
+	if err := s.CreateTrip(ctx, rec); err != nil {
+		return err
+	}
+	t.ID, t.CreatedAt, t.UpdatedAt = rec.ID, rec.CreatedAt, rec.UpdatedAt
+	return nil
+}
+
+// Update validates t and replaces the stored trip with its ID; the error
+// wraps store.ErrNotFound when the owner has no such trip
+func Update(ctx context.Context, s Store, t *Trip) error {
+	rec, err := record(t)
+	if err != nil {
+		return err
+	}
+	if err := s.UpdateTrip(ctx, rec); err != nil {
+		return err
+	}
+	t.CreatedAt, t.UpdatedAt = rec.CreatedAt, rec.UpdatedAt
+	return nil
+}
+
+// Get loads one of the owner's trips
+func Get(ctx context.Context, s Store, ownerID, id string) (*Trip, error) {
+	rec, err := s.LoadTrip(ctx, ownerID, id)
+	if err != nil {
+		return nil, err
+	}
+	return fromRecord(rec)
+}
+
+// List returns the owner's most recently updated trips first
+func List(ctx context.Context, s Store, ownerID string, limit int) ([]*Trip, error) {
+	recs, err := s.ListTrips(ctx, ownerID, limit)
+	if err != nil {
+		return nil, err
+	}
+	trips := make([]*Trip, 0, len(recs))
+	for _, rec := range recs {
+		t, err := fromRecord(rec)
+		if err != nil {
+			return nil, err
+		}
+		trips = append(trips, t)
+	}
+	return trips, nil
+}
+
+func record(t *Trip) (*store.TripRecord, error) {
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(t)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode trip: %w", err)
+	}
+	return &store.TripRecord{ID: t.ID, OwnerID: t.OwnerID, Data: data}, nil
+}
+
+func fromRecord(rec *store.TripRecord) (*Trip, error) {
+	var t Trip
+	if err := json.Unmarshal(rec.Data, &t); err != nil {
+		return nil, fmt.Errorf("failed to decode trip %s: %w", rec.ID, err)
+	}
+	t.ID, t.OwnerID, t.CreatedAt, t.UpdatedAt = rec.ID, rec.OwnerID, rec.CreatedAt, rec.UpdatedAt
+	return &t, nil
+}
@@ -0,0 +1,133 @@
+// Package trip is the typed trip a user plans with the Guardian Assistant:
+// where they go, when, with whom and on what budget. Sessions, agent runs and
+// feed cards reference a trip by ID.
+package trip
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	_ "time/tzdata" // Destination timezones resolve without a system zoneinfo
+)
+
+// Trip is one planned trip of an owner
+type Trip struct {
+	ID      string `json:"id"`
+	OwnerID string `json:"-"`
+
+	Destination Destination `json:"destination"`
+	// StartDate and EndDate are calendar days in the destination's timezone;
+	// EndDate is the last day of the trip
+	StartDate *Date `json:"start_date,omitempty"`
+	EndDate   *Date `json:"end_date,omitempty"`
+	// ArrivalTime and DepartureTime are local times of day ("15:04")
+	ArrivalTime   string `json:"arrival_time,omitempty"`
+	DepartureTime string `json:"departure_time,omitempty"`
+
+	Travellers int      `json:"travellers,omitempty"`
+	Budget     *Money   `json:"budget,omitempty"`
+	Interests  []string `json:"interests,omitempty"`
+	Venues     []string `json:"venues,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Destination is where a trip goes
+type Destination struct {
+	Name    string    `json:"name"` // As the user gave it, e.g. "Lisbon, Portugal"
+	Country string    `json:"country,omitempty"`
+	Geo     *GeoPoint `json:"geo,omitempty"`
+	// Timezone is an IANA zone name, e.g. "Europe/Lisbon"
+	Timezone string `json:"timezone,omitempty"`
+}
+
+// GeoPoint is a WGS 84 coordinate
+type GeoPoint struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// Money is an amount in an ISO 4217 currency
+type Money struct {
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"`
+}
+
+// Location returns the destination's timezone, UTC when it is unknown
+func (d Destination) Location() *time.Location {
+	if d.Timezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(d.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// Days is the number of calendar days the trip spans, 0 when its dates are unknown
+func (t *Trip) Days() int {
+	if t.StartDate == nil || t.EndDate == nil {
+		return 0
+	}
+	return t.EndDate.Sub(*t.StartDate) + 1
+}
+
+// Starts returns when the trip starts in its destination: the arrival time
+// on StartDate, or the start of that day
+func (t *Trip) Starts() (time.Time, bool) {
+	if t.StartDate == nil {
+		return time.Time{}, false
+	}
+	start := t.StartDate.In(t.Destination.Location())
+	if at, err := time.Parse("15:04", t.ArrivalTime); err == nil {
+		start = start.Add(time.Duration(at.Hour())*time.Hour + time.Duration(at.Minute())*time.Minute)
+	}
+	return start, true
+}
+
+// Validate checks the trip's fields; the destination name is required
+func (t *Trip) Validate() error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if strings.TrimSpace(t.Destination.Name) == "" {
+		add("destination.name is required")
+	}
+	if g := t.Destination.Geo; g != nil && (g.Lat < -90 || g.Lat > 90 || g.Lon < -180 || g.Lon > 180) {
+		add("destination.geo is out of range")
+	}
+	if t.Destination.Timezone != "" {
+		if _, err := time.LoadLocation(t.Destination.Timezone); err != nil {
+			add("destination.timezone %q is not an IANA timezone", t.Destination.Timezone)
+		}
+	}
+	if t.StartDate != nil && t.EndDate != nil && t.EndDate.Before(*t.StartDate) {
+		add("end_date is before start_date")
+	}
+	if _, err := time.Parse("15:04", t.ArrivalTime); t.ArrivalTime != "" && err != nil {
+		add("arrival_time must be HH:MM")
+	}
+	if _, err := time.Parse("15:04", t.DepartureTime); t.DepartureTime != "" && err != nil {
+		add("departure_time must be HH:MM")
+	}
+	if t.Travellers < 0 {
+		add("travellers must not be negative")
+	}
+	if b := t.Budget; b != nil {
+		if b.Amount < 0 {
+			add("budget.amount must not be negative")
+		}
+		if len(b.Currency) != 3 || strings.ToUpper(b.Currency) != b.Currency {
+			add("budget.currency must be an ISO 4217 code, e.g. EUR")
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid trip: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package trip
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDate(t *testing.T) {
+	d, err := ParseDate("2026-03-29")
+	require.NoError(t, err)
+	assert.Equal(t, "2026-04-02", d.AddDays(4).String())
+	assert.Equal(t, 4, d.AddDays(4).Sub(d), "across a DST change")
+	assert.True(t, d.Before(d.AddDays(1)))
+
+	_, err = ParseDate("29/03/2026")
+	assert.Error(t, err)
+
+	var trip Trip
+	require.NoError(t, json.Unmarshal([]byte(`{"destination":{"name":"Lisbon"},"start_date":"2026-03-29","end_date":"2026-04-02"}`), &trip))
+	assert.Equal(t, 5, trip.Days())
+	assert.Error(t, json.Unmarshal([]byte(`{"start_date":"next week"}`), &trip))
+}
+
+func TestTrip_Starts(t *testing.T) {
+	start := Date{Year: 2026, Month: time.July, Day: 1}
+	trip := Trip{Destination: Destination{Name: "Tokyo", Timezone: "Asia/Tokyo"}, StartDate: &start, ArrivalTime: "15:30"}
+	at, ok := trip.Starts()
+	require.True(t, ok)
+	assert.Equal(t, "2026-07-01T06:30:00Z", at.UTC().Format(time.RFC3339), "arrival in the destination's timezone")
+
+	trip.StartDate = nil
+	_, ok = trip.Starts()
+	assert.False(t, ok)
+}
+
+func TestTrip_Validate(t *testing.T) {
+	start, end := Date{Year: 2026, Month: time.July, Day: 5}, Date{Year: 2026, Month: time.July, Day: 1}
+	trip := Trip{
+		Destination: Destination{Geo: &GeoPoint{Lat: 91}, Timezone: "Mars/Olympus"},
+		StartDate:   &start,
+		EndDate:     &end,
+		ArrivalTime: "3pm",
+		Budget:      &Money{Amount: 100, Currency: "euro"},
+	}
+	err := trip.Validate()
+	require.Error(t, err)
+	for _, problem := range []string{"destination.name", "destination.geo", "Mars/Olympus", "end_date", "arrival_time", "budget.currency"} {
+		assert.Contains(t, err.Error(), problem)
+	}
+
+	assert.NoError(t, (&Trip{Destination: Destination{Name: "Lisbon", Timezone: "Europe/Lisbon"}}).Validate())
+}
+
+func TestTrip_ApplyVariables(t *testing.T) {
+	var trip Trip
+	trip.ApplyVariables(map[string]string{
+		"Destination":     "Lisbon, Portugal",
+		"Start Date":      "2026-06-10",
+		"Duration":        "4 nights",
+		"Arrival Time":    "3:30 pm",
+		"Departure_time":  "11:00",
+		"Budget":          "€1.5k",
+		"Interests":       "food, museums and fado",
+		"Specific Venues": "Belém Tower",
+		"Mode":            "walking",
+		"Travellers":      "2 adults",
+	})
+	assert.Equal(t, "Lisbon, Portugal", trip.Destination.Name)
+	assert.Equal(t, "2026-06-10", trip.StartDate.String())
+	assert.Equal(t, "2026-06-14", trip.EndDate.String())
+	assert.Equal(t, "15:30", trip.ArrivalTime)
+	assert.Equal(t, "11:00", trip.DepartureTime)
+	assert.Equal(t, &Money{Amount: 1500, Currency: "EUR"}, trip.Budget)
+	assert.Equal(t, []string{"food", "museums", "fado"}, trip.Interests)
+	assert.Equal(t, []string{"Belém Tower"}, trip.Venues)
+	assert.Equal(t, 2, trip.Travellers)
+	require.NoError(t, trip.Validate())
+
+	trip.Destination.Timezone = "Europe/Lisbon"
+	trip.ApplyVariables(map[string]string{"Destination": "lisbon, portugal", "Budget": "a lot", "Start Date": "next Friday"})
+	assert.Equal(t, "Europe/Lisbon", trip.Destination.Timezone, "same destination keeps its details")
+	assert.Equal(t, "EUR", trip.Budget.Currency, "unreadable values are ignored")
+	assert.Equal(t, "2026-06-10", trip.StartDate.String())
+
+	trip.ApplyVariables(map[string]string{"Destination": "Porto"})
+	assert.Equal(t, Destination{Name: "Porto"}, trip.Destination)
+}
+
+func TestTrip_ApplyVariablesAliasPrecedence(t *testing.T) {
+	vars := map[string]string{
+		"City":         "Porto",
+		"Destination":  "Lisbon",
+		"location":     "Faro",
+		"Arrival":      "09:00",
+		"Arrival Time": "10:00",
+		"arrival_time": "11:00",
+		"People":       "4",
+		"Travellers":   "2",
+	}
+	// Map iteration is random; the outcome must not be
+	for i := 0; i < 20; i++ {
+		var trip Trip
+		trip.ApplyVariables(vars)
+		assert.Equal(t, "Lisbon", trip.Destination.Name)
+		assert.Equal(t, "10:00", trip.ArrivalTime, "the canonical name, then folded duplicates in sorted order")
+		assert.Equal(t, 2, trip.Travellers)
+	}
+}
+
+func TestTrip_Variables(t *testing.T) {
+	var trip Trip
+	trip.ApplyVariables(map[string]string{
+		"Destination": "Lisbon, Portugal",
+		"Start Date":  "2026-06-10",
+		"Duration":    "4 nights",
+		"Budget":      "1500 EUR",
+		"Interests":   "food, fado",
+	})
+	vars := trip.Variables()
+	assert.Equal(t, map[string]string{
+		"Destination": "Lisbon, Portugal",
+		"Start Date":  "2026-06-10",
+		"End Date":    "2026-06-14",
+		"Duration":    "P5D",
+		"Budget":      "1500 EUR",
+		"Interests":   "food, fado",
+	}, vars)
+
+	var again Trip
+	again.ApplyVariables(vars)
+	assert.Equal(t, trip, again, "variables round-trip")
+}
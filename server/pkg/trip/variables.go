@@ -0,0 +1,129 @@
+package trip
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"guardian-gateway/pkg/variables"
+)
+
+// ApplyVariables fills the trip from the assistant's session variables
+// ("Destination", "Start Date", "Duration", ...), each read as its type in
+// pkg/variables. A value that cannot be read leaves its field unchanged.
+// Aliases are tried in the order listed, and names that fold together
+// ("Start Date", "start_date") in sorted order, so the result does not depend
+// on map iteration.
+func (t *Trip) ApplyVariables(vars map[string]string) {
+	now := time.Now()
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	byKey := make(map[string][]string, len(vars))
+	for _, k := range keys {
+		key := variables.FoldKey(k)
+		byKey[key] = append(byKey[key], vars[k])
+	}
+	get := func(typ variables.Type, names ...string) string {
+		for _, name := range names {
+			for _, v := range byKey[name] {
+				if normalized, err := variables.Normalize(typ, v, now); err == nil {
+					return normalized
+				}
+			}
+		}
+		return ""
+	}
+
+	if v := get(variables.TypeCity, "destination", "city", "location"); v != "" && !strings.EqualFold(v, t.Destination.Name) {
+		// Coordinates and timezone belonged to the previous destination
+		t.Destination = Destination{Name: v}
+	}
+	if d, err := ParseDate(get(variables.TypeDate, "startdate", "start", "arrivaldate")); err == nil {
+		t.StartDate = &d
+	}
+	if d, err := ParseDate(get(variables.TypeDate, "enddate", "end", "departuredate")); err == nil {
+		t.EndDate = &d
+	}
+	if days := durationDays(get(variables.TypeDuration, "duration", "length", "triplength")); days > 0 && t.StartDate != nil {
+		end := t.StartDate.AddDays(days - 1)
+		t.EndDate = &end
+	}
+	if v := get(variables.TypeTime, "arrivaltime", "arrival"); v != "" {
+		t.ArrivalTime = v
+	}
+	if v := get(variables.TypeTime, "departuretime", "departure"); v != "" {
+		t.DepartureTime = v
+	}
+travellers:
+	for _, name := range []string{"travellers", "travelers", "people", "groupsize"} {
+		for _, v := range byKey[name] {
+			if f := strings.Fields(v); len(f) > 0 {
+				if n, err := strconv.Atoi(f[0]); err == nil && n > 0 {
+					t.Travellers = n
+					break travellers
+				}
+			}
+		}
+	}
+	if amount, currency, ok := strings.Cut(get(variables.TypeMoney, "budget"), " "); ok {
+		if a, err := strconv.ParseFloat(amount, 64); err == nil {
+			t.Budget = &Money{Amount: a, Currency: currency}
+		}
+	}
+	if v := get(variables.TypeList, "interests"); v != "" {
+		t.Interests = strings.Split(v, ", ")
+	}
+	if v := get(variables.TypeList, "venues", "specificvenues"); v != "" {
+		t.Venues = strings.Split(v, ", ")
+	}
+}
+
+// Variables renders the trip back as variables under their pkg/variables
+// names, the inverse of ApplyVariables. Unset fields are left out.
+func (t *Trip) Variables() map[string]string {
+	vars := make(map[string]string)
+	set := func(name, v string) {
+		if v != "" {
+			vars[name] = v
+		}
+	}
+	set("Destination", t.Destination.Name)
+	set("Country", t.Destination.Country)
+	set("Timezone", t.Destination.Timezone)
+	if t.StartDate != nil {
+		set("Start Date", t.StartDate.String())
+	}
+	if t.EndDate != nil {
+		set("End Date", t.EndDate.String())
+	}
+	if days := t.Days(); days > 0 {
+		set("Duration", "P"+strconv.Itoa(days)+"D")
+	}
+	set("Arrival Time", t.ArrivalTime)
+	set("Departure Time", t.DepartureTime)
+	if t.Travellers > 0 {
+		set("Travellers", strconv.Itoa(t.Travellers))
+	}
+	if b := t.Budget; b != nil {
+		set("Budget", strconv.FormatFloat(b.Amount, 'f', -1, 64)+" "+b.Currency)
+	}
+	set("Interests", strings.Join(t.Interests, ", "))
+	set("Specific Venues", strings.Join(t.Venues, ", "))
+	return vars
+}
+
+// durationDays reads a normalized duration (P5D) as days; durations in hours
+// are part of a day
+func durationDays(iso string) int {
+	if n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(iso, "P"), "D")); err == nil {
+		return n
+	}
+	if strings.HasPrefix(iso, "PT") {
+		return 1
+	}
+	return 0
+}
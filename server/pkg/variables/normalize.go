@@ -0,0 +1,221 @@
+package variables
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// parsers normalize a value of each type
+var parsers = map[Type]func(value string, now time.Time) (string, error){
+	TypeDate:     normalizeDate,
+	TypeDuration: normalizeDuration,
+	TypeTime:     normalizeTime,
+	TypeCity:     normalizeCity,
+	TypeMoney:    normalizeMoney,
+	TypeList:     normalizeList,
+}
+
+// Normalize reads value as t and returns its normalized form (see Type)
+func Normalize(t Type, value string, now time.Time) (string, error) {
+	parse, ok := parsers[t]
+	if !ok {
+		return "", fmt.Errorf("unknown type %q", t)
+	}
+	value = strings.Join(strings.Fields(value), " ")
+	if placeholder(value) {
+		return "", errors.New("no value given")
+	}
+	return parse(value, now)
+}
+
+// placeholder reports the model's stand-ins for a missing value
+func placeholder(v string) bool {
+	switch strings.ToLower(strings.Trim(v, " .?!")) {
+	case "", "missing", "unknown", "n/a", "na", "none", "tbd", "tba", "null", "not specified":
+		return true
+	}
+	return false
+}
+
+const isoDate = "2006-01-02"
+
+var ordinalPattern = regexp.MustCompile(`(?i)\b(\d{1,2})(st|nd|rd|th)\b`)
+
+// dateLayouts are the dates read besides ISO 8601; day-first numeric dates
+// are ambiguous and not among them
+var dateLayouts = []string{
+	"2 January 2006", "2 Jan 2006", "January 2 2006", "Jan 2 2006", "2006/01/02",
+	"Monday 2 January 2006", "Monday January 2 2006",
+}
+
+// yearlessLayouts are dates without a year: the next such day from now
+var yearlessLayouts = []string{"2 January", "2 Jan", "January 2", "Jan 2"}
+
+func normalizeDate(v string, now time.Time) (string, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	switch strings.ToLower(v) {
+	case "today":
+		return today.Format(isoDate), nil
+	case "tomorrow":
+		return today.AddDate(0, 0, 1).Format(isoDate), nil
+	}
+
+	v = ordinalPattern.ReplaceAllString(strings.ReplaceAll(v, ",", ""), "$1")
+	for _, layout := range append([]string{isoDate}, dateLayouts...) {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t.Format(isoDate), nil
+		}
+	}
+	for _, layout := range yearlessLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			t = t.AddDate(today.Year()-t.Year(), 0, 0)
+			if t.Before(today) {
+				t = t.AddDate(1, 0, 0)
+			}
+			return t.Format(isoDate), nil
+		}
+	}
+	return "", errors.New("not a calendar date")
+}
+
+var (
+	durationPattern    = regexp.MustCompile(`(?i)^(\d+|[a-z]+)\s*(days?|nights?|weeks?|hours?|d|w|h)$`)
+	isoDurationPattern = regexp.MustCompile(`^P(?:(\d+)D|T(\d+)H)$`)
+	numberWords        = map[string]int{
+		"a": 1, "an": 1, "one": 1, "two": 2, "three": 3, "four": 4, "five": 5,
+		"six": 6, "seven": 7, "eight": 8, "nine": 9, "ten": 10, "fourteen": 14,
+	}
+)
+
+// normalizeDuration reads a trip length as ISO 8601: days (P5D), or hours
+// (PT36H) for short stays. n nights span n+1 days and weeks become days, so
+// there is one form per length.
+func normalizeDuration(v string, _ time.Time) (string, error) {
+	if isoDurationPattern.MatchString(strings.ToUpper(v)) {
+		return strings.ToUpper(v), nil
+	}
+	m := durationPattern.FindStringSubmatch(v)
+	if m == nil {
+		return "", errors.New("not a length in days, nights, weeks or hours")
+	}
+	n, err := strconv.Atoi(m[1])
+	if err != nil {
+		var ok bool
+		if n, ok = numberWords[strings.ToLower(m[1])]; !ok {
+			return "", errors.New("not a number")
+		}
+	}
+	if n <= 0 {
+		return "", errors.New("must be longer than zero")
+	}
+	switch unit := strings.ToLower(m[2]); {
+	case strings.HasPrefix(unit, "h"):
+		return fmt.Sprintf("PT%dH", n), nil
+	case strings.HasPrefix(unit, "w"):
+		return fmt.Sprintf("P%dD", n*7), nil
+	case strings.HasPrefix(unit, "n"):
+		return fmt.Sprintf("P%dD", n+1), nil
+	default:
+		return fmt.Sprintf("P%dD", n), nil
+	}
+}
+
+func normalizeTime(v string, _ time.Time) (string, error) {
+	switch strings.ToLower(v) {
+	case "noon", "midday":
+		return "12:00", nil
+	case "midnight":
+		return "00:00", nil
+	}
+	compact := strings.ToUpper(strings.NewReplacer(" ", "", ".", "").Replace(v))
+	for _, layout := range []string{"15:04", "3:04PM", "3PM", "1504"} {
+		if t, err := time.Parse(layout, compact); err == nil {
+			return t.Format("15:04"), nil
+		}
+	}
+	return "", errors.New("not a time of day")
+}
+
+func normalizeCity(v string, _ time.Time) (string, error) {
+	if !strings.ContainsFunc(v, unicode.IsLetter) {
+		return "", errors.New("not a place name")
+	}
+	if len(v) > 100 {
+		return "", errors.New("too long for a place name")
+	}
+	return v, nil
+}
+
+// currencyCodes are the ISO 4217 currencies a budget may be given in
+var currencyCodes = map[string]bool{
+	"AED": true, "ARS": true, "AUD": true, "BRL": true, "CAD": true, "CHF": true, "CLP": true, "CNY": true,
+	"COP": true, "CZK": true, "DKK": true, "EGP": true, "EUR": true, "GBP": true, "HKD": true, "HUF": true,
+	"IDR": true, "ILS": true, "INR": true, "ISK": true, "JPY": true, "KES": true, "KRW": true, "LKR": true,
+	"MAD": true, "MXN": true, "MYR": true, "NOK": true, "NPR": true, "NZD": true, "PEN": true, "PHP": true,
+	"PKR": true, "PLN": true, "QAR": true, "RON": true, "SAR": true, "SEK": true, "SGD": true, "THB": true,
+	"TRY": true, "TWD": true, "USD": true, "VND": true, "ZAR": true,
+}
+
+// currencyNames maps symbols and common names to ISO 4217 codes
+var currencyNames = map[string]string{
+	"$": "USD", "US$": "USD", "DOLLARS": "USD", "DOLLAR": "USD", "€": "EUR", "EUROS": "EUR", "EURO": "EUR",
+	"£": "GBP", "POUNDS": "GBP", "POUND": "GBP", "₹": "INR", "RS": "INR", "RS.": "INR", "RUPEES": "INR", "¥": "JPY", "YEN": "JPY",
+}
+
+var moneyPattern = regexp.MustCompile(`^([^\d\s]*)\s*(\d[\d,]*(?:\.\d+)?)\s*([kK])?\s*(\S*)(?:\s.*)?$`)
+
+// normalizeMoney reads an amount in a currency, e.g. "EUR 1,500", "€1500",
+// "1.5k euros" or "1500 EUR in total", as "1500 EUR"
+func normalizeMoney(v string, _ time.Time) (string, error) {
+	m := moneyPattern.FindStringSubmatch(v)
+	if m == nil {
+		return "", errors.New("not an amount")
+	}
+	amount, err := strconv.ParseFloat(strings.ReplaceAll(m[2], ",", ""), 64)
+	if err != nil {
+		return "", errors.New("not an amount")
+	}
+	if m[3] != "" {
+		amount *= 1000
+	}
+
+	var code string
+	for _, c := range []string{m[1], m[4]} {
+		if c == "" {
+			continue
+		}
+		c = strings.ToUpper(c)
+		if named, ok := currencyNames[c]; ok {
+			c = named
+		}
+		if !currencyCodes[c] || (code != "" && code != c) {
+			return "", fmt.Errorf("unknown currency %q", strings.TrimSpace(m[1]+" "+m[4]))
+		}
+		code = c
+	}
+	if code == "" {
+		return "", errors.New("no currency given")
+	}
+	return strconv.FormatFloat(amount, 'f', -1, 64) + " " + code, nil
+}
+
+var listSeparators = regexp.MustCompile(`\s*(?:,|;|\band\b|&)\s*`)
+
+// normalizeList reads "food; museums and fado" as "food, museums, fado"
+func normalizeList(v string, _ time.Time) (string, error) {
+	var items []string
+	for _, item := range listSeparators.Split(v, -1) {
+		if item = strings.TrimSpace(item); item != "" && !placeholder(item) {
+			items = append(items, item)
+		}
+	}
+	if len(items) == 0 {
+		return "", errors.New("no items given")
+	}
+	return strings.Join(items, ", "), nil
+}
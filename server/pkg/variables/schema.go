@@ -0,0 +1,244 @@
+// Package variables declares the trip details the Guardian Assistant collects
+// in conversation before it runs an agent: each variable's name, aliases,
+// type and requirement tier. Values the model extracts are parsed into one
+// normalized form per type, and values that cannot be read are turned back
+// into a clarifying question.
+package variables
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Type is how a variable's value is read and normalized
+type Type string
+
+const (
+	TypeDate     Type = "date"     // ISO 8601 calendar date: 2026-06-10
+	TypeDuration Type = "duration" // ISO 8601 duration in days or hours: P5D, PT36H
+	TypeTime     Type = "time"     // 24-hour local time: 15:30
+	TypeCity     Type = "city"     // Place name, whitespace collapsed
+	TypeMoney    Type = "money"    // Amount and ISO 4217 currency: 1500 EUR
+	TypeList     Type = "list"     // Comma-separated items: food, museums
+)
+
+// Requirement tiers
+const (
+	Tier1 = 1 // Required before the agent runs
+	Tier2 = 2 // Optional, asked for once
+)
+
+// Variable is one declared trip detail
+type Variable struct {
+	Name string `yaml:"name"`
+	Type Type   `yaml:"type"`
+	Tier int    `yaml:"tier"`
+	// Description is shown to the model next to the name, e.g. "When?"
+	Description string `yaml:"description"`
+	// Aliases are other keys the model may use for the variable
+	Aliases []string `yaml:"aliases"`
+	// Question asks for the variable again when a value cannot be read;
+	// empty uses one for its type
+	Question string `yaml:"question"`
+}
+
+// Schema is an agent's set of variables, in the order they are asked for
+type Schema struct {
+	Variables []Variable `yaml:"variables"`
+
+	byKey map[string]*Variable
+}
+
+//go:embed default.yaml
+var defaultYAML []byte
+
+var defaultSchema = mustParse(defaultYAML)
+
+// Default is the schema of agents that do not declare their own: the trip
+// details Trip Guardian needs
+func Default() *Schema { return defaultSchema }
+
+func mustParse(data []byte) *Schema {
+	s, err := Parse(data)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Parse reads a schema from YAML (see default.yaml)
+func Parse(data []byte) (*Schema, error) {
+	var s Schema
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("invalid variable schema: %w", err)
+	}
+	if len(s.Variables) == 0 {
+		return nil, errors.New("invalid variable schema: no variables")
+	}
+
+	s.byKey = make(map[string]*Variable)
+	for i := range s.Variables {
+		v := &s.Variables[i]
+		if strings.TrimSpace(v.Name) == "" {
+			return nil, fmt.Errorf("invalid variable schema: variable %d has no name", i+1)
+		}
+		if _, ok := parsers[v.Type]; !ok {
+			return nil, fmt.Errorf("invalid variable schema: %s has unknown type %q", v.Name, v.Type)
+		}
+		if v.Tier != Tier1 && v.Tier != Tier2 {
+			return nil, fmt.Errorf("invalid variable schema: %s has tier %d (expected 1 or 2)", v.Name, v.Tier)
+		}
+		for _, key := range append([]string{v.Name}, v.Aliases...) {
+			k := FoldKey(key)
+			if other, dup := s.byKey[k]; dup {
+				return nil, fmt.Errorf("invalid variable schema: %q names both %s and %s", key, other.Name, v.Name)
+			}
+			s.byKey[k] = v
+		}
+	}
+	return &s, nil
+}
+
+// Load reads a schema file
+func Load(path string) (*Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// ForAgent returns the schema an agent declares in <agent>.vars.yaml next to
+// its file, or Default when it declares none
+func ForAgent(agentPath string) (*Schema, error) {
+	path := strings.TrimSuffix(agentPath, filepath.Ext(agentPath)) + ".vars.yaml"
+	s, err := Load(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Default(), nil
+	}
+	return s, err
+}
+
+// Lookup finds the variable named or aliased by key, ignoring case, spaces,
+// underscores and hyphens
+func (s *Schema) Lookup(key string) (*Variable, bool) {
+	v, ok := s.byKey[FoldKey(key)]
+	return v, ok
+}
+
+// FoldKey folds variable names: "Start Date", "start_date" and "StartDate"
+// are one variable
+func FoldKey(k string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '_' || r == '-' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(k))
+}
+
+// Rejection is a value that could not be read as its variable's type
+type Rejection struct {
+	Variable *Variable
+	Value    string
+	Err      error
+}
+
+// Question asks the user for the variable again, saying what was wrong
+func (r Rejection) Question() string {
+	return fmt.Sprintf("I couldn't use %q for %s (%v). %s", r.Value, strings.ToLower(r.Variable.Name), r.Err, r.Variable.question())
+}
+
+var typeQuestions = map[Type]string{
+	TypeDate:     "Which date do you mean? For example 2026-06-10 or 10 June.",
+	TypeDuration: "How long is the trip? For example 5 days, 4 nights or 2 weeks.",
+	TypeTime:     "At what time? For example 14:30 or 2:30 pm.",
+	TypeCity:     "Which city are you going to?",
+	TypeMoney:    "How much, and in which currency? For example 1500 EUR.",
+	TypeList:     "Could you list them, separated by commas?",
+}
+
+func (v *Variable) question() string {
+	if v.Question != "" {
+		return v.Question
+	}
+	return typeQuestions[v.Type]
+}
+
+// Normalize reads value as the variable's type and returns its normalized
+// form. now resolves relative and year-less dates.
+func (v *Variable) Normalize(value string, now time.Time) (string, error) {
+	return Normalize(v.Type, value, now)
+}
+
+// Apply normalizes updates extracted from the conversation. Values of
+// declared variables are returned under the variable's name; keys the schema
+// does not declare pass through unchanged. Unreadable values are left out and
+// returned as rejections, in schema order.
+func (s *Schema) Apply(updates map[string]string, now time.Time) (map[string]string, []Rejection) {
+	values := make(map[string]string, len(updates))
+	var rejected []Rejection
+	for key, value := range updates {
+		v, ok := s.Lookup(key)
+		if !ok {
+			values[key] = value
+			continue
+		}
+		normalized, err := v.Normalize(value, now)
+		if err != nil {
+			rejected = append(rejected, Rejection{Variable: v, Value: value, Err: err})
+			continue
+		}
+		values[v.Name] = normalized
+	}
+	sort.Slice(rejected, func(i, j int) bool {
+		return s.index(rejected[i].Variable) < s.index(rejected[j].Variable)
+	})
+	return values, rejected
+}
+
+func (s *Schema) index(v *Variable) int {
+	for i := range s.Variables {
+		if &s.Variables[i] == v {
+			return i
+		}
+	}
+	return len(s.Variables)
+}
+
+// Prompt lists the variables by tier for the assistant's system prompt
+func (s *Schema) Prompt() string {
+	var b strings.Builder
+	b.WriteString("TIER 1 (MANDATORY - BLOCKER):\n")
+	var optional []string
+	for _, v := range s.Variables {
+		if v.Tier == Tier2 {
+			optional = append(optional, v.Name)
+			continue
+		}
+		b.WriteString("- " + v.Name)
+		if v.Description != "" {
+			b.WriteString(" (" + v.Description + ")")
+		}
+		b.WriteString("\n")
+	}
+	if len(optional) > 0 {
+		b.WriteString("\nTIER 2 (OPTIONAL - ASK ONCE):\n- " + strings.Join(optional, ", ") + "\n")
+	}
+	return b.String()
+}
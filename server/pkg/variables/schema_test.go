@@ -0,0 +1,152 @@
+package variables
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var now = time.Date(2026, time.May, 20, 15, 0, 0, 0, time.UTC)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		typ   Type
+		value string
+		want  string
+	}{
+		{TypeDate, "2026-06-10", "2026-06-10"},
+		{TypeDate, "10th June, 2026", "2026-06-10"},
+		{TypeDate, "Wednesday, June 10 2026", "2026-06-10"},
+		{TypeDate, "June 10", "2026-06-10"},
+		{TypeDate, "3 Jan", "2027-01-03"},
+		{TypeDate, "tomorrow", "2026-05-21"},
+		{TypeDuration, "5 days", "P5D"},
+		{TypeDuration, "4 nights", "P5D"},
+		{TypeDuration, "two weeks", "P14D"},
+		{TypeDuration, "36h", "PT36H"},
+		{TypeDuration, "p3d", "P3D"},
+		{TypeTime, "3:30 pm", "15:30"},
+		{TypeTime, "9 a.m.", "09:00"},
+		{TypeTime, "15.45", "15:45"},
+		{TypeTime, "noon", "12:00"},
+		{TypeCity, "  Lisbon,   Portugal ", "Lisbon, Portugal"},
+		{TypeCity, "São Paulo", "São Paulo"},
+		{TypeMoney, "EUR 1,500", "1500 EUR"},
+		{TypeMoney, "€1500", "1500 EUR"},
+		{TypeMoney, "$1.5k", "1500 USD"},
+		{TypeMoney, "2000 rupees in total", "2000 INR"},
+		{TypeMoney, "99.50 gbp", "99.5 GBP"},
+		{TypeList, "food; museums and fado", "food, museums, fado"},
+	}
+	for _, tt := range tests {
+		got, err := Normalize(tt.typ, tt.value, now)
+		if assert.NoError(t, err, "%s %q", tt.typ, tt.value) {
+			assert.Equal(t, tt.want, got, "%s %q", tt.typ, tt.value)
+		}
+	}
+
+	invalid := []struct {
+		typ   Type
+		value string
+	}{
+		{TypeDate, "next week"},
+		{TypeDate, "10/06/2026"},
+		{TypeDate, "2026-02-30"},
+		{TypeDuration, "5"},
+		{TypeDuration, "0 days"},
+		{TypeDuration, "a while"},
+		{TypeTime, "evening"},
+		{TypeTime, "25:00"},
+		{TypeCity, "12345"},
+		{TypeCity, "MISSING"},
+		{TypeMoney, "1500"},
+		{TypeMoney, "1500 for food"},
+		{TypeMoney, "EUR 1500 USD"},
+		{TypeMoney, "cheap"},
+		{TypeList, "n/a"},
+	}
+	for _, tt := range invalid {
+		_, err := Normalize(tt.typ, tt.value, now)
+		assert.Error(t, err, "%s %q", tt.typ, tt.value)
+	}
+}
+
+func TestSchema_Apply(t *testing.T) {
+	s := Default()
+	values, rejected := s.Apply(map[string]string{
+		"City":           "Lisbon",
+		"Start_date":     "June 10",
+		"Duration":       "a while",
+		"Budget":         "1500",
+		"Arrival":        "3pm",
+		"Travel Buddy":   "Sam",
+		"specificvenues": "Belém Tower",
+	}, now)
+
+	assert.Equal(t, map[string]string{
+		"Destination":     "Lisbon",
+		"Start Date":      "2026-06-10",
+		"Arrival Time":    "15:00",
+		"Specific Venues": "Belém Tower",
+		"Travel Buddy":    "Sam",
+	}, values, "declared variables under their name, others unchanged")
+
+	require.Len(t, rejected, 2)
+	assert.Equal(t, "Duration", rejected[0].Variable.Name, "in schema order")
+	assert.Equal(t, "Budget", rejected[1].Variable.Name)
+	assert.Equal(t, `I couldn't use "1500" for budget (no currency given). How much, and in which currency? For example 1500 EUR.`,
+		rejected[1].Question())
+}
+
+func TestSchema_Prompt(t *testing.T) {
+	prompt := Default().Prompt()
+	assert.Contains(t, prompt, "TIER 1 (MANDATORY - BLOCKER):\n- Destination (City/Location)\n- Start Date (When?)\n")
+	assert.Contains(t, prompt, "TIER 2 (OPTIONAL - ASK ONCE):\n- Specific Venues, Budget, Interests, Mode\n")
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for name, yaml := range map[string]string{
+		"empty":        "variables: []",
+		"unknown type": "variables: [{name: Budget, type: currency, tier: 2}]",
+		"bad tier":     "variables: [{name: Budget, type: money, tier: 3}]",
+		"no name":      "variables: [{type: money, tier: 2}]",
+		"alias clash":  "variables: [{name: Start, type: date, tier: 1}, {name: Begin, type: time, tier: 1, aliases: [start]}]",
+		"unknown key":  "variables: [{name: Budget, type: money, tier: 2, required: true}]",
+	} {
+		_, err := Parse([]byte(yaml))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestForAgent(t *testing.T) {
+	dir := t.TempDir()
+	agent := filepath.Join(dir, "museum_guide.m")
+
+	s, err := ForAgent(agent)
+	require.NoError(t, err)
+	assert.Same(t, Default(), s, "agents without a schema use the default")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "museum_guide.vars.yaml"), []byte(`
+variables:
+  - name: Museum
+    type: list
+    tier: 1
+    question: Which museums would you like to visit?
+`), 0o644))
+	s, err = ForAgent(agent)
+	require.NoError(t, err)
+	v, ok := s.Lookup("museum")
+	require.True(t, ok)
+	_, rejected := s.Apply(map[string]string{"Museum": "none"}, now)
+	require.Len(t, rejected, 1)
+	assert.Contains(t, rejected[0].Question(), "Which museums would you like to visit?")
+	assert.Equal(t, Tier1, v.Tier)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "museum_guide.vars.yaml"), []byte(`variables: [{name: X, type: blob, tier: 1}]`), 0o644))
+	_, err = ForAgent(agent)
+	assert.Error(t, err)
+}
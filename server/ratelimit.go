@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"guardian-gateway/pkg/config"
+	"guardian-gateway/pkg/logging"
+	"guardian-gateway/pkg/ratelimit"
+	"guardian-gateway/pkg/store"
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimiter enforces chat, agent and feed limits; nil disables rate limiting
+var rateLimiter *ratelimit.Limiter
+
+// Rate limit policies, loaded from config.RateLimitConfig by initRateLimiting
+var (
+	chatPolicy  = ratelimit.Policy{Name: "chat"}
+	agentPolicy = ratelimit.Policy{Name: "agent"}
+	feedPolicy  = ratelimit.Policy{Name: "feed"}
+)
+
+// trustProxies makes r honour X-Forwarded-For only from proxies. With none
+// configured gin would otherwise trust every peer, letting any client pick the
+// IP its per-IP buckets are keyed on.
+func trustProxies(r *gin.Engine, proxies []string) error {
+	if len(proxies) == 0 {
+		proxies = nil
+	}
+	return r.SetTrustedProxies(proxies)
+}
+
+// storeRateBackend uses Postgres once the store is connected and the
+// in-memory backend until then
+type storeRateBackend struct {
+	fallback *ratelimit.MemoryBackend
+}
+
+func (b storeRateBackend) Take(ctx context.Context, key string, l ratelimit.Limit, n int) (ratelimit.Result, error) {
+	pg, ok := feedStore.(*store.PostgresStore)
+	if !ok {
+		return b.fallback.Take(ctx, key, l, n)
+	}
+	return ratelimit.NewPostgresBackend(pg.DB).Take(ctx, key, l, n)
+}
+
+func (b storeRateBackend) Refund(ctx context.Context, key string, l ratelimit.Limit, n int) error {
+	pg, ok := feedStore.(*store.PostgresStore)
+	if !ok {
+		return b.fallback.Refund(ctx, key, l, n)
+	}
+	return ratelimit.NewPostgresBackend(pg.DB).Refund(ctx, key, l, n)
+}
+
+// initRateLimiting builds the policies and picks the backend (memory or postgres)
+func initRateLimiting(cfg config.RateLimitConfig) error {
+	if !cfg.Enabled {
+		slog.Warn("rate limiting disabled")
+		return nil
+	}
+	for _, p := range []struct {
+		policy          *ratelimit.Policy
+		perOwner, perIP string
+	}{
+		{&chatPolicy, cfg.Chat, cfg.ChatIP},
+		{&agentPolicy, cfg.Agent, cfg.AgentIP},
+		{&feedPolicy, cfg.Feed, cfg.FeedIP},
+	} {
+		var err error
+		if p.policy.PerOwner, err = ratelimit.ParseLimit(p.perOwner); err != nil {
+			return fmt.Errorf("%s: %w", p.policy.Name, err)
+		}
+		if p.policy.PerIP, err = ratelimit.ParseLimit(p.perIP); err != nil {
+			return fmt.Errorf("%s ip: %w", p.policy.Name, err)
+		}
+	}
+
+	switch cfg.Backend {
+	case "", "memory":
+		rateLimiter = ratelimit.NewLimiter(ratelimit.NewMemoryBackend())
+	case "postgres":
+		rateLimiter = ratelimit.NewLimiter(storeRateBackend{fallback: ratelimit.NewMemoryBackend()})
+		go pruneRateLimitBuckets(appCtx, time.Hour, longestPeriod())
+	default:
+		return fmt.Errorf("unknown rate limit backend %q", cfg.Backend)
+	}
+	slog.Info("rate limiting enabled", "backend", cfg.Backend,
+		"chat", chatPolicy.PerOwner.String(), "chat_ip", chatPolicy.PerIP.String(),
+		"agent", agentPolicy.PerOwner.String(), "agent_ip", agentPolicy.PerIP.String(),
+		"feed", feedPolicy.PerOwner.String(), "feed_ip", feedPolicy.PerIP.String())
+	return nil
+}
+
+// longestPeriod is how long a bucket can stay relevant after its last use
+func longestPeriod() time.Duration {
+	longest := time.Hour
+	for _, p := range []ratelimit.Policy{chatPolicy, agentPolicy, feedPolicy} {
+		for _, l := range []ratelimit.Limit{p.PerOwner, p.PerIP} {
+			if l.Period > longest {
+				longest = l.Period
+			}
+		}
+	}
+	return longest
+}
+
+// pruneRateLimitBuckets periodically deletes Postgres buckets idle for longer than idle
+func pruneRateLimitBuckets(ctx context.Context, interval, idle time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		pg, ok := feedStore.(*store.PostgresStore)
+		if !ok {
+			continue
+		}
+		tickCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		if _, err := ratelimit.NewPostgresBackend(pg.DB).Prune(tickCtx, idle); err != nil {
+			slog.Warn("failed to prune rate limit buckets", logging.Err(err))
+		}
+		cancel()
+	}
+}
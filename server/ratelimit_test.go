@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"guardian-gateway/pkg/auth"
+	"guardian-gateway/pkg/config"
+	"guardian-gateway/pkg/fastgraph/runtime"
+	"guardian-gateway/pkg/llm"
+	"guardian-gateway/pkg/ratelimit"
+	"guardian-gateway/pkg/session"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInitRateLimiting(t *testing.T) {
+	origLimiter, origChat := rateLimiter, chatPolicy
+	defer func() { rateLimiter, chatPolicy = origLimiter, origChat }()
+
+	rl := config.Default().RateLimit
+	rl.Chat = "5/m"
+	rl.ChatIP = "off"
+	require.NoError(t, initRateLimiting(rl))
+	assert.NotNil(t, rateLimiter)
+	assert.Equal(t, ratelimit.Limit{Capacity: 5, Period: time.Minute}, chatPolicy.PerOwner)
+	assert.False(t, chatPolicy.PerIP.Enabled())
+
+	rl.Chat = "lots"
+	assert.Error(t, initRateLimiting(rl))
+
+	rl.Chat = "5/m"
+	rl.Backend = "redis"
+	assert.Error(t, initRateLimiting(rl))
+}
+
+func TestTrustProxies_SpoofedForwardedFor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	limiter := ratelimit.NewLimiter(ratelimit.NewMemoryBackend())
+	policy := ratelimit.Policy{Name: "chat", PerIP: ratelimit.Limit{Capacity: 1, Period: time.Hour}}
+
+	r := gin.New()
+	require.NoError(t, trustProxies(r, nil))
+	r.Use(limiter.Middleware(policy))
+	r.GET("/", func(c *gin.Context) { c.String(http.StatusOK, c.ClientIP()) })
+
+	do := func(forwardedFor string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "203.0.113.7:4321"
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("198.51.100.1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "203.0.113.7", w.Body.String(), "an untrusted peer's X-Forwarded-For is ignored")
+	assert.Equal(t, http.StatusTooManyRequests, do("198.51.100.2").Code, "a new forwarded IP hits the same bucket")
+
+	// A configured proxy is still believed
+	trusted := gin.New()
+	require.NoError(t, trustProxies(trusted, []string{"203.0.113.0/24"}))
+	trusted.GET("/", func(c *gin.Context) { c.String(http.StatusOK, c.ClientIP()) })
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "203.0.113.7:4321"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	trusted.ServeHTTP(w, req)
+	assert.Equal(t, "198.51.100.1", w.Body.String())
+}
+
+func TestChatStreamHandler_AgentRateLimit(t *testing.T) {
+	session.Init()
+	origLimiter, origAgent, origGenerate := rateLimiter, agentPolicy, GenerateContentFunc
+	defer func() { rateLimiter, agentPolicy, GenerateContentFunc = origLimiter, origAgent, origGenerate }()
+
+	var prompt string
+	GenerateContentFunc = func(ctx context.Context, history []map[string]interface{}, systemPrompt string, userApiKey ...string) (string, *llm.Usage, error) {
+		prompt = systemPrompt
+		return "ACTION: RUN_AGENT SUMMARY: go", nil, nil
+	}
+	runs := 0
+	mockEngine := runtime.New()
+	mockEngine.MockRun = func(agentPath, input string, memory *runtime.MemoryConfig, onEvent func(string)) error {
+		runs++
+		return nil
+	}
+	engine = mockEngine
+
+	rateLimiter = ratelimit.NewLimiter(ratelimit.NewMemoryBackend())
+	agentPolicy = ratelimit.Policy{Name: "agent", PerOwner: ratelimit.Limit{Capacity: 1, Period: time.Hour}}
+
+	gin.SetMode(gin.TestMode)
+	do := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("POST", "/api/chat/stream", bytes.NewBufferString(`{"input": "go", "agent_path": "mock.m"}`))
+		c.Request.Header.Set("Content-Type", "application/json")
+		auth.SetPrincipal(c, &auth.Principal{Kind: auth.KindAnonymous, DeviceID: "rate-device"})
+		ChatStreamHandler(c)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, do().Code)
+	assert.NotContains(t, prompt, "rate limited")
+	w := do()
+	assert.Contains(t, prompt, "Agent runs are rate limited", "the model is told before the call")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, "and a run it picks anyway is refused")
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	assert.Equal(t, 1, runs)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"guardian-gateway/pkg/logging"
+	"guardian-gateway/pkg/store"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type agentVersionCtxKey struct{}
+
+// withAgentVersion tags the cards written under ctx with the version of the
+// agent at agentPath: its file name and a hash of its contents
+func withAgentVersion(ctx context.Context, agentPath string) context.Context {
+	version := filepath.Base(agentPath)
+	if content, err := os.ReadFile(agentPath); err == nil {
+		sum := sha256.Sum256(content)
+		version += "@" + hex.EncodeToString(sum[:6])
+	}
+	return context.WithValue(ctx, agentVersionCtxKey{}, version)
+}
+
+// agentVersion returns the agent version carried by ctx, or ""
+func agentVersion(ctx context.Context) string {
+	v, _ := ctx.Value(agentVersionCtxKey{}).(string)
+	return v
+}
+
+// warningTerms mark a sentence of a card as a warning
+var warningTerms = []string{
+	"warning", "alert", "safety", "unsafe", "avoid", "danger", "advisory", "closed", "closure",
+	"cancel", "strike", "protest", "unrest", "curfew", "evacuat", "storm", "flood",
+}
+
+// priorityRank orders card priorities; unknown ones rank lowest
+var priorityRank = map[string]int{"low": 1, "medium": 2, "normal": 2, "high": 3}
+
+var sentencePattern = regexp.MustCompile(`[^.!?\n]+[.!?]*`)
+
+// materialChanges lists what changed between two revisions of a card that a
+// user should hear about: it became a safety alert, its priority went up, or
+// it carries a warning it did not have before. Rewording is not material.
+func materialChanges(prev, next *store.CardRevision) []string {
+	var changes []string
+	if next.CardType == "safe_alert" && prev.CardType != "safe_alert" {
+		changes = append(changes, "Now a safety alert")
+	}
+	if priorityRank[next.Priority] > priorityRank[prev.Priority] {
+		changes = append(changes, fmt.Sprintf("Priority raised from %s to %s", prev.Priority, next.Priority))
+	}
+
+	seen := map[string]bool{}
+	for _, w := range warnings(revisionText(prev)) {
+		seen[normalizeSentence(w)] = true
+	}
+	for _, w := range warnings(revisionText(next)) {
+		if key := normalizeSentence(w); !seen[key] {
+			seen[key] = true
+			changes = append(changes, "New warning: "+w)
+		}
+	}
+	return changes
+}
+
+// revisionText is the text a revision shows
+func revisionText(r *store.CardRevision) string {
+	for _, field := range []string{"summary", "message", "description"} {
+		if text, ok := r.Data[field].(string); ok && text != "" {
+			return text
+		}
+	}
+	return ""
+}
+
+// warnings returns the sentences of text that mention a warning term
+func warnings(text string) []string {
+	var found []string
+	for _, sentence := range sentencePattern.FindAllString(text, -1) {
+		sentence = strings.TrimSpace(sentence)
+		lower := strings.ToLower(sentence)
+		for _, term := range warningTerms {
+			if strings.Contains(lower, term) {
+				found = append(found, sentence)
+				break
+			}
+		}
+	}
+	return found
+}
+
+func normalizeSentence(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(strings.TrimRight(s, ".!? "))), " ")
+}
+
+// changeNotice is the notification card for the material changes a run made
+// to card. It is keyed to the card and run, so a run raises one notice per card.
+func changeNotice(card *store.Card, prev, next *store.CardRevision, changes []string) *store.Card {
+	title, _ := card.Data["title"].(string)
+	if title == "" {
+		title = card.SourceNode
+	}
+	return &store.Card{
+		RunID:        next.RunID,
+		TripID:       card.TripID,
+		AgentVersion: next.AgentVersion,
+		StickyKey:    "change:" + card.ID + ":" + next.RunID,
+		CardType:     "change_alert",
+		Priority:     "high",
+		SourceNode:   card.SourceNode,
+		Data: map[string]interface{}{
+			"title":                "Updated: " + title,
+			"summary":              strings.Join(changes, "\n"),
+			"changes":              changes,
+			"card_id":              card.ID,
+			"revision_id":          next.ID,
+			"previous_revision_id": prev.ID,
+			"category":             "Update",
+			"colorTheme":           "red",
+		},
+	}
+}
+
+// notifyMaterialChanges compares the revision a run just finished on card with
+// the card's previous revision and saves a change notice when it changed
+// materially
+func notifyMaterialChanges(ctx context.Context, ownerID string, card *store.Card) {
+	if feedStore == nil || card.ID == "" || card.RunID == "" {
+		return
+	}
+	history, err := feedStore.CardHistory(ctx, ownerID, card.ID, 2)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to load card history", "node", card.SourceNode, logging.Err(err))
+		return
+	}
+	if len(history) < 2 || history[0].RunID != card.RunID {
+		return // New card, or the run changed nothing
+	}
+	changes := materialChanges(history[1], history[0])
+	if len(changes) == 0 {
+		return
+	}
+	if err := feedStore.UpsertCard(ctx, ownerID, changeNotice(card, history[1], history[0], changes)); err != nil {
+		slog.ErrorContext(ctx, "failed to save change notice", "node", card.SourceNode, logging.Err(err))
+		return
+	}
+	slog.InfoContext(ctx, "card changed materially", "node", card.SourceNode, "changes", len(changes))
+}
+
+// cardRevisionResponse is a revision with its material changes from the
+// revision before it
+type cardRevisionResponse struct {
+	*store.CardRevision
+	Changes []string `json:"changes,omitempty"`
+}
+
+// CardHistoryHandler godoc
+// @Summary      Card History
+// @Description  A card's revisions, newest first: the content each agent run left it showing, with its run ID and agent version. changes lists material changes from the previous revision (a new warning, a raised priority).
+// @Tags         feed
+// @Produce      json
+// @Param        id     path      string  true   "Card ID"
+// @Param        limit  query     int     false  "Number of revisions (default 50, max 200)"
+// @Success      200    {array}   cardRevisionResponse
+// @Failure      400    {object}  map[string]string
+// @Failure      404    {object}  map[string]string
+// @Router       /api/feed/{id}/history [get]
+func CardHistoryHandler(c *gin.Context) {
+	principal, ok := requestPrincipal(c)
+	if !ok {
+		return
+	}
+	limit, err := queryLimit(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if feedStore == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB not initialized"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	// One extra revision to diff the oldest returned one against
+	history, err := feedStore.CardHistory(ctx, principal.OwnerID(), c.Param("id"), limit+1)
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Card not found"})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load card history", logging.Err(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load card history"})
+		return
+	}
+
+	revisions := make([]cardRevisionResponse, 0, len(history))
+	for i, r := range history {
+		if i == limit {
+			break
+		}
+		rev := cardRevisionResponse{CardRevision: r}
+		if i+1 < len(history) {
+			rev.Changes = materialChanges(history[i+1], r)
+		}
+		revisions = append(revisions, rev)
+	}
+	c.JSON(http.StatusOK, revisions)
+}
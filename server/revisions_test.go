@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"guardian-gateway/pkg/auth"
+	"guardian-gateway/pkg/logging"
+	"guardian-gateway/pkg/store"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaterialChanges(t *testing.T) {
+	rev := func(cardType, priority, summary string) *store.CardRevision {
+		return &store.CardRevision{CardType: cardType, Priority: priority, Data: map[string]interface{}{"summary": summary}}
+	}
+
+	calm := rev("article", "medium", "Lisbon is calm. Trams run on time.")
+	assert.Empty(t, materialChanges(calm, rev("article", "medium", "Lisbon is calm and sunny. Trams run on time!")), "rewording")
+
+	changes := materialChanges(calm, rev("safe_alert", "high", "Lisbon is calm. Avoid Rossio square: protests expected."))
+	assert.Equal(t, []string{
+		"Now a safety alert",
+		"Priority raised from medium to high",
+		"New warning: Avoid Rossio square: protests expected.",
+	}, changes)
+
+	warned := rev("safe_alert", "high", "Warning: metro strike on Friday.")
+	assert.Empty(t, materialChanges(warned, rev("safe_alert", "high", "warning: metro strike on friday")), "same warning")
+	assert.Empty(t, materialChanges(warned, rev("safe_alert", "medium", "All clear.")), "relief is not a warning")
+}
+
+func TestCardWriter_NotifiesMaterialChanges(t *testing.T) {
+	origStore := feedStore
+	defer func() { feedStore = origStore }()
+	s := store.NewMemoryStore()
+	feedStore = s
+
+	run := func(runID, message string) {
+		ctx := logging.WithRunID(context.Background(), runID)
+		w := newCardWriter(ctx, "system_broadcast", "")
+		w.stickyKey = "schedule:guardian.m"
+		w.event(`{"type": "chunk", "node": "NewsAlert", "message": "` + message + `"}`)
+		w.close()
+	}
+	notices := func() []*store.Card {
+		page, err := s.GetFeed(context.Background(), "system_broadcast", store.FeedQuery{Limit: 10, CardTypes: []string{"change_alert"}})
+		require.NoError(t, err)
+		return page.Cards
+	}
+
+	run("run-1", "SAFETY: all calm downtown")
+	run("run-2", "SAFETY: all calm downtown")
+	assert.Empty(t, notices(), "an unchanged card raises nothing")
+
+	run("run-3", "SAFETY: all calm downtown. Avoid the Baixa tonight: protests.")
+	got := notices()
+	require.Len(t, got, 1)
+	assert.Equal(t, "high", got[0].Priority)
+	assert.Equal(t, "NewsAlert", got[0].SourceNode)
+	assert.Contains(t, got[0].Data["summary"], "New warning: Avoid the Baixa tonight: protests.")
+
+	page, err := s.GetFeed(context.Background(), "system_broadcast", store.FeedQuery{Limit: 10})
+	require.NoError(t, err)
+	assert.Len(t, page.Cards, 2, "the standing card and its notice")
+}
+
+func TestCardHistoryHandler(t *testing.T) {
+	origStore := feedStore
+	defer func() { feedStore = origStore }()
+	s := store.NewMemoryStore()
+	feedStore = s
+	ctx := context.Background()
+	var card *store.Card
+	for i, summary := range []string{"Quiet streets.", "Quiet streets. Warning: taxi strike.", "Quiet streets!"} {
+		card = &store.Card{CardType: "safe_alert", Priority: "high", SourceNode: "NewsAlert", StickyKey: "schedule:guardian.m",
+			RunID: []string{"run-1", "run-2", "run-3"}[i], Data: map[string]interface{}{"summary": summary}}
+		require.NoError(t, s.UpsertCard(ctx, "device:test-device", card))
+	}
+
+	gin.SetMode(gin.TestMode)
+	get := func(id, query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("GET", "/api/feed/"+id+"/history?"+query, nil)
+		c.Params = gin.Params{{Key: "id", Value: id}}
+		auth.SetPrincipal(c, &auth.Principal{Kind: auth.KindAnonymous, DeviceID: "test-device"})
+		CardHistoryHandler(c)
+		return w
+	}
+
+	w := get(card.ID, "limit=2")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var revisions []struct {
+		RunID   string   `json:"run_id"`
+		Changes []string `json:"changes"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &revisions))
+	require.Len(t, revisions, 2)
+	assert.Equal(t, "run-3", revisions[0].RunID)
+	assert.Empty(t, revisions[0].Changes)
+	assert.Equal(t, "run-2", revisions[1].RunID)
+	assert.Equal(t, []string{"New warning: Warning: taxi strike."}, revisions[1].Changes, "diffed against the revision before the page")
+
+	assert.Equal(t, http.StatusNotFound, get("missing", "").Code)
+	assert.Equal(t, http.StatusBadRequest, get(card.ID, "limit=0").Code)
+}
+
+func TestWithAgentVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "guardian.m")
+	require.NoError(t, os.WriteFile(path, []byte("agent v1"), 0o644))
+	v1 := agentVersion(withAgentVersion(context.Background(), path))
+	assert.True(t, strings.HasPrefix(v1, "guardian.m@"), v1)
+
+	require.NoError(t, os.WriteFile(path, []byte("agent v2"), 0o644))
+	assert.NotEqual(t, v1, agentVersion(withAgentVersion(context.Background(), path)), "a changed agent is a new version")
+	assert.Equal(t, "missing.m", agentVersion(withAgentVersion(context.Background(), "missing.m")))
+	assert.Empty(t, agentVersion(context.Background()))
+}
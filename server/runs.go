@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"guardian-gateway/pkg/logging"
+	"guardian-gateway/pkg/store"
+	"log/slog"
+	"path/filepath"
+	"time"
+)
+
+// recordRun logs an agent run in the store under the run ID carried by ctx
+// and returns a func that records its outcome. Store failures only log: the
+// run itself goes ahead.
+func recordRun(ctx context.Context, ownerID, agentPath, source string) func(err error, totalTokens int) {
+	s := feedStore
+	if s == nil {
+		return func(error, int) {}
+	}
+	run := &store.AgentRun{
+		ID:      logging.RunID(ctx),
+		OwnerID: ownerID,
+		Agent:   filepath.Base(agentPath),
+		Source:  source,
+		TripID:  tripID(ctx),
+	}
+	if err := s.StartRun(ctx, run); err != nil {
+		slog.WarnContext(ctx, "failed to record agent run", logging.Err(err))
+		return func(error, int) {}
+	}
+	return func(err error, totalTokens int) {
+		run.TotalTokens = totalTokens
+		switch {
+		case err == nil:
+			run.Status = store.RunStatusSucceeded
+		case errors.Is(err, context.Canceled), stoppedByShutdown(ctx):
+			run.Status, run.Error = store.RunStatusCancelled, err.Error()
+		default:
+			run.Status, run.Error = store.RunStatusFailed, err.Error()
+		}
+		// The run context may already be cancelled (shutdown)
+		finishCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		if err := s.FinishRun(finishCtx, run); err != nil {
+			slog.WarnContext(ctx, "failed to record agent run outcome", logging.Err(err))
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"guardian-gateway/pkg/logging"
+	"guardian-gateway/pkg/store"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordRun(t *testing.T) {
+	origStore := feedStore
+	defer func() { feedStore = origStore }()
+	s := store.NewMemoryStore()
+	feedStore = s
+
+	ctx, stop := inflight.runContext(context.Background())
+	defer stop()
+	finish := recordRun(logging.WithRunID(ctx, "run-ok"), "user:u1", "agents/trip_guardian.m", "chat")
+	finish(nil, 120)
+	finish = recordRun(logging.WithRunID(ctx, "run-cancelled"), "user:u1", "agents/trip_guardian.m", "chat")
+	finish(context.Canceled, 0)
+
+	runs, err := s.ListRuns(context.Background(), "user:u1", 10)
+	require.NoError(t, err)
+	require.Len(t, runs, 2)
+	byID := map[string]*store.AgentRun{runs[0].ID: runs[0], runs[1].ID: runs[1]}
+	assert.Equal(t, store.RunStatusSucceeded, byID["run-ok"].Status)
+	assert.Equal(t, 120, byID["run-ok"].TotalTokens)
+	assert.Equal(t, "trip_guardian.m", byID["run-ok"].Agent)
+	assert.Equal(t, store.RunStatusCancelled, byID["run-cancelled"].Status)
+
+	// Without a store runs are simply not recorded
+	feedStore = nil
+	recordRun(ctx, "user:u1", "agent.m", "chat")(nil, 0)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"guardian-gateway/pkg/logging"
+	"guardian-gateway/pkg/session"
+	"guardian-gateway/pkg/store"
+	"log/slog"
+	"time"
+)
+
+// loadSession returns the live session for key, restoring it from the store
+// after a restart (or on another replica) before creating a new one
+func loadSession(ctx context.Context, key string) *session.Session {
+	if s := feedStore; s != nil && !session.GlobalManager.Has(key) {
+		rec, err := s.LoadSession(ctx, key)
+		switch {
+		case err == nil:
+			if sess, err := session.GlobalManager.Restore(key, rec.Data); err != nil {
+				slog.WarnContext(ctx, "discarding unreadable stored session", logging.Err(err))
+			} else {
+				return sess
+			}
+		case !errors.Is(err, store.ErrNotFound):
+			slog.WarnContext(ctx, "failed to load stored session", logging.Err(err))
+		}
+	}
+	return session.GlobalManager.GetOrCreate(key)
+}
+
+// saveSession persists the session so it survives restarts. It runs after
+// the response, so a disconnected client does not cancel it.
+func saveSession(ctx context.Context, sess *session.Session) {
+	s := feedStore
+	if s == nil {
+		return
+	}
+	data, err := sess.Snapshot()
+	if err != nil {
+		slog.WarnContext(ctx, "failed to encode session", logging.Err(err))
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if err := s.SaveSession(ctx, &store.SessionRecord{ID: sess.ID, Data: data}); err != nil {
+		slog.WarnContext(ctx, "failed to save session", logging.Err(err))
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"guardian-gateway/pkg/session"
+	"guardian-gateway/pkg/store"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionSurvivesRestart(t *testing.T) {
+	origStore := feedStore
+	defer func() { feedStore = origStore }()
+	feedStore = store.NewMemoryStore()
+	ctx := context.Background()
+
+	session.Init()
+	sess := loadSession(ctx, "device:d1")
+	sess.UpdateVariables(map[string]string{"destination": "Lisbon"})
+	sess.SetState(session.StateCollecting)
+	saveSession(ctx, sess)
+
+	// A new process starts with no live sessions
+	session.Init()
+	restored := loadSession(ctx, "device:d1")
+	assert.Equal(t, "Lisbon", restored.GetVariables()["destination"])
+	assert.Equal(t, session.StateCollecting, restored.State)
+
+	fresh := loadSession(ctx, "device:d2")
+	assert.Empty(t, fresh.GetVariables())
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"guardian-gateway/pkg/logging"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// appCtx is cancelled when shutdown starts; background jobs (scheduler, key
+// reconciliation, bucket pruning, DB reconnects) stop with it
+var appCtx, stopBackground = context.WithCancel(context.Background())
+
+// errShuttingDown is the cancellation cause of runs cut off by shutdown
+var errShuttingDown = errors.New("server shutting down")
+
+// drainer tracks in-flight chat streams and scheduled runs so shutdown can
+// refuse new work, wait for what is running, and then cancel the stragglers
+type drainer struct {
+	mu       sync.Mutex
+	draining bool
+	wg       sync.WaitGroup
+
+	// stop is cancelled when the drain deadline passes
+	stop       context.Context
+	cancelRuns context.CancelCauseFunc
+}
+
+func newDrainer() *drainer {
+	stop, cancel := context.WithCancelCause(context.Background())
+	return &drainer{stop: stop, cancelRuns: cancel}
+}
+
+// inflight is the process-wide drainer used by the chat handler and scheduler
+var inflight = newDrainer()
+
+// begin registers a unit of work; ok is false once draining has started
+func (d *drainer) begin() (done func(), ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.draining {
+		return nil, false
+	}
+	d.wg.Add(1)
+	return sync.OnceFunc(d.wg.Done), true
+}
+
+// Draining reports whether shutdown has started
+func (d *drainer) Draining() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.draining
+}
+
+// runContext keeps parent's values (request, run and trace IDs) but not its
+// cancellation, so a run survives the client going away; it is cancelled
+// only when shutdown gives up waiting
+func (d *drainer) runContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(context.WithoutCancel(parent))
+	stop := context.AfterFunc(d.stop, func() { cancel(context.Cause(d.stop)) })
+	return ctx, func() {
+		stop()
+		cancel(context.Canceled)
+	}
+}
+
+// drain stops new work and waits up to timeout for in-flight work. Work still
+// running then is cancelled and given grace to wind down. It reports whether
+// everything finished.
+func (d *drainer) drain(timeout, grace time.Duration) bool {
+	d.mu.Lock()
+	d.draining = true
+	d.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return true
+	case <-time.After(timeout):
+	}
+	slog.Warn("drain deadline passed, cancelling in-flight runs", "timeout", timeout)
+	d.cancelRuns(errShuttingDown)
+
+	select {
+	case <-finished:
+		return true
+	case <-time.After(grace):
+		return false
+	}
+}
+
+// stoppedByShutdown reports whether ctx was cancelled by the drain deadline
+func stoppedByShutdown(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errShuttingDown)
+}
+
+// rejectWhileDraining answers 503 with Retry-After once shutdown has started,
+// so clients retry against another task
+func rejectWhileDraining(c *gin.Context) {
+	c.Header("Retry-After", "5")
+	c.Header("Connection", "close")
+	c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Server is restarting, please retry shortly"})
+}
+
+// shutdown drains in-flight work, stops the HTTP server and background jobs,
+// closes the database pool and flushes traces
+func shutdown(srv *http.Server, flush func(context.Context) error) {
+	timeout := appConfig.Server.ShutdownTimeout
+	grace := appConfig.Agent.StopGrace + 2*time.Second
+	slog.Info("shutting down", "drain_timeout", timeout)
+
+	// Stop tickers first so no scheduled run starts during the drain
+	stopBackground()
+
+	// Close listeners and idle connections while in-flight streams drain
+	serverDone := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout+grace)
+		defer cancel()
+		serverDone <- srv.Shutdown(ctx)
+	}()
+
+	if !inflight.drain(timeout, grace) {
+		slog.Warn("in-flight runs did not stop in time")
+	}
+	if err := <-serverDone; err != nil {
+		slog.Warn("forcing remaining connections closed", logging.Err(err))
+		_ = srv.Close()
+	}
+
+	if s := feedStore; s != nil {
+		if err := s.Close(); err != nil {
+			slog.Warn("failed to close store", logging.Err(err))
+		}
+	}
+	flushTraces(flush)
+	slog.Info("shutdown complete")
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"guardian-gateway/pkg/auth"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDrainer_WaitsForInFlightWork(t *testing.T) {
+	d := newDrainer()
+	done, ok := d.begin()
+	require.True(t, ok)
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		done()
+		done() // idempotent
+	}()
+	assert.True(t, d.drain(time.Second, time.Second))
+	assert.True(t, d.Draining())
+
+	_, ok = d.begin()
+	assert.False(t, ok, "no new work once draining")
+}
+
+func TestDrainer_CancelsRunsAtDeadline(t *testing.T) {
+	d := newDrainer()
+	done, ok := d.begin()
+	require.True(t, ok)
+
+	type key struct{}
+	parent, cancelParent := context.WithCancel(context.WithValue(context.Background(), key{}, "run-1"))
+	runCtx, cancelRun := d.runContext(parent)
+	defer cancelRun()
+
+	// The client going away does not stop the run
+	cancelParent()
+	assert.NoError(t, runCtx.Err())
+	assert.Equal(t, "run-1", runCtx.Value(key{}))
+
+	go func() {
+		<-runCtx.Done()
+		done()
+	}()
+	start := time.Now()
+	assert.True(t, d.drain(30*time.Millisecond, time.Second))
+	assert.Less(t, time.Since(start), time.Second)
+	assert.True(t, stoppedByShutdown(runCtx))
+}
+
+func TestDrainer_GivesUpAfterGrace(t *testing.T) {
+	d := newDrainer()
+	_, ok := d.begin()
+	require.True(t, ok)
+	assert.False(t, d.drain(10*time.Millisecond, 10*time.Millisecond))
+}
+
+func TestChatStreamHandler_RejectsWhileDraining(t *testing.T) {
+	orig := inflight
+	defer func() { inflight = orig }()
+	inflight = newDrainer()
+	inflight.drain(0, 0)
+
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/api/chat/stream", bytes.NewBufferString(`{"input":"hi"}`))
+	auth.SetPrincipal(c, &auth.Principal{Kind: auth.KindAnonymous, DeviceID: "device-1"})
+	ChatStreamHandler(c)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"guardian-gateway/pkg/logging"
+	"guardian-gateway/pkg/session"
+	"guardian-gateway/pkg/store"
+	"guardian-gateway/pkg/trip"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type tripIDCtxKey struct{}
+
+// withTripID tags the runs and cards started under ctx with a trip
+func withTripID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, tripIDCtxKey{}, id)
+}
+
+// tripID returns the trip carried by ctx, or ""
+func tripID(ctx context.Context) string {
+	id, _ := ctx.Value(tripIDCtxKey{}).(string)
+	return id
+}
+
+// syncSessionTrip saves the trip the session's variables describe, creating
+// it on the session's first agent run, and returns it. Without a store or a
+// destination there is no trip yet, and nil is returned.
+func syncSessionTrip(ctx context.Context, sess *session.Session, ownerID string) *trip.Trip {
+	if feedStore == nil {
+		return nil
+	}
+	t := &trip.Trip{OwnerID: ownerID}
+	if id := sess.GetTripID(); id != "" {
+		loaded, err := trip.Get(ctx, feedStore, ownerID, id)
+		switch {
+		case err == nil:
+			t = loaded
+		case errors.Is(err, store.ErrNotFound):
+			// Deleted since; the session starts a new trip
+		default:
+			slog.WarnContext(ctx, "failed to load session trip", logging.Err(err))
+			return nil
+		}
+	}
+	t.ApplyVariables(sess.GetVariables())
+	if t.Validate() != nil {
+		return nil // Not enough to plan a trip yet
+	}
+	locateDestination(ctx, &t.Destination)
+
+	var err error
+	if t.ID == "" {
+		err = trip.Create(ctx, feedStore, t)
+	} else {
+		err = trip.Update(ctx, feedStore, t)
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "failed to save session trip", logging.Err(err))
+		return nil
+	}
+	sess.SetTripID(t.ID)
+	saveMapCard(ctx, ownerID, t)
+	return t
+}
+
+// saveMapCard pins the trip's destination on its map card
+func saveMapCard(ctx context.Context, ownerID string, t *trip.Trip) {
+	if card := mapCard(ctx, t); card != nil {
+		if err := feedStore.UpsertCard(ctx, ownerID, card); err != nil {
+			slog.WarnContext(ctx, "failed to save map card", logging.Err(err))
+		}
+	}
+}
+
+// CreateTripHandler godoc
+// @Summary      Create Trip
+// @Description  Save a trip: its destination (with coordinates and IANA timezone), start and end dates in the destination's timezone, travellers, budget, interests and venues. Cards and agent runs reference it by ID.
+// @Tags         trips
+// @Accept       json
+// @Produce      json
+// @Param        trip  body      trip.Trip  true  "Trip"
+// @Success      201   {object}  trip.Trip
+// @Failure      400   {object}  map[string]string
+// @Router       /api/trips [post]
+func CreateTripHandler(c *gin.Context) {
+	principal, ok := requestPrincipal(c)
+	if !ok {
+		return
+	}
+	t, ok := bindTrip(c)
+	if !ok {
+		return
+	}
+	t.OwnerID = principal.OwnerID()
+
+	if feedStore == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB not initialized"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if err := trip.Create(ctx, feedStore, t); err != nil {
+		slog.ErrorContext(ctx, "failed to create trip", logging.Err(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create trip"})
+		return
+	}
+	saveMapCard(ctx, t.OwnerID, t)
+	c.JSON(http.StatusCreated, t)
+}
+
+// ListTripsHandler godoc
+// @Summary      List Trips
+// @Description  The caller's trips, most recently updated first
+// @Tags         trips
+// @Produce      json
+// @Param        limit  query     int  false  "Number of trips (default 50, max 200)"
+// @Success      200    {array}   trip.Trip
+// @Failure      400    {object}  map[string]string
+// @Router       /api/trips [get]
+func ListTripsHandler(c *gin.Context) {
+	principal, ok := requestPrincipal(c)
+	if !ok {
+		return
+	}
+	limit, err := queryLimit(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if feedStore == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB not initialized"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	trips, err := trip.List(ctx, feedStore, principal.OwnerID(), limit)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to list trips", logging.Err(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list trips"})
+		return
+	}
+	c.JSON(http.StatusOK, trips)
+}
+
+// GetTripHandler godoc
+// @Summary      Get Trip
+// @Tags         trips
+// @Produce      json
+// @Param        id   path      string  true  "Trip ID"
+// @Success      200  {object}  trip.Trip
+// @Failure      404  {object}  map[string]string
+// @Router       /api/trips/{id} [get]
+func GetTripHandler(c *gin.Context) {
+	principal, ok := requestPrincipal(c)
+	if !ok {
+		return
+	}
+
+	if feedStore == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB not initialized"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	t, err := trip.Get(ctx, feedStore, principal.OwnerID(), c.Param("id"))
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Trip not found"})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load trip", logging.Err(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load trip"})
+		return
+	}
+	c.JSON(http.StatusOK, t)
+}
+
+// UpdateTripHandler godoc
+// @Summary      Update Trip
+// @Description  Replace a trip with the request body
+// @Tags         trips
+// @Accept       json
+// @Produce      json
+// @Param        id    path      string     true  "Trip ID"
+// @Param        trip  body      trip.Trip  true  "Trip"
+// @Success      200   {object}  trip.Trip
+// @Failure      400   {object}  map[string]string
+// @Failure      404   {object}  map[string]string
+// @Router       /api/trips/{id} [put]
+func UpdateTripHandler(c *gin.Context) {
+	principal, ok := requestPrincipal(c)
+	if !ok {
+		return
+	}
+	t, ok := bindTrip(c)
+	if !ok {
+		return
+	}
+	t.ID, t.OwnerID = c.Param("id"), principal.OwnerID()
+
+	if feedStore == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB not initialized"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	err := trip.Update(ctx, feedStore, t)
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Trip not found"})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to update trip", logging.Err(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update trip"})
+		return
+	}
+	saveMapCard(ctx, t.OwnerID, t)
+	c.JSON(http.StatusOK, t)
+}
+
+// DeleteTripHandler godoc
+// @Summary      Delete Trip
+// @Description  Delete a trip and its feed cards
+// @Tags         trips
+// @Produce      json
+// @Param        id   path      string  true  "Trip ID"
+// @Success      200  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Router       /api/trips/{id} [delete]
+func DeleteTripHandler(c *gin.Context) {
+	principal, ok := requestPrincipal(c)
+	if !ok {
+		return
+	}
+
+	if feedStore == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB not initialized"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	err := feedStore.DeleteTrip(ctx, principal.OwnerID(), c.Param("id"))
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Trip not found"})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to delete trip", logging.Err(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete trip"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+// bindTrip reads and validates a trip request body, answering 400 when it is
+// not a valid trip. The destination's missing coordinates, country and
+// timezone are looked up.
+func bindTrip(c *gin.Context) (*trip.Trip, bool) {
+	var t trip.Trip
+	if err := c.ShouldBindJSON(&t); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return nil, false
+	}
+	if err := t.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	locateDestination(c.Request.Context(), &t.Destination)
+	return &t, true
+}
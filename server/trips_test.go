@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"guardian-gateway/pkg/auth"
+	"guardian-gateway/pkg/fastgraph/runtime"
+	"guardian-gateway/pkg/llm"
+	"guardian-gateway/pkg/session"
+	"guardian-gateway/pkg/store"
+	"guardian-gateway/pkg/trip"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTripHandlers(t *testing.T) {
+	origStore := feedStore
+	defer func() { feedStore = origStore }()
+	s := store.NewMemoryStore()
+	feedStore = s
+
+	gin.SetMode(gin.TestMode)
+	call := func(handler gin.HandlerFunc, method, id, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest(method, "/api/trips/"+id, strings.NewReader(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Params = gin.Params{{Key: "id", Value: id}}
+		auth.SetPrincipal(c, &auth.Principal{Kind: auth.KindAnonymous, DeviceID: "test-device"})
+		handler(c)
+		return w
+	}
+	var got struct {
+		ID          string `json:"id"`
+		Destination struct {
+			Name     string `json:"name"`
+			Timezone string `json:"timezone"`
+		} `json:"destination"`
+		StartDate  string `json:"start_date"`
+		Travellers int    `json:"travellers"`
+	}
+
+	w := call(CreateTripHandler, http.MethodPost, "", `{"destination": {"name": "Lisbon", "timezone": "Europe/Lisbon",
+		"geo": {"lat": 38.72, "lon": -9.14}}, "start_date": "2026-06-10", "end_date": "2026-06-14", "travellers": 2}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	require.NotEmpty(t, got.ID)
+	id := got.ID
+	assert.Equal(t, "2026-06-10", got.StartDate)
+
+	// The trip's destination is pinned on one map card, moved with the trip
+	mapCards := func() []*store.Card {
+		page, err := s.GetFeed(context.Background(), "device:test-device", store.FeedQuery{Limit: 50})
+		require.NoError(t, err)
+		var cards []*store.Card
+		for _, c := range page.Cards {
+			if c.CardType == "map_coord" {
+				cards = append(cards, c)
+			}
+		}
+		return cards
+	}
+	cards := mapCards()
+	require.Len(t, cards, 1)
+	assert.Equal(t, id, cards[0].TripID)
+	assert.Equal(t, 38.72, cards[0].Data["lat"])
+
+	assert.Equal(t, http.StatusBadRequest, call(CreateTripHandler, http.MethodPost, "", `{"destination": {}}`).Code)
+	assert.Equal(t, http.StatusBadRequest, call(CreateTripHandler, http.MethodPost, "", `{"destination": {"name": "Lisbon"}, "start_date": "June"}`).Code)
+
+	w = call(UpdateTripHandler, http.MethodPut, id, `{"destination": {"name": "Porto", "geo": {"lat": 41.15, "lon": -8.61}}, "travellers": 3}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	cards = mapCards()
+	require.Len(t, cards, 1)
+	assert.Equal(t, "Porto", cards[0].Data["label"])
+	assert.Equal(t, 41.15, cards[0].Data["lat"])
+	w = call(GetTripHandler, http.MethodGet, id, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	got.StartDate = ""
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, "Porto", got.Destination.Name)
+	assert.Equal(t, 3, got.Travellers)
+	assert.Empty(t, got.StartDate, "PUT replaces the trip")
+	assert.Equal(t, http.StatusNotFound, call(UpdateTripHandler, http.MethodPut, "missing", `{"destination": {"name": "Porto"}}`).Code)
+
+	w = call(ListTripsHandler, http.MethodGet, "", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var list []json.RawMessage
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Len(t, list, 1)
+
+	assert.Equal(t, http.StatusOK, call(DeleteTripHandler, http.MethodDelete, id, "").Code)
+	assert.Equal(t, http.StatusNotFound, call(GetTripHandler, http.MethodGet, id, "").Code)
+	assert.Equal(t, http.StatusNotFound, call(DeleteTripHandler, http.MethodDelete, id, "").Code)
+}
+
+func TestChatStreamHandler_SavesTrip(t *testing.T) {
+	origStore := feedStore
+	defer func() { feedStore = origStore }()
+	s := store.NewMemoryStore()
+	feedStore = s
+	session.Init()
+	session.GlobalManager.GetOrCreate("device:test-device").UpdateVariables(map[string]string{
+		"Destination": "Lisbon", "Start Date": "2026-06-10", "Duration": "3 days", "Budget": "900 EUR",
+	})
+
+	originalGenerate := GenerateContentFunc
+	defer func() { GenerateContentFunc = originalGenerate }()
+	GenerateContentFunc = func(ctx context.Context, history []map[string]interface{}, systemPrompt string, userApiKey ...string) (string, *llm.Usage, error) {
+		return "ACTION: RUN_AGENT SUMMARY: Run requested by test", nil, nil
+	}
+	mockEngine := runtime.New()
+	mockEngine.MockRun = func(agentPath, input string, memory *runtime.MemoryConfig, onEvent func(string)) error {
+		onEvent(`{"type": "chunk", "node": "Weather", "message": "Sunny all week"}`)
+		return nil
+	}
+	origEngine := engine
+	defer func() { engine = origEngine }()
+	engine = mockEngine
+
+	gin.SetMode(gin.TestMode)
+	chat := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("POST", "/api/chat/stream", bytes.NewBufferString(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		auth.SetPrincipal(c, &auth.Principal{Kind: auth.KindAnonymous, DeviceID: "test-device"})
+		ChatStreamHandler(c)
+		return w
+	}
+
+	assert.Equal(t, http.StatusNotFound, chat(`{"input": "Go", "agent_path": "mock.m", "trip_id": "missing"}`).Code)
+
+	w := chat(`{"input": "Go", "agent_path": "mock.m"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "event:trip")
+
+	ctx := context.Background()
+	trips, err := s.ListTrips(ctx, "device:test-device", 10)
+	require.NoError(t, err)
+	require.Len(t, trips, 1)
+	tripID := trips[0].ID
+	assert.Contains(t, string(trips[0].Data), `"end_date":"2026-06-12"`)
+	assert.Contains(t, string(trips[0].Data), `"country":"Portugal"`, "the destination is geocoded")
+	assert.Contains(t, string(trips[0].Data), `"timezone":"Europe/Lisbon"`)
+	assert.Equal(t, tripID, session.GlobalManager.GetOrCreate("device:test-device").GetTripID())
+
+	runs, err := s.ListRuns(ctx, "device:test-device", 10)
+	require.NoError(t, err)
+	require.Len(t, runs, 1)
+	assert.Equal(t, tripID, runs[0].TripID)
+	page, err := s.GetFeed(ctx, "device:test-device", store.FeedQuery{Limit: 10, TripIDs: []string{tripID}})
+	require.NoError(t, err)
+	require.Len(t, page.Cards, 2)
+	bySource := map[string]*store.Card{}
+	for _, card := range page.Cards {
+		bySource[card.SourceNode] = card
+	}
+	require.Contains(t, bySource, "Weather")
+	require.Contains(t, bySource, "Destination")
+	pin := bySource["Destination"]
+	assert.Equal(t, "map_coord", pin.CardType)
+	assert.Equal(t, 38.7223, pin.Data["lat"])
+	assert.Equal(t, -9.1393, pin.Data["lng"])
+	assert.Equal(t, "Lisbon", pin.Data["label"])
+
+	// A second run moves the same pin rather than adding one
+	session.GlobalManager.GetOrCreate("device:test-device").UpdateVariables(map[string]string{"Destination": "Porto"})
+	require.Equal(t, http.StatusOK, chat(`{"input": "Go", "agent_path": "mock.m"}`).Code)
+	page, err = s.GetFeed(ctx, "device:test-device", store.FeedQuery{Limit: 10, TripIDs: []string{tripID}, CardTypes: []string{"map_coord"}})
+	require.NoError(t, err)
+	require.Len(t, page.Cards, 1)
+	assert.Equal(t, "Porto", page.Cards[0].Data["label"])
+	assert.Equal(t, 41.1579, page.Cards[0].Data["lat"])
+}
+
+func TestChatStreamHandler_SwitchesTrips(t *testing.T) {
+	origStore := feedStore
+	defer func() { feedStore = origStore }()
+	s := store.NewMemoryStore()
+	feedStore = s
+	session.Init()
+
+	ctx := context.Background()
+	owner := "device:test-device"
+	lisbon := &trip.Trip{OwnerID: owner, Destination: trip.Destination{Name: "Lisbon"}}
+	start, err := trip.ParseDate("2026-06-10")
+	require.NoError(t, err)
+	lisbon.StartDate = &start
+	require.NoError(t, trip.Create(ctx, s, lisbon))
+	porto := &trip.Trip{OwnerID: owner, Destination: trip.Destination{Name: "Porto", Timezone: "Europe/Lisbon",
+		Geo: &trip.GeoPoint{Lat: 41.1579, Lon: -8.6291}}, Travellers: 2}
+	require.NoError(t, trip.Create(ctx, s, porto))
+
+	originalGenerate := GenerateContentFunc
+	defer func() { GenerateContentFunc = originalGenerate }()
+	GenerateContentFunc = func(ctx context.Context, history []map[string]interface{}, systemPrompt string, userApiKey ...string) (string, *llm.Usage, error) {
+		return "ACTION: RUN_AGENT SUMMARY: go", nil, nil
+	}
+	var inputs []string
+	mockEngine := runtime.New()
+	mockEngine.MockRun = func(agentPath, input string, memory *runtime.MemoryConfig, onEvent func(string)) error {
+		inputs = append(inputs, input)
+		return nil
+	}
+	origEngine := engine
+	defer func() { engine = origEngine }()
+	engine = mockEngine
+
+	gin.SetMode(gin.TestMode)
+	chat := func(tripID string) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("POST", "/api/chat/stream",
+			bytes.NewBufferString(`{"input": "Go", "agent_path": "mock.m", "trip_id": "`+tripID+`"}`))
+		c.Request.Header.Set("Content-Type", "application/json")
+		auth.SetPrincipal(c, &auth.Principal{Kind: auth.KindAnonymous, DeviceID: "test-device"})
+		ChatStreamHandler(c)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	chat(lisbon.ID)
+	chat(porto.ID)
+	chat(lisbon.ID)
+	require.Len(t, inputs, 3)
+	assert.Contains(t, inputs[1], "- Destination: Porto")
+	assert.NotContains(t, inputs[1], "2026-06-10", "the previous trip's dates stay with it")
+	assert.Contains(t, inputs[2], "- Destination: Lisbon")
+
+	got, err := trip.Get(ctx, s, owner, porto.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Porto", got.Destination.Name)
+	assert.Nil(t, got.StartDate)
+	assert.Equal(t, &trip.GeoPoint{Lat: 41.1579, Lon: -8.6291}, got.Destination.Geo)
+	assert.Equal(t, 2, got.Travellers)
+	got, err = trip.Get(ctx, s, owner, lisbon.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Lisbon", got.Destination.Name)
+	assert.Equal(t, "2026-06-10", got.StartDate.String())
+
+	page, err := s.GetFeed(ctx, owner, store.FeedQuery{Limit: 10, TripIDs: []string{porto.ID}, CardTypes: []string{"map_coord"}})
+	require.NoError(t, err)
+	require.Len(t, page.Cards, 1)
+	assert.Equal(t, "Porto", page.Cards[0].Data["label"], "each trip's pin stays on its own city")
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -34,7 +33,7 @@ func TestUnsplashDownloadTriggerResilience(t *testing.T) {
 		if elapsed > 100*time.Millisecond {
 			t.Errorf("Goroutine launch took %v, should be instant", elapsed)
 		} else {
-			fmt.Printf("✓ Goroutine launched in %v (non-blocking)\n", elapsed)
+			t.Logf("✓ Goroutine launched in %v (non-blocking)", elapsed)
 		}
 	})
 
@@ -54,7 +53,7 @@ func TestUnsplashDownloadTriggerResilience(t *testing.T) {
 
 		select {
 		case <-done:
-			fmt.Println("✓ Request timed out after 2 seconds as expected")
+			t.Log("✓ Request timed out after 2 seconds as expected")
 		case <-time.After(3 * time.Second):
 			t.Error("Timeout didn't work, request took too long")
 		}
@@ -64,7 +63,7 @@ func TestUnsplashDownloadTriggerResilience(t *testing.T) {
 	t.Run("Successful tracking completes", func(t *testing.T) {
 		successServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusOK)
-			fmt.Println("✓ Download tracking endpoint hit successfully")
+			t.Log("✓ Download tracking endpoint hit successfully")
 		}))
 		defer successServer.Close()
 
@@ -85,7 +84,7 @@ func TestUnsplashDownloadTriggerResilience(t *testing.T) {
 			if err != nil {
 				t.Errorf("Expected success, got error: %v", err)
 			} else {
-				fmt.Println("✓ Download tracking succeeded")
+				t.Log("✓ Download tracking succeeded")
 			}
 		case <-time.After(3 * time.Second):
 			t.Error("Request took too long")
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"guardian-gateway/pkg/llm"
+	"guardian-gateway/pkg/logging"
+	"guardian-gateway/pkg/store"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BudgetWarning is streamed as an SSE "warning" event when a user nears their budget
+type BudgetWarning struct {
+	Type      string  `json:"type"` // always "budget_warning"
+	SpentUSD  float64 `json:"spent_usd"`
+	BudgetUSD float64 `json:"budget_usd"`
+	Percent   float64 `json:"percent"`
+	Message   string  `json:"message"`
+}
+
+// recordUsage persists the usage of one LLM call or agent run. Failures are logged, never fatal.
+func recordUsage(ctx context.Context, ownerID, source string, usage *llm.Usage) {
+	if usage == nil || feedStore == nil || ownerID == "" {
+		return
+	}
+	ev := &store.UsageEvent{
+		UserID:           ownerID,
+		Source:           source,
+		Model:            usage.Model,
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		TotalTokens:      usage.TotalTokens,
+		CostUSD:          usage.CostUSD,
+		CostEstimated:    usage.CostEstimated,
+	}
+	if err := feedStore.RecordUsage(ctx, ev); err != nil {
+		slog.WarnContext(ctx, "failed to record usage", "usage_owner", ownerID, logging.Err(err))
+	}
+}
+
+// accumulateRunUsage adds a fastgraph "usage" event to the running total for an agent run
+func accumulateRunUsage(total *llm.Usage, eventJSON string) {
+	var evt struct {
+		Model   string          `json:"model"`
+		Usage   *llm.TokenUsage `json:"usage"`
+		CostUSD *float64        `json:"cost_usd"`
+	}
+	if err := json.Unmarshal([]byte(eventJSON), &evt); err != nil || evt.Usage == nil {
+		return
+	}
+
+	tokens := evt.Usage.TotalTokens
+	if tokens == 0 {
+		tokens = evt.Usage.PromptTokens + evt.Usage.CompletionTokens
+	}
+	if total.Model == "" {
+		total.Model = evt.Model
+	} else if evt.Model != "" && total.Model != evt.Model {
+		total.Model = "mixed"
+	}
+	total.PromptTokens += evt.Usage.PromptTokens
+	total.CompletionTokens += evt.Usage.CompletionTokens
+	total.TotalTokens += tokens
+	if evt.CostUSD != nil {
+		total.CostUSD += *evt.CostUSD
+	} else {
+		total.CostUSD += llm.EstimateCost(evt.Model, evt.Usage.PromptTokens, evt.Usage.CompletionTokens)
+		total.CostEstimated = true
+	}
+}
+
+// checkBudget returns a warning if the user's spend this period crossed the warning threshold
+func checkBudget(ctx context.Context, userID string) *BudgetWarning {
+	if feedStore == nil {
+		return nil
+	}
+	summary, err := feedStore.GetUserUsage(ctx, userID, store.BudgetPeriodStart(time.Now()))
+	if err != nil {
+		slog.WarnContext(ctx, "failed to check budget", logging.Err(err))
+		return nil
+	}
+	if summary.MaxBudget <= 0 {
+		return nil
+	}
+
+	threshold := appConfig.LiteLLM.BudgetWarningThreshold
+	ratio := summary.CostUSD / summary.MaxBudget
+	if ratio < threshold {
+		return nil
+	}
+
+	msg := fmt.Sprintf("You've used %.0f%% of your $%.2f monthly budget.", ratio*100, summary.MaxBudget)
+	if ratio >= 1 {
+		msg = fmt.Sprintf("You've reached your $%.2f monthly budget. Requests may be rejected until it resets.", summary.MaxBudget)
+	}
+	return &BudgetWarning{
+		Type:      "budget_warning",
+		SpentUSD:  summary.CostUSD,
+		BudgetUSD: summary.MaxBudget,
+		Percent:   ratio * 100,
+		Message:   msg,
+	}
+}
+
+// requireAdmin guards admin routes with the ADMIN_API_KEY shared secret (X-Admin-Key header)
+func requireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		adminKey := appConfig.Admin.APIKey
+		if adminKey == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admin API disabled"})
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(c.GetHeader("X-Admin-Key")), []byte(adminKey)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// parseSince reads the optional "since" query parameter (RFC 3339), defaulting to the budget period start
+func parseSince(c *gin.Context) (time.Time, error) {
+	if v := c.Query("since"); v != "" {
+		return time.Parse(time.RFC3339, v)
+	}
+	return store.BudgetPeriodStart(time.Now()), nil
+}
+
+// UsageHandler godoc
+// @Summary      Get Usage
+// @Description  Get token usage and spend for the current user (defaults to the current budget month)
+// @Tags         usage
+// @Produce      json
+// @Param        since  query     string  false  "RFC 3339 start time"
+// @Success      200    {object}  store.UsageSummary
+// @Failure      400    {object}  map[string]string
+// @Router       /api/usage [get]
+func UsageHandler(c *gin.Context) {
+	principal, ok := requestPrincipal(c)
+	if !ok {
+		return
+	}
+	ownerID := principal.OwnerID()
+
+	since, err := parseSince(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since (expected RFC 3339)"})
+		return
+	}
+
+	if feedStore == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB not initialized"})
+		return
+	}
+
+	summary, err := feedStore.GetUserUsage(c.Request.Context(), ownerID, since)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "failed to fetch usage", logging.Err(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch usage"})
+		return
+	}
+	c.JSON(http.StatusOK, summary)
+}
+
+// AdminUsageHandler godoc
+// @Summary      Get Usage (Admin)
+// @Description  Get per-user usage aggregated since a point in time, highest spend first
+// @Tags         admin
+// @Produce      json
+// @Param        since  query     string  false  "RFC 3339 start time"
+// @Param        limit  query     int     false  "Max users (default 100)"
+// @Success      200    {array}   store.UserUsage
+// @Failure      400    {object}  map[string]string
+// @Failure      401    {object}  map[string]string
+// @Router       /api/admin/usage [get]
+func AdminUsageHandler(c *gin.Context) {
+	since, err := parseSince(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since (expected RFC 3339)"})
+		return
+	}
+	limit := 100
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 1000 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit (1-1000)"})
+			return
+		}
+		limit = n
+	}
+
+	if feedStore == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB not initialized"})
+		return
+	}
+
+	report, err := feedStore.GetUsageAggregate(c.Request.Context(), since, limit)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "failed to fetch usage aggregate", logging.Err(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch usage"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
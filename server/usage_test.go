@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"guardian-gateway/pkg/auth"
+	"guardian-gateway/pkg/llm"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccumulateRunUsage(t *testing.T) {
+	total := &llm.Usage{}
+
+	accumulateRunUsage(total, `{"type":"usage","model":"gemini-2.0-flash","usage":{"prompt_tokens":1000,"completion_tokens":500}}`)
+	accumulateRunUsage(total, `{"type":"usage","model":"gemini-2.0-flash","usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15},"cost_usd":0.01}`)
+	accumulateRunUsage(total, `{"type":"usage"}`) // no usage block: ignored
+	accumulateRunUsage(total, `not json`)
+
+	assert.Equal(t, "gemini-2.0-flash", total.Model)
+	assert.Equal(t, 1010, total.PromptTokens)
+	assert.Equal(t, 505, total.CompletionTokens)
+	assert.Equal(t, 1515, total.TotalTokens)
+	assert.True(t, total.CostEstimated)
+	assert.InDelta(t, 0.01+llm.EstimateCost("gemini-2.0-flash", 1000, 500), total.CostUSD, 1e-9)
+
+	accumulateRunUsage(total, `{"type":"usage","model":"gpt-4o","usage":{"total_tokens":1}}`)
+	assert.Equal(t, "mixed", total.Model)
+}
+
+func TestRequireAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/api/admin/ping", requireAdmin(), func(c *gin.Context) { c.String(http.StatusOK, "pong") })
+
+	do := func(key string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/admin/ping", nil)
+		if key != "" {
+			req.Header.Set("X-Admin-Key", key)
+		}
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	origKey := appConfig.Admin.APIKey
+	defer func() { appConfig.Admin.APIKey = origKey }()
+
+	appConfig.Admin.APIKey = ""
+	assert.Equal(t, http.StatusForbidden, do("anything"))
+
+	appConfig.Admin.APIKey = "s3cret"
+	assert.Equal(t, http.StatusUnauthorized, do(""))
+	assert.Equal(t, http.StatusUnauthorized, do("wrong"))
+	assert.Equal(t, http.StatusOK, do("s3cret"))
+}
+
+func TestUsageHandler(t *testing.T) {
+	globalFeedStore := feedStore
+	defer func() { feedStore = globalFeedStore }()
+	feedStore = nil
+
+	gin.SetMode(gin.TestMode)
+
+	// Invalid since
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/api/usage?since=yesterday", nil)
+	auth.SetPrincipal(c, &auth.Principal{Kind: auth.KindUser, UserID: "user-1"})
+	UsageHandler(c)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Nil store
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/api/usage", nil)
+	auth.SetPrincipal(c, &auth.Principal{Kind: auth.KindUser, UserID: "user-1"})
+	UsageHandler(c)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "DB not initialized")
+}
+
+func TestAdminUsageHandler_InvalidLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/api/admin/usage?limit=0", nil)
+
+	AdminUsageHandler(c)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}